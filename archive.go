@@ -0,0 +1,313 @@
+package ddb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ejfhp/ddb/errs"
+	"github.com/ejfhp/trail"
+	"github.com/ejfhp/trail/trace"
+)
+
+//ArchiveVersion is the version of the archive format written by Write.
+const ArchiveVersion = 1
+
+//Archive bundles the history of some addresses, the TXs and the merkle proofs of the mined ones.
+//Imported into a cache it allows to read the entries with cacheOnly, without any explorer.
+type Archive struct {
+	Version   int                 `json:"version"`
+	Created   int64               `json:"created"`
+	Addresses map[string][]string `json:"addresses"`
+	TXs       []*ArchivedTX       `json:"txs"`
+}
+
+//ArchivedTX is a raw TX, with block and merkle proof if mined.
+type ArchivedTX struct {
+	TXID        string          `json:"txid"`
+	Hex         string          `json:"hex"`
+	BlockHash   string          `json:"blockhash,omitempty"`
+	BlockHeight int             `json:"blockheight,omitempty"`
+	MerkleProof json.RawMessage `json:"merkleproof,omitempty"`
+}
+
+//ReadArchive reads an archive written by Write.
+func ReadArchive(file string) (*Archive, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open archive %s: %w", file, err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read archive %s: %w", file, err)
+	}
+	defer gz.Close()
+	archive := Archive{}
+	err = json.NewDecoder(gz).Decode(&archive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode archive %s: %w", file, err)
+	}
+	if archive.Version > ArchiveVersion {
+		return nil, fmt.Errorf("archive %s has version %d, newer than the supported %d", file, archive.Version, ArchiveVersion)
+	}
+	return &archive, nil
+}
+
+//Write writes the archive to file as gzipped JSON.
+func (a *Archive) Write(file string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create archive %s: %w", file, err)
+	}
+	defer f.Close()
+	gz := gzip.NewWriter(f)
+	err = json.NewEncoder(gz).Encode(a)
+	if err != nil {
+		return fmt.Errorf("failed to encode archive %s: %w", file, err)
+	}
+	err = gz.Close()
+	if err != nil {
+		return fmt.Errorf("failed to write archive %s: %w", file, err)
+	}
+	return nil
+}
+
+//ExportArchive builds the archive of all the TXs of the addresses, with the merkle proofs of the mined ones.
+func (b *Blockchain) ExportArchive(addresses []string) (*Archive, error) {
+	tr := trace.New().Source("archive.go", "Blockchain", "ExportArchive")
+	archive := Archive{Version: ArchiveVersion, Created: time.Now().Unix(), Addresses: map[string][]string{}, TXs: []*ArchivedTX{}}
+	archived := map[string]bool{}
+	for _, address := range addresses {
+		txids, err := b.ListTXIDs(address, false)
+		if err != nil {
+			return nil, fmt.Errorf("cannot list TXs of address %s: %w", address, err)
+		}
+		archive.Addresses[address] = txids
+		for _, id := range txids {
+			if archived[id] {
+				continue
+			}
+			tx, err := b.GetTX(id, false)
+			if err != nil {
+				return nil, fmt.Errorf("cannot get TX %s: %w", id, err)
+			}
+			atx := ArchivedTX{TXID: id, Hex: tx.ToString()}
+			proof, err := b.GetTXProof(id)
+			if err != nil {
+				trail.Println(trace.Info("TX archived without proof").UTC().Add("id", id).Error(err).Append(tr))
+			} else {
+				atx.BlockHash = proof.BlockHash
+				atx.BlockHeight = proof.BlockHeight
+				atx.MerkleProof = proof.MerkleProof
+			}
+			archive.TXs = append(archive.TXs, &atx)
+			archived[id] = true
+		}
+	}
+	return &archive, nil
+}
+
+//ImportArchive stores in the cache the TXs, proofs and address histories of the archive.
+//TXs whose ID doesn't match their content, or whose merkle proof is wrong, are refused.
+//A TX is imported as mined only if its proof leads to a merkle root or block header, a block hash cannot be verified offline.
+func (b *Blockchain) ImportArchive(archive *Archive) error {
+	tr := trace.New().Source("archive.go", "Blockchain", "ImportArchive")
+	if b.Cache == nil {
+		return fmt.Errorf("no cache to import the archive into")
+	}
+	for _, atx := range archive.TXs {
+		tx, err := DataTXFromHex(atx.Hex)
+		if err != nil || tx.GetTxID() != atx.TXID {
+			trail.Println(trace.Alert("archived TX is corrupted").UTC().Add("id", atx.TXID).Error(err).Append(tr))
+			return fmt.Errorf("archived TX %s is corrupted", atx.TXID)
+		}
+		verified := false
+		if len(atx.MerkleProof) > 0 {
+			verified, err = verifyProof(atx.TXID, atx.BlockHash, atx.MerkleProof)
+			if err != nil {
+				trail.Println(trace.Alert("archived merkle proof is wrong").UTC().Add("id", atx.TXID).Error(err).Append(tr))
+				return fmt.Errorf("merkle proof of archived TX %s is wrong: %w", atx.TXID, err)
+			}
+		}
+		err = b.Cache.StoreTX(atx.TXID, tx.ToBytes())
+		if err != nil {
+			return fmt.Errorf("cannot store TX %s in cache: %w", atx.TXID, err)
+		}
+		if atx.BlockHeight <= 0 {
+			continue
+		}
+		if !verified {
+			trail.Println(trace.Warning("TX imported as unconfirmed, its proof cannot be verified").UTC().Add("id", atx.TXID).Append(tr))
+			continue
+		}
+		err = b.Cache.SetTXHeight(atx.TXID, atx.BlockHeight)
+		if err != nil {
+			return fmt.Errorf("cannot store height of TX %s in cache: %w", atx.TXID, err)
+		}
+		status := TXStatus{TXID: atx.TXID, Status: TXStatusMined, BlockHash: atx.BlockHash, BlockHeight: atx.BlockHeight, MerkleProof: atx.MerkleProof, Updated: time.Now().Unix()}
+		err = b.Cache.StoreTXStatus(&status)
+		if err != nil {
+			return fmt.Errorf("cannot store status of TX %s in cache: %w", atx.TXID, err)
+		}
+	}
+	for address, txids := range archive.Addresses {
+		err := b.Cache.StoreTXIDs(address, txids)
+		if err != nil {
+			return fmt.Errorf("cannot store TXs of address %s in cache: %w", address, err)
+		}
+	}
+	return nil
+}
+
+//GetTXProof returns the block and the merkle proof of the TX, from the cache or from the explorer.
+//The proof is empty if the explorer cannot provide it, errs.ErrNotFound is returned if the TX is not mined.
+func (b *Blockchain) GetTXProof(txid string) (*TXStatus, error) {
+	tr := trace.New().Source("archive.go", "Blockchain", "GetTXProof")
+	if b.Cache != nil {
+		status, err := b.Cache.RetrieveTXStatus(txid)
+		if err == nil && status.Status == TXStatusMined && len(status.MerkleProof) > 0 {
+			return status, nil
+		}
+	}
+	if b.explorer == nil {
+		return nil, fmt.Errorf("no explorer to get proof of TX %s", txid)
+	}
+	tx, err := b.explorer.GetTX(txid)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get TX %s: %w", txid, err)
+	}
+	if tx.Confirmations <= 0 {
+		return nil, fmt.Errorf("TX %s is not mined: %w", txid, errs.ErrNotFound)
+	}
+	status := TXStatus{TXID: txid, Status: TXStatusMined, BlockHash: tx.BlockHash, BlockHeight: tx.BlockHeight, Updated: time.Now().Unix()}
+	if pexplorer, ok := b.explorer.(ProofExplorer); ok {
+		status.MerkleProof, err = pexplorer.GetMerkleProof(txid)
+		if err != nil && !errors.Is(err, errs.ErrNotFound) {
+			return nil, fmt.Errorf("cannot get merkle proof of TX %s: %w", txid, err)
+		}
+	}
+	if b.Cache != nil {
+		err = b.Cache.StoreTXStatus(&status)
+		if err != nil {
+			trail.Println(trace.Warning("cannot store TX proof in cache").UTC().Add("id", txid).Error(err).Append(tr))
+		}
+	}
+	return &status, nil
+}
+
+//tscProof is a merkle proof in TSC format, target is a block hash unless targetType is header or merkleRoot.
+type tscProof struct {
+	Index      int      `json:"index"`
+	TXOrID     string   `json:"txOrId"`
+	TargetType string   `json:"targetType"`
+	Target     string   `json:"target"`
+	Nodes      []string `json:"nodes"`
+}
+
+//verifyProof returns an error if the proof, in TSC format, is about another TX or doesn't lead to its target.
+//txOrId can be the TXID or the full TX in hex. Returns false if the target is a block hash, that cannot be verified without the block header.
+//The header, if it is the target, must have the given block hash, when not empty.
+func verifyProof(txid string, blockHash string, proof json.RawMessage) (bool, error) {
+	tsc := tscProof{}
+	err := json.Unmarshal(proof, &tsc)
+	if err != nil {
+		return false, fmt.Errorf("cannot decode proof: %w", err)
+	}
+	if tsc.TXOrID == "" {
+		return false, fmt.Errorf("proof without txOrId")
+	}
+	if len(tsc.TXOrID) != len(txid) {
+		tx, err := DataTXFromHex(tsc.TXOrID)
+		if err != nil {
+			return false, fmt.Errorf("cannot decode txOrId of proof: %w", err)
+		}
+		tsc.TXOrID = tx.GetTxID()
+	}
+	if tsc.TXOrID != txid {
+		return false, fmt.Errorf("proof is for TX %s", tsc.TXOrID)
+	}
+	var root []byte
+	switch tsc.TargetType {
+	case "", "hash":
+		return false, nil
+	case "merkleRoot":
+		root, err = hexHash(tsc.Target)
+		if err != nil {
+			return false, fmt.Errorf("invalid merkle root: %w", err)
+		}
+	case "header":
+		header, err := hex.DecodeString(tsc.Target)
+		if err != nil || len(header) != 80 {
+			return false, fmt.Errorf("invalid block header")
+		}
+		if blockHash != "" && hashHex(doubleSHA256(header)) != blockHash {
+			return false, fmt.Errorf("block header is not of block %s", blockHash)
+		}
+		//Merkle root is in the header in internal byte order
+		root = header[36:68]
+	default:
+		return false, fmt.Errorf("unknown target type %s", tsc.TargetType)
+	}
+	hash, err := hexHash(txid)
+	if err != nil {
+		return false, fmt.Errorf("invalid TXID: %w", err)
+	}
+	index := tsc.Index
+	for _, node := range tsc.Nodes {
+		sibling := hash
+		if node != "*" {
+			sibling, err = hexHash(node)
+			if err != nil {
+				return false, fmt.Errorf("invalid proof node: %w", err)
+			}
+		}
+		if index%2 == 0 {
+			hash = doubleSHA256(append(append([]byte{}, hash...), sibling...))
+		} else {
+			hash = doubleSHA256(append(append([]byte{}, sibling...), hash...))
+		}
+		index /= 2
+	}
+	if !bytes.Equal(hash, root) {
+		return false, fmt.Errorf("proof leads to merkle root %s instead of %s", hashHex(hash), hashHex(root))
+	}
+	return true, nil
+}
+
+//hexHash decodes a hash displayed in hex, like TXIDs, into internal byte order.
+func hexHash(h string) ([]byte, error) {
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 32 {
+		return nil, fmt.Errorf("hash %s is not 32 bytes", h)
+	}
+	return reversed(b), nil
+}
+
+//hashHex encodes a hash in internal byte order as displayed in hex.
+func hashHex(b []byte) string {
+	return hex.EncodeToString(reversed(b))
+}
+
+func reversed(b []byte) []byte {
+	r := make([]byte, len(b))
+	for i := range b {
+		r[len(b)-1-i] = b[i]
+	}
+	return r
+}
+
+func doubleSHA256(b []byte) []byte {
+	first := sha256.Sum256(b)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
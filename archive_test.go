@@ -0,0 +1,182 @@
+package ddb_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/errs"
+	"github.com/ejfhp/ddb/keys"
+	"github.com/ejfhp/ddb/satoshi"
+	"github.com/libsv/go-bt/bscript"
+)
+
+func TestArchive_ExportImport(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "archive_trh")
+	defer os.RemoveAll(dir)
+	address := "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	mined, unconfirmed := Helper_FakeTX(t), Helper_FakeTX(t)
+	explorer := &fakeProofExplorer{&fakeHistoryExplorer{fakeExplorer: newFakeExplorer(), heights: map[string]int{mined.GetTxID(): 715000}}}
+	explorer.txs[mined.GetTxID()] = mined
+	explorer.txs[unconfirmed.GetTxID()] = unconfirmed
+	explorer.history[address] = []string{mined.GetTxID(), unconfirmed.GetTxID()}
+	source, err := ddb.NewTXCache(filepath.Join(dir, "source"))
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	blockchain := ddb.NewBlockchain(&fakeMiner{}, explorer, source)
+	archive, err := blockchain.ExportArchive([]string{address})
+	if err != nil {
+		t.Logf("failed to export: %v", err)
+		t.FailNow()
+	}
+	if len(archive.TXs) != 2 || len(archive.Addresses[address]) != 2 {
+		t.Logf("unexpected archive: %d TXs, %v", len(archive.TXs), archive.Addresses)
+		t.FailNow()
+	}
+	file := filepath.Join(dir, "archive.trh.gz")
+	err = archive.Write(file)
+	if err != nil {
+		t.Logf("failed to write archive: %v", err)
+		t.FailNow()
+	}
+	read, err := ddb.ReadArchive(file)
+	if err != nil {
+		t.Logf("failed to read archive: %v", err)
+		t.FailNow()
+	}
+	dest, err := ddb.NewTXCache(filepath.Join(dir, "dest"))
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	offline := ddb.NewBlockchain(&fakeMiner{}, nil, dest)
+	err = offline.ImportArchive(read)
+	if err != nil {
+		t.Logf("failed to import: %v", err)
+		t.FailNow()
+	}
+	txids, err := offline.ListTXIDs(address, true)
+	if err != nil || len(txids) != 2 {
+		t.Logf("unexpected txids from cache: %v %v", txids, err)
+		t.FailNow()
+	}
+	tx, err := offline.GetTX(unconfirmed.GetTxID(), true)
+	if err != nil || tx.GetTxID() != unconfirmed.GetTxID() {
+		t.Logf("failed to get tx from cache: %v", err)
+		t.FailNow()
+	}
+	proof, err := offline.GetTXProof(mined.GetTxID())
+	if err != nil || proof.BlockHeight != 715000 || len(proof.MerkleProof) == 0 {
+		t.Logf("unexpected proof from cache: %v %v", proof, err)
+		t.FailNow()
+	}
+	read.TXs[0].MerkleProof = fakeProof(mined.ToString(), mined.GetTxID())
+	err = offline.ImportArchive(read)
+	if err != nil {
+		t.Logf("proof with the full TX as txOrId should be accepted: %v", err)
+		t.FailNow()
+	}
+	for _, txOrID := range []string{unconfirmed.ToString(), "0123", ""} {
+		read.TXs[0].MerkleProof = fakeProof(txOrID, mined.GetTxID())
+		err = offline.ImportArchive(read)
+		if err == nil {
+			t.Logf("proof with txOrId '%s' should be refused", txOrID)
+			t.FailNow()
+		}
+	}
+	read.TXs[0].MerkleProof = fakeProof(mined.GetTxID(), unconfirmed.GetTxID())
+	err = offline.ImportArchive(read)
+	if err == nil {
+		t.Logf("proof leading to another merkle root should be refused")
+		t.FailNow()
+	}
+	read.TXs[0].MerkleProof = json.RawMessage(fmt.Sprintf(`{"index":1,"txOrId":"%s","target":"block715000","nodes":["*"]}`, mined.GetTxID()))
+	unverified, err := ddb.NewTXCache(filepath.Join(dir, "unverified"))
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	err = ddb.NewBlockchain(&fakeMiner{}, nil, unverified).ImportArchive(read)
+	if err != nil {
+		t.Logf("proof with a block hash target should be accepted: %v", err)
+		t.FailNow()
+	}
+	_, err = unverified.RetrieveTXStatus(mined.GetTxID())
+	if !errors.Is(err, errs.ErrNotCached) {
+		t.Logf("TX with an unverified proof should not be mined: %v", err)
+		t.FailNow()
+	}
+	read.TXs[0].TXID = unconfirmed.GetTxID()
+	err = offline.ImportArchive(read)
+	if err == nil {
+		t.Logf("archive with wrong TXID should be refused")
+		t.FailNow()
+	}
+}
+
+func TestArchive_GetEntryCacheOnly(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	dir := filepath.Join(os.TempDir(), "archive_entry_trh")
+	defer os.RemoveAll(dir)
+	keystore, err := keys.NewKeystore(destinationKey, "mainpassword")
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	entry := ddb.NewEntryFromData("archived.txt", "text/plain", []byte(strings.Repeat("TRH - The Rabbit Hole, read from the archive. ", 30)), []string{"archive"}, "notes")
+	node, err := keystore.NewNode(entry.Name, entry.HashOfEntry())
+	if err != nil {
+		t.Logf("failed to generate node: %v", err)
+		t.FailNow()
+	}
+	explorer := &fakeProofExplorer{&fakeHistoryExplorer{fakeExplorer: newFakeExplorer(), heights: map[string]int{}}}
+	utxos := fakeUTXOs(100000)
+	script, _ := bscript.NewP2PKHFromAddress(destinationAddress)
+	utxos[0].ScriptPubKeyHex = script.ToString()
+	explorer.utxos[destinationAddress] = utxos
+	blockchain := ddb.NewBlockchain(&fakeMiner{maxOpReturn: 300}, explorer, nil)
+	btrunk := ddb.NewBTrunk(destinationKey, destinationAddress, keystore.Source().Password(), blockchain)
+	txs, err := btrunk.TXOfBranchedEntry(node, entry, "test01234", satoshi.Satoshi(100000), false)
+	if err != nil {
+		t.Logf("failed to build entry TXs: %v", err)
+		t.FailNow()
+	}
+	for i, tx := range txs {
+		explorer.txs[tx.GetTxID()] = tx
+		explorer.heights[tx.GetTxID()] = 715000 + i
+		explorer.history[node.Address()] = append(explorer.history[node.Address()], tx.GetTxID())
+	}
+	archive, err := blockchain.ExportArchive([]string{node.Address()})
+	if err != nil {
+		t.Logf("failed to export: %v", err)
+		t.FailNow()
+	}
+	dest, err := ddb.NewTXCache(filepath.Join(dir, "dest"))
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	offline := ddb.NewBlockchain(&fakeMiner{}, nil, dest)
+	err = offline.ImportArchive(archive)
+	if err != nil {
+		t.Logf("failed to import: %v", err)
+		t.FailNow()
+	}
+	restored, err := ddb.NewBTrunk(destinationKey, destinationAddress, keystore.Source().Password(), offline).GetEntry(node, true)
+	if err != nil {
+		t.Logf("failed to get entry from the imported archive: %v", err)
+		t.FailNow()
+	}
+	if restored.Name != entry.Name || !bytes.Equal(restored.Data, entry.Data) {
+		t.Logf("entry read from the archive differs: %s", restored.Name)
+		t.FailNow()
+	}
+}
@@ -1,67 +1,130 @@
 package ddb
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
+	"github.com/ejfhp/ddb/errs"
 	"github.com/ejfhp/ddb/miner"
 	"github.com/ejfhp/ddb/satoshi"
 	"github.com/ejfhp/trail"
 	"github.com/ejfhp/trail/trace"
+	"github.com/libsv/go-bt/bscript"
 )
 
 type Blockchain struct {
-	miner    miner.Miner
-	explorer Explorer
-	Cache    *TXCache
+	miner      miner.Miner
+	explorer   Explorer
+	Cache      Cache
+	feesCached bool
+	historyTTL time.Duration
 }
 
 //NewBlockchain builds a new Blockchain. This is the access point to write and read from a blockchain.
-func NewBlockchain(miner miner.Miner, explorer Explorer, cache *TXCache) *Blockchain {
+func NewBlockchain(miner miner.Miner, explorer Explorer, cache Cache) *Blockchain {
 	return &Blockchain{miner: miner, explorer: explorer, Cache: cache}
 }
 
+//SetHistoryTTL makes ListTXIDs use the cached history of an address, without asking the explorer, if synced less than ttl ago.
+//With ttl 0 the explorer is always asked.
+func (b *Blockchain) SetHistoryTTL(ttl time.Duration) {
+	b.historyTTL = ttl
+}
+
 //CacheDir returns the cache folder path
 func (b *Blockchain) CacheDir() string {
 	if b.Cache == nil {
 		return ""
 	}
-	return b.Cache.path
+	return b.Cache.DirPath()
 }
 
+//EstimateDataTXFee returns the fee of a TX with numUTXO inputs and the given data, splitting standard and data bytes as the feespec requires.
 func (b *Blockchain) EstimateDataTXFee(numUTXO int, data []byte, header string) (satoshi.Satoshi, error) {
-	tr := trace.New().Source("blockchain.go", "Blockchain", "Submit")
+	tr := trace.New().Source("blockchain.go", "Blockchain", "EstimateDataTXFee")
 	key, add, utxos := fakeKeyAddUTXO(numUTXO)
 	dataTX, err := NewDataTX(key, add, add, utxos, satoshi.Satoshi(100), satoshi.Satoshi(1), data, header)
 	if err != nil {
 		trail.Println(trace.Alert("cannot build fake DataTX").UTC().Append(tr).Error(err))
-		return 0, fmt.Errorf("cannot submit TX to miner: %w", err)
-	}
-	fee, err := b.miner.GetDataFee()
-	if err != nil {
-		trail.Println(trace.Alert("cannot get Fee").UTC().Append(tr).Error(err))
-		return 0, fmt.Errorf("cannot get Fee: %w", err)
+		return 0, fmt.Errorf("cannot build fake DataTX: %w", err)
 	}
-	return fee.CalculateFee(len(dataTX.ToBytes())), nil
+	return b.EstimateFee(dataTX)
 }
 
+//EstimateStandardTXFee returns the fee of a TX with numUTXO inputs and no data.
 func (b *Blockchain) EstimateStandardTXFee(numUTXO int) (satoshi.Satoshi, error) {
-	tr := trace.New().Source("blockchain.go", "Blockchain", "Submit")
+	tr := trace.New().Source("blockchain.go", "Blockchain", "EstimateStandardTXFee")
 	key, add, utxos := fakeKeyAddUTXO(numUTXO)
 	noDataTX, err := NewDataTX(key, add, add, utxos, satoshi.Satoshi(100), satoshi.Satoshi(1), nil, "")
 	if err != nil {
 		trail.Println(trace.Alert("cannot build fake DataTX").UTC().Append(tr).Error(err))
-		return 0, fmt.Errorf("cannot submit TX to miner: %w", err)
+		return 0, fmt.Errorf("cannot build fake DataTX: %w", err)
+	}
+	return b.EstimateFee(noDataTX)
+}
+
+//EstimateFanOutTXFee returns the fee of a TX with numUTXO inputs, numOutputs outputs and change.
+func (b *Blockchain) EstimateFanOutTXFee(numUTXO int, numOutputs int) (satoshi.Satoshi, error) {
+	tr := trace.New().Source("blockchain.go", "Blockchain", "EstimateFanOutTXFee")
+	key, add, utxos := fakeKeyAddUTXO(numUTXO)
+	amounts := make([]satoshi.Satoshi, numOutputs)
+	for i := range amounts {
+		amounts[i] = satoshi.Satoshi(100)
 	}
-	fee, err := b.miner.GetStandardFee()
+	fanOutTX, err := NewFanOutTX(key, add, add, utxos, amounts, satoshi.Satoshi(1))
 	if err != nil {
-		trail.Println(trace.Alert("cannot get Fee").UTC().Append(tr).Error(err))
-		return 0, fmt.Errorf("cannot get Fee: %w", err)
+		trail.Println(trace.Alert("cannot build fake fan-out DataTX").UTC().Append(tr).Error(err))
+		return 0, fmt.Errorf("cannot build fake fan-out DataTX: %w", err)
 	}
-	return fee.CalculateFee(len(noDataTX.ToBytes())), nil
+	return b.EstimateFee(fanOutTX)
 }
 
-//Submit submits all the transactions to the miner to be included in the blockchain, returns the TX IDs with result
-func (b *Blockchain) Submit(txs []*DataTX) ([][]string, error) {
+//EstimateBatchTXFee estimates the fee of a batch TX with the given outputs, spending numUTXO inputs.
+func (b *Blockchain) EstimateBatchTXFee(numUTXO int, outputs []*BatchOutput, header string) (satoshi.Satoshi, error) {
+	tr := trace.New().Source("blockchain.go", "Blockchain", "EstimateBatchTXFee")
+	key, add, utxos := fakeKeyAddUTXO(numUTXO)
+	batchTX, err := NewBatchDataTX(key, add, utxos, outputs, satoshi.Satoshi(1), header)
+	if err != nil {
+		trail.Println(trace.Alert("cannot build fake batch TX").UTC().Error(err).Append(tr))
+		return 0, fmt.Errorf("cannot build fake batch TX: %w", err)
+	}
+	return b.EstimateFee(batchTX)
+}
+
+//EstimateFee returns the fee required by the miner for the given TX, fee policy of the miner included.
+func (b *Blockchain) EstimateFee(tx *DataTX) (satoshi.Satoshi, error) {
+	tr := trace.New().Source("blockchain.go", "Blockchain", "EstimateFee")
+	fees, err := b.miner.GetFees()
+	if err != nil {
+		trail.Println(trace.Alert("cannot get Fees").UTC().Append(tr).Error(err))
+		return 0, fmt.Errorf("cannot get Fees: %w", err)
+	}
+	if b.Cache != nil && !b.feesCached && b.miner.GetName() != "offline" {
+		//Last quote is kept for offline estimations
+		err = b.Cache.StoreFees(fees)
+		if err != nil {
+			trail.Println(trace.Warning("cannot cache Fees").UTC().Append(tr).Error(err))
+		}
+		b.feesCached = true
+	}
+	standardBytes, dataBytes := tx.Sizes()
+	fee, err := fees.CalculateFee(standardBytes, dataBytes)
+	if err != nil {
+		trail.Println(trace.Alert("cannot calculate Fee").UTC().Append(tr).Error(err))
+		return 0, fmt.Errorf("cannot calculate Fee: %w", err)
+	}
+	return b.miner.GetFeePolicy().Apply(fee), nil
+}
+
+//Fees returns the fee schedule of the miner.
+func (b *Blockchain) Fees() (miner.Fees, error) {
+	return b.miner.GetFees()
+}
+
+//Submit submits all the transactions to the miner to be included in the blockchain, returns the result of each TX.
+//TXs accepted are stored in cache, if any TX is refused the results are returned together with a *miner.SubmitError.
+func (b *Blockchain) Submit(txs []*DataTX) ([]*miner.SubmitResult, error) {
 	tr := trace.New().Source("blockchain.go", "Blockchain", "Submit")
 	txsdata := make([]string, len(txs))
 	for i, tx := range txs {
@@ -76,21 +139,54 @@ func (b *Blockchain) Submit(txs []*DataTX) ([][]string, error) {
 		return nil, fmt.Errorf("something weird happened, miner response miss some transactions")
 	}
 	for i, tx := range txs {
-		result := restxs[i][1]
-		if result != miner.ResponseSuccess {
+		if !restxs[i].Success() {
+			trail.Println(trace.Alert("TX refused by miner").UTC().Add("TXID", tx.GetTxID()).Add("description", restxs[i].Description).Append(tr))
 			continue
 		}
-		txsdata[i] = tx.ToString()
 		if b.Cache != nil {
 			err = b.Cache.StoreTX(tx.GetTxID(), tx.ToBytes())
 			if err != nil {
 				trail.Println(trace.Alert("error while storing submitted TX in cache").UTC().Add("TXID", tx.GetTxID()).Append(tr))
 			}
+			err = b.Cache.StoreTXStatus(&TXStatus{TXID: tx.GetTxID(), Status: TXStatusSubmitted, Updated: time.Now().Unix()})
+			if err != nil {
+				trail.Println(trace.Alert("error while storing submitted TX status in cache").UTC().Add("TXID", tx.GetTxID()).Append(tr))
+			}
 		}
 	}
+	if failed := miner.Failed(restxs); failed != nil {
+		return restxs, failed
+	}
 	return restxs, nil
 }
 
+//IsConfirmed returns true if the TX has been included in a block.
+//The status recorded by miner callbacks is checked before asking the explorer.
+func (b *Blockchain) IsConfirmed(txid string) (bool, error) {
+	tr := trace.New().Source("blockchain.go", "Blockchain", "IsConfirmed")
+	if b.Cache != nil {
+		status, err := b.Cache.RetrieveTXStatus(txid)
+		if err == nil && status.Status == TXStatusMined {
+			return true, nil
+		}
+	}
+	if b.explorer == nil {
+		return false, fmt.Errorf("no explorer to check confirmation of TX %s", txid)
+	}
+	tx, err := b.explorer.GetTX(txid)
+	if err != nil {
+		trail.Println(trace.Alert("cannot get TX").UTC().Add("id", txid).Error(err).Append(tr))
+		return false, fmt.Errorf("cannot get TX %s: %w", txid, err)
+	}
+	if tx.Confirmations > 0 && b.Cache != nil {
+		err = b.Cache.SetTXHeight(txid, tx.BlockHeight)
+		if err != nil {
+			trail.Println(trace.Warning("cannot record TX height in cache").UTC().Add("id", txid).Error(err).Append(tr))
+		}
+	}
+	return tx.Confirmations > 0, nil
+}
+
 func (b *Blockchain) MaxDataSize() int {
 	//9 is header size and must never be changed
 	avai := b.miner.MaxOpReturn() - 9
@@ -109,11 +205,56 @@ func (b *Blockchain) GetUTXO(address string) ([]*UTXO, error) {
 	}
 	if len(utxos) < 1 {
 		trail.Println(trace.Alert("found no UTXO").UTC().Add("address", address).Append(tr))
-		return nil, fmt.Errorf("found no UTXO")
+		return nil, fmt.Errorf("%w for address %s", errs.ErrNoUTXO, address)
 	}
 	return utxos, nil
 }
 
+//CheckUnspent verifies that the inputs of the given TXs, not spending outputs of the TXs themselves, are still unspent.
+func (b *Blockchain) CheckUnspent(txs []*DataTX) error {
+	tr := trace.New().Source("blockchain.go", "Blockchain", "CheckUnspent")
+	inSet := make(map[string]bool, len(txs))
+	for _, tx := range txs {
+		inSet[tx.GetTxID()] = true
+	}
+	unspent := make(map[string]map[string]bool)
+	for _, tx := range txs {
+		for _, in := range tx.Inputs {
+			if inSet[in.PreviousTxID] {
+				continue
+			}
+			if in.PreviousTxScript == nil {
+				return fmt.Errorf("unknown locking script of input %s:%d of TX %s", in.PreviousTxID, in.PreviousTxOutIndex, tx.GetTxID())
+			}
+			hash, err := in.PreviousTxScript.GetPublicKeyHash()
+			if err != nil {
+				return fmt.Errorf("input %s:%d of TX %s is not P2PKH: %w", in.PreviousTxID, in.PreviousTxOutIndex, tx.GetTxID(), err)
+			}
+			address, err := bscript.NewAddressFromPublicKeyHash(hash, true)
+			if err != nil {
+				return fmt.Errorf("cannot get address of input %s:%d: %w", in.PreviousTxID, in.PreviousTxOutIndex, err)
+			}
+			outpoints, ok := unspent[address.AddressString]
+			if !ok {
+				outpoints = make(map[string]bool)
+				utxos, err := b.GetUTXO(address.AddressString)
+				if err != nil && !errors.Is(err, errs.ErrNoUTXO) {
+					return fmt.Errorf("cannot get UTXO of address %s: %w", address.AddressString, err)
+				}
+				for _, u := range utxos {
+					outpoints[fmt.Sprintf("%s:%d", u.TXHash, u.TXPos)] = true
+				}
+				unspent[address.AddressString] = outpoints
+			}
+			if !outpoints[fmt.Sprintf("%s:%d", in.PreviousTxID, in.PreviousTxOutIndex)] {
+				trail.Println(trace.Alert("input already spent").UTC().Add("txid", tx.GetTxID()).Add("input", in.PreviousTxID).Append(tr))
+				return fmt.Errorf("input %s:%d of TX %s is already spent", in.PreviousTxID, in.PreviousTxOutIndex, tx.GetTxID())
+			}
+		}
+	}
+	return nil
+}
+
 func (b *Blockchain) GetTX(id string, cacheOnly bool) (*DataTX, error) {
 	tr := trace.New().Source("blockchain.go", "Blockchain", "GetTX")
 	trail.Println(trace.Debug("get TX").UTC().Add("cacheOnly", fmt.Sprintf("%t", cacheOnly)).Append(tr))
@@ -121,7 +262,7 @@ func (b *Blockchain) GetTX(id string, cacheOnly bool) (*DataTX, error) {
 	if b.Cache != nil {
 		cacheTx, err := b.Cache.RetrieveTX(id)
 		if err != nil {
-			if err != ErrNotCached {
+			if !errors.Is(err, ErrNotCached) {
 				trail.Println(trace.Alert("cannot get TX from cache").UTC().Add("id", id).Error(err).Append(tr))
 				return nil, fmt.Errorf("cannot get TX with id %s from cache: %w", id, err)
 			}
@@ -137,7 +278,7 @@ func (b *Blockchain) GetTX(id string, cacheOnly bool) (*DataTX, error) {
 	}
 	if cacheOnly {
 		trail.Println(trace.Alert("TX not in cache").UTC().Add("id", id).Append(tr))
-		return nil, fmt.Errorf("TX not in cache")
+		return nil, fmt.Errorf("TX %s: %w", id, ErrNotCached)
 	}
 	hex, err := b.explorer.GetRAWTXHEX(id)
 	if err != nil {
@@ -173,29 +314,71 @@ func (b *Blockchain) GetTXs(ids []string, cacheOnly bool) ([]*DataTX, error) {
 	return txs, nil
 }
 
+//ListTXIDs returns the history of the address. With cacheOnly only the cached history is returned,
+//otherwise the cached one if fresh (see SetHistoryTTL) or the one synced with the explorer.
 func (b *Blockchain) ListTXIDs(address string, cacheOnly bool) ([]string, error) {
 	tr := trace.New().Source("blockchain.go", "Blockchain", "ListTXIDs")
 	trail.Println(trace.Debug("listing TXIDs").UTC().Add("address", address).Append(tr))
-	txids := []string{}
-	if b.explorer != nil && !cacheOnly {
-		ids, err := b.explorer.GetTXIDs(address)
+	var cached *AddressInfo
+	if b.Cache != nil {
+		info, err := b.Cache.GetAddressInfo(address)
+		if err != nil && !errors.Is(err, ErrNotCached) {
+			trail.Println(trace.Alert("error while getting TXIDs from cache").UTC().Add("address", address).Error(err).Append(tr))
+			return nil, fmt.Errorf("error while getting TXIDs from cache: %w", err)
+		}
+		cached = info
+	}
+	if b.explorer == nil || cacheOnly || cached.Fresh(b.historyTTL) {
+		if cached == nil {
+			return []string{}, nil
+		}
+		return cached.TXIDs, nil
+	}
+	synced, err := b.syncHistory(address, cached)
+	if err != nil {
+		trail.Println(trace.Alert("error while getting TXIDs from explorer").UTC().Add("address", address).Error(err).Append(tr))
+		return nil, fmt.Errorf("error while getting TXIDs from explorer: %w", err)
+	}
+	return synced.TXIDs, nil
+}
+
+//syncHistory merges into the cached history the one returned by the explorer.
+//If the explorer supports it, only the TXs mined after the last known height are asked.
+func (b *Blockchain) syncHistory(address string, cached *AddressInfo) (*AddressInfo, error) {
+	tr := trace.New().Source("blockchain.go", "Blockchain", "syncHistory")
+	synced := AddressInfo{Address: address, TXIDs: []string{}}
+	if cached != nil {
+		synced.TXIDs = append(synced.TXIDs, cached.TXIDs...)
+		synced.Height = cached.Height
+	}
+	if hexplorer, ok := b.explorer.(HistoryExplorer); ok {
+		history, err := hexplorer.GetHistory(address, synced.Height)
 		if err != nil {
-			trail.Println(trace.Alert("error while getting TXIDs from explorer").UTC().Add("address", address).Error(err).Append(tr))
-			return nil, fmt.Errorf("error while getting TXIDs from explorer: %w", err)
+			return nil, err
 		}
-		if b.Cache != nil {
-			b.Cache.StoreTXIDs(address, ids)
+		ids := make([]string, len(history))
+		for i, h := range history {
+			ids[i] = h.TXID
+			if h.Height > synced.Height {
+				synced.Height = h.Height
+			}
 		}
-		txids = append(txids, ids...)
-	} else if b.Cache != nil {
-		ids, err := b.Cache.GetTXIDs(address)
-		if err != nil && err != ErrNotCached {
-			trail.Println(trace.Alert("error while getting TXIDs from cache").UTC().Add("address", address).Error(err).Append(tr))
-			return nil, fmt.Errorf("error while getting TXIDs from cache: %w", err)
+		synced.TXIDs = mergeTXIDs(synced.TXIDs, ids)
+	} else {
+		ids, err := b.explorer.GetTXIDs(address)
+		if err != nil {
+			return nil, err
+		}
+		synced.TXIDs = mergeTXIDs(synced.TXIDs, ids)
+	}
+	synced.Synced = time.Now().Unix()
+	if b.Cache != nil {
+		err := b.Cache.StoreAddressInfo(&synced)
+		if err != nil {
+			trail.Println(trace.Warning("cannot cache address history").UTC().Add("address", address).Error(err).Append(tr))
 		}
-		txids = append(txids, ids...)
 	}
-	return txids, nil
+	return &synced, nil
 }
 
 //Data returns data inside OP_RETURN and version of TX
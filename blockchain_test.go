@@ -1,12 +1,16 @@
 package ddb_test
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/ejfhp/ddb"
 	"github.com/ejfhp/ddb/miner"
+	"github.com/ejfhp/ddb/satoshi"
+	"github.com/libsv/go-bt/bscript"
 )
 
 func TestBlockchain_EstimateDataTXFee(t *testing.T) {
@@ -47,6 +51,37 @@ func TestBlockchain_EstimateStandardTXFee(t *testing.T) {
 	}
 }
 
+func TestBlockchain_EstimateFeePolicy(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	fake := &fakeMiner{}
+	blk := ddb.NewBlockchain(fake, newFakeExplorer(), nil)
+	quoted, err := blk.EstimateStandardTXFee(1)
+	if err != nil {
+		t.Logf("cannot estimate fee: %v", err)
+		t.FailNow()
+	}
+	fake.SetFeePolicy(miner.FeePolicy{Multiplier: 2})
+	fee, err := blk.EstimateStandardTXFee(1)
+	if err != nil {
+		t.Logf("cannot estimate fee: %v", err)
+		t.FailNow()
+	}
+	if fee != quoted*2 {
+		t.Logf("multiplier not applied: %d %d", quoted, fee)
+		t.FailNow()
+	}
+	fake.SetFeePolicy(miner.FeePolicy{Multiplier: 1, MinFee: 1000})
+	fee, err = blk.EstimateStandardTXFee(1)
+	if err != nil {
+		t.Logf("cannot estimate fee: %v", err)
+		t.FailNow()
+	}
+	if fee != 1000 {
+		t.Logf("floor not applied: %d", fee)
+		t.FailNow()
+	}
+}
+
 func TestBlockchain_Submit(t *testing.T) {
 	// trail.SetWriter(os.Stdout)
 	t.SkipNow()
@@ -70,6 +105,42 @@ func TestBlockchain_Submit(t *testing.T) {
 	}
 }
 
+func TestBlockchain_SubmitRefused(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	key, address := "L4ZaBkP1UTyxdEM7wysuPd1scHMLLf8sf8B2tcEcssUZ7ujrYWcQ", "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	utxos := Helper_FakeTX(t).UTXOs()[:1]
+	txs := []*ddb.DataTX{}
+	for i := 0; i < 3; i++ {
+		tx, err := ddb.NewDataTX(key, address, address, utxos, satoshi.EmptyWallet, satoshi.Satoshi(200), []byte("refused"), "123456789")
+		if err != nil {
+			t.Logf("failed to build tx %d: %v", i, err)
+			t.FailNow()
+		}
+		txs = append(txs, tx)
+		utxos = tx.UTXOs()[:1]
+	}
+	mir := &fakeMiner{refuse: map[string]string{txs[1].GetTxID(): "Missing inputs"}}
+	blk := ddb.NewBlockchain(mir, newFakeExplorer(), nil)
+	results, err := blk.Submit(txs)
+	if len(results) != len(txs) {
+		t.Logf("unexpected number of results: %d", len(results))
+		t.FailNow()
+	}
+	var subErr *miner.SubmitError
+	if !errors.As(err, &subErr) {
+		t.Logf("submit should fail with a SubmitError: %v", err)
+		t.FailNow()
+	}
+	if len(subErr.Failures) != 1 || subErr.Failures[0].TXID != txs[1].GetTxID() || subErr.Failures[0].Description != "Missing inputs" {
+		t.Logf("unexpected failures: %v", subErr)
+		t.FailNow()
+	}
+	if !results[0].Success() || results[1].Success() {
+		t.Logf("unexpected results: %v %v", results[0], results[1])
+		t.FailNow()
+	}
+}
+
 func TestBlockchain_ListTXIDs(t *testing.T) {
 	// trail.SetWriter(os.Stdout)
 	destinationAddress := "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
@@ -162,3 +233,99 @@ func TestBlockchain_GetTX_OnlyCache(t *testing.T) {
 // 		t.Logf("%d: %s", i, p)
 // 	}
 // }
+
+func TestBlockchain_CheckUnspent(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	script, _ := bscript.NewP2PKHFromAddress(destinationAddress)
+	utxos := fakeUTXOs(10000)
+	utxos[0].ScriptPubKeyHex = script.ToString()
+	first, err := ddb.NewDataTX(destinationKey, destinationAddress, destinationAddress, utxos, satoshi.Satoshi(5000), satoshi.Satoshi(200), nil, "")
+	if err != nil {
+		t.Logf("failed to build TX: %v", err)
+		t.FailNow()
+	}
+	second, err := ddb.NewDataTX(destinationKey, destinationAddress, destinationAddress, first.UTXOs()[:1], satoshi.EmptyWallet, satoshi.Satoshi(200), nil, "")
+	if err != nil {
+		t.Logf("failed to build TX: %v", err)
+		t.FailNow()
+	}
+	explorer := newFakeExplorer()
+	blk := ddb.NewBlockchain(&fakeMiner{}, explorer, nil)
+	explorer.utxos[destinationAddress] = utxos
+	err = blk.CheckUnspent([]*ddb.DataTX{first, second})
+	if err != nil {
+		t.Logf("inputs should be unspent: %v", err)
+		t.FailNow()
+	}
+	//Without first, the output spent by second is not known to the explorer
+	err = blk.CheckUnspent([]*ddb.DataTX{second})
+	if err == nil {
+		t.Logf("input spending an unknown output should be detected")
+		t.FailNow()
+	}
+	decoded, err := ddb.DataTXFromHex(first.ToString())
+	if err != nil {
+		t.Logf("failed to decode TX: %v", err)
+		t.FailNow()
+	}
+	err = blk.CheckUnspent([]*ddb.DataTX{decoded})
+	if err == nil {
+		t.Logf("input without locking script should be refused")
+		t.FailNow()
+	}
+	explorer.utxos[destinationAddress] = []*ddb.UTXO{}
+	err = blk.CheckUnspent([]*ddb.DataTX{first, second})
+	if err == nil {
+		t.Logf("spent input should be detected")
+		t.FailNow()
+	}
+}
+
+func TestBlockchain_ListTXIDsIncremental(t *testing.T) {
+	cache, err := ddb.NewTXCache(filepath.Join(os.TempDir(), "history_trh"))
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	defer cache.Clear()
+	address := "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	explorer := &fakeHistoryExplorer{fakeExplorer: newFakeExplorer(), heights: map[string]int{"a": 700000, "b": 715000}}
+	explorer.history[address] = []string{"a", "b"}
+	blk := ddb.NewBlockchain(&fakeMiner{}, explorer, cache)
+	txids, err := blk.ListTXIDs(address, false)
+	if err != nil || len(txids) != 2 {
+		t.Logf("unexpected txids: %v %v", txids, err)
+		t.FailNow()
+	}
+	info, err := cache.GetAddressInfo(address)
+	if err != nil {
+		t.Logf("address not cached: %v", err)
+		t.FailNow()
+	}
+	if info.Height != 715000 || info.Synced == 0 {
+		t.Logf("unexpected sync info: %+v", info)
+		t.FailNow()
+	}
+	explorer.history[address] = append(explorer.history[address], "c")
+	txids, err = blk.ListTXIDs(address, false)
+	if err != nil || len(txids) != 3 {
+		t.Logf("unexpected txids after new tx: %v %v", txids, err)
+		t.FailNow()
+	}
+	if explorer.fromHeights[1] != 715000 {
+		t.Logf("history not asked from last known height: %v", explorer.fromHeights)
+		t.FailNow()
+	}
+	blk.SetHistoryTTL(time.Hour)
+	explorer.history[address] = append(explorer.history[address], "d")
+	txids, err = blk.ListTXIDs(address, false)
+	if err != nil || len(txids) != 3 || len(explorer.fromHeights) != 2 {
+		t.Logf("fresh history should come from cache: %v %v", txids, err)
+		t.FailNow()
+	}
+	txids, err = blk.ListTXIDs("1BitcoinEaterAddressDontSendf59kuE", true)
+	if err != nil || len(txids) != 0 {
+		t.Logf("unexpected txids of unknown address: %v %v", txids, err)
+		t.FailNow()
+	}
+}
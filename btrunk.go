@@ -15,11 +15,23 @@ type Results struct {
 	TXIDs []string
 }
 
+const (
+	//LayoutChain chains every part TX through the output of the previous one
+	LayoutChain = "chain"
+	//LayoutFanOut funds every part TX from its own output of a single funding TX
+	LayoutFanOut = "fanout"
+	//LayoutBatch stores many small entries in a single TX, see TXOfBatchedEntries
+	LayoutBatch = "batch"
+)
+
 type BTrunk struct {
 	key        string
 	address    string
 	password   string
-	passBytes  [32]byte
+	trunkKeys  *trunkKeys
+	layout     string
+	selection  *CoinSelection
+	unsigned   bool
 	blockchain *Blockchain
 }
 
@@ -28,72 +40,185 @@ func NewBTrunk(key, address, password string, blockchain *Blockchain) *BTrunk {
 		key:        key,
 		address:    address,
 		password:   password,
-		passBytes:  keys.StringToPassword(password),
+		trunkKeys:  newTrunkKeys(password),
+		layout:     LayoutChain,
+		selection:  &CoinSelection{Strategy: SelectLargestFirst},
 		blockchain: blockchain,
 	}
 	return &btrunk
 }
 
+//SetLayout sets how the part TXs of the entries are funded, LayoutChain or LayoutFanOut.
+func (bt *BTrunk) SetLayout(layout string) error {
+	if layout != LayoutChain && layout != LayoutFanOut {
+		return fmt.Errorf("unknown layout: %s", layout)
+	}
+	bt.layout = layout
+	return nil
+}
+
+//Layout returns the layout used to store the entries.
+func (bt *BTrunk) Layout() string {
+	return bt.layout
+}
+
+//SetUnsigned makes the TXs be built without signing them, they have to be signed by a Signer in the given order.
+func (bt *BTrunk) SetUnsigned(unsigned bool) {
+	bt.unsigned = unsigned
+}
+
+//SetCoinSelection sets how the UTXOs funding the stores are chosen.
+func (bt *BTrunk) SetCoinSelection(selection *CoinSelection) {
+	bt.selection = selection
+}
+
 //TXOfBranchedEntry generate all the transactions needed to store the given entry. BranchKey (WIF) and branchAddress must be generated through BTrunk.GenerateKeyAndAddress().
 func (bt *BTrunk) TXOfBranchedEntry(node *keys.Node, entry *Entry, header string, maxAmountToSpend satoshi.Satoshi, simulate bool) ([]*DataTX, error) {
 	// fBranch, err := bt.newFBranch(node.Key(), node.Address(), node.Password())
-	fBranch := FBranch{BitcoinWIF: node.Key(), BitcoinAdd: node.Address(), Password: node.Password(), Blockchain: bt.blockchain}
+	fBranch := FBranch{BitcoinWIF: node.Key(), BitcoinAdd: node.Address(), Password: node.Password(), Blockchain: bt.blockchain, Unsigned: bt.unsigned}
 	// if err != nil {
 	// 	return nil, fmt.Errorf("error while generating new FBranch: %v", err)
 	// }
 	metaEntry := NewMetaEntry(node, entry)
-	metaEntryData, err := metaEntry.Encrypt(bt.passBytes)
+	metaEntryData, err := bt.trunkKeys.encrypt(metaEntry, header)
 	if err != nil {
-		return nil, fmt.Errorf("error while encrypting metaEntry: %v", err)
+		return nil, fmt.Errorf("error while encrypting metaEntry: %w", err)
 	}
-	utxo, err := bt.getUTXOs(simulate)
+	utxo, err := bt.selectUTXOs(simulate, maxAmountToSpend)
 	if err != nil {
-		return nil, fmt.Errorf("error while getting UTXOs: %v", err)
+		return nil, fmt.Errorf("error while getting UTXOs: %w", err)
 	}
 	//Fee for initial transaction of moving fund to FBranch and casting metaEntry
 	mefee, err := bt.blockchain.EstimateDataTXFee(len(utxo), metaEntryData, header)
 	if err != nil {
-		return nil, fmt.Errorf("error while estimating metaEntry TX fee: %v", err)
+		return nil, fmt.Errorf("error while estimating metaEntry TX fee: %w", err)
 	}
 
 	//Fee to bring back remaining fund to BTrunk address
 	finfee, err := bt.blockchain.EstimateStandardTXFee(1)
 	if err != nil {
-		return nil, fmt.Errorf("error while estimating final TX fee: %v", err)
+		return nil, fmt.Errorf("error while estimating final TX fee: %w", err)
 	}
 
 	allTXs := make([]*DataTX, 0)
 	maxAmountToUse, err := maxAmountToSpend.Sub(mefee)
 	if err != nil {
-		return nil, fmt.Errorf("error while calculating amount to transfer to branched chain: %v", err)
+		return nil, fmt.Errorf("error while calculating amount to transfer to branched chain: %w", err)
 	}
 	//First TX with metaEntry
-	meTX, err := NewDataTX(bt.key, fBranch.BitcoinAdd, bt.address, utxo, maxAmountToUse, mefee, metaEntryData, header)
+	var meTX *DataTX
+	if bt.unsigned {
+		meTX, err = NewUnsignedDataTX(fBranch.BitcoinAdd, bt.address, utxo, maxAmountToUse, mefee, metaEntryData, header)
+	} else {
+		meTX, err = NewDataTX(bt.key, fBranch.BitcoinAdd, bt.address, utxo, maxAmountToUse, mefee, metaEntryData, header)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error while making metaEntry DataTX: %v", err)
+		return nil, fmt.Errorf("error while making metaEntry DataTX: %w", err)
 	}
 	allTXs = append(allTXs, meTX)
 
+	if bt.layout == LayoutFanOut {
+		//Funding TX sends its change back to BTrunk, no final TX needed.
+		entryTXs, err := fBranch.ProcessEntryFanOut(entry, meTX.UTXOs()[:1], header, bt.address)
+		if err != nil {
+			return nil, fmt.Errorf("error while making fan-out entry DataTXs: %w", err)
+		}
+		allTXs = append(allTXs, entryTXs...)
+		return allTXs, nil
+	}
+
 	//Entry TXs, only the first UTXO has to be considered.
 	entryTXs, err := fBranch.ProcessEntry(entry, meTX.UTXOs()[:1], header)
 	if err != nil {
-		return nil, fmt.Errorf("error while making entry DataTXs: %v", err)
+		return nil, fmt.Errorf("error while making entry DataTXs: %w", err)
 	}
 	allTXs = append(allTXs, entryTXs...)
 
 	//Final transaction to move change back to BTrunk wallet
 	lastTX := entryTXs[len(entryTXs)-1]
 	if err != nil {
-		return nil, fmt.Errorf("error getting output from last eintity TX: %v", err)
+		return nil, fmt.Errorf("error getting output from last eintity TX: %w", err)
 	}
-	finTX, err := NewDataTX(fBranch.BitcoinWIF, bt.address, bt.address, lastTX.UTXOs()[:1], satoshi.EmptyWallet, finfee, nil, header)
+	finTX, err := fBranch.newDataTX(bt.address, lastTX.UTXOs()[:1], satoshi.EmptyWallet, finfee, nil, header)
 	if err != nil {
-		return nil, fmt.Errorf("error while making final DataTX: %v", err)
+		return nil, fmt.Errorf("error while making final DataTX: %w", err)
 	}
 	allTXs = append(allTXs, finTX)
 	return allTXs, nil
 }
 
+//TXOfBatchedEntries generates a single transaction storing all the given entries, node i is used for entry i.
+//Every entry must fit in a single part. For each entry the TX carries the metaEntry encrypted with the BTrunk password,
+//a DustLimit output to the node address followed by the entry part encrypted with the node password. Change goes back to BTrunk.
+//The first output is always a DustLimit output to the BTrunk address, so that the TX is in the BTrunk history even without change.
+func (bt *BTrunk) TXOfBatchedEntries(nodes []*keys.Node, entries []*Entry, header string, maxAmountToSpend satoshi.Satoshi, simulate bool) (*DataTX, error) {
+	tr := trace.New().Source("btrunk.go", "BTrunk", "TXOfBatchedEntries")
+	if len(nodes) != len(entries) {
+		return nil, fmt.Errorf("number of nodes %d and entries %d don't match", len(nodes), len(entries))
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no entries to batch")
+	}
+	outputs := make([]*BatchOutput, 0, len(entries)*2+1)
+	outputs = append(outputs, &BatchOutput{Address: bt.address, Value: DustLimit})
+	for i, entry := range entries {
+		node := nodes[i]
+		metaEntry := NewMetaEntry(node, entry)
+		metaEntryData, err := bt.trunkKeys.encrypt(metaEntry, header)
+		if err != nil {
+			return nil, fmt.Errorf("error while encrypting metaEntry of %s: %w", entry.Name, err)
+		}
+		parts, err := entry.ToParts(node.Password(), bt.blockchain.miner.MaxOpReturn())
+		if err != nil {
+			return nil, fmt.Errorf("error making parts of entry %s: %w", entry.Name, err)
+		}
+		if len(parts) != 1 {
+			trail.Println(trace.Alert("entry too big to be batched").UTC().Add("entry", entry.Name).Add("parts", fmt.Sprintf("%d", len(parts))).Append(tr))
+			return nil, fmt.Errorf("entry %s too big to be batched, it needs %d parts", entry.Name, len(parts))
+		}
+		partData, err := parts[0].Encrypt(node.Password())
+		if err != nil {
+			return nil, fmt.Errorf("error while encrypting part of %s: %w", entry.Name, err)
+		}
+		outputs = append(outputs, &BatchOutput{Data: metaEntryData})
+		outputs = append(outputs, &BatchOutput{Address: node.Address(), Value: DustLimit, Data: partData})
+	}
+	//The fee depends on the number of inputs, selection is repeated until it covers the cost.
+	numUTXO := 1
+	var utxo []*UTXO
+	var fee satoshi.Satoshi
+	var err error
+	for {
+		fee, err = bt.blockchain.EstimateBatchTXFee(numUTXO, outputs, header)
+		if err != nil {
+			return nil, fmt.Errorf("error while estimating batch TX fee: %w", err)
+		}
+		cost := fee.Add(DustLimit.Satoshi() * satoshi.Satoshi(len(entries)+1))
+		if cost > maxAmountToSpend {
+			return nil, fmt.Errorf("batch cost %d is more than the max amount to spend %d", cost, maxAmountToSpend)
+		}
+		utxo, err = bt.selectUTXOs(simulate, cost)
+		if err != nil {
+			return nil, fmt.Errorf("error while getting UTXOs: %w", err)
+		}
+		if len(utxo) <= numUTXO {
+			break
+		}
+		numUTXO = len(utxo)
+	}
+	var batchTX *DataTX
+	if bt.unsigned {
+		batchTX, err = NewUnsignedBatchDataTX(bt.address, utxo, outputs, fee, header)
+	} else {
+		batchTX, err = NewBatchDataTX(bt.key, bt.address, utxo, outputs, fee, header)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while making batch DataTX: %w", err)
+	}
+	trail.Println(trace.Info("batch TX ready").UTC().Add("entries", fmt.Sprintf("%d", len(entries))).Add("fee", fmt.Sprintf("%d", fee)).Append(tr))
+	return batchTX, nil
+}
+
 // func (bt *BTrunk) newFBranch(wif, address string, password [32]byte) (*FBranch, error) {
 // 	tr := trace.New().Source("btrunk.go", "BTrunk", "NewFBranch")
 // 	trail.Println(trace.Debug("generating new FBranch").UTC().Append(tr).Add("address", address))
@@ -101,6 +226,17 @@ func (bt *BTrunk) TXOfBranchedEntry(node *keys.Node, entry *Entry, header string
 // 	return &fb, nil
 // }
 
+func (bt *BTrunk) selectUTXOs(simulate bool, target satoshi.Satoshi) ([]*UTXO, error) {
+	utxo, err := bt.getUTXOs(simulate)
+	if err != nil {
+		return nil, err
+	}
+	if bt.selection == nil {
+		return utxo, nil
+	}
+	return bt.selection.Select(utxo, target)
+}
+
 func (bt *BTrunk) getUTXOs(simulate bool) ([]*UTXO, error) {
 	tr := trace.New().Source("btrunk.go", "BTrunk", "getUTXOs")
 	trail.Println(trace.Info("getting green bud UTXO of the btrunk").Append(tr).UTC())
@@ -127,33 +263,74 @@ func (bt *BTrunk) ListEntries(cacheOnly bool) ([]*MetaEntry, error) {
 	trail.Println(trace.Debug("listing transactions for main address").Append(tr).UTC().Add("address", bt.address))
 	TXIDs, err := bt.blockchain.ListTXIDs(bt.address, cacheOnly)
 	if err != nil {
-		return nil, fmt.Errorf("error while listing BTrunk transactions: %v", err)
+		return nil, fmt.Errorf("error while listing BTrunk transactions: %w", err)
 	}
 	meList := []*MetaEntry{}
 	for _, TXID := range TXIDs {
 		tx, err := bt.blockchain.GetTX(TXID, cacheOnly)
 		if err != nil {
-			return nil, fmt.Errorf("error while getting BTrunk transaction: %v", err)
+			return nil, fmt.Errorf("error while getting BTrunk transaction: %w", err)
 		}
-		data, _, err := tx.Data()
+		data, headers, err := tx.AllData()
 		if err != nil {
 			trail.Println(trace.Warning("error while getting transaction data").Append(tr).UTC().Error(err))
 		}
-		me, _ := MetaEntryFromEncrypted(bt.passBytes, data)
-		if me != nil && me.Timestamp > 0 {
-			meList = append(meList, me)
+		for i, d := range data {
+			me, _ := bt.trunkKeys.decrypt(headers[i], d)
+			if me != nil && me.Timestamp > 0 {
+				meList = append(meList, me)
+			}
 		}
 	}
 	return meList, nil
 }
 
+//RestoreNodes adds to the keystore the nodes of all the MetaEntries on the BTrunk address, the keystore must have the same source.
+//Returns the nodes added, the ones already in the keystore are skipped.
+func (bt *BTrunk) RestoreNodes(keystore *keys.Keystore, cacheOnly bool) ([]*keys.Node, error) {
+	tr := trace.New().Source("btrunk.go", "BTrunk", "RestoreNodes")
+	if keystore.Source().Address() != bt.address {
+		return nil, fmt.Errorf("keystore source %s is not the BTrunk address %s", keystore.Source().Address(), bt.address)
+	}
+	metaEntries, err := bt.ListEntries(cacheOnly)
+	if err != nil {
+		return nil, fmt.Errorf("error while listing MetaEntries: %w", err)
+	}
+	restored := []*keys.Node{}
+	for _, me := range metaEntries {
+		var node *keys.Node
+		var added bool
+		if me.Index != nil && keystore.Source().XKey() != "" {
+			node, added, err = keystore.RestoreHDNode(me.Name, me.Key, me.Address, me.Password, me.EntryHash, me.Timestamp, *me.Index)
+		} else {
+			node, added, err = keystore.RestoreNode(me.Name, me.Key, me.Address, me.Password, me.EntryHash, me.Timestamp)
+		}
+		if err != nil {
+			trail.Println(trace.Warning("MetaEntry with invalid node").Append(tr).UTC().Add("name", me.Name).Add("address", me.Address).Error(err))
+			continue
+		}
+		if added {
+			restored = append(restored, node)
+		}
+	}
+	return restored, nil
+}
+
 func (bt *BTrunk) GetEntry(node *keys.Node, cacheOnly bool) (*Entry, error) {
 	tr := trace.New().Source("btrunk.go", "BTrunk", "GetEntry")
 
-	trail.Println(trace.Debug("listing transactions for node address").Append(tr).UTC().Add("address", node.Address()))
-	TXIDs, err := bt.blockchain.ListTXIDs(node.Address(), cacheOnly)
-	if err != nil {
-		return nil, fmt.Errorf("error while listing FBranch transactions: %v", err)
+	var TXIDs []string
+	if bt.blockchain.Cache != nil {
+		//TXs of the entries stored with this cache are indexed, no need to list the node address
+		TXIDs, _ = bt.blockchain.Cache.EntryTXIDs(node.ID())
+	}
+	if len(TXIDs) == 0 {
+		trail.Println(trace.Debug("listing transactions for node address").Append(tr).UTC().Add("address", node.Address()))
+		var err error
+		TXIDs, err = bt.blockchain.ListTXIDs(node.Address(), cacheOnly)
+		if err != nil {
+			return nil, fmt.Errorf("error while listing FBranch transactions: %w", err)
+		}
 	}
 	trail.Println(trace.Debug("TXs found").Append(tr).UTC().Add("num of TXs found", fmt.Sprintf("%d", len(TXIDs))))
 	fb := FBranch{BitcoinWIF: node.Key(), BitcoinAdd: node.Address(), Password: node.Password(), Blockchain: bt.blockchain}
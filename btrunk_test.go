@@ -3,6 +3,7 @@ package ddb_test
 import (
 	"fmt"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/ejfhp/ddb"
@@ -10,6 +11,7 @@ import (
 	"github.com/ejfhp/ddb/miner"
 	"github.com/ejfhp/ddb/satoshi"
 	"github.com/ejfhp/trail"
+	"github.com/libsv/go-bt/bscript"
 )
 
 const (
@@ -107,6 +109,186 @@ func TestBTrunk_TXOfBranchedEntry(t *testing.T) {
 	}
 }
 
+func TestBTrunk_TXOfBranchedEntry_FanOut(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	keystore, err := keys.NewKeystore(destinationKey, "mainpassword")
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	blockchain := ddb.NewBlockchain(&fakeMiner{maxOpReturn: 1000}, newFakeExplorer(), nil)
+	btrunk := ddb.NewBTrunk(destinationKey, destinationAddress, keystore.Source().Password(), blockchain)
+	err = btrunk.SetLayout(ddb.LayoutFanOut)
+	if err != nil {
+		t.Logf("failed to set layout: %v", err)
+		t.FailNow()
+	}
+	entry, err := ddb.NewEntryFromFile("image.png", "testdata/image.png", []string{"label1"}, "notes")
+	if err != nil {
+		t.Logf("failed to generate entry: %v", err)
+		t.FailNow()
+	}
+	node, err := keystore.NewNode(entry.Name, entry.HashOfEntry())
+	if err != nil {
+		t.Logf("failed to generate node: %v", err)
+		t.FailNow()
+	}
+	txs, err := btrunk.TXOfBranchedEntry(node, entry, "test01234", satoshi.Satoshi(100000), true)
+	if err != nil {
+		t.Logf("failed to generate branched entry TXs: %v", err)
+		t.FailNow()
+	}
+	funding := txs[1]
+	if len(funding.Outputs) != len(txs)-1 {
+		t.Logf("unexpected number of funding outputs: %d with %d TXs", len(funding.Outputs), len(txs))
+		t.FailNow()
+	}
+	change := funding.Outputs[len(funding.Outputs)-1]
+	changeScript, _ := bscript.NewP2PKHFromAddress(destinationAddress)
+	if change.GetLockingScriptHexString() != changeScript.ToString() {
+		t.Logf("funding change should go back to BTrunk")
+		t.FailNow()
+	}
+	for i, tx := range txs[2:] {
+		if tx.Inputs[0].PreviousTxID != funding.GetTxID() {
+			t.Logf("part %d doesn't spend the funding TX", i)
+			t.FailNow()
+		}
+	}
+}
+
+func TestBTrunk_TXOfBranchedEntry_BranchAndBound(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	keystore, err := keys.NewKeystore(destinationKey, "mainpassword")
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	script, _ := bscript.NewP2PKHFromAddress(destinationAddress)
+	explorer := newFakeExplorer()
+	//60000+40100 is 100 above the target, within the tolerance of branch and bound
+	for i, v := range []satoshi.Satoshi{60000, 40100, 5000} {
+		explorer.utxos[destinationAddress] = append(explorer.utxos[destinationAddress], &ddb.UTXO{TXHash: fmt.Sprintf("%064x", i+1), TXPos: 0, Value: v.Bitcoin(), ScriptPubKeyHex: script.ToString()})
+	}
+	blockchain := ddb.NewBlockchain(&fakeMiner{maxOpReturn: 1000}, explorer, nil)
+	btrunk := ddb.NewBTrunk(destinationKey, destinationAddress, keystore.Source().Password(), blockchain)
+	selection, err := ddb.NewCoinSelection(ddb.SelectBranchAndBound, nil)
+	if err != nil {
+		t.Logf("failed to create selection: %v", err)
+		t.FailNow()
+	}
+	btrunk.SetCoinSelection(selection)
+	entry, err := ddb.NewEntryFromFile("test.txt", "testdata/test.txt", []string{"label1"}, "notes")
+	if err != nil {
+		t.Logf("failed to generate entry: %v", err)
+		t.FailNow()
+	}
+	node, err := keystore.NewNode(entry.Name, entry.HashOfEntry())
+	if err != nil {
+		t.Logf("failed to generate node: %v", err)
+		t.FailNow()
+	}
+	txs, err := btrunk.TXOfBranchedEntry(node, entry, "test01234", satoshi.Satoshi(100000), false)
+	if err != nil {
+		t.Logf("failed to generate branched entry TXs: %v", err)
+		t.FailNow()
+	}
+	meTX := txs[0]
+	in, _, _, err := meTX.TotInOutFee()
+	if err != nil || len(meTX.Inputs) != 2 || in != satoshi.Satoshi(100100) {
+		t.Logf("unexpected branch and bound selection: %d inputs value %d %v", len(meTX.Inputs), in, err)
+		t.FailNow()
+	}
+	for i, out := range meTX.Outputs {
+		if out.GetLockingScriptHexString() == script.ToString() && satoshi.Satoshi(out.Satoshis) < ddb.DustLimit {
+			t.Logf("dust change output %d: %d", i, out.Satoshis)
+			t.FailNow()
+		}
+	}
+}
+
+func TestBTrunk_TXOfBatchedEntries(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	keystore, err := keys.NewKeystore(destinationKey, "mainpassword")
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	explorer := newFakeExplorer()
+	blockchain := ddb.NewBlockchain(&fakeMiner{maxOpReturn: 1000}, explorer, nil)
+	btrunk := ddb.NewBTrunk(destinationKey, destinationAddress, keystore.Source().Password(), blockchain)
+	entries := []*ddb.Entry{
+		ddb.NewEntryFromData("note1.txt", "text/plain", []byte("first small note"), []string{"label1"}, "notes"),
+		ddb.NewEntryFromData("note2.txt", "text/plain", []byte("second small note"), []string{"label2"}, "notes"),
+	}
+	nodes := make([]*keys.Node, len(entries))
+	for i, e := range entries {
+		nodes[i], err = keystore.NewNode(e.Name, e.HashOfEntry())
+		if err != nil {
+			t.Logf("failed to generate node: %v", err)
+			t.FailNow()
+		}
+	}
+	tx, err := btrunk.TXOfBatchedEntries(nodes, entries, "test01234", satoshi.Satoshi(10000), true)
+	if err != nil {
+		t.Logf("failed to generate batch TX: %v", err)
+		t.FailNow()
+	}
+	data, _, err := tx.AllData()
+	if err != nil {
+		t.Logf("failed to get batch TX data: %v", err)
+		t.FailNow()
+	}
+	if len(data) != 2*len(entries) {
+		t.Logf("unexpected number of data outputs: %d", len(data))
+		t.FailNow()
+	}
+	trunkScript, err := bscript.NewP2PKHFromAddress(destinationAddress)
+	if err != nil {
+		t.Logf("failed to make BTrunk script: %v", err)
+		t.FailNow()
+	}
+	if tx.Outputs[0].LockingScript.ToString() != trunkScript.ToString() || tx.Outputs[0].Satoshis != uint64(ddb.DustLimit) {
+		t.Logf("first output must pay the BTrunk address")
+		t.FailNow()
+	}
+	explorer.txs[tx.GetTxID()] = tx
+	explorer.history[destinationAddress] = []string{tx.GetTxID()}
+	for _, n := range nodes {
+		explorer.history[n.Address()] = []string{tx.GetTxID()}
+	}
+	metaEntries, err := btrunk.ListEntries(false)
+	if err != nil {
+		t.Logf("failed to list entries: %v", err)
+		t.FailNow()
+	}
+	if len(metaEntries) != len(entries) {
+		t.Logf("unexpected number of meta entries: %d", len(metaEntries))
+		t.FailNow()
+	}
+	for i, n := range nodes {
+		entry, err := btrunk.GetEntry(n, false)
+		if err != nil {
+			t.Logf("failed to get entry %d: %v", i, err)
+			t.FailNow()
+		}
+		if entry.Name != entries[i].Name || string(entry.Data) != string(entries[i].Data) {
+			t.Logf("unexpected entry %d: %s", i, entry.Name)
+			t.FailNow()
+		}
+	}
+	big, err := ddb.NewEntryFromFile("image.png", "testdata/image.png", []string{"label1"}, "notes")
+	if err != nil {
+		t.Logf("failed to generate entry: %v", err)
+		t.FailNow()
+	}
+	_, err = btrunk.TXOfBatchedEntries(nodes[:1], []*ddb.Entry{big}, "test01234", satoshi.Satoshi(10000), true)
+	if err == nil {
+		t.Logf("entry bigger than a part should not be batched")
+		t.FailNow()
+	}
+}
+
 func TestBTrunk_ListEntries(t *testing.T) {
 	trail.SetWriter(os.Stdout)
 	woc := ddb.NewWOC()
@@ -133,3 +315,172 @@ func TestBTrunk_ListEntries(t *testing.T) {
 		}
 	}
 }
+
+func TestBTrunk_RestoreNodes(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "restore_trh")
+	defer os.RemoveAll(dir)
+	keystore, err := keys.NewKeystore(destinationKey, "mainpassword")
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	explorer := newFakeExplorer()
+	for _, name := range []string{"test.txt", "image.png"} {
+		entry := ddb.NewEntryFromData(name, "text/plain", []byte(name), []string{}, "")
+		node, err := keystore.NewNode(name, entry.HashOfEntry())
+		if err != nil {
+			t.Logf("failed to build node: %v", err)
+			t.FailNow()
+		}
+		encrypted, err := ddb.NewMetaEntry(node, entry).Encrypt(keys.StringToPassword("mainpassword"))
+		if err != nil {
+			t.Logf("failed to encrypt meta entry: %v", err)
+			t.FailNow()
+		}
+		tx := Helper_DataTX(t, encrypted)
+		explorer.txs[tx.GetTxID()] = tx
+		explorer.history[destinationAddress] = append(explorer.history[destinationAddress], tx.GetTxID())
+	}
+	cache, err := ddb.NewTXCache(dir)
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	blockchain := ddb.NewBlockchain(&fakeMiner{}, explorer, cache)
+	lost, err := keys.NewKeystore(destinationKey, "mainpassword")
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	btrunk := ddb.NewBTrunk(destinationKey, destinationAddress, "mainpassword", blockchain)
+	restored, err := btrunk.RestoreNodes(lost, false)
+	if err != nil || len(restored) != 2 {
+		t.Logf("unexpected nodes restored: %d %v", len(restored), err)
+		t.FailNow()
+	}
+	for _, n := range keystore.Nodes() {
+		r, err := lost.GetNode(n.ID())
+		if err != nil || r.Key() != n.Key() || r.Password() != n.Password() {
+			t.Logf("node %s not restored: %v", n.Name(), err)
+			t.FailNow()
+		}
+	}
+	restored, err = btrunk.RestoreNodes(lost, true)
+	if err != nil || len(restored) != 0 {
+		t.Logf("nodes restored twice: %d %v", len(restored), err)
+		t.FailNow()
+	}
+}
+
+func TestBTrunk_ListEntriesSalted(t *testing.T) {
+	keystore, err := keys.NewKeystore(destinationKey, "mainpassword")
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	explorer := newFakeExplorer()
+	blockchain := ddb.NewBlockchain(&fakeMiner{maxOpReturn: 1000}, explorer, nil)
+	btrunk := ddb.NewBTrunk(destinationKey, destinationAddress, keystore.Source().Password(), blockchain)
+	txids := []string{}
+	txs := []*ddb.DataTX{}
+	for i, header := range []string{ddb.HeaderLegacy, ddb.HeaderSalted} {
+		entry := ddb.NewEntryFromData(fmt.Sprintf("note%d.txt", i), "text/plain", []byte("small note"), []string{"label"}, "notes")
+		node, err := keystore.NewNode(entry.Name, entry.HashOfEntry())
+		if err != nil {
+			t.Logf("failed to generate node: %v", err)
+			t.FailNow()
+		}
+		tx, err := btrunk.TXOfBatchedEntries([]*keys.Node{node}, []*ddb.Entry{entry}, header, satoshi.Satoshi(10000), true)
+		if err != nil {
+			t.Logf("failed to generate TX with header %s: %v", header, err)
+			t.FailNow()
+		}
+		explorer.txs[tx.GetTxID()] = tx
+		txids = append(txids, tx.GetTxID())
+		txs = append(txs, tx)
+	}
+	explorer.history[destinationAddress] = txids
+	metaEntries, err := btrunk.ListEntries(false)
+	if err != nil {
+		t.Logf("failed to list entries: %v", err)
+		t.FailNow()
+	}
+	if len(metaEntries) != 2 {
+		t.Logf("unexpected number of meta entries: %d", len(metaEntries))
+		t.FailNow()
+	}
+	decoded, err := ddb.DecodeTXs(txs[1:], keystore.Source().Password())
+	if err != nil {
+		t.Logf("failed to decode salted TX: %v", err)
+		t.FailNow()
+	}
+	if len(decoded.MetaEntries) != 1 || len(decoded.Entries) != 1 || decoded.Entries[0].Name != "note1.txt" {
+		t.Logf("unexpected decoded salted TX: %d meta entries, %d entries", len(decoded.MetaEntries), len(decoded.Entries))
+		t.FailNow()
+	}
+	decoded, err = ddb.DecodeTXs(txs[1:], "wrongpassword")
+	if err != nil {
+		t.Logf("failed to decode salted TX: %v", err)
+		t.FailNow()
+	}
+	if len(decoded.MetaEntries) != 0 {
+		t.Logf("salted meta entry decrypted with wrong password")
+		t.FailNow()
+	}
+}
+
+func TestBTrunk_RestoreHDNodes(t *testing.T) {
+	xprv := "xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi"
+	keystore, err := keys.NewKeystoreHD(xprv, "mainpassword")
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	address := keystore.Source().Address()
+	explorer := newFakeExplorer()
+	for _, name := range []string{"test.txt", "image.png"} {
+		entry := ddb.NewEntryFromData(name, "text/plain", []byte(name), []string{}, "")
+		node, err := keystore.NewNode(name, entry.HashOfEntry())
+		if err != nil {
+			t.Logf("failed to build node: %v", err)
+			t.FailNow()
+		}
+		meta := ddb.NewMetaEntry(node, entry)
+		if meta.Index == nil || *meta.Index != *node.Index() {
+			t.Logf("node index not in meta entry")
+			t.FailNow()
+		}
+		encrypted, err := meta.Encrypt(keys.StringToPassword("mainpassword"))
+		if err != nil {
+			t.Logf("failed to encrypt meta entry: %v", err)
+			t.FailNow()
+		}
+		tx := Helper_DataTX(t, encrypted)
+		explorer.txs[tx.GetTxID()] = tx
+		explorer.history[address] = append(explorer.history[address], tx.GetTxID())
+	}
+	blockchain := ddb.NewBlockchain(&fakeMiner{}, explorer, nil)
+	lost, err := keys.NewKeystoreHD(xprv, "mainpassword")
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	btrunk := ddb.NewBTrunk(keystore.Source().Key(), address, "mainpassword", blockchain)
+	restored, err := btrunk.RestoreNodes(lost, false)
+	if err != nil || len(restored) != 2 {
+		t.Logf("unexpected nodes restored: %d %v", len(restored), err)
+		t.FailNow()
+	}
+	for _, n := range keystore.Nodes() {
+		r, err := lost.GetNode(n.ID())
+		if err != nil || r.Key() != n.Key() || r.Index() == nil || *r.Index() != *n.Index() {
+			t.Logf("node %s not restored: %v", n.Name(), err)
+			t.FailNow()
+		}
+	}
+	next, err := lost.NewNode("next.txt", [32]byte{'n', 'e', 'x', 't'})
+	if err != nil || *next.Index() != 2 {
+		t.Logf("new node should follow the restored ones: %v", err)
+		t.FailNow()
+	}
+}
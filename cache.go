@@ -9,26 +9,130 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/ejfhp/ddb/errs"
+	"github.com/ejfhp/ddb/miner"
 	"github.com/ejfhp/trail"
 	"github.com/ejfhp/trail/trace"
 )
 
+//Cache keeps TXs, address histories, entry indexes, fee quotes, TX statuses and chain progress between runs.
+//TXCache stores them in a dir of files, KVCache in a single embedded key-value file.
+type Cache interface {
+	DirPath() string
+	StoreTX(id string, tx []byte) error
+	RetrieveTX(id string) ([]byte, error)
+	StoreTXIDs(address string, txids []string) error
+	GetTXIDs(address string) ([]string, error)
+	StoreAddressInfo(info *AddressInfo) error
+	GetAddressInfo(address string) (*AddressInfo, error)
+	StoreFees(fees miner.Fees) error
+	RetrieveFees() (miner.Fees, error)
+	StoreTXStatus(status *TXStatus) error
+	RetrieveTXStatus(id string) (*TXStatus, error)
+	RecordCallback(cb *miner.Callback) error
+	StoreChainProgress(progress *ChainProgress) error
+	RetrieveChainProgress(id string) (*ChainProgress, error)
+	DeleteChainProgress(id string) error
+	ListChainProgress() ([]string, error)
+	SetMaxBytes(maxBytes int64)
+	SetTXHeight(id string, height int) error
+	IndexEntry(hash string, txids []string) error
+	EntryTXIDs(hash string) ([]string, error)
+	Stats() (*CacheStats, error)
+	Verify() (*CacheReport, error)
+	Prune(maxBytes int64) ([]string, error)
+	Size() (int, error)
+	Clear() error
+	Close() error
+	CacheSalt() ([]byte, error)
+	SetEncryptTXs(encrypt bool)
+	SetEncryptionKey(key [32]byte) error
+	ChangeEncryptionKey(key [32]byte) error
+	Encrypted() bool
+}
+
+const (
+	CacheTypeFile = "file"
+	CacheTypeKV   = "kv"
+)
+
 type TXCache struct {
-	path string
+	path     string
+	maxBytes int64
+	key      *[32]byte
+	sealTXs  bool
+	mu       sync.Mutex
 }
 
+//AddressInfo is the history of an address, Synced is the time of the last sync with the explorer
+//and Height the highest block with TXs of the address seen then.
 type AddressInfo struct {
 	Address string   `json:"address"`
 	TXIDs   []string `json:"txids"`
+	Synced  int64    `json:"synced,omitempty"`
+	Height  int      `json:"height,omitempty"`
+}
+
+//Fresh returns true if the history has been synced less than ttl ago.
+func (a *AddressInfo) Fresh(ttl time.Duration) bool {
+	if a == nil || ttl <= 0 || a.Synced == 0 {
+		return false
+	}
+	return time.Since(time.Unix(a.Synced, 0)) < ttl
+}
+
+const (
+	TXStatusSubmitted          = "submitted"
+	TXStatusMined              = "mined"
+	TXStatusDoubleSpend        = "doublespend"
+	TXStatusDoubleSpendAttempt = "doublespendattempt"
+)
+
+//TXStatus is the last known state of a submitted TX, updated by miner callbacks.
+type TXStatus struct {
+	TXID        string          `json:"txid"`
+	Status      string          `json:"status"`
+	BlockHash   string          `json:"blockhash,omitempty"`
+	BlockHeight int             `json:"blockheight,omitempty"`
+	MerkleProof json.RawMessage `json:"merkleproof,omitempty"`
+	Updated     int64           `json:"updated"`
 }
 
-var ErrNotCached error = fmt.Errorf("entry not in cache")
+const chainProgressPrefix = "chain-"
+
+var ErrNotCached error = errs.ErrNotCached
+
+//NewUserCache opens the cache of the given type in the user cache dir.
+//If cacheType is empty the key-value cache is used when its file exists, the dir of files otherwise.
+func NewUserCache(cacheType string) (Cache, error) {
+	path := userCachePath()
+	if cacheType == "" {
+		cacheType = CacheTypeFile
+		_, err := os.Stat(filepath.Join(path, kvCacheFile))
+		if err == nil {
+			cacheType = CacheTypeKV
+		}
+	}
+	switch cacheType {
+	case CacheTypeFile:
+		return NewTXCache(path)
+	case CacheTypeKV:
+		return NewKVCache(path)
+	default:
+		return nil, fmt.Errorf("unknown cache type '%s', use %s or %s", cacheType, CacheTypeFile, CacheTypeKV)
+	}
+}
 
 func NewUserTXCache() (*TXCache, error) {
+	return NewTXCache(userCachePath())
+}
+
+func userCachePath() string {
 	usercache, _ := os.UserCacheDir()
-	path := filepath.Join(usercache, "trh")
-	return NewTXCache(path)
+	return filepath.Join(usercache, "trh")
 }
 
 func NewTXCache(path string) (*TXCache, error) {
@@ -54,20 +158,41 @@ func (c *TXCache) DirPath() string {
 func (c *TXCache) StoreTX(id string, tx []byte) error {
 	tr := trace.New().Source("cache.go", "TXCache", "Store")
 	trail.Println(trace.Debug("storing TX").UTC().Add("path", c.path).Add("id", id).Append(tr))
-	txpath := c.PathOf(id)
-	err := ioutil.WriteFile(txpath, tx, 0600)
+	sealed, err := sealMeta(c.txKey(), tx)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(c.PathOf(c.txName(id)), sealed, 0600)
 	if err != nil {
 		trail.Println(trace.Alert("error storing tx to cache").UTC().Add("path", c.path).Add("id", id).Error(err).Append(tr))
 		return fmt.Errorf("error storing tx '%s' to cache dir '%s': %w", id, c.path, err)
 	}
-	return nil
+	unlock, err := c.lockIndex()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	index, err := c.loadIndex()
+	if err != nil {
+		return err
+	}
+	meta, ok := index.TXs[id]
+	if !ok {
+		meta = &TXMeta{Added: time.Now().Unix()}
+		index.TXs[id] = meta
+	}
+	meta.Size = len(tx)
+	_, err = c.evict(index, c.maxBytes)
+	if err != nil {
+		trail.Println(trace.Warning("error evicting txs from cache").UTC().Add("path", c.path).Error(err).Append(tr))
+	}
+	return c.saveIndex(index)
 }
 
 func (c *TXCache) RetrieveTX(id string) ([]byte, error) {
 	tr := trace.New().Source("cache.go", "TXCache", "Retrieve")
 	trail.Println(trace.Debug("retrieving TX").UTC().Add("id", id).Append(tr))
-	txpath := c.PathOf(id)
-	tx, err := ioutil.ReadFile(txpath)
+	stored, err := ioutil.ReadFile(c.PathOf(c.txName(id)))
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			trail.Println(trace.Alert("tx not in cache").UTC().Add("path", c.path).Add("id", id).Error(err).Append(tr))
@@ -76,34 +201,35 @@ func (c *TXCache) RetrieveTX(id string) ([]byte, error) {
 		trail.Println(trace.Alert("error retrieving tx from cache").UTC().Add("path", c.path).Add("id", id).Error(err).Append(tr))
 		return nil, fmt.Errorf("error retrieving tx '%s' from cache dir '%s': %w", id, c.path, err)
 	}
+	tx, err := openMeta(c.key, stored)
+	if err != nil || !txMatches(id, tx) {
+		//A corrupted TX is dropped so that it is downloaded again
+		trail.Println(trace.Alert("corrupted tx in cache").UTC().Add("path", c.path).Add("id", id).Append(tr))
+		err = c.updateIndex(func(index *CacheIndex) bool {
+			c.removeTX(index, id)
+			return true
+		})
+		if err != nil {
+			trail.Println(trace.Warning("error removing corrupted tx from cache").UTC().Add("path", c.path).Add("id", id).Error(err).Append(tr))
+		}
+		return nil, ErrNotCached
+	}
+	c.touch(id)
 	return tx, nil
 }
 
 func (c *TXCache) StoreTXIDs(address string, txids []string) error {
 	tr := trace.New().Source("cache.go", "TXCache", "StoreTXID")
 	trail.Println(trace.Debug("storing TXIDs").UTC().Add("path", c.path).Add("address", address).Append(tr))
-	addinfo, err := c.retrieveAddressInfo(address)
-	if err != nil {
-		if err == ErrNotCached {
+	err := c.updateIndex(func(index *CacheIndex) bool {
+		addinfo := c.addressInfo(index, address)
+		if addinfo == nil {
 			addinfo = &AddressInfo{TXIDs: []string{}, Address: address}
-		} else {
-			trail.Println(trace.Alert("error storing txid to cache").UTC().Add("path", c.path).Add("address", address).Error(err).Append(tr))
-			return fmt.Errorf("error storing txid of address '%s' to cache dir '%s': %w", address, c.path, err)
+			index.Addresses[address] = addinfo
 		}
-	}
-	for _, incom := range txids {
-		exist := false
-		for _, exing := range addinfo.TXIDs {
-			if incom == exing {
-				exist = true
-			}
-		}
-		if !exist {
-			addinfo.TXIDs = append(addinfo.TXIDs, incom)
-		}
-	}
-	err = c.storeAddressInfo(addinfo)
-	// fmt.Println(addinfo)
+		addinfo.TXIDs = mergeTXIDs(addinfo.TXIDs, txids)
+		return true
+	})
 	if err != nil {
 		trail.Println(trace.Alert("error storing txid to cache").UTC().Add("path", c.path).Add("address", address).Error(err).Append(tr))
 		return fmt.Errorf("error storing txid for address '%s' to cache dir '%s': %w", address, c.path, err)
@@ -111,71 +237,285 @@ func (c *TXCache) StoreTXIDs(address string, txids []string) error {
 	return nil
 }
 
+//GetTXIDs returns the TXs of the address, ErrNotCached if the address is unknown.
 func (c *TXCache) GetTXIDs(address string) ([]string, error) {
-	tr := trace.New().Source("cache.go", "TXCache", "GetTXIDs")
-	trail.Println(trace.Debug("getting TXID").UTC().Add("path", c.path).Add("address", address).Append(tr))
-	addinfo, err := c.retrieveAddressInfo(address)
+	addinfo, err := c.GetAddressInfo(address)
 	if err != nil {
-		if err == ErrNotCached {
-			trail.Println(trace.Alert("address not in cache").UTC().Add("path", c.path).Add("address", address).Error(err).Append(tr))
-			return nil, err
-		} else {
-			trail.Println(trace.Alert("error retrieving address from cache").UTC().Add("path", c.path).Add("address", address).Error(err).Append(tr))
-			return nil, fmt.Errorf("error retrieving address '%s' from cache dir '%s': %w", address, c.path, err)
-		}
+		return nil, err
 	}
-	if addinfo.TXIDs == nil || len(addinfo.TXIDs) == 0 {
-		trail.Println(trace.Alert("txid not found in cache").UTC().Add("path", c.path).Add("address", address).Error(err).Append(tr))
+	return addinfo.TXIDs, nil
+}
+
+//StoreAddressInfo replaces the history of the address.
+func (c *TXCache) StoreAddressInfo(info *AddressInfo) error {
+	tr := trace.New().Source("cache.go", "TXCache", "StoreAddressInfo")
+	trail.Println(trace.Debug("storing address info").UTC().Add("path", c.path).Add("address", info.Address).Append(tr))
+	err := c.updateIndex(func(index *CacheIndex) bool {
+		index.Addresses[info.Address] = info
+		return true
+	})
+	if err != nil {
+		trail.Println(trace.Alert("error storing address info to cache").UTC().Add("path", c.path).Add("address", info.Address).Error(err).Append(tr))
+		return fmt.Errorf("error storing address '%s' to cache dir '%s': %w", info.Address, c.path, err)
+	}
+	return nil
+}
+
+//GetAddressInfo returns the history of the address, ErrNotCached if the address is unknown.
+func (c *TXCache) GetAddressInfo(address string) (*AddressInfo, error) {
+	tr := trace.New().Source("cache.go", "TXCache", "GetAddressInfo")
+	trail.Println(trace.Debug("getting address info").UTC().Add("path", c.path).Add("address", address).Append(tr))
+	var addinfo *AddressInfo
+	err := c.updateIndex(func(index *CacheIndex) bool {
+		_, indexed := index.Addresses[address]
+		addinfo = c.addressInfo(index, address)
+		//Save only if the address info has been migrated from the old layout
+		return !indexed && addinfo != nil
+	})
+	if err != nil {
+		trail.Println(trace.Alert("error retrieving address from cache").UTC().Add("path", c.path).Add("address", address).Error(err).Append(tr))
+		return nil, fmt.Errorf("error retrieving address '%s' from cache dir '%s': %w", address, c.path, err)
+	}
+	if addinfo == nil {
+		trail.Println(trace.Alert("address not in cache").UTC().Add("path", c.path).Add("address", address).Append(tr))
 		return nil, ErrNotCached
 	}
-	return addinfo.TXIDs, nil
+	if addinfo.TXIDs == nil {
+		addinfo.TXIDs = []string{}
+	}
+	return addinfo, nil
 }
 
-func (c *TXCache) retrieveAddressInfo(address string) (*AddressInfo, error) {
-	txpath := c.PathOf(address)
-	bytes, err := ioutil.ReadFile(txpath)
+//addressInfo returns the info of the address in the index, nil if unknown.
+//Info stored in its own file by older versions is moved into the index.
+func (c *TXCache) addressInfo(index *CacheIndex, address string) *AddressInfo {
+	if addinfo, ok := index.Addresses[address]; ok {
+		return addinfo
+	}
+	bytes, err := ioutil.ReadFile(c.PathOf(address))
+	if err != nil {
+		return nil
+	}
+	var addinfo AddressInfo
+	err = json.Unmarshal(bytes, &addinfo)
+	if err != nil || addinfo.Address != address {
+		return nil
+	}
+	index.Addresses[address] = &addinfo
+	os.Remove(c.PathOf(address))
+	return &addinfo
+}
+
+//StoreFees keeps the last fee quote of the miner.
+func (c *TXCache) StoreFees(fees miner.Fees) error {
+	tr := trace.New().Source("cache.go", "TXCache", "StoreFees")
+	bytes, err := json.Marshal(fees)
+	if err != nil {
+		return fmt.Errorf("error marshaling fees: %w", err)
+	}
+	err = ioutil.WriteFile(c.PathOf("fees"), bytes, 0600)
+	if err != nil {
+		trail.Println(trace.Alert("error storing fees to cache").UTC().Add("path", c.path).Error(err).Append(tr))
+		return fmt.Errorf("error storing fees to cache dir '%s': %w", c.path, err)
+	}
+	return nil
+}
+
+//RetrieveFees returns the last fee quote stored, ErrNotCached if there is none.
+func (c *TXCache) RetrieveFees() (miner.Fees, error) {
+	tr := trace.New().Source("cache.go", "TXCache", "RetrieveFees")
+	bytes, err := ioutil.ReadFile(c.PathOf("fees"))
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
 			return nil, ErrNotCached
 		}
-		return nil, fmt.Errorf("error retrieving sourceoutput of address '%s' from cache dir '%s': %w", address, c.path, err)
+		trail.Println(trace.Alert("error retrieving fees from cache").UTC().Add("path", c.path).Error(err).Append(tr))
+		return nil, fmt.Errorf("error retrieving fees from cache dir '%s': %w", c.path, err)
 	}
-	var addinfo AddressInfo
-	json.Unmarshal(bytes, &addinfo)
+	fees := miner.Fees{}
+	err = json.Unmarshal(bytes, &fees)
 	if err != nil {
-		return nil, fmt.Errorf("error unmarshaling address info for '%s': %w", address, err)
+		return nil, fmt.Errorf("error unmarshaling fees: %w", err)
 	}
-	return &addinfo, nil
+	return fees, nil
 }
 
-func (c *TXCache) storeAddressInfo(addressinfo *AddressInfo) error {
-	aipath := c.PathOf(addressinfo.Address)
-	bytes, err := json.Marshal(addressinfo)
+func (c *TXCache) StoreTXStatus(status *TXStatus) error {
+	tr := trace.New().Source("cache.go", "TXCache", "StoreTXStatus")
+	trail.Println(trace.Debug("storing TX status").UTC().Add("path", c.path).Add("id", status.TXID).Add("status", status.Status).Append(tr))
+	bytes, err := json.Marshal(status)
 	if err != nil {
-		return fmt.Errorf("error marshaling address info for '%s': %w", addressinfo.Address, err)
+		return fmt.Errorf("error marshaling status of tx '%s': %w", status.TXID, err)
 	}
-	err = ioutil.WriteFile(aipath, bytes, 0600)
+	bytes, err = sealMeta(c.key, bytes)
 	if err != nil {
-		return fmt.Errorf("error storing address info for address '%s' to cache dir '%s': %w", addressinfo.Address, c.path, err)
+		return err
+	}
+	err = ioutil.WriteFile(c.PathOf("status-"+c.txName(status.TXID)), bytes, 0600)
+	if err != nil {
+		trail.Println(trace.Alert("error storing tx status to cache").UTC().Add("path", c.path).Add("id", status.TXID).Error(err).Append(tr))
+		return fmt.Errorf("error storing status of tx '%s' to cache dir '%s': %w", status.TXID, c.path, err)
 	}
 	return nil
 }
 
-func (c *TXCache) Size() (int, error) {
-	tr := trace.New().Source("cache.go", "TXCache", "Size")
-	trail.Println(trace.Debug("getting cache cardinality").UTC().Add("dir", c.path).Append(tr))
+func (c *TXCache) RetrieveTXStatus(id string) (*TXStatus, error) {
+	tr := trace.New().Source("cache.go", "TXCache", "RetrieveTXStatus")
+	trail.Println(trace.Debug("retrieving TX status").UTC().Add("id", id).Append(tr))
+	bytes, err := ioutil.ReadFile(c.PathOf("status-" + c.txName(id)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotCached
+		}
+		trail.Println(trace.Alert("error retrieving tx status from cache").UTC().Add("path", c.path).Add("id", id).Error(err).Append(tr))
+		return nil, fmt.Errorf("error retrieving status of tx '%s' from cache dir '%s': %w", id, c.path, err)
+	}
+	bytes, err = openMeta(c.key, bytes)
+	if err != nil {
+		return nil, err
+	}
+	var status TXStatus
+	err = json.Unmarshal(bytes, &status)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling status of tx '%s': %w", id, err)
+	}
+	return &status, nil
+}
+
+//RecordCallback updates the status of the TX notified by the miner callback.
+func (c *TXCache) RecordCallback(cb *miner.Callback) error {
+	return recordCallback(c, cb)
+}
+
+//recordCallback stores the status notified by the callback and, for merkle proofs, the height of the TX.
+func recordCallback(c Cache, cb *miner.Callback) error {
+	status := TXStatus{TXID: cb.CallbackTXID, BlockHash: cb.BlockHash, BlockHeight: cb.BlockHeight, Updated: time.Now().Unix()}
+	switch cb.CallbackReason {
+	case miner.CallbackMerkleProof:
+		status.Status = TXStatusMined
+		status.MerkleProof = cb.CallbackPayload
+		err := c.SetTXHeight(cb.CallbackTXID, cb.BlockHeight)
+		if err != nil {
+			return fmt.Errorf("error recording height of tx '%s': %w", cb.CallbackTXID, err)
+		}
+	case miner.CallbackDoubleSpend:
+		status.Status = TXStatusDoubleSpend
+	case miner.CallbackDoubleSpendAttempt:
+		//An attempt doesn't change the fate of a TX already known as mined or double spent
+		prev, err := c.RetrieveTXStatus(cb.CallbackTXID)
+		if err != nil && !errors.Is(err, ErrNotCached) {
+			return fmt.Errorf("error retrieving status of tx '%s': %w", cb.CallbackTXID, err)
+		}
+		if prev != nil && (prev.Status == TXStatusMined || prev.Status == TXStatusDoubleSpend) {
+			return nil
+		}
+		status.Status = TXStatusDoubleSpendAttempt
+	default:
+		return fmt.Errorf("unknown callback reason '%s' for tx '%s'", cb.CallbackReason, cb.CallbackTXID)
+	}
+	return c.StoreTXStatus(&status)
+}
+
+func (c *TXCache) StoreChainProgress(progress *ChainProgress) error {
+	tr := trace.New().Source("cache.go", "TXCache", "StoreChainProgress")
+	trail.Println(trace.Debug("storing chain progress").UTC().Add("path", c.path).Add("id", progress.ID).Append(tr))
+	bytes, err := json.Marshal(progress)
+	if err != nil {
+		return fmt.Errorf("error marshaling progress of chain '%s': %w", progress.ID, err)
+	}
+	bytes, err = sealMeta(c.key, bytes)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(c.PathOf(chainProgressPrefix+c.txName(progress.ID)), bytes, 0600)
+	if err != nil {
+		trail.Println(trace.Alert("error storing chain progress to cache").UTC().Add("path", c.path).Add("id", progress.ID).Error(err).Append(tr))
+		return fmt.Errorf("error storing progress of chain '%s' to cache dir '%s': %w", progress.ID, c.path, err)
+	}
+	return nil
+}
+
+func (c *TXCache) RetrieveChainProgress(id string) (*ChainProgress, error) {
+	bytes, err := ioutil.ReadFile(c.PathOf(chainProgressPrefix + c.txName(id)))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, ErrNotCached
+		}
+		return nil, fmt.Errorf("error retrieving progress of chain '%s' from cache dir '%s': %w", id, c.path, err)
+	}
+	bytes, err = openMeta(c.key, bytes)
+	if err != nil {
+		return nil, err
+	}
+	var progress ChainProgress
+	err = json.Unmarshal(bytes, &progress)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling progress of chain '%s': %w", id, err)
+	}
+	return &progress, nil
+}
+
+func (c *TXCache) DeleteChainProgress(id string) error {
+	err := os.Remove(c.PathOf(chainProgressPrefix + c.txName(id)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error deleting progress of chain '%s' from cache dir '%s': %w", id, c.path, err)
+	}
+	return nil
+}
+
+//ListChainProgress returns the IDs of the chains not completely submitted.
+func (c *TXCache) ListChainProgress() ([]string, error) {
+	tr := trace.New().Source("cache.go", "TXCache", "ListChainProgress")
 	dir, err := os.Open(c.path)
 	if err != nil {
 		trail.Println(trace.Alert("error opening cache dir").UTC().Add("dir", c.path).Error(err).Append(tr))
-		return -1, fmt.Errorf("error opening cache dir '%s': %w", c.path, err)
+		return nil, fmt.Errorf("error opening cache dir '%s': %w", c.path, err)
 	}
 	defer dir.Close()
 	names, err := dir.Readdirnames(-1)
 	if err != nil {
-		trail.Println(trace.Debug("error listing files in cache dir").UTC().Add("dir", c.path).Error(err).Append(tr))
-		return -1, fmt.Errorf("error listiing files in cache dir '%s': %w", c.path, err)
+		return nil, fmt.Errorf("error listiing files in cache dir '%s': %w", c.path, err)
+	}
+	ids := []string{}
+	for _, name := range names {
+		if !strings.HasPrefix(name, chainProgressPrefix) || !strings.HasSuffix(name, ".trh") {
+			continue
+		}
+		if c.txKey() == nil {
+			ids = append(ids, strings.TrimSuffix(strings.TrimPrefix(name, chainProgressPrefix), ".trh"))
+			continue
+		}
+		//Names are hidden, the ID is in the progress
+		var progress ChainProgress
+		err = c.readMetaFile(name, &progress)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, progress.ID)
 	}
-	return len(names), nil
+	return ids, nil
+}
+
+//Size returns the number of TXs in cache.
+func (c *TXCache) Size() (int, error) {
+	tr := trace.New().Source("cache.go", "TXCache", "Size")
+	trail.Println(trace.Debug("getting cache cardinality").UTC().Add("dir", c.path).Append(tr))
+	unlock, err := c.lockIndex()
+	if err != nil {
+		return -1, err
+	}
+	defer unlock()
+	index, err := c.loadIndex()
+	if err != nil {
+		trail.Println(trace.Alert("error loading cache index").UTC().Add("dir", c.path).Error(err).Append(tr))
+		return -1, err
+	}
+	return len(index.TXs), nil
+}
+
+//Close does nothing, files are not kept open.
+func (c *TXCache) Close() error {
+	return nil
 }
 
 func (c *TXCache) Clear() error {
@@ -199,7 +539,7 @@ func (c *TXCache) Clear() error {
 		return fmt.Errorf("error listiing files in cache dir '%s': %w", c.path, err)
 	}
 	for _, name := range names {
-		if strings.HasSuffix(name, ".trh") {
+		if strings.HasSuffix(name, ".trh") || strings.HasSuffix(name, ".trh.tmp") {
 			err = os.Remove(path.Join(c.path, name))
 			if err != nil {
 				trail.Println(trace.Warning("error deleting file in cache dir").UTC().Add("dir", c.path).Add("name", name).Error(err).Append(tr))
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/miner"
 )
 
 func TestNewTXCache(t *testing.T) {
@@ -42,10 +43,11 @@ func TestNewTXCache(t *testing.T) {
 
 func TestTXCache_StoreRetrieveTX(t *testing.T) {
 	// trail.SetWriter(os.Stdout)
-	txid := "existingfaketxid"
+	dataTX := Helper_FakeTX(t)
+	txid := dataTX.GetTxID()
 	random := sha256.Sum256([]byte(time.Now().Format("Mon Jan 2 15:04:05 -0700 MST 2006")))
 	ranid := string(hex.EncodeToString(random[:]))
-	tx := []byte("jdjdlkajljflvajafldsa;gf;gjdlijljlkngdskjgfksdjfaksj;kjdsf;kajskjf")
+	tx := dataTX.ToBytes()
 	usercache, _ := os.UserCacheDir()
 
 	cache, err := ddb.NewTXCache(filepath.Join(usercache, "trh"))
@@ -76,13 +78,14 @@ func TestTXCache_StoreRetrieveTX(t *testing.T) {
 		t.Logf("retrieved tx is wrong: %v", err)
 		t.Fail()
 	}
-	rtx, err = cache.RetrieveTX(ranid)
-	if err != nil {
-		t.Logf("failed to retrieve random tx: %v", err)
+	//The ID of the TX doesn't match the random one, the TX is corrupted
+	_, err = cache.RetrieveTX(ranid)
+	if err != ddb.ErrNotCached {
+		t.Logf("unexpected error for corrupted tx: %v", err)
 		t.Fail()
 	}
-	if !reflect.DeepEqual(rtx, tx) {
-		t.Logf("retrieved random tx is wrong: %v", err)
+	if _, err := os.Stat(cache.PathOf(ranid)); !errors.Is(err, os.ErrNotExist) {
+		t.Logf("corrupted tx should be deleted: %v", err)
 		t.Fail()
 	}
 	_, err = cache.RetrieveTX("notexists")
@@ -135,6 +138,17 @@ func TestTXCache_StoreRetrieveTXID(t *testing.T) {
 		t.Fail()
 	}
 
+	err = cache.StoreTXIDs("empty", []string{})
+	if err != nil {
+		t.Logf("failed to store empty history: %v", err)
+		t.FailNow()
+	}
+	sos, err = cache.GetTXIDs("empty")
+	if err != nil || len(sos) != 0 {
+		t.Logf("unexpected result for empty history: %v %v", sos, err)
+		t.Fail()
+	}
+
 	_, err = cache.GetTXIDs("notexists")
 	if err != ddb.ErrNotCached {
 		t.Logf("unexpected error for not existent sourceoutput: %v", err)
@@ -191,3 +205,82 @@ func TestTXCache_Clear(t *testing.T) {
 	}
 
 }
+
+func TestTXCache_RecordCallback(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	cache, err := ddb.NewTXCache(filepath.Join(os.TempDir(), "status_trh"))
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	defer cache.Clear()
+	txid := "8750e986a296d39262dfeb12b0e5ae2f4c2aa2d4fbc4a6a8cf4bc2bb1e18e2c2"
+	err = cache.StoreTXStatus(&ddb.TXStatus{TXID: txid, Status: ddb.TXStatusSubmitted})
+	if err != nil {
+		t.Logf("failed to store status: %v", err)
+		t.FailNow()
+	}
+	err = cache.RecordCallback(&miner.Callback{CallbackTXID: txid, CallbackReason: miner.CallbackMerkleProof, BlockHash: "blockhash", BlockHeight: 153})
+	if err != nil {
+		t.Logf("failed to record callback: %v", err)
+		t.FailNow()
+	}
+	status, err := cache.RetrieveTXStatus(txid)
+	if err != nil {
+		t.Logf("failed to retrieve status: %v", err)
+		t.FailNow()
+	}
+	if status.Status != ddb.TXStatusMined || status.BlockHeight != 153 {
+		t.Logf("unexpected status: %s %d", status.Status, status.BlockHeight)
+		t.FailNow()
+	}
+	err = cache.RecordCallback(&miner.Callback{CallbackTXID: txid, CallbackReason: miner.CallbackDoubleSpendAttempt})
+	if err != nil {
+		t.Logf("failed to record late callback: %v", err)
+		t.FailNow()
+	}
+	status, err = cache.RetrieveTXStatus(txid)
+	if err != nil {
+		t.Logf("failed to retrieve status: %v", err)
+		t.FailNow()
+	}
+	if status.Status != ddb.TXStatusMined {
+		t.Logf("double spend attempt should not overwrite mined status: %s", status.Status)
+		t.FailNow()
+	}
+	_, err = cache.RetrieveTXStatus("notexists")
+	if err != ddb.ErrNotCached {
+		t.Logf("unexpected error for not existent status: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestTXCache_StoreRetrieveFees(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	cache, err := ddb.NewTXCache(filepath.Join(os.TempDir(), "fees_trh"))
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	defer cache.Clear()
+	_, err = cache.RetrieveFees()
+	if err != ddb.ErrNotCached {
+		t.Logf("unexpected error for not cached fees: %v", err)
+		t.FailNow()
+	}
+	err = cache.StoreFees(miner.DefaultFees())
+	if err != nil {
+		t.Logf("failed to store fees: %v", err)
+		t.FailNow()
+	}
+	fees, err := cache.RetrieveFees()
+	if err != nil {
+		t.Logf("failed to retrieve fees: %v", err)
+		t.FailNow()
+	}
+	std, err := fees.GetStandardFee()
+	if err != nil || std.Rate() != 500 {
+		t.Logf("unexpected standard fee: %v", err)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,758 @@
+package ddb
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/ejfhp/ddb/errs"
+	"github.com/ejfhp/ddb/keys"
+	"github.com/ejfhp/trail"
+	"github.com/ejfhp/trail/trace"
+	bolt "go.etcd.io/bbolt"
+)
+
+const cacheSaltName = "salt"
+
+//sealedPrefix marks the metadata encrypted, metadata without it are plaintext written before encryption was enabled.
+var sealedPrefix = []byte("trhenc1:")
+
+//ErrCacheLocked is returned when the cache metadata are encrypted and the key has not been set.
+var ErrCacheLocked error = fmt.Errorf("cache is encrypted, PIN needed: %w", errs.ErrWrongPassword)
+
+//sealMeta encrypts the metadata with key, if key is nil they are returned as they are.
+func sealMeta(key *[32]byte, plain []byte) ([]byte, error) {
+	if key == nil {
+		return plain, nil
+	}
+	encrypted, err := keys.AESEncrypt(*key, plain)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encrypt cache metadata: %w", err)
+	}
+	return append(append([]byte{}, sealedPrefix...), encrypted...), nil
+}
+
+//openMeta decrypts the metadata written by sealMeta, plaintext metadata are returned as they are.
+func openMeta(key *[32]byte, data []byte) ([]byte, error) {
+	if !isSealed(data) {
+		return data, nil
+	}
+	if key == nil {
+		return nil, ErrCacheLocked
+	}
+	plain, err := keys.AESDecrypt(*key, data[len(sealedPrefix):])
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt cache metadata: %w", errs.ErrWrongPassword)
+	}
+	return plain, nil
+}
+
+func isSealed(data []byte) bool {
+	return bytes.HasPrefix(data, sealedPrefix)
+}
+
+//sealName hides names, like addresses, used as keys of encrypted metadata.
+func sealName(key *[32]byte, name string) string {
+	if key == nil {
+		return name
+	}
+	mac := hmac.New(sha256.New, key[:])
+	mac.Write([]byte(name))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newCacheSalt() ([]byte, error) {
+	salt := make([]byte, 16)
+	_, err := io.ReadFull(rand.Reader, salt)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate cache salt: %w", err)
+	}
+	return salt, nil
+}
+
+//CacheSalt returns the salt used to derive the encryption key of the cache, it is created the first time.
+func (c *TXCache) CacheSalt() ([]byte, error) {
+	saltPath := c.PathOf(cacheSaltName)
+	stored, err := ioutil.ReadFile(saltPath)
+	if err == nil {
+		return hex.DecodeString(string(stored))
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error reading salt of cache dir '%s': %w", c.path, err)
+	}
+	salt, err := newCacheSalt()
+	if err != nil {
+		return nil, err
+	}
+	err = ioutil.WriteFile(saltPath, []byte(hex.EncodeToString(salt)), 0600)
+	if err != nil {
+		return nil, fmt.Errorf("error writing salt of cache dir '%s': %w", c.path, err)
+	}
+	return salt, nil
+}
+
+//Encrypted returns true if the index of the cache is encrypted.
+func (c *TXCache) Encrypted() bool {
+	stored, err := ioutil.ReadFile(c.PathOf(cacheIndexName))
+	return err == nil && isSealed(stored)
+}
+
+//SetEncryptTXs makes SetEncryptionKey encrypt the raw TXs too, so that their outputs don't tell the addresses of the cache.
+//The files of the TXs, of their status and of the chain progress are named with the HMAC of the TX ID.
+//It must be called before SetEncryptionKey, TXs once encrypted stay encrypted.
+func (c *TXCache) SetEncryptTXs(encrypt bool) {
+	c.sealTXs = encrypt
+}
+
+//EncryptsTXs returns true if the raw TXs are encrypted.
+func (c *TXCache) EncryptsTXs() bool {
+	return c.txKey() != nil
+}
+
+//txKey returns the key encrypting the raw TXs, nil if they are in plaintext.
+func (c *TXCache) txKey() *[32]byte {
+	if !c.sealTXs {
+		return nil
+	}
+	return c.key
+}
+
+//txName returns the base name of the files of the TX, the HMAC of the ID when the TXs are encrypted, so that the names don't tell which TXs are cached.
+func (c *TXCache) txName(id string) string {
+	return sealName(c.txKey(), id)
+}
+
+//txIDOfFile returns the ID of the TX in the file with the given base name.
+func (c *TXCache) txIDOfFile(base string) (string, error) {
+	stored, err := ioutil.ReadFile(c.PathOf(base))
+	if err != nil {
+		return "", fmt.Errorf("error reading tx '%s' from cache dir '%s': %w", base, c.path, err)
+	}
+	plain, err := openMeta(c.key, stored)
+	if err != nil {
+		return "", err
+	}
+	tx, err := DataTXFromBytes(plain)
+	if err != nil {
+		return "", fmt.Errorf("invalid tx '%s' in cache dir '%s': %w", base, c.path, err)
+	}
+	return tx.GetTxID(), nil
+}
+
+//readMetaFile unmarshals into v the metadata file with the given name.
+func (c *TXCache) readMetaFile(name string, v interface{}) error {
+	stored, err := ioutil.ReadFile(path.Join(c.path, name))
+	if err != nil {
+		return fmt.Errorf("error reading '%s' from cache dir '%s': %w", name, c.path, err)
+	}
+	plain, err := openMeta(c.key, stored)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(plain, v)
+	if err != nil {
+		return fmt.Errorf("error unmarshaling '%s' of cache dir '%s': %w", name, c.path, err)
+	}
+	return nil
+}
+
+//metaFileName returns the name that the status or chain progress file with the given content has with the current key.
+func (c *TXCache) metaFileName(name string, plain []byte) string {
+	var meta struct {
+		TXID string `json:"txid"`
+		ID   string `json:"id"`
+	}
+	if json.Unmarshal(plain, &meta) != nil {
+		return name
+	}
+	if strings.HasPrefix(name, chainProgressPrefix) && meta.ID != "" {
+		return chainProgressPrefix + c.txName(meta.ID) + ".trh"
+	}
+	if strings.HasPrefix(name, "status-") && meta.TXID != "" {
+		return "status-" + c.txName(meta.TXID) + ".trh"
+	}
+	return name
+}
+
+//resealTXFiles encrypts with key the TX files encrypted with oldKey, or in plaintext if nil, and names them with the HMAC of their ID.
+//TX files that cannot be decrypted are deleted, they are downloaded again when needed.
+func (c *TXCache) resealTXFiles(oldKey *[32]byte, key *[32]byte) error {
+	tr := trace.New().Source("cachecrypt.go", "TXCache", "resealTXFiles")
+	names, err := c.listNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		base := strings.TrimSuffix(name, ".trh")
+		if !strings.HasSuffix(name, ".trh") || !isTXID(base) {
+			continue
+		}
+		stored, err := ioutil.ReadFile(c.PathOf(base))
+		if err != nil {
+			return fmt.Errorf("error reading tx '%s' from cache dir '%s': %w", base, c.path, err)
+		}
+		plain, err := openMeta(oldKey, stored)
+		if err != nil {
+			return fmt.Errorf("error decrypting tx '%s' in cache dir '%s': %w", base, c.path, err)
+		}
+		tx, err := DataTXFromBytes(plain)
+		if err != nil {
+			trail.Println(trace.Warning("deleting invalid tx from cache").UTC().Add("name", name).Error(err).Append(tr))
+			os.Remove(c.PathOf(base))
+			continue
+		}
+		sealed, err := sealMeta(key, plain)
+		if err != nil {
+			return err
+		}
+		sealedName := sealName(key, tx.GetTxID())
+		err = ioutil.WriteFile(c.PathOf(sealedName), sealed, 0600)
+		if err != nil {
+			return fmt.Errorf("error encrypting tx '%s' in cache dir '%s': %w", base, c.path, err)
+		}
+		if sealedName != base {
+			os.Remove(c.PathOf(base))
+		}
+	}
+	return nil
+}
+
+//SetEncryptionKey makes the cache encrypt index, TX statuses and chain progress with key, the ones in plaintext are encrypted now.
+//Raw TXs are public and stay in plaintext, unless SetEncryptTXs is set. Returns ErrWrongPassword if the cache is already encrypted with another key.
+func (c *TXCache) SetEncryptionKey(key [32]byte) error {
+	tr := trace.New().Source("cachecrypt.go", "TXCache", "SetEncryptionKey")
+	unlock, err := c.lockIndex()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	c.key = &key
+	index, err := c.loadIndex()
+	if err != nil {
+		c.key = nil
+		return err
+	}
+	c.sealTXs = c.sealTXs || index.SealedTXs
+	if c.sealTXs && !index.SealedTXs {
+		err = c.resealTXFiles(nil, c.key)
+		if err != nil {
+			return err
+		}
+		index.SealedTXs = true
+	}
+	err = c.saveIndex(index)
+	if err != nil {
+		return err
+	}
+	names, err := c.listNames()
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".trh") || !(strings.HasPrefix(name, "status-") || strings.HasPrefix(name, chainProgressPrefix)) {
+			continue
+		}
+		stored, err := ioutil.ReadFile(path.Join(c.path, name))
+		if err != nil {
+			continue
+		}
+		plain, err := openMeta(c.key, stored)
+		if err != nil {
+			return fmt.Errorf("error decrypting '%s' in cache dir '%s': %w", name, c.path, err)
+		}
+		//Files already encrypted are renamed when the TXs are encrypted now
+		sealedName := c.metaFileName(name, plain)
+		if isSealed(stored) && sealedName == name {
+			continue
+		}
+		err = c.writeMetaFile(name, sealedName, plain)
+		if err != nil {
+			trail.Println(trace.Warning("cannot encrypt file in cache dir").UTC().Add("name", name).Error(err).Append(tr))
+			return err
+		}
+	}
+	return nil
+}
+
+//writeMetaFile encrypts the metadata file name into sealedName, the file name is deleted if renamed.
+func (c *TXCache) writeMetaFile(name string, sealedName string, plain []byte) error {
+	sealed, err := sealMeta(c.key, plain)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(path.Join(c.path, sealedName), sealed, 0600)
+	if err != nil {
+		return fmt.Errorf("error encrypting '%s' in cache dir '%s': %w", name, c.path, err)
+	}
+	if sealedName != name {
+		os.Remove(path.Join(c.path, name))
+	}
+	return nil
+}
+
+//ChangeEncryptionKey encrypts again with key the metadata encrypted with the current key, set by SetEncryptionKey.
+//Does nothing if the cache is not encrypted.
+func (c *TXCache) ChangeEncryptionKey(key [32]byte) error {
+	unlock, err := c.lockIndex()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if c.key == nil {
+		stored, err := ioutil.ReadFile(c.PathOf(cacheIndexName))
+		if err == nil && isSealed(stored) {
+			return ErrCacheLocked
+		}
+		return nil
+	}
+	index, err := c.loadIndex()
+	if err != nil {
+		return err
+	}
+	names, err := c.listNames()
+	if err != nil {
+		return err
+	}
+	plain := map[string][]byte{}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".trh") || !(strings.HasPrefix(name, "status-") || strings.HasPrefix(name, chainProgressPrefix)) {
+			continue
+		}
+		stored, err := ioutil.ReadFile(path.Join(c.path, name))
+		if err != nil {
+			continue
+		}
+		plain[name], err = openMeta(c.key, stored)
+		if err != nil {
+			return fmt.Errorf("error decrypting '%s' in cache dir '%s': %w", name, c.path, err)
+		}
+	}
+	oldKey := c.key
+	c.key = &key
+	err = c.saveIndex(index)
+	if err != nil {
+		return err
+	}
+	if index.SealedTXs {
+		err = c.resealTXFiles(oldKey, c.key)
+		if err != nil {
+			return err
+		}
+	}
+	for name, data := range plain {
+		err = c.writeMetaFile(name, c.metaFileName(name, data), data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const kvKeyCheck = "keycheck"
+
+//kvSealedTXs marks the KVCache whose raw TXs are encrypted.
+const kvSealedTXs = "sealedtxs"
+
+//isSealedBucket returns true for the buckets of KVCache holding metadata encrypted when a key is set.
+func isSealedBucket(bucket []byte) bool {
+	for _, sealed := range [][]byte{bucketTXMeta, bucketAddresses, bucketEntries, bucketStatus, bucketChains} {
+		if bytes.Equal(bucket, sealed) {
+			return true
+		}
+	}
+	return false
+}
+
+//dbKey returns the key of name in the bucket, addresses and entry hashes are hidden when the cache is encrypted,
+//the IDs of TXs and chains when the TXs are encrypted too.
+func (c *KVCache) dbKey(bucket []byte, name string) []byte {
+	return dbKeyWith(c.key, c.sealTXs, bucket, name)
+}
+
+func dbKeyWith(key *[32]byte, sealTXs bool, bucket []byte, name string) []byte {
+	if bytes.Equal(bucket, bucketAddresses) || bytes.Equal(bucket, bucketEntries) {
+		return []byte(sealName(key, name))
+	}
+	if sealTXs && isTXBucket(bucket) {
+		return []byte(sealName(key, name))
+	}
+	return []byte(name)
+}
+
+//isTXBucket returns true for the buckets of KVCache whose keys are TX IDs.
+func isTXBucket(bucket []byte) bool {
+	for _, named := range [][]byte{bucketTXs, bucketTXMeta, bucketStatus, bucketChains} {
+		if bytes.Equal(bucket, named) {
+			return true
+		}
+	}
+	return false
+}
+
+//checkKey returns ErrCacheLocked if the bucket holds metadata encrypted and the key has not been set.
+func (c *KVCache) checkKey(btx *bolt.Tx, bucket []byte) error {
+	if c.key == nil && isSealedBucket(bucket) && btx.Bucket(bucketMisc).Get([]byte(kvKeyCheck)) != nil {
+		return ErrCacheLocked
+	}
+	return nil
+}
+
+//CacheSalt returns the salt used to derive the encryption key of the cache, it is created the first time.
+func (c *KVCache) CacheSalt() ([]byte, error) {
+	var salt []byte
+	err := c.update(func(btx *bolt.Tx) error {
+		misc := btx.Bucket(bucketMisc)
+		stored := misc.Get([]byte(cacheSaltName))
+		if stored != nil {
+			salt = append([]byte{}, stored...)
+			return nil
+		}
+		var err error
+		salt, err = newCacheSalt()
+		if err != nil {
+			return err
+		}
+		return misc.Put([]byte(cacheSaltName), salt)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading salt of cache file in '%s': %w", c.path, err)
+	}
+	return salt, nil
+}
+
+//Encrypted returns true if the metadata of the cache are encrypted.
+func (c *KVCache) Encrypted() bool {
+	encrypted := false
+	c.view(func(btx *bolt.Tx) error {
+		encrypted = btx.Bucket(bucketMisc).Get([]byte(kvKeyCheck)) != nil
+		return nil
+	})
+	return encrypted
+}
+
+//SetEncryptTXs makes SetEncryptionKey encrypt the raw TXs too, so that their outputs don't tell the addresses of the cache.
+//TXs, their metadata and status and the chain progress are stored under the HMAC of the TX ID.
+//It must be called before SetEncryptionKey, TXs once encrypted stay encrypted.
+func (c *KVCache) SetEncryptTXs(encrypt bool) {
+	c.sealTXs = encrypt
+}
+
+//txKey returns the key encrypting the raw TXs, nil if they are in plaintext.
+func (c *KVCache) txKey() *[32]byte {
+	if !c.sealTXs {
+		return nil
+	}
+	return c.key
+}
+
+//storedMap returns a copy of the content of the bucket, buckets cannot be changed while iterating.
+func storedMap(bucket *bolt.Bucket) (map[string][]byte, error) {
+	stored := map[string][]byte{}
+	err := bucket.ForEach(func(k, v []byte) error {
+		stored[string(k)] = append([]byte{}, v...)
+		return nil
+	})
+	return stored, err
+}
+
+//resealTXs encrypts with key the TXs of the bucket encrypted with oldKey, or in plaintext if nil, and stores them under the HMAC of their ID.
+//TXs that cannot be decrypted are dropped, they are downloaded again when needed.
+func resealTXs(bucket *bolt.Bucket, oldKey *[32]byte, key *[32]byte) (int, error) {
+	stored, err := storedMap(bucket)
+	if err != nil {
+		return 0, err
+	}
+	for k, v := range stored {
+		err = bucket.Delete([]byte(k))
+		if err != nil {
+			return 0, err
+		}
+		plain, err := openMeta(oldKey, v)
+		if err != nil {
+			continue
+		}
+		tx, err := DataTXFromBytes(plain)
+		if err != nil {
+			continue
+		}
+		sealed, err := sealMeta(key, plain)
+		if err != nil {
+			return 0, err
+		}
+		err = bucket.Put(dbKeyWith(key, true, bucketTXs, tx.GetTxID()), sealed)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(stored), nil
+}
+
+//rekeyTXMetas encrypts with key the metadata, status and chain progress of the TXs encrypted with oldKey and stores them under the keys they have with key.
+func rekeyTXMetas(btx *bolt.Tx, oldKey *[32]byte, key *[32]byte, sealTXs bool) error {
+	for _, bucketName := range [][]byte{bucketTXMeta, bucketStatus, bucketChains} {
+		bucket := btx.Bucket(bucketName)
+		stored, err := storedMap(bucket)
+		if err != nil {
+			return err
+		}
+		for k, v := range stored {
+			plain, err := openMeta(oldKey, v)
+			if err != nil {
+				return err
+			}
+			id, plain, err := txIDOfMeta(bucketName, k, plain)
+			if err != nil {
+				return err
+			}
+			sealed, err := sealMeta(key, plain)
+			if err != nil {
+				return err
+			}
+			err = bucket.Delete([]byte(k))
+			if err != nil {
+				return err
+			}
+			err = bucket.Put(dbKeyWith(key, sealTXs, bucketName, id), sealed)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+//txIDOfMeta returns the ID of the TX, or chain, of the metadata stored with key k in the bucket.
+//Metadata written before the ID was kept in them get it from the key, that is the ID.
+func txIDOfMeta(bucket []byte, k string, plain []byte) (string, []byte, error) {
+	switch {
+	case bytes.Equal(bucket, bucketTXMeta):
+		meta := TXMeta{}
+		err := json.Unmarshal(plain, &meta)
+		if err != nil {
+			return "", nil, fmt.Errorf("error unmarshaling metadata of tx '%s': %w", k, err)
+		}
+		if meta.TXID != "" {
+			return meta.TXID, plain, nil
+		}
+		meta.TXID = k
+		plain, err = json.Marshal(&meta)
+		return k, plain, err
+	case bytes.Equal(bucket, bucketStatus):
+		status := TXStatus{}
+		err := json.Unmarshal(plain, &status)
+		if err != nil {
+			return "", nil, fmt.Errorf("error unmarshaling status of tx '%s': %w", k, err)
+		}
+		return status.TXID, plain, nil
+	default:
+		progress := ChainProgress{}
+		err := json.Unmarshal(plain, &progress)
+		if err != nil {
+			return "", nil, fmt.Errorf("error unmarshaling progress of chain '%s': %w", k, err)
+		}
+		return progress.ID, plain, nil
+	}
+}
+
+//SetEncryptionKey makes the cache encrypt the metadata with key, the ones in plaintext are encrypted now.
+//Addresses and entry hashes are stored as HMAC, raw TXs are public and stay in plaintext, unless SetEncryptTXs is set.
+//Returns ErrWrongPassword if the cache is already encrypted with another key.
+func (c *KVCache) SetEncryptionKey(key [32]byte) error {
+	encrypted := 0
+	sealTXsWanted := c.sealTXs
+	err := c.update(func(btx *bolt.Tx) error {
+		misc := btx.Bucket(bucketMisc)
+		check := misc.Get([]byte(kvKeyCheck))
+		if check != nil {
+			_, err := openMeta(&key, check)
+			if err != nil {
+				return err
+			}
+		} else {
+			sealed, err := sealMeta(&key, []byte(kvKeyCheck))
+			if err != nil {
+				return err
+			}
+			err = misc.Put([]byte(kvKeyCheck), sealed)
+			if err != nil {
+				return err
+			}
+		}
+		c.key = &key
+		//Keys as they are in the file until the TXs are encrypted
+		sealedTXs := misc.Get([]byte(kvSealedTXs)) != nil
+		sealTXs := c.sealTXs || sealedTXs
+		c.sealTXs = sealedTXs
+		for _, name := range [][]byte{bucketTXMeta, bucketAddresses, bucketEntries, bucketStatus, bucketChains} {
+			bucket := btx.Bucket(name)
+			plain := map[string][]byte{}
+			err := bucket.ForEach(func(k, v []byte) error {
+				if !isSealed(v) {
+					plain[string(k)] = append([]byte{}, v...)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for k, v := range plain {
+				sealed, err := sealMeta(c.key, v)
+				if err != nil {
+					return err
+				}
+				err = bucket.Delete([]byte(k))
+				if err != nil {
+					return err
+				}
+				err = bucket.Put(c.dbKey(name, k), sealed)
+				if err != nil {
+					return err
+				}
+				encrypted++
+			}
+		}
+		if sealTXs && !sealedTXs {
+			sealed, err := resealTXs(btx.Bucket(bucketTXs), nil, c.key)
+			if err != nil {
+				return err
+			}
+			err = rekeyTXMetas(btx, c.key, c.key, true)
+			if err != nil {
+				return err
+			}
+			encrypted += sealed
+			c.sealTXs = true
+			return misc.Put([]byte(kvSealedTXs), []byte{1})
+		}
+		return nil
+	})
+	if err != nil {
+		c.key = nil
+		c.sealTXs = sealTXsWanted
+		return fmt.Errorf("error encrypting cache file in '%s': %w", c.path, err)
+	}
+	if encrypted > 0 {
+		return c.compact()
+	}
+	return nil
+}
+
+//ChangeEncryptionKey encrypts again with key the metadata encrypted with the current key, set by SetEncryptionKey.
+//The HMAC of the entry hashes cannot be reversed, the entry index is dropped and rebuilt by the next stores.
+//Does nothing if the cache is not encrypted.
+func (c *KVCache) ChangeEncryptionKey(key [32]byte) error {
+	if c.key == nil {
+		if c.Encrypted() {
+			return ErrCacheLocked
+		}
+		return nil
+	}
+	oldKey := c.key
+	err := c.update(func(btx *bolt.Tx) error {
+		sealed, err := sealMeta(&key, []byte(kvKeyCheck))
+		if err != nil {
+			return err
+		}
+		err = btx.Bucket(bucketMisc).Put([]byte(kvKeyCheck), sealed)
+		if err != nil {
+			return err
+		}
+		if btx.Bucket(bucketMisc).Get([]byte(kvSealedTXs)) != nil {
+			_, err = resealTXs(btx.Bucket(bucketTXs), oldKey, &key)
+			if err != nil {
+				return err
+			}
+		}
+		err = rekeyTXMetas(btx, oldKey, &key, c.sealTXs)
+		if err != nil {
+			return err
+		}
+		for _, bucketName := range [][]byte{bucketAddresses, bucketEntries} {
+			bucket := btx.Bucket(bucketName)
+			stored := map[string][]byte{}
+			err := bucket.ForEach(func(k, v []byte) error {
+				stored[string(k)] = append([]byte{}, v...)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			for k, v := range stored {
+				err = bucket.Delete([]byte(k))
+				if err != nil {
+					return err
+				}
+				if bytes.Equal(bucketName, bucketEntries) {
+					continue
+				}
+				plain, err := openMeta(oldKey, v)
+				if err != nil {
+					return err
+				}
+				name := k
+				if bytes.Equal(bucketName, bucketAddresses) {
+					info := AddressInfo{}
+					err = json.Unmarshal(plain, &info)
+					if err != nil {
+						return fmt.Errorf("error unmarshaling address info: %w", err)
+					}
+					name = info.Address
+				}
+				resealed, err := sealMeta(&key, plain)
+				if err != nil {
+					return err
+				}
+				err = bucket.Put(dbKeyWith(&key, c.sealTXs, bucketName, name), resealed)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error encrypting cache file in '%s': %w", c.path, err)
+	}
+	c.key = &key
+	return c.compact()
+}
+
+//compact rewrites the cache file with the live data only, the free pages of the old file still hold the metadata in plaintext.
+func (c *KVCache) compact() error {
+	dbPath := filepath.Join(c.path, kvCacheFile)
+	os.Remove(dbPath + ".tmp")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	//The file is locked for writing during the whole compaction, so that nothing is written to the old file meanwhile
+	src, err := c.open(false)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := bolt.Open(dbPath+".tmp", 0600, kvOptions)
+	if err != nil {
+		return fmt.Errorf("error creating compacted cache file in '%s': %w", c.path, err)
+	}
+	err = bolt.Compact(dst, src, 0)
+	dst.Close()
+	if err != nil {
+		os.Remove(dbPath + ".tmp")
+		return fmt.Errorf("error compacting cache file in '%s': %w", c.path, err)
+	}
+	err = os.Rename(dbPath+".tmp", dbPath)
+	if err != nil {
+		return fmt.Errorf("error replacing cache file in '%s': %w", c.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,332 @@
+package ddb_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/errs"
+)
+
+func TestTXCache_Encryption(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	dir := filepath.Join(os.TempDir(), "encrypted_trh")
+	defer os.RemoveAll(dir)
+	cache, err := ddb.NewTXCache(dir)
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	address := "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	tx := Helper_FakeTX(t)
+	err = cache.StoreTX(tx.GetTxID(), tx.ToBytes())
+	if err != nil {
+		t.Logf("failed to store tx: %v", err)
+		t.FailNow()
+	}
+	err = cache.StoreTXIDs(address, []string{tx.GetTxID()})
+	if err != nil {
+		t.Logf("failed to store txids: %v", err)
+		t.FailNow()
+	}
+	err = cache.StoreTXStatus(&ddb.TXStatus{TXID: tx.GetTxID(), Status: ddb.TXStatusSubmitted})
+	if err != nil {
+		t.Logf("failed to store status: %v", err)
+		t.FailNow()
+	}
+	err = cache.SetEncryptionKey([32]byte{1, 2, 3})
+	if err != nil {
+		t.Logf("failed to set key: %v", err)
+		t.FailNow()
+	}
+	index, _ := ioutil.ReadFile(cache.PathOf("index"))
+	status, _ := ioutil.ReadFile(cache.PathOf("status-" + tx.GetTxID()))
+	if strings.Contains(string(index), address) || strings.Contains(string(status), ddb.TXStatusSubmitted) || !cache.Encrypted() {
+		t.Logf("metadata still in plaintext")
+		t.FailNow()
+	}
+	txids, err := cache.GetTXIDs(address)
+	if err != nil || len(txids) != 1 {
+		t.Logf("unexpected txids with key: %v %v", txids, err)
+		t.FailNow()
+	}
+	locked, _ := ddb.NewTXCache(dir)
+	_, err = locked.GetTXIDs(address)
+	if !errors.Is(err, ddb.ErrCacheLocked) {
+		t.Logf("unexpected error without key: %v", err)
+		t.FailNow()
+	}
+	stored, err := locked.RetrieveTX(tx.GetTxID())
+	if err != nil || string(stored) != string(tx.ToBytes()) {
+		t.Logf("raw txs should stay in plaintext: %v", err)
+		t.FailNow()
+	}
+	err = locked.SetEncryptionKey([32]byte{3, 2, 1})
+	if !errors.Is(err, errs.ErrWrongPassword) {
+		t.Logf("unexpected error with wrong key: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestKVCache_Encryption(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "encrypted_kv_trh")
+	defer os.RemoveAll(dir)
+	cache, err := ddb.NewKVCache(dir)
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	address := "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	err = cache.StoreTXIDs(address, []string{"a", "b"})
+	if err != nil {
+		t.Logf("failed to store txids: %v", err)
+		t.FailNow()
+	}
+	err = cache.IndexEntry("entryhash", []string{"a"})
+	if err != nil {
+		t.Logf("failed to index entry: %v", err)
+		t.FailNow()
+	}
+	salt, err := cache.CacheSalt()
+	if err != nil || len(salt) == 0 {
+		t.Logf("failed to get salt: %v", err)
+		t.FailNow()
+	}
+	err = cache.SetEncryptionKey([32]byte{1, 2, 3})
+	if err != nil {
+		t.Logf("failed to set key: %v", err)
+		t.FailNow()
+	}
+	txids, err := cache.GetTXIDs(address)
+	if err != nil || len(txids) != 2 {
+		t.Logf("unexpected txids with key: %v %v", txids, err)
+		t.FailNow()
+	}
+	txids, err = cache.EntryTXIDs("entryhash")
+	if err != nil || len(txids) != 1 {
+		t.Logf("unexpected entry txids with key: %v %v", txids, err)
+		t.FailNow()
+	}
+	cache.Close()
+	raw, _ := ioutil.ReadFile(filepath.Join(dir, "cache.db"))
+	if strings.Contains(string(raw), address) || strings.Contains(string(raw), "entryhash") {
+		t.Logf("metadata still in plaintext")
+		t.FailNow()
+	}
+	locked, err := ddb.NewKVCache(dir)
+	if err != nil {
+		t.Logf("failed to reopen cache: %v", err)
+		t.FailNow()
+	}
+	defer locked.Close()
+	if !locked.Encrypted() {
+		t.Logf("cache should be encrypted")
+		t.FailNow()
+	}
+	_, err = locked.GetTXIDs(address)
+	if !errors.Is(err, ddb.ErrCacheLocked) {
+		t.Logf("unexpected error without key: %v", err)
+		t.FailNow()
+	}
+	err = locked.SetEncryptionKey([32]byte{3, 2, 1})
+	if !errors.Is(err, errs.ErrWrongPassword) {
+		t.Logf("unexpected error with wrong key: %v", err)
+		t.FailNow()
+	}
+	err = locked.SetEncryptionKey([32]byte{1, 2, 3})
+	if err != nil {
+		t.Logf("failed to unlock with right key: %v", err)
+		t.FailNow()
+	}
+	txids, err = locked.GetTXIDs(address)
+	if err != nil || len(txids) != 2 {
+		t.Logf("unexpected txids after unlock: %v %v", txids, err)
+		t.FailNow()
+	}
+}
+
+func TestCache_ChangeEncryptionKey(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "rekey_trh")
+	defer os.RemoveAll(dir)
+	address := "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	tx := Helper_FakeTX(t)
+	open := map[string]func() (ddb.Cache, error){
+		"file": func() (ddb.Cache, error) { return ddb.NewTXCache(filepath.Join(dir, "file")) },
+		"kv":   func() (ddb.Cache, error) { return ddb.NewKVCache(filepath.Join(dir, "kv")) },
+	}
+	for name, openCache := range open {
+		cache, err := openCache()
+		if err != nil {
+			t.Logf("%s - failed to create cache: %v", name, err)
+			t.FailNow()
+		}
+		err = cache.StoreTXIDs(address, []string{tx.GetTxID()})
+		if err != nil {
+			t.Logf("%s - failed to store txids: %v", name, err)
+			t.FailNow()
+		}
+		err = cache.StoreTXStatus(&ddb.TXStatus{TXID: tx.GetTxID(), Status: ddb.TXStatusSubmitted})
+		if err != nil {
+			t.Logf("%s - failed to store status: %v", name, err)
+			t.FailNow()
+		}
+		err = cache.SetEncryptionKey([32]byte{1, 2, 3})
+		if err != nil {
+			t.Logf("%s - failed to set key: %v", name, err)
+			t.FailNow()
+		}
+		err = cache.ChangeEncryptionKey([32]byte{4, 5, 6})
+		if err != nil {
+			t.Logf("%s - failed to change key: %v", name, err)
+			t.FailNow()
+		}
+		cache.Close()
+		reopened, err := openCache()
+		if err != nil {
+			t.Logf("%s - failed to reopen cache: %v", name, err)
+			t.FailNow()
+		}
+		err = reopened.SetEncryptionKey([32]byte{1, 2, 3})
+		if !errors.Is(err, errs.ErrWrongPassword) {
+			t.Logf("%s - old key should not open the cache: %v", name, err)
+			t.FailNow()
+		}
+		err = reopened.SetEncryptionKey([32]byte{4, 5, 6})
+		if err != nil {
+			t.Logf("%s - failed to set new key: %v", name, err)
+			t.FailNow()
+		}
+		txids, err := reopened.GetTXIDs(address)
+		if err != nil || len(txids) != 1 {
+			t.Logf("%s - unexpected txids with new key: %v %v", name, txids, err)
+			t.FailNow()
+		}
+		status, err := reopened.RetrieveTXStatus(tx.GetTxID())
+		if err != nil || status.Status != ddb.TXStatusSubmitted {
+			t.Logf("%s - unexpected status with new key: %v %v", name, status, err)
+			t.FailNow()
+		}
+		reopened.Close()
+	}
+}
+
+func TestCache_EncryptTXs(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	dir := filepath.Join(os.TempDir(), "sealedtxs_trh")
+	defer os.RemoveAll(dir)
+	stored, added := Helper_FakeTX(t), Helper_FakeTX(t)
+	open := map[string]func() (ddb.Cache, error){
+		"file": func() (ddb.Cache, error) { return ddb.NewTXCache(filepath.Join(dir, "file")) },
+		"kv":   func() (ddb.Cache, error) { return ddb.NewKVCache(filepath.Join(dir, "kv")) },
+	}
+	for name, openCache := range open {
+		cache, err := openCache()
+		if err != nil {
+			t.Logf("%s - failed to create cache: %v", name, err)
+			t.FailNow()
+		}
+		err = cache.StoreTX(stored.GetTxID(), stored.ToBytes())
+		if err != nil {
+			t.Logf("%s - failed to store tx: %v", name, err)
+			t.FailNow()
+		}
+		err = cache.StoreTXStatus(&ddb.TXStatus{TXID: stored.GetTxID(), Status: ddb.TXStatusSubmitted})
+		if err != nil {
+			t.Logf("%s - failed to store tx status: %v", name, err)
+			t.FailNow()
+		}
+		err = cache.StoreChainProgress(&ddb.ChainProgress{ID: stored.GetTxID(), TXs: []string{}})
+		if err != nil {
+			t.Logf("%s - failed to store chain progress: %v", name, err)
+			t.FailNow()
+		}
+		cache.SetEncryptTXs(true)
+		err = cache.SetEncryptionKey([32]byte{1, 2, 3})
+		if err != nil {
+			t.Logf("%s - failed to set key: %v", name, err)
+			t.FailNow()
+		}
+		err = cache.StoreTX(added.GetTxID(), added.ToBytes())
+		if err != nil {
+			t.Logf("%s - failed to store tx: %v", name, err)
+			t.FailNow()
+		}
+		files, err := ioutil.ReadDir(cache.DirPath())
+		if err != nil {
+			t.Logf("%s - failed to list cache dir: %v", name, err)
+			t.FailNow()
+		}
+		for _, f := range files {
+			content, _ := ioutil.ReadFile(filepath.Join(cache.DirPath(), f.Name()))
+			for _, tx := range []*ddb.DataTX{stored, added} {
+				if strings.Contains(string(content), string(tx.ToBytes())) {
+					t.Logf("%s - tx %s still in plaintext in %s", name, tx.GetTxID(), f.Name())
+					t.FailNow()
+				}
+				if strings.Contains(f.Name(), tx.GetTxID()) || strings.Contains(string(content), tx.GetTxID()) {
+					t.Logf("%s - ID of tx %s not hidden in %s", name, tx.GetTxID(), f.Name())
+					t.FailNow()
+				}
+			}
+		}
+		report, err := cache.Verify()
+		if err != nil || report.Checked != 2 || len(report.Corrupted) != 0 || len(report.Missing) != 0 {
+			t.Logf("%s - unexpected verify report: %v %v", name, report, err)
+			t.FailNow()
+		}
+		err = cache.ChangeEncryptionKey([32]byte{4, 5, 6})
+		if err != nil {
+			t.Logf("%s - failed to change key: %v", name, err)
+			t.FailNow()
+		}
+		cache.Close()
+		//TXs once encrypted stay encrypted, without SetEncryptTXs too
+		reopened, err := openCache()
+		if err != nil {
+			t.Logf("%s - failed to reopen cache: %v", name, err)
+			t.FailNow()
+		}
+		err = reopened.SetEncryptionKey([32]byte{4, 5, 6})
+		if err != nil {
+			t.Logf("%s - failed to set new key: %v", name, err)
+			t.FailNow()
+		}
+		for _, tx := range []*ddb.DataTX{stored, added} {
+			raw, err := reopened.RetrieveTX(tx.GetTxID())
+			if err != nil || string(raw) != string(tx.ToBytes()) {
+				t.Logf("%s - failed to retrieve encrypted tx: %v", name, err)
+				t.FailNow()
+			}
+		}
+		status, err := reopened.RetrieveTXStatus(stored.GetTxID())
+		if err != nil || status.Status != ddb.TXStatusSubmitted {
+			t.Logf("%s - failed to retrieve tx status: %v", name, err)
+			t.FailNow()
+		}
+		chains, err := reopened.ListChainProgress()
+		if err != nil || len(chains) != 1 || chains[0] != stored.GetTxID() {
+			t.Logf("%s - unexpected chains: %v %v", name, chains, err)
+			t.FailNow()
+		}
+		_, err = reopened.RetrieveChainProgress(stored.GetTxID())
+		if err != nil {
+			t.Logf("%s - failed to retrieve chain progress: %v", name, err)
+			t.FailNow()
+		}
+		removed, err := reopened.Prune(1)
+		if err != nil || len(removed) != 2 || (removed[0] != stored.GetTxID() && removed[1] != stored.GetTxID()) {
+			t.Logf("%s - encrypted txs should be evicted: %v %v", name, removed, err)
+			t.FailNow()
+		}
+		_, err = reopened.RetrieveTX(stored.GetTxID())
+		if !errors.Is(err, ddb.ErrNotCached) {
+			t.Logf("%s - evicted tx still in cache: %v", name, err)
+			t.FailNow()
+		}
+		reopened.Close()
+	}
+}
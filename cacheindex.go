@@ -0,0 +1,458 @@
+package ddb
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ejfhp/trail"
+	"github.com/ejfhp/trail/trace"
+)
+
+const cacheIndexName = "index"
+
+const (
+	//indexLockTimeout is how long to wait for the index locked by another process.
+	indexLockTimeout = 5 * time.Second
+	//indexLockStale is the age after which a lock is considered left by a crashed process.
+	indexLockStale = 30 * time.Second
+)
+
+//DefaultCacheMaxBytes is the size the cache is pruned to when no other limit is given.
+var DefaultCacheMaxBytes int64 = 100 << 20
+
+//CacheIndex is the metadata of the cache: history of the addresses, cached TXs and TXs storing each entry.
+//SealedTXs is true once the raw TXs are encrypted.
+type CacheIndex struct {
+	Addresses map[string]*AddressInfo `json:"addresses"`
+	TXs       map[string]*TXMeta      `json:"txs"`
+	Entries   map[string][]string     `json:"entries"`
+	SealedTXs bool                    `json:"sealedtxs,omitempty"`
+}
+
+//TXMeta describes a cached TX, Height is 0 until the TX is known to be mined.
+//Used is the last read time kept by KVCache, TXCache uses the modification time of the file.
+//TXID is kept by KVCache, whose keys are the HMAC of the IDs when the TXs are encrypted.
+type TXMeta struct {
+	TXID   string `json:"txid,omitempty"`
+	Size   int    `json:"size"`
+	Height int    `json:"height"`
+	Added  int64  `json:"added"`
+	Used   int64  `json:"used,omitempty"`
+}
+
+//CacheStats summarizes the content of the cache.
+type CacheStats struct {
+	TXs         int   `json:"txs"`
+	Confirmed   int   `json:"confirmed"`
+	Unconfirmed int   `json:"unconfirmed"`
+	Bytes       int64 `json:"bytes"`
+	Addresses   int   `json:"addresses"`
+	Entries     int   `json:"entries"`
+}
+
+//CacheReport is the result of the verification of the cached TXs.
+type CacheReport struct {
+	Checked   int      `json:"checked"`
+	Indexed   int      `json:"indexed"`
+	Missing   []string `json:"missing"`
+	Corrupted []string `json:"corrupted"`
+}
+
+func newCacheIndex() *CacheIndex {
+	return &CacheIndex{Addresses: map[string]*AddressInfo{}, TXs: map[string]*TXMeta{}, Entries: map[string][]string{}}
+}
+
+//SetMaxBytes sets the size above which stored TXs are evicted, 0 means no limit.
+func (c *TXCache) SetMaxBytes(maxBytes int64) {
+	c.maxBytes = maxBytes
+}
+
+//SetTXHeight records the height of the block including the TX, TXs not in cache are ignored.
+func (c *TXCache) SetTXHeight(id string, height int) error {
+	return c.updateIndex(func(index *CacheIndex) bool {
+		meta, ok := index.TXs[id]
+		if !ok || meta.Height == height {
+			return false
+		}
+		meta.Height = height
+		return true
+	})
+}
+
+//IndexEntry records the TXs storing the entry with the given hash.
+func (c *TXCache) IndexEntry(hash string, txids []string) error {
+	return c.updateIndex(func(index *CacheIndex) bool {
+		index.Entries[hash] = mergeTXIDs(index.Entries[hash], txids)
+		return true
+	})
+}
+
+//EntryTXIDs returns the TXs storing the entry with the given hash, ErrNotCached if the entry is unknown.
+func (c *TXCache) EntryTXIDs(hash string) ([]string, error) {
+	unlock, err := c.lockIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	index, err := c.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	txids, ok := index.Entries[hash]
+	if !ok || len(txids) == 0 {
+		return nil, ErrNotCached
+	}
+	return txids, nil
+}
+
+//Stats returns the number and size of the cached TXs and the number of addresses and entries indexed.
+func (c *TXCache) Stats() (*CacheStats, error) {
+	unlock, err := c.lockIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	index, err := c.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	stats := CacheStats{TXs: len(index.TXs), Addresses: len(index.Addresses), Entries: len(index.Entries)}
+	for _, meta := range index.TXs {
+		stats.Bytes += int64(meta.Size)
+		if meta.Height > 0 {
+			stats.Confirmed++
+		} else {
+			stats.Unconfirmed++
+		}
+	}
+	return &stats, nil
+}
+
+//Verify recomputes the ID of every cached TX. TX files not indexed are added to the index, indexed TXs without file are removed from it.
+//Corrupted TXs are only reported, Prune deletes them.
+func (c *TXCache) Verify() (*CacheReport, error) {
+	tr := trace.New().Source("cacheindex.go", "TXCache", "Verify")
+	unlock, err := c.lockIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	index, err := c.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	names, err := c.listNames()
+	if err != nil {
+		return nil, err
+	}
+	report := CacheReport{Missing: []string{}, Corrupted: []string{}}
+	ids := make(map[string]bool, len(index.TXs))
+	for id := range index.TXs {
+		ids[id] = true
+	}
+	named := make(map[string]bool, len(ids))
+	for id := range ids {
+		named[c.txName(id)] = true
+	}
+	for _, name := range names {
+		base := strings.TrimSuffix(name, ".trh")
+		if !isTXID(base) || named[base] {
+			continue
+		}
+		if c.txKey() == nil {
+			ids[base] = true
+			continue
+		}
+		//Names are hidden, the ID of a TX not indexed is read from the TX
+		id, err := c.txIDOfFile(base)
+		if err != nil {
+			trail.Println(trace.Warning("deleting unreadable tx from cache").UTC().Add("name", name).Error(err).Append(tr))
+			os.Remove(c.PathOf(base))
+			report.Corrupted = append(report.Corrupted, base)
+			continue
+		}
+		ids[id] = true
+	}
+	for id := range ids {
+		bytes, err := ioutil.ReadFile(c.PathOf(c.txName(id)))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				report.Missing = append(report.Missing, id)
+				delete(index.TXs, id)
+				continue
+			}
+			return nil, fmt.Errorf("error reading tx '%s' from cache dir '%s': %w", id, c.path, err)
+		}
+		report.Checked++
+		bytes, err = openMeta(c.key, bytes)
+		if err != nil || !txMatches(id, bytes) {
+			trail.Println(trace.Warning("corrupted tx in cache").UTC().Add("id", id).Append(tr))
+			report.Corrupted = append(report.Corrupted, id)
+		}
+		if _, ok := index.TXs[id]; !ok {
+			index.TXs[id] = &TXMeta{Size: len(bytes), Added: time.Now().Unix()}
+			report.Indexed++
+		}
+	}
+	if report.Indexed > 0 || len(report.Missing) > 0 {
+		err = c.saveIndex(index)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &report, nil
+}
+
+//Prune deletes the corrupted TXs and then evicts TXs until the cache is not bigger than maxBytes.
+//Unconfirmed TXs are evicted first, least recently used first. Returns the IDs of the TXs deleted.
+func (c *TXCache) Prune(maxBytes int64) ([]string, error) {
+	report, err := c.Verify()
+	if err != nil {
+		return nil, err
+	}
+	unlock, err := c.lockIndex()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	index, err := c.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	removed := []string{}
+	for _, id := range report.Corrupted {
+		err = c.removeTX(index, id)
+		if err != nil {
+			return removed, err
+		}
+		removed = append(removed, id)
+	}
+	evicted, err := c.evict(index, maxBytes)
+	removed = append(removed, evicted...)
+	if err != nil {
+		return removed, err
+	}
+	return removed, c.saveIndex(index)
+}
+
+//evict deletes the TXs exceeding maxBytes, the index is updated but not saved.
+func (c *TXCache) evict(index *CacheIndex, maxBytes int64) ([]string, error) {
+	tr := trace.New().Source("cacheindex.go", "TXCache", "evict")
+	ids := evictionOrder(index.TXs, maxBytes, func(id string, meta *TXMeta) time.Time {
+		info, err := os.Stat(c.PathOf(c.txName(id)))
+		if err != nil {
+			return time.Unix(meta.Added, 0)
+		}
+		return info.ModTime()
+	})
+	evicted := []string{}
+	for _, id := range ids {
+		err := c.removeTX(index, id)
+		if err != nil {
+			return evicted, err
+		}
+		evicted = append(evicted, id)
+	}
+	if len(evicted) > 0 {
+		trail.Println(trace.Info("evicted TXs from cache").UTC().Add("evicted", fmt.Sprintf("%d", len(evicted))).Append(tr))
+	}
+	return evicted, nil
+}
+
+//evictionOrder returns the IDs of the TXs to delete so that the total size is not bigger than maxBytes.
+//Unconfirmed TXs go first, then the least recently used. The use time is asked only if something has to be evicted.
+func evictionOrder(txs map[string]*TXMeta, maxBytes int64, used func(id string, meta *TXMeta) time.Time) []string {
+	type candidate struct {
+		id       string
+		size     int64
+		height   int
+		accessed time.Time
+	}
+	ids := []string{}
+	total := int64(0)
+	for _, meta := range txs {
+		total += int64(meta.Size)
+	}
+	if maxBytes <= 0 || total <= maxBytes {
+		return ids
+	}
+	candidates := make([]candidate, 0, len(txs))
+	for id, meta := range txs {
+		candidates = append(candidates, candidate{id: id, size: int64(meta.Size), height: meta.Height, accessed: used(id, meta)})
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if (candidates[i].height == 0) != (candidates[j].height == 0) {
+			return candidates[i].height == 0
+		}
+		return candidates[i].accessed.Before(candidates[j].accessed)
+	})
+	for _, cand := range candidates {
+		if total <= maxBytes {
+			break
+		}
+		total -= cand.size
+		ids = append(ids, cand.id)
+	}
+	return ids
+}
+
+func (c *TXCache) removeTX(index *CacheIndex, id string) error {
+	err := os.Remove(c.PathOf(c.txName(id)))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error deleting tx '%s' from cache dir '%s': %w", id, c.path, err)
+	}
+	delete(index.TXs, id)
+	return nil
+}
+
+//touch marks the TX as used now, the modification time of the file is the LRU clock.
+func (c *TXCache) touch(id string) {
+	now := time.Now()
+	os.Chtimes(c.PathOf(c.txName(id)), now, now)
+}
+
+//updateIndex loads the index, applies change and saves the index if change returns true.
+func (c *TXCache) updateIndex(change func(index *CacheIndex) bool) error {
+	unlock, err := c.lockIndex()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	index, err := c.loadIndex()
+	if err != nil {
+		return err
+	}
+	if !change(index) {
+		return nil
+	}
+	return c.saveIndex(index)
+}
+
+//lockIndex locks the index for this process, with the mutex, and for the other processes using the same cache dir, with a lock file.
+//The returned function releases both locks.
+func (c *TXCache) lockIndex() (func(), error) {
+	tr := trace.New().Source("cacheindex.go", "TXCache", "lockIndex")
+	c.mu.Lock()
+	lockPath := c.PathOf(cacheIndexName) + ".lock"
+	deadline := time.Now().Add(indexLockTimeout)
+	for {
+		lock, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			lock.Close()
+			return func() {
+				os.Remove(lockPath)
+				c.mu.Unlock()
+			}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			c.mu.Unlock()
+			return nil, fmt.Errorf("error locking index of cache dir '%s': %w", c.path, err)
+		}
+		info, err := os.Stat(lockPath)
+		if err == nil && time.Since(info.ModTime()) > indexLockStale {
+			trail.Println(trace.Warning("removing stale index lock").UTC().Add("path", lockPath).Append(tr))
+			os.Remove(lockPath)
+			continue
+		}
+		if time.Now().After(deadline) {
+			c.mu.Unlock()
+			return nil, fmt.Errorf("index of cache dir '%s' is in use by another process", c.path)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+//loadIndex reads the index from disk, so that it is shared with other processes using the same cache dir.
+//The caller holds the lock of lockIndex.
+func (c *TXCache) loadIndex() (*CacheIndex, error) {
+	bytes, err := ioutil.ReadFile(c.PathOf(cacheIndexName))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return newCacheIndex(), nil
+		}
+		return nil, fmt.Errorf("error reading index of cache dir '%s': %w", c.path, err)
+	}
+	bytes, err = openMeta(c.key, bytes)
+	if err != nil {
+		return nil, err
+	}
+	index := newCacheIndex()
+	err = json.Unmarshal(bytes, index)
+	if err != nil {
+		return nil, fmt.Errorf("error unmarshaling index of cache dir '%s': %w", c.path, err)
+	}
+	return index, nil
+}
+
+//saveIndex writes the index to a temporary file and renames it, so the index on disk is never half written.
+func (c *TXCache) saveIndex(index *CacheIndex) error {
+	bytes, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("error marshaling index of cache dir '%s': %w", c.path, err)
+	}
+	bytes, err = sealMeta(c.key, bytes)
+	if err != nil {
+		return err
+	}
+	indexPath := c.PathOf(cacheIndexName)
+	err = ioutil.WriteFile(indexPath+".tmp", bytes, 0600)
+	if err != nil {
+		return fmt.Errorf("error writing index of cache dir '%s': %w", c.path, err)
+	}
+	err = os.Rename(indexPath+".tmp", indexPath)
+	if err != nil {
+		return fmt.Errorf("error replacing index of cache dir '%s': %w", c.path, err)
+	}
+	return nil
+}
+
+func (c *TXCache) listNames() ([]string, error) {
+	dir, err := os.Open(c.path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening cache dir '%s': %w", c.path, err)
+	}
+	defer dir.Close()
+	names, err := dir.Readdirnames(-1)
+	if err != nil {
+		return nil, fmt.Errorf("error listiing files in cache dir '%s': %w", c.path, err)
+	}
+	return names, nil
+}
+
+//txMatches returns true if the bytes are a TX with the given ID.
+func txMatches(id string, bytes []byte) bool {
+	tx, err := DataTXFromBytes(bytes)
+	if err != nil {
+		return false
+	}
+	return tx.GetTxID() == id
+}
+
+func isTXID(name string) bool {
+	if len(name) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+func mergeTXIDs(existing []string, incoming []string) []string {
+	known := make(map[string]bool, len(existing))
+	for _, id := range existing {
+		known[id] = true
+	}
+	for _, id := range incoming {
+		if !known[id] {
+			existing = append(existing, id)
+			known[id] = true
+		}
+	}
+	return existing
+}
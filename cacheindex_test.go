@@ -0,0 +1,203 @@
+package ddb_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ejfhp/ddb"
+)
+
+func TestTXCache_Index(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	cache, err := ddb.NewTXCache(filepath.Join(os.TempDir(), "index_trh"))
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	defer cache.Clear()
+	tx1, tx2 := Helper_FakeTX(t), Helper_FakeTX(t)
+	for _, tx := range []*ddb.DataTX{tx1, tx2} {
+		err = cache.StoreTX(tx.GetTxID(), tx.ToBytes())
+		if err != nil {
+			t.Logf("failed to store tx: %v", err)
+			t.FailNow()
+		}
+	}
+	err = cache.SetTXHeight(tx1.GetTxID(), 715000)
+	if err != nil {
+		t.Logf("failed to set height: %v", err)
+		t.FailNow()
+	}
+	err = cache.IndexEntry("entryhash", []string{tx1.GetTxID(), tx2.GetTxID()})
+	if err != nil {
+		t.Logf("failed to index entry: %v", err)
+		t.FailNow()
+	}
+	err = cache.StoreTXIDs("1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X", []string{tx1.GetTxID()})
+	if err != nil {
+		t.Logf("failed to store txids: %v", err)
+		t.FailNow()
+	}
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Logf("failed to get stats: %v", err)
+		t.FailNow()
+	}
+	if stats.TXs != 2 || stats.Confirmed != 1 || stats.Unconfirmed != 1 || stats.Addresses != 1 || stats.Entries != 1 {
+		t.Logf("unexpected stats: %+v", stats)
+		t.FailNow()
+	}
+	if stats.Bytes != int64(len(tx1.ToBytes())+len(tx2.ToBytes())) {
+		t.Logf("unexpected cache bytes: %d", stats.Bytes)
+		t.FailNow()
+	}
+	txids, err := cache.EntryTXIDs("entryhash")
+	if err != nil || len(txids) != 2 {
+		t.Logf("unexpected entry txids: %v %v", txids, err)
+		t.FailNow()
+	}
+	_, err = cache.EntryTXIDs("unknown")
+	if err != ddb.ErrNotCached {
+		t.Logf("unexpected error for unknown entry: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestTXCache_VerifyPrune(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	cache, err := ddb.NewTXCache(filepath.Join(os.TempDir(), "prune_trh"))
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	defer cache.Clear()
+	confirmed, old, recent := Helper_FakeTX(t), Helper_FakeTX(t), Helper_FakeTX(t)
+	for _, tx := range []*ddb.DataTX{confirmed, old, recent} {
+		err = cache.StoreTX(tx.GetTxID(), tx.ToBytes())
+		if err != nil {
+			t.Logf("failed to store tx: %v", err)
+			t.FailNow()
+		}
+	}
+	cache.SetTXHeight(confirmed.GetTxID(), 715000)
+	past := time.Now().Add(-time.Hour)
+	os.Chtimes(cache.PathOf(old.GetTxID()), past, past)
+	_, err = cache.RetrieveTX(recent.GetTxID())
+	if err != nil {
+		t.Logf("failed to retrieve tx: %v", err)
+		t.FailNow()
+	}
+	//A TX written by an older version, not in the index, and a corrupted one
+	legacy := Helper_FakeTX(t)
+	ioutil.WriteFile(cache.PathOf(legacy.GetTxID()), legacy.ToBytes(), 0600)
+	corruptedID := old.GetTxID()[:60] + "0000"
+	ioutil.WriteFile(cache.PathOf(corruptedID), recent.ToBytes(), 0600)
+	report, err := cache.Verify()
+	if err != nil {
+		t.Logf("failed to verify cache: %v", err)
+		t.FailNow()
+	}
+	if report.Checked != 5 || report.Indexed != 2 || len(report.Corrupted) != 1 || report.Corrupted[0] != corruptedID {
+		t.Logf("unexpected report: %+v", report)
+		t.FailNow()
+	}
+	//Corrupted, then unconfirmed least recently used first
+	size := int64(len(confirmed.ToBytes()) + len(recent.ToBytes()) + len(legacy.ToBytes()))
+	removed, err := cache.Prune(size)
+	if err != nil {
+		t.Logf("failed to prune cache: %v", err)
+		t.FailNow()
+	}
+	if len(removed) != 2 || removed[0] != corruptedID || removed[1] != old.GetTxID() {
+		t.Logf("unexpected removed txs: %v", removed)
+		t.FailNow()
+	}
+	num, _ := cache.Size()
+	if num != 3 {
+		t.Logf("unexpected cache size: %d", num)
+		t.FailNow()
+	}
+}
+
+func TestTXCache_LegacyAddressInfo(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	cache, err := ddb.NewTXCache(filepath.Join(os.TempDir(), "legacy_trh"))
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	defer cache.Clear()
+	address := "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	legacy, _ := json.Marshal(ddb.AddressInfo{Address: address, TXIDs: []string{"txid1"}})
+	ioutil.WriteFile(cache.PathOf(address), legacy, 0600)
+	err = cache.StoreTXIDs(address, []string{"txid2"})
+	if err != nil {
+		t.Logf("failed to store txids: %v", err)
+		t.FailNow()
+	}
+	txids, err := cache.GetTXIDs(address)
+	if err != nil || len(txids) != 2 || txids[0] != "txid1" || txids[1] != "txid2" {
+		t.Logf("unexpected txids: %v %v", txids, err)
+		t.FailNow()
+	}
+	if _, err := os.Stat(cache.PathOf(address)); !os.IsNotExist(err) {
+		t.Logf("legacy address file should be moved into the index: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestTXCache_SharedIndex(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "shared_index_trh")
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+	//Two caches on the same dir behave like two processes, the mutex of one doesn't protect the other
+	caches := make([]*ddb.TXCache, 2)
+	for i := range caches {
+		cache, err := ddb.NewTXCache(dir)
+		if err != nil {
+			t.Logf("failed to create cache: %v", err)
+			t.FailNow()
+		}
+		caches[i] = cache
+	}
+	txs := make([]*ddb.DataTX, 20)
+	for i := range txs {
+		txs[i] = Helper_FakeTX(t)
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, len(txs))
+	for i, tx := range txs {
+		wg.Add(1)
+		go func(cache *ddb.TXCache, tx *ddb.DataTX) {
+			defer wg.Done()
+			errs <- cache.StoreTX(tx.GetTxID(), tx.ToBytes())
+		}(caches[i%2], tx)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Logf("failed to store tx: %v", err)
+			t.FailNow()
+		}
+	}
+	size, err := caches[0].Size()
+	if err != nil || size != len(txs) {
+		t.Logf("TXs lost from the index: %d %v", size, err)
+		t.FailNow()
+	}
+	stale := filepath.Join(dir, "index.trh.lock")
+	ioutil.WriteFile(stale, []byte{}, 0600)
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(stale, old, old)
+	_, err = caches[1].Size()
+	if err != nil {
+		t.Logf("stale lock should be taken over: %v", err)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,156 @@
+package ddb
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ejfhp/ddb/miner"
+	"github.com/ejfhp/trail"
+	"github.com/ejfhp/trail/trace"
+)
+
+const (
+	DefaultAncestorLimit = 25
+	DefaultPollInterval  = time.Minute
+	DefaultConfirmWait   = 6 * time.Hour
+)
+
+//ChainProgress records how many TXs of a chain have been accepted by the miner, so that an interrupted submission can be resumed.
+type ChainProgress struct {
+	ID        string   `json:"id"`
+	TXs       []string `json:"txs"`
+	Submitted int      `json:"submitted"`
+	Updated   int64    `json:"updated"`
+}
+
+//ChainSubmitter submits a chain of dependent TXs in batches, so that no batch has more unconfirmed ancestors than the miner accepts.
+type ChainSubmitter struct {
+	Blockchain    *Blockchain
+	AncestorLimit int
+	PollInterval  time.Duration
+	ConfirmWait   time.Duration
+}
+
+func NewChainSubmitter(blockchain *Blockchain) *ChainSubmitter {
+	return &ChainSubmitter{Blockchain: blockchain, AncestorLimit: DefaultAncestorLimit, PollInterval: DefaultPollInterval, ConfirmWait: DefaultConfirmWait}
+}
+
+//Submit submits the chained TXs, each TX is expected to spend an output of the previous one.
+//Between batches it waits for the last TX of the batch to be confirmed. Progress is persisted in the cache.
+func (s *ChainSubmitter) Submit(txs []*DataTX) ([]*miner.SubmitResult, error) {
+	if len(txs) == 0 {
+		return []*miner.SubmitResult{}, nil
+	}
+	progress := &ChainProgress{ID: txs[0].GetTxID(), TXs: make([]string, len(txs))}
+	for i, tx := range txs {
+		progress.TXs[i] = tx.ToString()
+	}
+	if s.Blockchain.Cache != nil {
+		stored, err := s.Blockchain.Cache.RetrieveChainProgress(progress.ID)
+		if err == nil && len(stored.TXs) == len(progress.TXs) {
+			progress.Submitted = stored.Submitted
+		}
+	}
+	return s.submit(progress)
+}
+
+//Resume continues the submission of the chain with the given ID stored in the cache.
+func (s *ChainSubmitter) Resume(id string) ([]*miner.SubmitResult, error) {
+	if s.Blockchain.Cache == nil {
+		return nil, fmt.Errorf("cannot resume chain %s without cache", id)
+	}
+	progress, err := s.Blockchain.Cache.RetrieveChainProgress(id)
+	if err != nil {
+		return nil, fmt.Errorf("cannot retrieve progress of chain %s: %w", id, err)
+	}
+	return s.submit(progress)
+}
+
+func (s *ChainSubmitter) submit(progress *ChainProgress) ([]*miner.SubmitResult, error) {
+	tr := trace.New().Source("chain.go", "ChainSubmitter", "submit")
+	limit := s.AncestorLimit
+	if limit < 1 {
+		limit = DefaultAncestorLimit
+	}
+	txs := make([]*DataTX, len(progress.TXs))
+	for i, h := range progress.TXs {
+		tx, err := DataTXFromHex(h)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode TX %d of chain %s: %w", i, progress.ID, err)
+		}
+		txs[i] = tx
+	}
+	results := make([]*miner.SubmitResult, 0, len(txs))
+	for i := 0; i < progress.Submitted; i++ {
+		results = append(results, &miner.SubmitResult{TXID: txs[i].GetTxID(), Result: miner.ResultSuccess, Description: "already submitted"})
+	}
+	for progress.Submitted < len(txs) {
+		start := progress.Submitted
+		if start > 0 {
+			err := s.waitConfirmation(txs[start-1].GetTxID())
+			if err != nil {
+				s.storeProgress(progress)
+				return results, fmt.Errorf("chain %s stopped after %d TXs: %w", progress.ID, start, err)
+			}
+		}
+		end := start + limit
+		if end > len(txs) {
+			end = len(txs)
+		}
+		trail.Println(trace.Info("submitting batch").UTC().Add("chain", progress.ID).Add("from", fmt.Sprintf("%d", start)).Add("to", fmt.Sprintf("%d", end)).Append(tr))
+		//Stored before submitting, so that the chain can be resumed if the process dies while submitting
+		s.storeProgress(progress)
+		restxs, err := s.Blockchain.Submit(txs[start:end])
+		var refused *miner.SubmitError
+		if err != nil && !errors.As(err, &refused) {
+			return results, fmt.Errorf("cannot submit batch %d-%d of chain %s: %w", start, end, progress.ID, err)
+		}
+		for _, res := range restxs {
+			results = append(results, res)
+			if !res.Success() {
+				s.storeProgress(progress)
+				return results, fmt.Errorf("TX %s of chain %s refused by miner: %w", res.TXID, progress.ID, refused)
+			}
+			progress.Submitted++
+		}
+		s.storeProgress(progress)
+	}
+	if s.Blockchain.Cache != nil {
+		err := s.Blockchain.Cache.DeleteChainProgress(progress.ID)
+		if err != nil {
+			trail.Println(trace.Warning("cannot delete chain progress").UTC().Add("chain", progress.ID).Error(err).Append(tr))
+		}
+	}
+	return results, nil
+}
+
+func (s *ChainSubmitter) waitConfirmation(txid string) error {
+	tr := trace.New().Source("chain.go", "ChainSubmitter", "waitConfirmation")
+	deadline := time.Now().Add(s.ConfirmWait)
+	for {
+		confirmed, err := s.Blockchain.IsConfirmed(txid)
+		if err != nil {
+			trail.Println(trace.Warning("cannot check confirmation").UTC().Add("txid", txid).Error(err).Append(tr))
+		}
+		if confirmed {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("TX %s not confirmed within %s", txid, s.ConfirmWait)
+		}
+		trail.Println(trace.Info("waiting for confirmation").UTC().Add("txid", txid).Append(tr))
+		time.Sleep(s.PollInterval)
+	}
+}
+
+func (s *ChainSubmitter) storeProgress(progress *ChainProgress) {
+	if s.Blockchain.Cache == nil {
+		return
+	}
+	progress.Updated = time.Now().Unix()
+	err := s.Blockchain.Cache.StoreChainProgress(progress)
+	if err != nil {
+		trail.Println(trace.Warning("cannot store chain progress").UTC().Add("chain", progress.ID).Error(err))
+	}
+}
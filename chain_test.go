@@ -0,0 +1,167 @@
+package ddb_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/miner"
+	"github.com/ejfhp/ddb/satoshi"
+)
+
+func TestChainSubmitter_Submit(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	mir := &fakeMiner{}
+	expl := newFakeExplorer()
+	cache, err := ddb.NewTXCache(filepath.Join(os.TempDir(), "chain_trh"))
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	defer cache.Clear()
+	blockchain := ddb.NewBlockchain(mir, expl, cache)
+	key, address := "L4ZaBkP1UTyxdEM7wysuPd1scHMLLf8sf8B2tcEcssUZ7ujrYWcQ", "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	utxos := Helper_FakeTX(t).UTXOs()[:1]
+	txs := []*ddb.DataTX{}
+	for i := 0; i < 7; i++ {
+		tx, err := ddb.NewDataTX(key, address, address, utxos, satoshi.EmptyWallet, satoshi.Satoshi(200), []byte("chained"), "123456789")
+		if err != nil {
+			t.Logf("failed to build tx %d: %v", i, err)
+			t.FailNow()
+		}
+		txs = append(txs, tx)
+		utxos = tx.UTXOs()[:1]
+		expl.confirmed[tx.GetTxID()] = true
+	}
+	submitter := &ddb.ChainSubmitter{Blockchain: blockchain, AncestorLimit: 3, PollInterval: time.Millisecond, ConfirmWait: time.Second}
+	res, err := submitter.Submit(txs)
+	if err != nil {
+		t.Logf("failed to submit chain: %v", err)
+		t.FailNow()
+	}
+	if len(res) != len(txs) {
+		t.Logf("unexpected number of results: %d", len(res))
+		t.FailNow()
+	}
+	if len(mir.submitted) != 3 {
+		t.Logf("unexpected number of batches: %d", len(mir.submitted))
+		t.FailNow()
+	}
+	pending, err := cache.ListChainProgress()
+	if err != nil || len(pending) != 0 {
+		t.Logf("progress of a completed chain should be deleted: %v %v", pending, err)
+		t.FailNow()
+	}
+}
+
+func TestChainSubmitter_Resume(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	mir := &fakeMiner{}
+	expl := newFakeExplorer()
+	cache, err := ddb.NewTXCache(filepath.Join(os.TempDir(), "chain_resume_trh"))
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	defer cache.Clear()
+	blockchain := ddb.NewBlockchain(mir, expl, cache)
+	key, address := "L4ZaBkP1UTyxdEM7wysuPd1scHMLLf8sf8B2tcEcssUZ7ujrYWcQ", "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	utxos := Helper_FakeTX(t).UTXOs()[:1]
+	txs := []*ddb.DataTX{}
+	for i := 0; i < 4; i++ {
+		tx, err := ddb.NewDataTX(key, address, address, utxos, satoshi.EmptyWallet, satoshi.Satoshi(200), []byte("chained"), "123456789")
+		if err != nil {
+			t.Logf("failed to build tx %d: %v", i, err)
+			t.FailNow()
+		}
+		txs = append(txs, tx)
+		utxos = tx.UTXOs()[:1]
+	}
+	submitter := &ddb.ChainSubmitter{Blockchain: blockchain, AncestorLimit: 2, PollInterval: time.Millisecond, ConfirmWait: 10 * time.Millisecond}
+	_, err = submitter.Submit(txs)
+	if err == nil {
+		t.Logf("submission should stop waiting for unconfirmed TX")
+		t.FailNow()
+	}
+	pending, err := cache.ListChainProgress()
+	if err != nil || len(pending) != 1 {
+		t.Logf("unexpected pending chains: %v %v", pending, err)
+		t.FailNow()
+	}
+	expl.confirmed[txs[1].GetTxID()] = true
+	res, err := submitter.Resume(pending[0])
+	if err != nil {
+		t.Logf("failed to resume chain: %v", err)
+		t.FailNow()
+	}
+	if len(res) != len(txs) {
+		t.Logf("unexpected number of results: %d", len(res))
+		t.FailNow()
+	}
+	if len(mir.submitted) != 2 || len(mir.submitted[1]) != 2 {
+		t.Logf("unexpected submissions: %d", len(mir.submitted))
+		t.FailNow()
+	}
+}
+
+//progressMiner is a fakeMiner recording, for each submission, how many TXs of the chain the stored progress says are submitted.
+type progressMiner struct {
+	*fakeMiner
+	cache     ddb.Cache
+	chainID   string
+	submitted []int
+}
+
+func (m *progressMiner) SubmitMultiTX(rawTXs []string) ([]*miner.SubmitResult, error) {
+	stored := -1
+	progress, err := m.cache.RetrieveChainProgress(m.chainID)
+	if err == nil {
+		stored = progress.Submitted
+	}
+	m.submitted = append(m.submitted, stored)
+	return m.fakeMiner.SubmitMultiTX(rawTXs)
+}
+
+func TestChainSubmitter_ProgressBeforeSubmit(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	expl := newFakeExplorer()
+	cache, err := ddb.NewTXCache(filepath.Join(os.TempDir(), "chain_progress_trh"))
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	defer cache.Clear()
+	key, address := "L4ZaBkP1UTyxdEM7wysuPd1scHMLLf8sf8B2tcEcssUZ7ujrYWcQ", "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	utxos := Helper_FakeTX(t).UTXOs()[:1]
+	txs := []*ddb.DataTX{}
+	for i := 0; i < 5; i++ {
+		tx, err := ddb.NewDataTX(key, address, address, utxos, satoshi.EmptyWallet, satoshi.Satoshi(200), []byte("chained"), "123456789")
+		if err != nil {
+			t.Logf("failed to build tx %d: %v", i, err)
+			t.FailNow()
+		}
+		txs = append(txs, tx)
+		utxos = tx.UTXOs()[:1]
+		expl.confirmed[tx.GetTxID()] = true
+	}
+	mir := &progressMiner{fakeMiner: &fakeMiner{}, cache: cache, chainID: txs[0].GetTxID()}
+	blockchain := ddb.NewBlockchain(mir, expl, cache)
+	submitter := &ddb.ChainSubmitter{Blockchain: blockchain, AncestorLimit: 2, PollInterval: time.Millisecond, ConfirmWait: time.Second}
+	_, err = submitter.Submit(txs)
+	if err != nil {
+		t.Logf("failed to submit chain: %v", err)
+		t.FailNow()
+	}
+	if len(mir.submitted) != 3 {
+		t.Logf("unexpected number of batches: %d", len(mir.submitted))
+		t.FailNow()
+	}
+	for i, stored := range mir.submitted {
+		if stored != i*2 {
+			t.Logf("progress of batch %d not stored before submitting: %d", i, stored)
+			t.FailNow()
+		}
+	}
+}
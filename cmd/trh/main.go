@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
@@ -11,13 +12,17 @@ import (
 	"text/tabwriter"
 	"time"
 
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/errs"
 	"github.com/ejfhp/ddb/keys"
+	"github.com/ejfhp/ddb/miner"
+	"github.com/ejfhp/ddb/satoshi"
 	"github.com/ejfhp/ddb/trh"
 	"github.com/ejfhp/trail"
 )
 
 const (
-	defaultHeader    = "TRH202101"
+	defaultHeader    = ddb.HeaderSalted
 	keystoreCmd      = "keystore"
 	txCmd            = "tx"
 	storeCmd         = "store"
@@ -32,6 +37,18 @@ const (
 	exitStoreError
 )
 
+//Exit codes of commands terminated with error.
+const (
+	exitGenericError     = 1
+	exitNoUTXO           = 2
+	exitInsufficientFund = 3
+	exitWrongPassword    = 4
+	exitNetworkError     = 5
+	exitMinerRejected    = 6
+	exitNotCached        = 7
+	exitCorruptedPart    = 8
+)
+
 type command struct {
 	name        string
 	description string
@@ -39,20 +56,65 @@ type command struct {
 }
 
 var commands = map[string]command{
-	"kshow":      {name: "keystore_show", description: "keystore show", params: []string{"pin"}},
-	"ktouncry":   {name: "keystore_tounencrypted", description: "keystore export to unencrypted", params: []string{"pin"}},
-	"kfromuncry": {name: "keystore_fromunenecrypted", description: "keystore load from unencrypted", params: []string{"pin"}},
-	"kgenfromkp": {name: "keystore_fromkeypass", description: "keystore generate from key and password", params: []string{"pin", "key", "password"}},
-	"kgenfromph": {name: "keystore_frompassphrase", description: "keystore generate from phrase", params: []string{"pin", "phrase"}},
-	"estimate":   {name: "estimate_file", description: "estimate cost to store file", params: []string{"pin", "file", "comma separated labels", "notes"}},
-	"utxos":      {name: "utxo_show", description: "show all utxos", params: []string{"pin"}},
-	"txshow":     {name: "tx_showall", description: "show all transactions", params: []string{"pin"}},
-	"collect":    {name: "collect_all", description: "collect unspent money", params: []string{"pin"}},
-	"store":      {name: "storefile_file", description: "store file", params: []string{"pin", "file", "comma separated labels", "notes", "max spend (satoshi)"}},
-	"list":       {name: "listfile_all", description: "list all files stored", params: []string{"pin"}},
-	"get":        {name: "retrieve_file", description: "get file", params: []string{"pin", "entryhash", "outfolder"}},
+	"kshow":            {name: "keystore_show", description: "keystore show", params: []string{"pin"}},
+	"ktouncry":         {name: "keystore_tounencrypted", description: "keystore export to unencrypted", params: []string{"pin"}},
+	"kfromuncry":       {name: "keystore_fromunenecrypted", description: "keystore load from unencrypted", params: []string{"pin"}},
+	"kgenfromkp":       {name: "keystore_fromkeypass", description: "keystore generate from key and password, the password must be at least 10 chars and hard to guess, with an xprv key the nodes are HD children", params: []string{"pin", "key", "password"}},
+	"kgenfromph":       {name: "keystore_frompassphrase", description: "keystore generate from phrase", params: []string{"pin", "phrase"}},
+	"kgenfrommnemonic": {name: "keystore_frommnemonic", description: "keystore generate from BIP39 mnemonic, with the passphrase of -bip39pass", params: []string{"pin", "mnemonic"}},
+	"kchpin":           {name: "keystore_changepin", description: "keystore change PIN", params: []string{"pin", "new pin"}},
+	"kscan":            {name: "keystore_scan", description: "keystore rebuild from phrase, with the nodes of all the files stored", params: []string{"pin", "phrase"}},
+	"kscanmnemonic":    {name: "keystore_scanmnemonic", description: "keystore rebuild from BIP39 mnemonic, with the passphrase of -bip39pass, with the nodes of all the files stored", params: []string{"pin", "mnemonic"}},
+	"kscanfromkp":      {name: "keystore_scankeypass", description: "keystore rebuild from key and password, with the nodes of all the files stored, HD nodes with an xprv key", params: []string{"pin", "key", "password"}},
+	"kxpub":            {name: "keystore_xpub", description: "show the xpub of the nodes of an HD keystore, for watch-only listing", params: []string{"pin"}},
+	"watch":            {name: "watch_xpub", description: "list the node addresses of the xpub with their transactions, watch-only", params: []string{"xpub"}},
+	"estimate":         {name: "estimate_file", description: "estimate cost to store file", params: []string{"pin", "file", "comma separated labels", "notes"}},
+	"utxos":            {name: "utxo_show", description: "show all utxos", params: []string{"pin"}},
+	"txshow":           {name: "tx_showall", description: "show all transactions", params: []string{"pin"}},
+	"balance":          {name: "wallet_balance", description: "show balance of every address of the keystore", params: []string{"pin"}},
+	"send":             {name: "wallet_send", description: "send satoshi from the source address", params: []string{"pin", "destination address", "amount (satoshi)"}},
+	"consolidate":      {name: "wallet_consolidate", description: "merge all the UTXOs of the source address", params: []string{"pin"}},
+	"sign":             {name: "tx_sign", description: "sign exported transactions, works offline", params: []string{"pin", "unsigned file", "signed file"}},
+	"submit":           {name: "tx_submit", description: "submit signed or prepared transactions if their inputs are unspent", params: []string{"signed file"}},
+	"collect":          {name: "collect_all", description: "collect unspent money", params: []string{"pin"}},
+	"store":            {name: "storefile_file", description: "store file", params: []string{"pin", "file", "comma separated labels", "notes", "max spend (satoshi)"}},
+	"prepare":          {name: "storefile_prepare", description: "prepare the transactions storing a file without submitting them", params: []string{"pin", "file", "comma separated labels", "notes", "max spend (satoshi)", "out file"}},
+	"storebatch":       {name: "storefile_batch", description: "store small files in a single transaction", params: []string{"pin", "comma separated files", "comma separated labels", "notes", "max spend (satoshi)"}},
+	"list":             {name: "listfile_all", description: "list all files stored", params: []string{"pin"}},
+	"get":              {name: "retrieve_file", description: "get file", params: []string{"pin", "entryhash", "outfolder"}},
+	"callbacks":        {name: "callbacks_listen", description: "receive miner callbacks", params: []string{"listen address"}},
+	"txstatus":         {name: "tx_status", description: "show status of submitted transaction", params: []string{"txid"}},
+	"resume":           {name: "resume_store", description: "resume interrupted stores", params: []string{"pin"}},
+	"cache":            {name: "cache_manage", description: "show stats, verify, prune or migrate the local cache", params: []string{"stat|verify|prune|migrate"}},
+	"decode":           {name: "decode_keystore", description: "extract the files of the keystore from raw transaction or block files, works offline", params: []string{"pin", "comma separated files", "outfolder"}},
+	"decodepass":       {name: "decode_password", description: "extract the files stored with the password from raw transaction or block files, works offline", params: []string{"password", "comma separated files", "outfolder"}},
+	"archive":          {name: "archive_manage", description: "export the keystore transactions with merkle proofs, or import them in the local cache", params: []string{"export|import", "pin", "file"}},
 }
 var flagLog bool
+var flagDsCheck bool
+var flagMerkleProof bool
+var flagCallbackURL string
+var flagCallbackToken string
+var flagAncestors int
+var flagFanOut bool
+var flagCoinSelect string
+var flagUTXOs string
+var flagSimulate bool
+var flagUnsigned string
+var flagNoSign bool
+var flagOffline bool
+var flagFees string
+var flagFeeMultiplier float64
+var flagMinFee uint64
+var flagCacheSize int64
+var flagCacheType string
+var flagCacheOnly bool
+var flagKDF string
+var flagHistoryTTL time.Duration
+var flagCacheEncrypt bool
+var flagCacheEncryptTXs bool
+var flagCachePIN string
+var flagBIP39Pass string
 
 func printMainHelp() {
 	fmt.Printf(`
@@ -84,12 +146,70 @@ Examples:
    trh kfromuncry 1346
    trh kgenfromkp 1346 Kxn6wiqVGzGjMq7JA8m9fxRdukwzzjGgYkXir5eyRwvvrRs7GZKZ therabbithole 
    trh kgenfromph 1346 "Lunedi 8 Novembre 2021"
+   trh kgenfrommnemonic 1346 "legal winner thank year wave sausage worth useful legal winner thank yellow"
+   trh -bip39pass "TREZOR" kgenfrommnemonic 1346 "legal winner thank year wave sausage worth useful legal winner thank yellow"
+   trh kchpin 1346 73916
+   trh -kdf argon2id kgenfromph 1346 "Lunedi 8 Novembre 2021"
+   trh kscan 1346 "Lunedi 8 Novembre 2021"
+   trh kgenfromkp 1346 xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi "the rabbit hole"
+   trh -bip39pass "TREZOR" kscanmnemonic 1346 "legal winner thank year wave sausage worth useful legal winner thank yellow"
+   trh kscanfromkp 1346 xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi "the rabbit hole"
+   trh kxpub 1346
+   trh watch xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw
    trh estimate 1346 keystore.trh "keystore,bitcoin,trh" "very important"
+   trh -offline estimate 1346 keystore.trh "keystore,bitcoin,trh" "very important"
+   trh -offline -fees fees.json estimate 1346 keystore.trh "keystore,bitcoin,trh" "very important"
+   trh -feemultiplier 1.2 -minfee 200 store 1346 bitcoin.pdf "bitcoin,pdf" "test import" 200000
    trh txshow 1346
    trh utxos 1346
    trh collect 1346
+   trh -simulate collect 1346
+   trh balance 1346
+   trh send 1346 1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X 10000
+   trh -simulate consolidate 1346
+   trh -unsigned send.json send 1346 1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X 10000
+   trh sign 1346 send.json send_signed.json
+   trh submit send_signed.json
    trh store 1346 bitcoin.pdf "bitcoin,pdf" "test import" 200000 
    trh list 1346
+   trh -dscheck -merkleproof -callbackurl https://example.com/callback -callbacktoken secret store 1346 bitcoin.pdf "bitcoin,pdf" "test import" 200000
+   trh -ancestors 25 store 1346 bitcoin.pdf "bitcoin,pdf" "test import" 200000
+   trh -fanout store 1346 bitcoin.pdf "bitcoin,pdf" "test import" 200000
+   trh -coinselect bnb store 1346 bitcoin.pdf "bitcoin,pdf" "test import" 200000
+   trh -utxo 0fe1ac2e5a96c25bcbd7a2fd5a7be86f97c2a8e0e3a9b1b5e0e1c2ab3e0cf4a1:1 store 1346 bitcoin.pdf "bitcoin,pdf" "test import" 200000
+   trh prepare 1346 bitcoin.pdf "bitcoin,pdf" "test import" 200000 bitcoin_txs.json
+   trh submit bitcoin_txs.json
+   trh -nosign prepare 1346 bitcoin.pdf "bitcoin,pdf" "test import" 200000 bitcoin_unsigned.json
+   trh sign 1346 bitcoin_unsigned.json bitcoin_txs.json
+   trh storebatch 1346 "note1.txt,note2.txt" "notes" "test batch" 20000
+   trh resume 1346
+   trh -callbacktoken secret callbacks :8080
+   trh txstatus 0fe1ac2e5a96c25bcbd7a2fd5a7be86f97c2a8e0e3a9b1b5e0e1c2ab3e0cf4a1
+   trh cache stat
+   trh cache verify
+   trh -cachesize 50 cache prune
+   trh cache migrate
+   trh -cachetype file list 1346
+   trh -historyttl 10m list 1346
+   trh -cacheencrypt list 1346
+   trh -cacheencrypttxs list 1346
+   trh -cachepin 1346 cache stat
+   trh decode 1346 "tx1.hex,tx2.hex,block.bin" /tmp
+   trh decodepass therabbithole txs.hex /tmp
+   trh archive export 1346 backup.trh.gz
+   trh archive import 1346 backup.trh.gz
+   trh -cacheonly get 1346 8b5c1ecd1fb0a5d6f9e0a4b1e4e3e8d2b7a3c9f0e1d2c3b4a5968778695a4b3c /tmp
+
+Exit codes:
+
+   1 generic error
+   2 no UTXO
+   3 insufficient funds
+   4 wrong PIN or password
+   5 network error
+   6 transaction rejected by miner
+   7 data not in cache
+   8 corrupted entry part
 `)
 	fmt.Printf("\nBuilt time: %s\n", buildTimestamp)
 }
@@ -97,6 +217,30 @@ Examples:
 //go:generate go run buildscript/timebuilt.go
 func main() {
 	flag.BoolVar(&flagLog, "log", false, "enable log")
+	flag.BoolVar(&flagDsCheck, "dscheck", false, "ask the miner for double spend check")
+	flag.BoolVar(&flagMerkleProof, "merkleproof", false, "ask the miner for merkle proof callback")
+	flag.StringVar(&flagCallbackURL, "callbackurl", "", "url where the miner sends callbacks")
+	flag.StringVar(&flagCallbackToken, "callbacktoken", "", "token of the callbacks")
+	flag.IntVar(&flagAncestors, "ancestors", 0, "max number of unconfirmed chained transactions submitted at once")
+	flag.BoolVar(&flagFanOut, "fanout", false, "fund each part of the file from its own output instead of chaining them")
+	flag.StringVar(&flagCoinSelect, "coinselect", ddb.SelectLargestFirst, "how to choose the UTXOs funding a store: all, largest, bnb, privacy")
+	flag.StringVar(&flagUTXOs, "utxo", "", "comma separated outpoints (txid:pos) that must fund the store")
+	flag.BoolVar(&flagSimulate, "simulate", false, "build wallet and collecting transactions without submitting them")
+	flag.StringVar(&flagUnsigned, "unsigned", "", "write the unsigned wallet transaction to this file instead of submitting it")
+	flag.BoolVar(&flagNoSign, "nosign", false, "prepare the transactions storing a file unsigned, to be signed offline with 'trh sign'")
+	flag.BoolVar(&flagOffline, "offline", false, "estimate fees with the last cached fee quote, or the -fees schedule, without asking the miner")
+	flag.StringVar(&flagFees, "fees", "", "JSON file with the fee schedule used by -offline")
+	flag.Float64Var(&flagFeeMultiplier, "feemultiplier", 1, "multiplier of the fees calculated from the miner fee quote")
+	flag.Uint64Var(&flagMinFee, "minfee", 0, "min fee in satoshi paid by each transaction")
+	flag.Int64Var(&flagCacheSize, "cachesize", 0, "max size of the local cache in MB, older transactions are evicted")
+	flag.DurationVar(&flagHistoryTTL, "historyttl", 0, "use the cached history of an address synced less than this ago, e.g. 10m")
+	flag.BoolVar(&flagCacheEncrypt, "cacheencrypt", false, "encrypt the addresses and metadata of the local cache with a key derived from the PIN")
+	flag.BoolVar(&flagCacheEncryptTXs, "cacheencrypttxs", false, "encrypt also the raw transactions of the local cache and hide their IDs, implies -cacheencrypt")
+	flag.StringVar(&flagCachePIN, "cachepin", "", "PIN unlocking the encrypted cache for commands without PIN, like cache, txstatus and submit")
+	flag.StringVar(&flagCacheType, "cachetype", "", "local cache backend: file or kv, default kv if 'trh cache migrate' has been run")
+	flag.BoolVar(&flagCacheOnly, "cacheonly", false, "get files from the local cache only, without explorer, e.g. after 'trh archive import'")
+	flag.StringVar(&flagBIP39Pass, "bip39pass", "", "BIP39 passphrase of the mnemonic of kgenfrommnemonic and kscanmnemonic")
+	flag.StringVar(&flagKDF, "kdf", keys.KDFScrypt, "KDF deriving the key of the keystores created or migrated from the PIN: scrypt or argon2id")
 	flag.Parse()
 	if flagLog {
 		trail.SetWriter(os.Stderr)
@@ -123,7 +267,63 @@ func main() {
 	fmt.Printf("%s: %s\n", command.name, command.description)
 	fmt.Printf("\n")
 	var mainerr error
+	if flagKDF != keys.KDFScrypt && flagKDF != keys.KDFArgon2id {
+		fmt.Printf("Unknown KDF '%s', use scrypt or argon2id\n", flagKDF)
+		os.Exit(1)
+	}
+	keys.KeystoreKDF = flagKDF
+	if (flagMerkleProof || flagDsCheck) && flagCallbackURL == "" {
+		fmt.Printf("Flags -merkleproof and -dscheck need -callbackurl\n")
+		os.Exit(1)
+	}
 	th := trh.NewWithoutKeystore()
+	th.SetSubmitOptions(miner.SubmitOptions{CallBackURL: flagCallbackURL, CallBackToken: flagCallbackToken, DsCheck: flagDsCheck, MerkleProof: flagMerkleProof})
+	if flagFeeMultiplier <= 0 {
+		fmt.Printf("Fee multiplier must be greater than 0\n")
+		os.Exit(1)
+	}
+	th.SetFeePolicy(miner.FeePolicy{Multiplier: flagFeeMultiplier, MinFee: satoshi.Satoshi(flagMinFee)})
+	th.SetAncestorLimit(flagAncestors)
+	th.SetFanOut(flagFanOut)
+	th.SetHistoryTTL(flagHistoryTTL)
+	th.SetCacheMaxBytes(flagCacheSize << 20)
+	pinned := []string{}
+	if flagUTXOs != "" {
+		for _, p := range strings.Split(flagUTXOs, ",") {
+			pinned = append(pinned, strings.TrimSpace(p))
+		}
+	}
+	err := th.SetCoinSelection(flagCoinSelect, pinned)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	err = th.SetCacheType(flagCacheType)
+	if err != nil {
+		fmt.Printf("%v\n", err)
+		os.Exit(1)
+	}
+	th.SetCacheEncryption(flagCacheEncrypt)
+	th.SetCacheTXEncryption(flagCacheEncryptTXs)
+	if flagCachePIN != "" {
+		ks, err := keys.LoadKeystore(ksf, flagCachePIN)
+		if err != nil {
+			fmt.Printf("%v\n", err)
+			os.Exit(exitCode(err))
+		}
+		th.SetCacheKeystore(ks)
+	}
+	if flagOffline {
+		var fees miner.Fees
+		if flagFees != "" {
+			fees, err = miner.FeesFromFile(flagFees)
+			if err != nil {
+				fmt.Printf("%v\n", err)
+				os.Exit(1)
+			}
+		}
+		th.SetOffline(true, fees)
+	}
 	switch command.name {
 	case "keystore_show":
 		ks, err := keys.LoadKeystore(ksf, inputs[0])
@@ -161,6 +361,50 @@ func main() {
 		if mainerr == nil {
 			fmt.Printf("Keystore created: %s\n", ksf)
 		}
+	case "keystore_frommnemonic":
+		_, mainerr = th.KeystoreGenFromMnemonic(inputs[0], inputs[1], flagBIP39Pass, ksf)
+		if mainerr == nil {
+			fmt.Printf("Keystore created: %s\n", ksf)
+		}
+	case "keystore_changepin":
+		mainerr = th.KeystoreChangePIN(inputs[0], inputs[1], ksf)
+		if mainerr == nil {
+			fmt.Printf("PIN changed: %s\n", ksf)
+		}
+	case "keystore_scan", "keystore_scankeypass", "keystore_scanmnemonic":
+		var restored []*keys.Node
+		var err error
+		switch command.name {
+		case "keystore_scan":
+			restored, err = th.KeystoreScan(inputs[0], inputs[1], 3, ksf)
+		case "keystore_scankeypass":
+			restored, err = th.KeystoreScanKey(inputs[0], inputs[1], inputs[2], ksf)
+		default:
+			restored, err = th.KeystoreScanMnemonic(inputs[0], inputs[1], flagBIP39Pass, ksf)
+		}
+		if err == nil {
+			fmt.Printf("Nodes restored: %d\n", len(restored))
+			for _, n := range restored {
+				fmt.Printf(" - Name: %s  Address: %s  Hash: %s  time: %s\n", n.Name(), n.Address(), n.ID(), n.Timestamp().Format("2006-01-02 15:04 EST"))
+			}
+			fmt.Printf("Keystore saved: %s\n", ksf)
+		}
+		mainerr = err
+	case "keystore_xpub":
+		xpub, err := th.KeystoreXPub(inputs[0], ksf)
+		if err == nil {
+			fmt.Printf("Nodes XPub: %s\n", xpub)
+		}
+		mainerr = err
+	case "watch_xpub":
+		addresses, txs, err := th.WatchXPub(inputs[0], 0)
+		if err == nil {
+			fmt.Printf("Node addresses used: %d\n", len(addresses))
+			for _, add := range addresses {
+				fmt.Printf(" - Address: %s  TXs: %d\n", add, len(txs[add]))
+			}
+		}
+		mainerr = err
 	case "estimate_file":
 		ks, err := keys.LoadKeystore(ksf, inputs[0])
 		if err != nil {
@@ -172,18 +416,23 @@ func main() {
 			fmt.Printf("Fatal error: %v\n", err)
 			os.Exit(1)
 		}
-		filePar := inputs[0]
-		labelPar := inputs[1]
-		notePar := inputs[2]
+		filePar := inputs[1]
+		labelPar := inputs[2]
+		notePar := inputs[3]
 		labels := strings.Split(labelPar, ",")
 		lbls := make([]string, len(labels))
 		for i, l := range labels {
 			lbls[i] = strings.TrimSpace(l)
 		}
-		txs, cost, err := th.Simulate(filePar, filePar, lbls, notePar, defaultHeader, 10000000)
+		estimate, err := th.Estimate(filePar, filePar, lbls, notePar, defaultHeader)
 		if err == nil {
-			fmt.Printf("Estimated cost: %d satoshi\n", cost)
-			fmt.Printf("Estimated num of txs: %d\n", len(txs))
+			fmt.Printf("Fee rates: standard %.0f sat/kB, data %.0f sat/kB\n", estimate.StandardRate, estimate.DataRate)
+			for num, tx := range estimate.TXs {
+				fmt.Printf("%d: %-9s inputs: %d outputs: %d standard bytes: %d data bytes: %d fee: %d\n", num, tx.Role, tx.Inputs, tx.Outputs, tx.StandardBytes, tx.DataBytes, tx.Fee)
+			}
+			fmt.Printf("Total bytes: %d\n", estimate.TotalBytes)
+			fmt.Printf("Estimated cost: %d satoshi\n", estimate.TotalFee)
+			fmt.Printf("Estimated num of txs: %d\n", len(estimate.TXs))
 		}
 		mainerr = err
 	case "utxo_show":
@@ -243,6 +492,132 @@ func main() {
 			fmt.Printf("No TXs found.\n")
 		}
 		mainerr = err
+	case "wallet_balance":
+		ks, err := keys.LoadKeystore(ksf, inputs[0])
+		if err != nil {
+			mainerr = err
+			break
+		}
+		err = th.SetKeystore(ks)
+		if err != nil {
+			fmt.Printf("Fatal error: %v\n", err)
+			os.Exit(1)
+		}
+		balance, err := th.Balance()
+		if err == nil {
+			tot := uint64(0)
+			addresses := make([]string, 0, len(balance))
+			for add := range balance {
+				addresses = append(addresses, add)
+			}
+			sort.Strings(addresses)
+			fmt.Printf("Balance of the addresses of this keystore:\n")
+			for _, add := range addresses {
+				fmt.Printf("  Address: %s  balance: %d satoshi\n", add, balance[add])
+				tot += uint64(balance[add])
+			}
+			fmt.Printf("\nTotal: %d\n", tot)
+		}
+		mainerr = err
+	case "wallet_send":
+		ks, err := keys.LoadKeystore(ksf, inputs[0])
+		if err != nil {
+			mainerr = err
+			break
+		}
+		err = th.SetKeystore(ks)
+		if err != nil {
+			fmt.Printf("Fatal error: %v\n", err)
+			os.Exit(1)
+		}
+		amount, err := strconv.ParseUint(inputs[2], 10, 64)
+		if err != nil {
+			mainerr = err
+			break
+		}
+		if flagUnsigned != "" {
+			tx, err := th.PrepareSend(inputs[1], satoshi.Satoshi(amount), flagSimulate)
+			if err == nil {
+				err = th.ExportTXs([]*ddb.DataTX{tx}, flagUnsigned)
+			}
+			if err == nil {
+				fmt.Printf("Unsigned transaction written to: %s\n", flagUnsigned)
+			}
+			mainerr = err
+			break
+		}
+		tx, err := th.Send(inputs[1], satoshi.Satoshi(amount), flagSimulate)
+		if err == nil {
+			_, _, fee, _ := tx.TotInOutFee()
+			fmt.Printf("TXID: %s\n", tx.GetTxID())
+			fmt.Printf("Fee: %d satoshi\n", fee)
+			if flagSimulate {
+				fmt.Printf("Simulation only, transaction not submitted.\n")
+			}
+		}
+		mainerr = err
+	case "wallet_consolidate":
+		ks, err := keys.LoadKeystore(ksf, inputs[0])
+		if err != nil {
+			mainerr = err
+			break
+		}
+		err = th.SetKeystore(ks)
+		if err != nil {
+			fmt.Printf("Fatal error: %v\n", err)
+			os.Exit(1)
+		}
+		if flagUnsigned != "" {
+			tx, err := th.PrepareConsolidate(flagSimulate)
+			if err == nil && tx == nil {
+				fmt.Printf("Single UTXO, nothing to consolidate.\n")
+				break
+			}
+			if err == nil {
+				err = th.ExportTXs([]*ddb.DataTX{tx}, flagUnsigned)
+			}
+			if err == nil {
+				fmt.Printf("Unsigned transaction written to: %s\n", flagUnsigned)
+			}
+			mainerr = err
+			break
+		}
+		tx, err := th.Consolidate(flagSimulate)
+		if err == nil && tx == nil {
+			fmt.Printf("Single UTXO, nothing to consolidate.\n")
+		} else if err == nil {
+			_, _, fee, _ := tx.TotInOutFee()
+			fmt.Printf("TXID: %s\n", tx.GetTxID())
+			fmt.Printf("Inputs merged: %d fee: %d satoshi\n", len(tx.Inputs), fee)
+			if flagSimulate {
+				fmt.Printf("Simulation only, transaction not submitted.\n")
+			}
+		}
+		mainerr = err
+	case "tx_sign":
+		ks, err := keys.LoadKeystore(ksf, inputs[0])
+		if err != nil {
+			mainerr = err
+			break
+		}
+		th.SetKeystoreOffline(ks)
+		txs, err := th.SignFile(inputs[1], inputs[2])
+		if err == nil {
+			fmt.Printf("Signed transactions written to: %s\n", inputs[2])
+			for num, tx := range txs {
+				fmt.Printf("%d: %s\n", num, tx.GetTxID())
+			}
+		}
+		mainerr = err
+	case "tx_submit":
+		txres, err := th.SubmitFile(inputs[0])
+		if len(txres) > 0 {
+			fmt.Printf("Result of submitted transactions\n")
+			for num, res := range txres {
+				fmt.Printf("%d: %s\n", num, res)
+			}
+		}
+		mainerr = err
 	case "collect_all":
 		ks, err := keys.LoadKeystore(ksf, inputs[0])
 		if err != nil {
@@ -254,15 +629,19 @@ func main() {
 			fmt.Printf("Fatal error: %v\n", err)
 			os.Exit(1)
 		}
-		txResults, err := th.Collect(ks)
+		txResults, err := th.Collect(flagSimulate)
 		if err == nil {
 			if len(txResults) == 0 {
-				fmt.Printf("No UTXO found in branched address so no transaction has been submitted.\n")
+				fmt.Printf("No UTXO worth collecting found in branched address so no transaction has been submitted.\n")
 				break
 			}
 			fmt.Printf("IDs of transactions to collect UTXO of the keystore:\n")
-			for _, id := range txResults {
-				fmt.Printf("  %s\n", id)
+			for _, tx := range txResults {
+				in, out, fee, _ := tx.TotInOutFee()
+				fmt.Printf("  %s inputs: %d in: %d out: %d fee: %d\n", tx.GetTxID(), len(tx.Inputs), in, out, fee)
+			}
+			if flagSimulate {
+				fmt.Printf("Simulation only, transactions not submitted.\n")
 			}
 		}
 		mainerr = err
@@ -301,14 +680,92 @@ func main() {
 			fmt.Printf("Amount to spend (%d) is not enough, estimation is: %d\n", maxSpend, cost)
 			break
 		}
-		txs, err := th.Store(filePar, filePar, lbls, notePar, defaultHeader, maxSpend)
+		txres, err := th.Store(filePar, filePar, lbls, notePar, defaultHeader, maxSpend)
+		if len(txres) > 0 {
+			fmt.Printf("Result of transactions that store the file\n")
+			for num, res := range txres {
+				fmt.Printf("%d: %s\n", num, res)
+			}
+		}
+		mainerr = err
+	case "storefile_prepare":
+		pinPar := inputs[0]
+		filePar := inputs[1]
+		labelPar := inputs[2]
+		notePar := inputs[3]
+		spendPar := inputs[4]
+		outPar := inputs[5]
+		ks, err := keys.LoadKeystore(ksf, pinPar)
+		if err != nil {
+			mainerr = err
+			break
+		}
+		err = th.SetKeystore(ks)
+		if err != nil {
+			fmt.Printf("Fatal error: %v\n", err)
+			os.Exit(1)
+		}
+		labels := strings.Split(labelPar, ",")
+		lbls := make([]string, len(labels))
+		for i, l := range labels {
+			lbls[i] = strings.TrimSpace(l)
+		}
+		maxSpend, err := strconv.ParseUint(spendPar, 10, 64)
+		if err != nil {
+			mainerr = err
+			break
+		}
+		prepared, err := th.Prepare(filePar, filePar, lbls, notePar, defaultHeader, maxSpend, outPar, flagNoSign)
 		if err == nil {
-			fmt.Printf("IDs of transactions that store the file\n")
-			for num, txid := range txs {
-				fmt.Printf("%d: %s\n", num, txid)
+			txs, _ := prepared.DataTXs()
+			fmt.Printf("Transactions that store the file, layout '%s':\n", prepared.Layout)
+			for num, tx := range txs {
+				_, out, fee, _ := tx.TotInOutFee()
+				fmt.Printf("%d: %s outputs: %d out: %d fee: %d\n", num, tx.GetTxID(), len(tx.Outputs), out, fee)
+			}
+			fmt.Printf("Total fee: %d\n", prepared.Fee)
+			if flagNoSign {
+				fmt.Printf("Saved to: %s, sign with 'trh sign' before submitting\n", outPar)
+			} else {
+				fmt.Printf("Saved to: %s, submit with 'trh submit %s'\n", outPar, outPar)
 			}
 		}
 		mainerr = err
+	case "storefile_batch":
+		pinPar := inputs[0]
+		filesPar := inputs[1]
+		labelPar := inputs[2]
+		notePar := inputs[3]
+		spendPar := inputs[4]
+		ks, err := keys.LoadKeystore(ksf, pinPar)
+		if err != nil {
+			mainerr = err
+			break
+		}
+		err = th.SetKeystore(ks)
+		if err != nil {
+			fmt.Printf("Fatal error: %v\n", err)
+			os.Exit(1)
+		}
+		files := strings.Split(filesPar, ",")
+		for i, f := range files {
+			files[i] = strings.TrimSpace(f)
+		}
+		labels := strings.Split(labelPar, ",")
+		lbls := make([]string, len(labels))
+		for i, l := range labels {
+			lbls[i] = strings.TrimSpace(l)
+		}
+		maxSpend, err := strconv.ParseUint(spendPar, 10, 64)
+		if err != nil {
+			mainerr = err
+			break
+		}
+		txid, err := th.StoreBatch(files, lbls, notePar, defaultHeader, maxSpend)
+		if err == nil {
+			fmt.Printf("ID of transaction that stores the files: %s\n", txid)
+		}
+		mainerr = err
 	case "listfile_all":
 		ks, err := keys.LoadKeystore(ksf, inputs[0])
 		if err != nil {
@@ -342,7 +799,7 @@ func main() {
 			fmt.Printf("Fatal error: %v\n", err)
 			os.Exit(1)
 		}
-		ent, err := th.RetrieveFile(entryhashPar, outFolderPar, false)
+		ent, err := th.RetrieveFile(entryhashPar, outFolderPar, flagCacheOnly)
 		if err == nil && ent != nil {
 			fmt.Printf("File retrieved:\n")
 			fmt.Printf("Name: %s\n", ent.Name)
@@ -354,14 +811,169 @@ func main() {
 			fmt.Printf("Saved as: %s\n", filepath.Join(outFolderPar, ent.Name))
 		}
 		mainerr = err
+	case "resume_store":
+		ks, err := keys.LoadKeystore(ksf, inputs[0])
+		if err != nil {
+			mainerr = err
+			break
+		}
+		err = th.SetKeystore(ks)
+		if err != nil {
+			fmt.Printf("Fatal error: %v\n", err)
+			os.Exit(1)
+		}
+		resumed, err := th.ResumeStores()
+		if len(resumed) == 0 && err == nil {
+			fmt.Printf("No interrupted store found.\n")
+		}
+		for chain, txres := range resumed {
+			fmt.Printf("Result of transactions of chain %s\n", chain)
+			for num, res := range txres {
+				fmt.Printf("%d: %s\n", num, res)
+			}
+		}
+		mainerr = err
+	case "callbacks_listen":
+		fmt.Printf("Listening for miner callbacks on %s/callback\n", inputs[0])
+		mainerr = th.ServeCallbacks(inputs[0], "/callback", flagCallbackToken)
+	case "cache_manage":
+		switch inputs[0] {
+		case "stat":
+			stats, err := th.CacheStats()
+			if err == nil {
+				fmt.Printf("Transactions: %d (confirmed %d, unconfirmed %d)\n", stats.TXs, stats.Confirmed, stats.Unconfirmed)
+				fmt.Printf("Size (B): %d\n", stats.Bytes)
+				fmt.Printf("Addresses: %d\n", stats.Addresses)
+				fmt.Printf("Entries: %d\n", stats.Entries)
+			}
+			mainerr = err
+		case "verify":
+			report, err := th.VerifyCache()
+			if err == nil {
+				fmt.Printf("Transactions checked: %d\n", report.Checked)
+				fmt.Printf("Transactions added to index: %d\n", report.Indexed)
+				fmt.Printf("Indexed transactions missing: %d\n", len(report.Missing))
+				fmt.Printf("Corrupted transactions: %d\n", len(report.Corrupted))
+				for _, id := range report.Corrupted {
+					fmt.Printf("   %s\n", id)
+				}
+				if len(report.Corrupted) > 0 {
+					fmt.Printf("Run 'trh cache prune' to delete them.\n")
+				}
+			}
+			mainerr = err
+		case "prune":
+			removed, err := th.PruneCache(flagCacheSize << 20)
+			fmt.Printf("Transactions deleted from cache: %d\n", len(removed))
+			mainerr = err
+		case "migrate":
+			stats, err := th.MigrateCache()
+			if stats != nil {
+				fmt.Printf("Transactions migrated: %d\n", stats.TXs)
+				fmt.Printf("Addresses migrated: %d\n", stats.Addresses)
+				fmt.Printf("Entries migrated: %d\n", stats.Entries)
+			}
+			mainerr = err
+		default:
+			mainerr = fmt.Errorf("unknown cache action '%s', use stat, verify, prune or migrate", inputs[0])
+		}
+	case "decode_keystore", "decode_password":
+		files := []string{}
+		for _, f := range strings.Split(inputs[1], ",") {
+			files = append(files, strings.TrimSpace(f))
+		}
+		var decoded *ddb.Decoded
+		var err error
+		if command.name == "decode_password" {
+			decoded, err = th.DecodeWithPassword(inputs[0], files, inputs[2])
+		} else {
+			ks, kerr := keys.LoadKeystore(ksf, inputs[0])
+			if kerr != nil {
+				mainerr = kerr
+				break
+			}
+			th.SetKeystoreOffline(ks)
+			decoded, err = th.Decode(files, inputs[2])
+		}
+		if err == nil {
+			fmt.Printf("Transactions decoded: %d\n", decoded.TXs)
+			fmt.Printf("Files stored:\n")
+			for i, me := range decoded.MetaEntries {
+				fmt.Printf("%d  Name: '%s' entryhash: '%s'  time: %s\n", i, me.Name, me.EntryHash, time.Unix(me.Timestamp, 0).Format("2006-01-02 15:04 EST"))
+			}
+			fmt.Printf("Files extracted to %s:\n", inputs[2])
+			for _, e := range decoded.Entries {
+				fmt.Printf("Name: %s  Hash: %s  Size (B): %d\n", e.Name, e.DataHash, e.Size)
+			}
+		}
+		mainerr = err
+	case "archive_manage":
+		ks, err := keys.LoadKeystore(ksf, inputs[1])
+		if err != nil {
+			mainerr = err
+			break
+		}
+		err = th.SetKeystore(ks)
+		if err != nil {
+			mainerr = err
+			break
+		}
+		var archive *ddb.Archive
+		switch inputs[0] {
+		case "export":
+			archive, err = th.ExportArchive(inputs[2])
+		case "import":
+			archive, err = th.ImportArchive(inputs[2])
+		default:
+			err = fmt.Errorf("unknown archive action '%s', use export or import", inputs[0])
+		}
+		if err == nil {
+			fmt.Printf("Addresses: %d\n", len(archive.Addresses))
+			fmt.Printf("Transactions: %d\n", len(archive.TXs))
+			fmt.Printf("Created: %s\n", time.Unix(archive.Created, 0).Format("2006-01-02 15:04 EST"))
+		}
+		mainerr = err
+	case "tx_status":
+		status, err := th.TXStatus(inputs[0])
+		if err == nil {
+			fmt.Printf("TXID: %s\n", status.TXID)
+			fmt.Printf("Status: %s\n", status.Status)
+			if status.BlockHash != "" {
+				fmt.Printf("Block: %s (%d)\n", status.BlockHash, status.BlockHeight)
+			}
+			fmt.Printf("Updated: %s\n", time.Unix(status.Updated, 0).Format("2006-01-02 15:04 EST"))
+		}
+		mainerr = err
 	}
+	th.Close()
 	if mainerr == nil {
 		fmt.Printf("\n\nCommand terminated succesfully.\n")
 		os.Exit(0)
 	} else {
 		fmt.Printf("\n\nCommand terminated with error: %v\n", mainerr)
-		os.Exit(1)
+		os.Exit(exitCode(mainerr))
+	}
+}
+
+//exitCode maps the error to the exit code of the command.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, errs.ErrMinerRejected):
+		return exitMinerRejected
+	case errors.Is(err, errs.ErrNetwork):
+		return exitNetworkError
+	case errors.Is(err, errs.ErrWrongPassword):
+		return exitWrongPassword
+	case errors.Is(err, errs.ErrNoUTXO):
+		return exitNoUTXO
+	case errors.Is(err, errs.ErrInsufficientFunds):
+		return exitInsufficientFund
+	case errors.Is(err, errs.ErrNotCached):
+		return exitNotCached
+	case errors.Is(err, errs.ErrCorruptedPart):
+		return exitCorruptedPart
 	}
+	return exitGenericError
 }
 
 func getKeystorePath() string {
@@ -1,5 +1,16 @@
 package main
 
+import (
+	"fmt"
+	"testing"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/keys"
+	"github.com/ejfhp/ddb/miner"
+	"github.com/ejfhp/ddb/satoshi"
+	"github.com/libsv/go-bt/bscript"
+)
+
 // func TestPassphrase(t *testing.T) {
 // 	trail.SetWriter(os.Stdout)
 // 	clis := [][]string{
@@ -80,3 +91,60 @@ package main
 // 	}
 
 // }
+
+//utxoExplorer serves the given UTXOs for every address, TXs are never found.
+type utxoExplorer struct {
+	utxos []*ddb.UTXO
+}
+
+func (e *utxoExplorer) GetUTXOs(address string) ([]*ddb.UTXO, error) {
+	return e.utxos, nil
+}
+
+func (e *utxoExplorer) GetTX(txHash string) (*ddb.TX, error) {
+	return nil, fmt.Errorf("tx not found: %s", txHash)
+}
+
+func (e *utxoExplorer) GetRAWTXHEX(txHash string) ([]byte, error) {
+	return nil, fmt.Errorf("tx not found: %s", txHash)
+}
+
+func (e *utxoExplorer) GetTXIDs(address string) ([]string, error) {
+	return []string{}, nil
+}
+
+func TestExitCode_TXOfBranchedEntry(t *testing.T) {
+	key, address := "L4ZaBkP1UTyxdEM7wysuPd1scHMLLf8sf8B2tcEcssUZ7ujrYWcQ", "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	keystore, err := keys.NewKeystore(key, "mainpassword")
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	entry, err := ddb.NewEntryFromFile("test.txt", "../../testdata/test.txt", []string{"label1"}, "notes")
+	if err != nil {
+		t.Logf("failed to generate entry: %v", err)
+		t.FailNow()
+	}
+	node, err := keystore.NewNode(entry.Name, entry.HashOfEntry())
+	if err != nil {
+		t.Logf("failed to generate node: %v", err)
+		t.FailNow()
+	}
+	script, _ := bscript.NewP2PKHFromAddress(address)
+	tests := []struct {
+		utxos []*ddb.UTXO
+		code  int
+	}{
+		{utxos: []*ddb.UTXO{}, code: exitNoUTXO},
+		{utxos: []*ddb.UTXO{{TXHash: fmt.Sprintf("%064x", 1), Value: satoshi.Satoshi(1000).Bitcoin(), ScriptPubKeyHex: script.ToString()}}, code: exitInsufficientFund},
+	}
+	for i, tt := range tests {
+		blockchain := ddb.NewBlockchain(miner.NewOffline(nil), &utxoExplorer{utxos: tt.utxos}, nil)
+		btrunk := ddb.NewBTrunk(key, address, keystore.Source().Password(), blockchain)
+		_, err := btrunk.TXOfBranchedEntry(node, entry, "test01234", satoshi.Satoshi(100000), false)
+		if exitCode(err) != tt.code {
+			t.Logf("%d - unexpected exit code %d for: %v", i, exitCode(err), err)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package ddb
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ejfhp/ddb/errs"
+	"github.com/ejfhp/ddb/satoshi"
+	"github.com/ejfhp/trail"
+	"github.com/ejfhp/trail/trace"
+)
+
+const (
+	//SelectAll spends every available UTXO
+	SelectAll = "all"
+	//SelectLargestFirst spends the biggest UTXOs until the target is reached
+	SelectLargestFirst = "largest"
+	//SelectBranchAndBound looks for a set of UTXOs matching the target without change, falls back to SelectLargestFirst
+	SelectBranchAndBound = "bnb"
+	//SelectPrivacy spends the smallest single UTXO covering the target, or random UTXOs, to avoid linking many coins together
+	SelectPrivacy = "privacy"
+)
+
+//MaxBranchAndBoundTries limits the search of SelectBranchAndBound
+var MaxBranchAndBoundTries = 100000
+
+//CoinSelection picks the UTXOs funding a TX. Pinned outpoints (txid:pos) are always spent first.
+type CoinSelection struct {
+	Strategy string
+	Pinned   []string
+}
+
+//NewCoinSelection returns a CoinSelection with the given strategy and pinned outpoints.
+func NewCoinSelection(strategy string, pinned []string) (*CoinSelection, error) {
+	switch strategy {
+	case SelectAll, SelectLargestFirst, SelectBranchAndBound, SelectPrivacy:
+	default:
+		return nil, fmt.Errorf("unknown coin selection strategy: %s", strategy)
+	}
+	for _, p := range pinned {
+		if _, _, err := ParseOutpoint(p); err != nil {
+			return nil, err
+		}
+	}
+	return &CoinSelection{Strategy: strategy, Pinned: pinned}, nil
+}
+
+//ParseOutpoint splits an outpoint in the form txid:pos.
+func ParseOutpoint(outpoint string) (string, uint32, error) {
+	parts := strings.Split(outpoint, ":")
+	if len(parts) != 2 || len(parts[0]) != 64 {
+		return "", 0, fmt.Errorf("invalid outpoint, must be txid:pos: %s", outpoint)
+	}
+	pos, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid outpoint position %s: %w", outpoint, err)
+	}
+	return parts[0], uint32(pos), nil
+}
+
+//Select returns the UTXOs whose total value covers target.
+func (c *CoinSelection) Select(utxos []*UTXO, target satoshi.Satoshi) ([]*UTXO, error) {
+	tr := trace.New().Source("coinselect.go", "CoinSelection", "Select")
+	selected := []*UTXO{}
+	selectedValue := satoshi.Satoshi(0)
+	available := make([]*UTXO, 0, len(utxos))
+	pinned := make(map[string]bool, len(c.Pinned))
+	for _, p := range c.Pinned {
+		pinned[p] = true
+	}
+	for _, u := range utxos {
+		outpoint := fmt.Sprintf("%s:%d", u.TXHash, u.TXPos)
+		if pinned[outpoint] {
+			selected = append(selected, u)
+			selectedValue = selectedValue.Add(u.Value)
+			delete(pinned, outpoint)
+			continue
+		}
+		available = append(available, u)
+	}
+	for p := range pinned {
+		trail.Println(trace.Alert("pinned outpoint not available").UTC().Add("outpoint", p).Append(tr))
+		return nil, fmt.Errorf("pinned outpoint not available: %s", p)
+	}
+	if len(c.Pinned) > 0 && selectedValue >= target {
+		return selected, nil
+	}
+	missing, _ := target.Sub(selectedValue)
+	var others []*UTXO
+	switch c.Strategy {
+	case SelectAll:
+		others = available
+	case SelectLargestFirst:
+		others = largestFirst(available, missing)
+	case SelectBranchAndBound:
+		others = branchAndBound(available, missing, DustLimit)
+		if others == nil {
+			others = largestFirst(available, missing)
+		}
+	case SelectPrivacy:
+		others = privacyFirst(available, missing)
+	default:
+		return nil, fmt.Errorf("unknown coin selection strategy: %s", c.Strategy)
+	}
+	selected = append(selected, others...)
+	total := satoshi.Satoshi(0)
+	for _, u := range selected {
+		total = total.Add(u.Value)
+	}
+	if total < target {
+		trail.Println(trace.Alert("not enough funds").UTC().Add("target", fmt.Sprintf("%d", target)).Add("available", fmt.Sprintf("%d", total)).Append(tr))
+		return nil, fmt.Errorf("%w, target %d available %d", errs.ErrInsufficientFunds, target, total)
+	}
+	trail.Println(trace.Info("UTXOs selected").UTC().Add("strategy", c.Strategy).Add("num", fmt.Sprintf("%d", len(selected))).Add("value", fmt.Sprintf("%d", total)).Append(tr))
+	return selected, nil
+}
+
+func largestFirst(utxos []*UTXO, target satoshi.Satoshi) []*UTXO {
+	sorted := sortedByValue(utxos)
+	return accumulate(sorted, target)
+}
+
+//branchAndBound searches depth first, largest UTXOs first, a subset with value in [target, target+tolerance).
+func branchAndBound(utxos []*UTXO, target satoshi.Satoshi, tolerance satoshi.Satoshi) []*UTXO {
+	if target == 0 {
+		return []*UTXO{}
+	}
+	sorted := sortedByValue(utxos)
+	//remaining[i] is the sum of the values from i to the end
+	remaining := make([]satoshi.Satoshi, len(sorted)+1)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1].Add(sorted[i].Value)
+	}
+	tries := 0
+	included := make([]bool, len(sorted))
+	var search func(i int, value satoshi.Satoshi) bool
+	search = func(i int, value satoshi.Satoshi) bool {
+		tries++
+		if value >= target {
+			return value < target.Add(tolerance)
+		}
+		if i == len(sorted) || tries > MaxBranchAndBoundTries || value.Add(remaining[i]) < target {
+			return false
+		}
+		included[i] = true
+		if search(i+1, value.Add(sorted[i].Value)) {
+			return true
+		}
+		included[i] = false
+		return search(i+1, value)
+	}
+	if !search(0, 0) {
+		return nil
+	}
+	selected := []*UTXO{}
+	for i, in := range included {
+		if in {
+			selected = append(selected, sorted[i])
+		}
+	}
+	return selected
+}
+
+func privacyFirst(utxos []*UTXO, target satoshi.Satoshi) []*UTXO {
+	if target == 0 {
+		return []*UTXO{}
+	}
+	var single *UTXO
+	for _, u := range utxos {
+		if u.Value.Satoshi() >= target && (single == nil || u.Value.Satoshi() < single.Value.Satoshi()) {
+			single = u
+		}
+	}
+	if single != nil {
+		return []*UTXO{single}
+	}
+	shuffled := make([]*UTXO, len(utxos))
+	copy(shuffled, utxos)
+	rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+	return accumulate(shuffled, target)
+}
+
+func sortedByValue(utxos []*UTXO) []*UTXO {
+	sorted := make([]*UTXO, len(utxos))
+	copy(sorted, utxos)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Value.Satoshi() > sorted[j].Value.Satoshi() })
+	return sorted
+}
+
+func accumulate(utxos []*UTXO, target satoshi.Satoshi) []*UTXO {
+	selected := []*UTXO{}
+	value := satoshi.Satoshi(0)
+	for _, u := range utxos {
+		if value >= target {
+			break
+		}
+		selected = append(selected, u)
+		value = value.Add(u.Value)
+	}
+	return selected
+}
@@ -0,0 +1,117 @@
+package ddb_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/errs"
+	"github.com/ejfhp/ddb/satoshi"
+)
+
+const fakeUTXOTXID = "e6706b900df5a46253b8788f691cbe1506c1e9b76766f1f9d6b3602e1458f055"
+
+func fakeUTXOs(values ...uint64) []*ddb.UTXO {
+	utxos := make([]*ddb.UTXO, len(values))
+	for i, v := range values {
+		utxos[i] = &ddb.UTXO{TXHash: fakeUTXOTXID, TXPos: uint32(i), Value: satoshi.Satoshi(v).Bitcoin(), ScriptPubKeyHex: "76a9142f353ff06fe8c4d558b9f58dce952948252e5df788ac"}
+	}
+	return utxos
+}
+
+func sumUTXOs(utxos []*ddb.UTXO) satoshi.Satoshi {
+	tot := satoshi.Satoshi(0)
+	for _, u := range utxos {
+		tot = tot.Add(u.Value)
+	}
+	return tot
+}
+
+func TestCoinSelection_Select(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	utxos := fakeUTXOs(1000, 5000, 3000, 20000, 700)
+	tests := []struct {
+		strategy string
+		target   satoshi.Satoshi
+		num      int
+		value    satoshi.Satoshi
+	}{
+		{strategy: ddb.SelectAll, target: 1000, num: 5, value: 29700},
+		{strategy: ddb.SelectLargestFirst, target: 1000, num: 1, value: 20000},
+		{strategy: ddb.SelectLargestFirst, target: 22000, num: 2, value: 25000},
+		{strategy: ddb.SelectBranchAndBound, target: 8000, num: 2, value: 8000},
+		{strategy: ddb.SelectBranchAndBound, target: 4000, num: 2, value: 4000},
+		{strategy: ddb.SelectPrivacy, target: 2500, num: 1, value: 3000},
+	}
+	for i, tt := range tests {
+		selection, err := ddb.NewCoinSelection(tt.strategy, nil)
+		if err != nil {
+			t.Logf("%d - failed to create selection: %v", i, err)
+			t.FailNow()
+		}
+		selected, err := selection.Select(utxos, tt.target)
+		if err != nil {
+			t.Logf("%d - failed to select: %v", i, err)
+			t.FailNow()
+		}
+		if len(selected) != tt.num || sumUTXOs(selected) != tt.value {
+			t.Logf("%d - %s unexpected selection: %d UTXOs value %d", i, tt.strategy, len(selected), sumUTXOs(selected))
+			t.FailNow()
+		}
+	}
+	selection, _ := ddb.NewCoinSelection(ddb.SelectPrivacy, nil)
+	selected, err := selection.Select(utxos, 26000)
+	if err != nil {
+		t.Logf("failed to select with privacy: %v", err)
+		t.FailNow()
+	}
+	if sumUTXOs(selected) < 26000 {
+		t.Logf("privacy selection doesn't cover the target: %d", sumUTXOs(selected))
+		t.FailNow()
+	}
+	_, err = selection.Select(utxos, 30000)
+	if !errors.Is(err, errs.ErrInsufficientFunds) {
+		t.Logf("selection should fail with ErrInsufficientFunds when funds are not enough: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestCoinSelection_Pinned(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	utxos := fakeUTXOs(1000, 5000, 3000)
+	selection, err := ddb.NewCoinSelection(ddb.SelectLargestFirst, []string{fmt.Sprintf("%s:%d", fakeUTXOTXID, 2)})
+	if err != nil {
+		t.Logf("failed to create selection: %v", err)
+		t.FailNow()
+	}
+	selected, err := selection.Select(utxos, 2000)
+	if err != nil {
+		t.Logf("failed to select: %v", err)
+		t.FailNow()
+	}
+	if len(selected) != 1 || selected[0].TXPos != 2 {
+		t.Logf("pinned outpoint should be enough: %d", len(selected))
+		t.FailNow()
+	}
+	selected, err = selection.Select(utxos, 7000)
+	if err != nil {
+		t.Logf("failed to select: %v", err)
+		t.FailNow()
+	}
+	if len(selected) != 2 || selected[0].TXPos != 2 || selected[1].TXPos != 1 {
+		t.Logf("pinned outpoint should be first followed by the largest: %d", len(selected))
+		t.FailNow()
+	}
+	missing, _ := ddb.NewCoinSelection(ddb.SelectLargestFirst, []string{fmt.Sprintf("%s:%d", fakeUTXOTXID, 9)})
+	_, err = missing.Select(utxos, 100)
+	if err == nil {
+		t.Logf("selection should fail when pinned outpoint is missing")
+		t.FailNow()
+	}
+	_, err = ddb.NewCoinSelection(ddb.SelectLargestFirst, []string{"notanoutpoint"})
+	if err == nil {
+		t.Logf("invalid outpoint should be refused")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,181 @@
+package ddb
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/ejfhp/trail"
+	"github.com/ejfhp/trail/trace"
+	"github.com/libsv/go-bt"
+)
+
+//blockHeaderSize is the size of the header preceding the TXs in a raw block.
+const blockHeaderSize = 80
+
+//minTXSize is the size of the smallest TX: version, no input, no output and locktime.
+const minTXSize = 10
+
+//Decoded holds what has been extracted from raw TXs.
+type Decoded struct {
+	TXs         int
+	MetaEntries []*MetaEntry
+	Entries     []*Entry
+}
+
+//ReadRawTXs reads the TXs in file, without explorer. The file can hold a raw TX or a raw block, in binary or hex,
+//or many hex TXs one per line.
+func ReadRawTXs(file string) ([]*DataTX, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read raw TXs file %s: %w", file, err)
+	}
+	lines := strings.Fields(string(data))
+	raws := make([][]byte, 0, len(lines))
+	for _, l := range lines {
+		raw, err := hex.DecodeString(l)
+		if err != nil {
+			//Not hex, binary TX or block
+			raws = [][]byte{data}
+			break
+		}
+		raws = append(raws, raw)
+	}
+	txs := []*DataTX{}
+	for _, raw := range raws {
+		rtxs, err := rawToTXs(raw)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode raw TXs file %s: %w", file, err)
+		}
+		txs = append(txs, rtxs...)
+	}
+	return txs, nil
+}
+
+//rawToTXs returns the TX in raw, or the TXs of the block in raw.
+func rawToTXs(raw []byte) ([]*DataTX, error) {
+	tx, used, err := txFromStream(raw)
+	if err == nil && used == len(raw) {
+		return []*DataTX{{Tx: tx}}, nil
+	}
+	if len(raw) <= blockHeaderSize {
+		return nil, fmt.Errorf("data is neither a TX nor a block")
+	}
+	num, size, err := readVarInt(raw[blockHeaderSize:])
+	if err != nil {
+		return nil, fmt.Errorf("data is neither a TX nor a block: %w", err)
+	}
+	offset := blockHeaderSize + size
+	//The count is read from untrusted data, it cannot exceed the TXs that fit in the rest
+	if num > uint64((len(raw)-offset)/minTXSize) {
+		return nil, fmt.Errorf("data is neither a TX nor a block, %d TXs cannot fit in %d bytes", num, len(raw)-offset)
+	}
+	txs := make([]*DataTX, 0, num)
+	for i := uint64(0); i < num; i++ {
+		tx, used, err := txFromStream(raw[offset:])
+		if err != nil {
+			return nil, fmt.Errorf("cannot decode TX %d of block: %w", i, err)
+		}
+		offset += used
+		txs = append(txs, &DataTX{Tx: tx})
+	}
+	if offset != len(raw) {
+		return nil, fmt.Errorf("data is neither a TX nor a block, %d bytes left", len(raw)-offset)
+	}
+	return txs, nil
+}
+
+//readVarInt is bt.DecodeVarInt, data shorter than the varint make it panic instead of returning error.
+func readVarInt(data []byte) (uint64, int, error) {
+	if len(data) == 0 {
+		return 0, 0, fmt.Errorf("missing varint")
+	}
+	size := 1
+	switch data[0] {
+	case 0xff:
+		size = 9
+	case 0xfe:
+		size = 5
+	case 0xfd:
+		size = 3
+	}
+	if len(data) < size {
+		return 0, 0, fmt.Errorf("truncated varint")
+	}
+	num, size := bt.DecodeVarInt(data)
+	return num, size, nil
+}
+
+//txFromStream is bt.NewTxFromStream, truncated data make it panic instead of returning error.
+func txFromStream(raw []byte) (tx *bt.Tx, used int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			tx, used, err = nil, 0, fmt.Errorf("truncated TX: %v", r)
+		}
+	}()
+	return bt.NewTxFromStream(raw)
+}
+
+//DecodeTXs extracts from the TXs the MetaEntries encrypted with the BTrunk password and the Entries
+//encrypted with the node passwords, the ones in the MetaEntries found or in nodePasswords.
+//Data that cannot be decrypted is skipped, entries with missing parts are not returned.
+func DecodeTXs(txs []*DataTX, password string, nodePasswords ...[32]byte) (*Decoded, error) {
+	tr := trace.New().Source("decode.go", "", "DecodeTXs")
+	trunkKeys := newTrunkKeys(password)
+	decoded := Decoded{MetaEntries: []*MetaEntry{}, Entries: []*Entry{}}
+	data := [][]byte{}
+	headers := []string{}
+	seen := map[string]bool{}
+	for _, tx := range txs {
+		if seen[tx.GetTxID()] {
+			continue
+		}
+		seen[tx.GetTxID()] = true
+		decoded.TXs++
+		oprs, hdrs, err := tx.AllData()
+		if err != nil {
+			trail.Println(trace.Debug("TX without data").Append(tr).UTC().Add("TXID", tx.GetTxID()).Error(err))
+			continue
+		}
+		data = append(data, oprs...)
+		headers = append(headers, hdrs...)
+	}
+	passwords := append([][32]byte{trunkKeys.legacy}, nodePasswords...)
+	for i, d := range data {
+		me, _ := trunkKeys.decrypt(headers[i], d)
+		if me != nil && me.Timestamp > 0 {
+			decoded.MetaEntries = append(decoded.MetaEntries, me)
+			passwords = append(passwords, me.Password)
+		}
+	}
+	tried := map[[32]byte]bool{}
+	for _, pass := range passwords {
+		if tried[pass] {
+			continue
+		}
+		tried[pass] = true
+		groups := map[string][]*EntryPart{}
+		for _, d := range data {
+			ep, err := EntryPartFromEncrypted(pass, d)
+			if err != nil || ep.NumPart <= 0 || ep.IdxPart < 0 || ep.IdxPart >= ep.NumPart {
+				continue
+			}
+			group := groups[ep.Name+ep.Hash]
+			if len(group) > 0 && group[0].NumPart != ep.NumPart {
+				continue
+			}
+			groups[ep.Name+ep.Hash] = append(group, ep)
+		}
+		//Each entry on its own, one with missing parts doesn't stop the others
+		for _, parts := range groups {
+			entries, err := EntriesFromParts(parts)
+			if err != nil {
+				trail.Println(trace.Warning("error while reassembling Entry").UTC().Add("name", parts[0].Name).Error(err).Append(tr))
+				continue
+			}
+			decoded.Entries = append(decoded.Entries, entries...)
+		}
+	}
+	return &decoded, nil
+}
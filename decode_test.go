@@ -0,0 +1,118 @@
+package ddb_test
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/keys"
+	"github.com/ejfhp/ddb/satoshi"
+)
+
+func TestDecode_DecodeTXs(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "decode_trh")
+	os.MkdirAll(dir, 0700)
+	defer os.RemoveAll(dir)
+	trunkPassword := "therabbithole"
+	nodePassword := keys.StringToPassword("thenodepassword")
+	entry := ddb.NewEntryFromData("decoded.txt", "text/plain", []byte(strings.Repeat("TRH - The Rabbit Hole, decoded offline. ", 20)), []string{"decode"}, "notes")
+	parts, err := entry.ToParts(nodePassword, 300)
+	if err != nil || len(parts) < 2 {
+		t.Logf("failed to make parts: %d %v", len(parts), err)
+		t.FailNow()
+	}
+	payloads := [][]byte{}
+	for _, p := range parts {
+		enc, err := p.Encrypt(nodePassword)
+		if err != nil {
+			t.Logf("failed to encrypt part: %v", err)
+			t.FailNow()
+		}
+		payloads = append(payloads, enc)
+	}
+	meta := ddb.MetaEntry{Name: entry.Name, Password: nodePassword, EntryHash: "entryhash", DataHash: entry.DataHash, Timestamp: 1636000000, Size: entry.Size}
+	encMeta, err := meta.Encrypt(keys.StringToPassword(trunkPassword))
+	if err != nil {
+		t.Logf("failed to encrypt meta entry: %v", err)
+		t.FailNow()
+	}
+	payloads = append(payloads, encMeta)
+	txs := []*ddb.DataTX{}
+	for _, p := range payloads {
+		txs = append(txs, Helper_DataTX(t, p))
+	}
+	hexLines := []string{}
+	block := make([]byte, 80)
+	block = append(block, byte(len(txs)))
+	for _, tx := range txs {
+		hexLines = append(hexLines, tx.ToString())
+		block = append(block, tx.ToBytes()...)
+	}
+	hexFile := filepath.Join(dir, "txs.hex")
+	blockFile := filepath.Join(dir, "block.bin")
+	ioutil.WriteFile(hexFile, []byte(strings.Join(hexLines, "\n")+"\n"), 0600)
+	ioutil.WriteFile(blockFile, block, 0600)
+	for _, file := range []string{hexFile, blockFile} {
+		read, err := ddb.ReadRawTXs(file)
+		if err != nil {
+			t.Logf("failed to read %s: %v", file, err)
+			t.FailNow()
+		}
+		decoded, err := ddb.DecodeTXs(read, trunkPassword)
+		if err != nil {
+			t.Logf("failed to decode %s: %v", file, err)
+			t.FailNow()
+		}
+		if decoded.TXs != len(txs) || len(decoded.MetaEntries) != 1 || len(decoded.Entries) != 1 {
+			t.Logf("unexpected decode of %s: %d TXs, %d meta entries, %d entries", file, decoded.TXs, len(decoded.MetaEntries), len(decoded.Entries))
+			t.FailNow()
+		}
+		if string(decoded.Entries[0].Data) != string(entry.Data) {
+			t.Logf("decoded entry is different")
+			t.FailNow()
+		}
+	}
+	//Without the first part the entry cannot be rebuilt
+	decoded, err := ddb.DecodeTXs(txs[1:], "wrongpassword", nodePassword)
+	if err != nil || len(decoded.MetaEntries) != 0 || len(decoded.Entries) != 0 {
+		t.Logf("unexpected decode of incomplete TXs: %v %v", decoded, err)
+		t.FailNow()
+	}
+	wrong := filepath.Join(dir, "wrong.hex")
+	ioutil.WriteFile(wrong, []byte(hex.EncodeToString([]byte("not a transaction at all"))), 0600)
+	_, err = ddb.ReadRawTXs(wrong)
+	if err == nil {
+		t.Logf("reading invalid TX should fail")
+		t.FailNow()
+	}
+	for i, count := range [][]byte{{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, {0xfe, 0xff, 0xff, 0x00, 0x00}, {0xff, 0x01}} {
+		huge := make([]byte, 100)
+		copy(huge[80:], count)
+		hugeFile := filepath.Join(dir, fmt.Sprintf("huge%d.bin", i))
+		ioutil.WriteFile(hugeFile, huge, 0600)
+		_, err = ddb.ReadRawTXs(hugeFile)
+		if err == nil {
+			t.Logf("reading block with TX count %x should fail", count)
+			t.FailNow()
+		}
+	}
+}
+
+func Helper_DataTX(t *testing.T, payload []byte) *ddb.DataTX {
+	destinationAddress := "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	destinationKey := "L4ZaBkP1UTyxdEM7wysuPd1scHMLLf8sf8B2tcEcssUZ7ujrYWcQ"
+	txid := "e6706b900df5a46253b8788f691cbe1506c1e9b76766f1f9d6b3602e1458f055"
+	scriptHex := "76a9142f353ff06fe8c4d558b9f58dce952948252e5df788ac"
+	header, _ := ddb.BuildDataHeader("test")
+	utxos := []*ddb.UTXO{{TXHash: txid, TXPos: 1, Value: satoshi.Bitcoin(0.000402740), ScriptPubKeyHex: scriptHex}}
+	datatx, err := ddb.NewDataTX(destinationKey, destinationAddress, destinationAddress, utxos, satoshi.EmptyWallet, satoshi.Satoshi(1000), payload, header)
+	if err != nil {
+		t.Fatalf("failed to create data tx: %v", err)
+	}
+	return datatx
+}
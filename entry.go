@@ -11,6 +11,7 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/ejfhp/ddb/errs"
 	"github.com/ejfhp/ddb/keys"
 	"github.com/ejfhp/trail"
 	"github.com/ejfhp/trail/trace"
@@ -137,7 +138,7 @@ func EntriesFromParts(parts []*EntryPart) ([]*Entry, error) {
 		nhash := hex.EncodeToString(nh[:])
 		if nhash != entry.DataHash {
 			trail.Println(trace.Alert("hash of decoded entry doesn't match").UTC().Add("new hash", nhash).Add("hash", entry.DataHash))
-			return nil, fmt.Errorf("hash of decoded entry doesn't match stored:%s  new:%s", entry.DataHash, nhash)
+			return nil, fmt.Errorf("hash of decoded entry doesn't match stored:%s  new:%s: %w", entry.DataHash, nhash, errs.ErrCorruptedPart)
 		}
 		entry.Data = data
 		entry.Size = len(data)
@@ -172,12 +173,12 @@ func EntryPartFromJSON(encoded []byte) (*EntryPart, error) {
 func EntryPartFromEncrypted(password [32]byte, encrypted []byte) (*EntryPart, error) {
 	encoded, err := keys.AESDecrypt(password, encrypted)
 	if err != nil {
-		return nil, fmt.Errorf("cannot decrypt data: %w", err)
+		return nil, fmt.Errorf("cannot decrypt data: %w", errs.ErrWrongPassword)
 	}
 	var entry EntryPart
 	err = json.Unmarshal(encoded, &entry)
 	if err != nil {
-		return nil, fmt.Errorf("cannot unmarshal data: %w", err)
+		return nil, fmt.Errorf("cannot unmarshal data: %v: %w", err, errs.ErrCorruptedPart)
 	}
 	return &entry, nil
 }
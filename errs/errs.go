@@ -1,5 +1,62 @@
 package errs
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var ErrNotFound error = errors.New("not found")
+
+//ErrNoUTXO is returned when an address has no unspent output.
+var ErrNoUTXO error = errors.New("found no UTXO")
+
+//ErrInsufficientFunds is returned when the UTXOs available don't cover amount and fee.
+var ErrInsufficientFunds error = errors.New("insufficient funds")
+
+//ErrWrongPassword is returned when data cannot be decrypted with the given PIN or password.
+var ErrWrongPassword error = errors.New("wrong password")
+
+//ErrNetwork is matched by every NetworkError.
+var ErrNetwork error = errors.New("network error")
+
+//ErrMinerRejected is matched by the errors of TXs refused by the miner.
+var ErrMinerRejected error = errors.New("rejected by miner")
+
+//ErrNotCached is returned when the data requested is not in the cache.
+var ErrNotCached error = errors.New("entry not in cache")
+
+//ErrCorruptedPart is returned when an entry part or the entry rebuilt from its parts is not valid.
+var ErrCorruptedPart error = errors.New("corrupted entry part")
+
+//NetworkError is a failed call to a remote service, explorer or miner.
+type NetworkError struct {
+	Service string
+	URL     string
+	Status  string
+	Err     error
+}
+
+//Network returns a NetworkError for the failed call to url.
+func Network(service string, url string, err error) error {
+	return &NetworkError{Service: service, URL: url, Err: err}
+}
+
+//NetworkStatus returns a NetworkError for the call to url answered with a bad HTTP status.
+func NetworkStatus(service string, url string, status string) error {
+	return &NetworkError{Service: service, URL: url, Status: status}
+}
+
+func (e *NetworkError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("%s replied with bad status: %s", e.Service, e.Status)
+	}
+	return fmt.Sprintf("error calling %s: %v", e.Service, e.Err)
+}
+
+func (e *NetworkError) Unwrap() error {
+	return e.Err
+}
+
+func (e *NetworkError) Is(target error) bool {
+	return target == ErrNetwork
+}
@@ -0,0 +1,32 @@
+package errs_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ejfhp/ddb/errs"
+)
+
+func TestNetworkError(t *testing.T) {
+	cause := fmt.Errorf("connection refused")
+	err := fmt.Errorf("error while getting TX: %w", errs.Network("whatsonchain", "https://example.com", cause))
+	if !errors.Is(err, errs.ErrNetwork) {
+		t.Logf("wrapped NetworkError should match ErrNetwork: %v", err)
+		t.FailNow()
+	}
+	if !errors.Is(err, cause) {
+		t.Logf("NetworkError should unwrap to its cause: %v", err)
+		t.FailNow()
+	}
+	var netErr *errs.NetworkError
+	if !errors.As(err, &netErr) || netErr.Service != "whatsonchain" {
+		t.Logf("unexpected NetworkError: %v", netErr)
+		t.FailNow()
+	}
+	status := errs.NetworkStatus("taal", "https://example.com", "503 Service Unavailable")
+	if !errors.Is(status, errs.ErrNetwork) || errors.Is(status, errs.ErrNoUTXO) {
+		t.Logf("bad status should match only ErrNetwork: %v", status)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,78 @@
+package ddb
+
+import (
+	"fmt"
+
+	"github.com/ejfhp/ddb/satoshi"
+)
+
+const (
+	TXRoleMetaEntry = "metaentry"
+	TXRoleFunding   = "funding"
+	TXRolePart      = "part"
+	TXRoleFinal     = "final"
+	TXRoleBatch     = "batch"
+)
+
+//TXCost is the cost of a single TX of a store.
+type TXCost struct {
+	Role          string          `json:"role"`
+	Inputs        int             `json:"inputs"`
+	Outputs       int             `json:"outputs"`
+	StandardBytes int             `json:"standardbytes"`
+	DataBytes     int             `json:"databytes"`
+	Fee           satoshi.Satoshi `json:"fee"`
+}
+
+//CostEstimate is the breakdown of the cost of storing an entry.
+type CostEstimate struct {
+	TXs          []*TXCost       `json:"txs"`
+	TotalBytes   int             `json:"totalbytes"`
+	TotalFee     satoshi.Satoshi `json:"totalfee"`
+	StandardRate float64         `json:"standardrate"`
+	DataRate     float64         `json:"datarate"`
+}
+
+//EstimateCost returns the breakdown of the cost of the TXs generated by BTrunk with the given layout, LayoutBatch for TXOfBatchedEntries.
+//Rates are in satoshi per kB of the miner fee schedule, so with an offline miner no connection is needed.
+func (b *Blockchain) EstimateCost(txs []*DataTX, layout string) (*CostEstimate, error) {
+	fees, err := b.miner.GetFees()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get Fees: %w", err)
+	}
+	stdFee, err := fees.GetStandardFee()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get standard fee: %w", err)
+	}
+	dataFee, err := fees.GetDataFee()
+	if err != nil {
+		return nil, fmt.Errorf("cannot get data fee: %w", err)
+	}
+	estimate := CostEstimate{TXs: make([]*TXCost, 0, len(txs)), StandardRate: stdFee.Rate(), DataRate: dataFee.Rate()}
+	for i, tx := range txs {
+		_, _, fee, err := tx.TotInOutFee()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get fee of TX %d: %w", i, err)
+		}
+		standardBytes, dataBytes := tx.Sizes()
+		cost := TXCost{Role: txRole(i, len(txs), layout), Inputs: len(tx.Inputs), Outputs: len(tx.Outputs), StandardBytes: standardBytes, DataBytes: dataBytes, Fee: fee}
+		estimate.TXs = append(estimate.TXs, &cost)
+		estimate.TotalBytes += standardBytes + dataBytes
+		estimate.TotalFee = estimate.TotalFee.Add(fee)
+	}
+	return &estimate, nil
+}
+
+func txRole(i int, num int, layout string) string {
+	switch {
+	case layout == LayoutBatch:
+		return TXRoleBatch
+	case i == 0:
+		return TXRoleMetaEntry
+	case layout == LayoutFanOut && i == 1:
+		return TXRoleFunding
+	case layout == LayoutChain && i == num-1:
+		return TXRoleFinal
+	}
+	return TXRolePart
+}
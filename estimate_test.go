@@ -0,0 +1,75 @@
+package ddb_test
+
+import (
+	"testing"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/keys"
+	"github.com/ejfhp/ddb/miner"
+	"github.com/ejfhp/ddb/satoshi"
+)
+
+func TestBlockchain_EstimateCost_Offline(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	keystore, err := keys.NewKeystore(destinationKey, "mainpassword")
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	off := miner.NewOffline(nil)
+	off.MaxData = 1000
+	blockchain := ddb.NewBlockchain(off, nil, nil)
+	btrunk := ddb.NewBTrunk(destinationKey, destinationAddress, keystore.Source().Password(), blockchain)
+	entry, err := ddb.NewEntryFromFile("image.png", "testdata/image.png", []string{"label1"}, "notes")
+	if err != nil {
+		t.Logf("failed to generate entry: %v", err)
+		t.FailNow()
+	}
+	node, err := keystore.NewNode(entry.Name, entry.HashOfEntry())
+	if err != nil {
+		t.Logf("failed to generate node: %v", err)
+		t.FailNow()
+	}
+	txs, err := btrunk.TXOfBranchedEntry(node, entry, "test01234", satoshi.Satoshi(100000), true)
+	if err != nil {
+		t.Logf("failed to generate TXs offline: %v", err)
+		t.FailNow()
+	}
+	estimate, err := blockchain.EstimateCost(txs, btrunk.Layout())
+	if err != nil {
+		t.Logf("failed to estimate cost: %v", err)
+		t.FailNow()
+	}
+	if len(estimate.TXs) != len(txs) {
+		t.Logf("unexpected number of TX costs: %d", len(estimate.TXs))
+		t.FailNow()
+	}
+	if estimate.TXs[0].Role != ddb.TXRoleMetaEntry || estimate.TXs[1].Role != ddb.TXRolePart || estimate.TXs[len(txs)-1].Role != ddb.TXRoleFinal {
+		t.Logf("unexpected roles: %s %s %s", estimate.TXs[0].Role, estimate.TXs[1].Role, estimate.TXs[len(txs)-1].Role)
+		t.FailNow()
+	}
+	totFee := satoshi.Satoshi(0)
+	totBytes := 0
+	for _, c := range estimate.TXs {
+		totFee = totFee.Add(c.Fee)
+		totBytes += c.StandardBytes + c.DataBytes
+	}
+	if totFee != estimate.TotalFee || totBytes != estimate.TotalBytes {
+		t.Logf("totals don't match: %d %d", estimate.TotalFee, estimate.TotalBytes)
+		t.FailNow()
+	}
+	if estimate.StandardRate != 500 || estimate.DataRate != 250 {
+		t.Logf("unexpected rates: %f %f", estimate.StandardRate, estimate.DataRate)
+		t.FailNow()
+	}
+	single, err := blockchain.EstimateCost(txs[:1], btrunk.Layout())
+	if err != nil || single.TXs[0].Role != ddb.TXRoleMetaEntry {
+		t.Logf("single TX of a chain not a meta entry: %v", err)
+		t.FailNow()
+	}
+	batch, err := blockchain.EstimateCost(txs[:1], ddb.LayoutBatch)
+	if err != nil || batch.TXs[0].Role != ddb.TXRoleBatch {
+		t.Logf("TX of a batch not labelled as batch: %v", err)
+		t.FailNow()
+	}
+}
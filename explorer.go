@@ -1,6 +1,10 @@
 package ddb
 
-import "github.com/ejfhp/ddb/satoshi"
+import (
+	"encoding/json"
+
+	"github.com/ejfhp/ddb/satoshi"
+)
 
 type UTXO struct {
 	TXPos           uint32
@@ -10,14 +14,17 @@ type UTXO struct {
 }
 
 type TX struct {
-	ID       string  `json:"txid"`
-	Hash     string  `json:"hash"`
-	Hex      string  `json:"hex"`
-	Version  int     `json:"version"`
-	Size     uint32  `json:"size"`
-	Locktime uint32  `json:"locktime"`
-	In       []*Vin  `json:"vin"`
-	Out      []*Vout `json:"vout"`
+	ID            string  `json:"txid"`
+	Hash          string  `json:"hash"`
+	Hex           string  `json:"hex"`
+	Version       int     `json:"version"`
+	Size          uint32  `json:"size"`
+	Locktime      uint32  `json:"locktime"`
+	In            []*Vin  `json:"vin"`
+	Out           []*Vout `json:"vout"`
+	BlockHash     string  `json:"blockhash"`
+	BlockHeight   int     `json:"blockheight"`
+	Confirmations int     `json:"confirmations"`
 }
 
 type Vin struct {
@@ -53,3 +60,19 @@ type Explorer interface {
 	GetRAWTXHEX(txHash string) ([]byte, error)
 	GetTXIDs(address string) ([]string, error)
 }
+
+//HistoryTX is a TX of the history of an address, Height is 0 if the TX is not mined yet.
+type HistoryTX struct {
+	TXID   string
+	Height int
+}
+
+//HistoryExplorer is implemented by the explorers able to return only the history after a given height.
+type HistoryExplorer interface {
+	GetHistory(address string, fromHeight int) ([]*HistoryTX, error)
+}
+
+//ProofExplorer is implemented by the explorers able to return the merkle proof, in TSC format, of a mined TX.
+type ProofExplorer interface {
+	GetMerkleProof(txHash string) (json.RawMessage, error)
+}
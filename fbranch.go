@@ -10,11 +10,16 @@ import (
 	"github.com/ejfhp/trail/trace"
 )
 
+//DustLimit is the value that each part TX of the fan-out layout sends back to the FBranch address.
+var DustLimit = satoshi.Satoshi(546)
+
 type FBranch struct {
 	BitcoinWIF string
 	BitcoinAdd string
 	Password   [32]byte
 	Blockchain *Blockchain
+	//Unsigned makes the TXs be built without signing them, they have to be signed by a Signer in the given order.
+	Unsigned bool
 }
 
 type BResult struct {
@@ -44,6 +49,62 @@ func (fb *FBranch) ProcessEntry(entry *Entry, utxo []*UTXO, header string) ([]*D
 	return txs, nil
 }
 
+//ProcessEntryFanOut prepares all the TXs required to store the entry using the fan-out layout.
+//The first TX funds an output for each part, every part TX spends its own output, so part TXs don't depend on each other.
+//The funding change goes to changeAddress.
+func (fb *FBranch) ProcessEntryFanOut(entry *Entry, utxo []*UTXO, header string, changeAddress string) ([]*DataTX, error) {
+	tr := trace.New().Source("fbranch.go", "FBranch", "ProcessEntryFanOut")
+	trail.Println(trace.Info("preparing file").Add("file", entry.Name).Add("size", fmt.Sprintf("%d", len(entry.Data))).UTC().Append(tr))
+	entryParts, err := entry.ToParts(fb.Password, fb.Blockchain.miner.MaxOpReturn())
+	if err != nil {
+		trail.Println(trace.Alert("error making parts of entry").UTC().Error(err).Append(tr))
+		return nil, fmt.Errorf("error making parts of entry: %w", err)
+	}
+	encParts := make([][]byte, len(entryParts))
+	amounts := make([]satoshi.Satoshi, len(entryParts))
+	fees := make([]satoshi.Satoshi, len(entryParts))
+	for i, ep := range entryParts {
+		encParts[i], err = ep.Encrypt(fb.Password)
+		if err != nil {
+			trail.Println(trace.Alert("error while encrypting entry part").UTC().Error(err).Append(tr))
+			return nil, fmt.Errorf("error while encrypting entry part: %w", err)
+		}
+		fees[i], err = fb.Blockchain.EstimateDataTXFee(1, encParts[i], header)
+		if err != nil {
+			trail.Println(trace.Alert("cannot calculate DataTX fee").UTC().Error(err).Append(tr))
+			return nil, fmt.Errorf("cannot calculate DataTX fee: %w", err)
+		}
+		amounts[i] = fees[i].Add(DustLimit)
+	}
+	fundingFee, err := fb.Blockchain.EstimateFanOutTXFee(len(utxo), len(amounts))
+	if err != nil {
+		trail.Println(trace.Alert("cannot calculate fan-out TX fee").UTC().Error(err).Append(tr))
+		return nil, fmt.Errorf("cannot calculate fan-out TX fee: %w", err)
+	}
+	var fundingTX *DataTX
+	if fb.Unsigned {
+		fundingTX, err = NewUnsignedFanOutTX(fb.BitcoinAdd, changeAddress, utxo, amounts, fundingFee)
+	} else {
+		fundingTX, err = NewFanOutTX(fb.BitcoinWIF, fb.BitcoinAdd, changeAddress, utxo, amounts, fundingFee)
+	}
+	if err != nil {
+		trail.Println(trace.Alert("cannot build fan-out TX").UTC().Error(err).Append(tr))
+		return nil, fmt.Errorf("cannot build fan-out TX: %w", err)
+	}
+	txs := []*DataTX{fundingTX}
+	fundingUTXOs := fundingTX.UTXOs()
+	for i, encbytes := range encParts {
+		dataTx, err := fb.newDataTX(fb.BitcoinAdd, fundingUTXOs[i:i+1], satoshi.EmptyWallet, fees[i], encbytes, header)
+		if err != nil {
+			trail.Println(trace.Alert("cannot build TX").UTC().Error(err).Append(tr))
+			return nil, fmt.Errorf("cannot build TX: %w", err)
+		}
+		trail.Println(trace.Info("DataTX built").UTC().Add("fee", fmt.Sprintf("%0.8f", fees[i].Bitcoin())).Add("txid", dataTx.GetTxID()).Append(tr))
+		txs = append(txs, dataTx)
+	}
+	return txs, nil
+}
+
 func (fb *FBranch) EstimateEntryFee(header string, entry *Entry) (satoshi.Satoshi, error) {
 	tr := trace.New().Source("fbranch.go", "FBranch", "EstimateEntryFee")
 	entryParts, err := entry.ToParts(fb.Password, fb.Blockchain.miner.MaxOpReturn())
@@ -86,7 +147,7 @@ func (fb *FBranch) packEntryParts(header string, parts []*EntryPart, utxos []*UT
 			trail.Println(trace.Alert("cannot calculate DataTX fee").UTC().Error(err).Append(tr))
 			return nil, fmt.Errorf("cannot calculate DataTX fee: %w", err)
 		}
-		dataTx, err := NewDataTX(fb.BitcoinWIF, fb.BitcoinAdd, fb.BitcoinAdd, utxos, satoshi.EmptyWallet, fee, encbytes, header)
+		dataTx, err := fb.newDataTX(fb.BitcoinAdd, utxos, satoshi.EmptyWallet, fee, encbytes, header)
 		if err != nil {
 			trail.Println(trace.Alert("cannot build TX").UTC().Error(err).Append(tr))
 			return nil, fmt.Errorf("cannot build TX: %w", err)
@@ -100,6 +161,14 @@ func (fb *FBranch) packEntryParts(header string, parts []*EntryPart, utxos []*UT
 	return dataTXs, nil
 }
 
+//newDataTX builds a DataTX spending UTXOs of the FBranch address, change goes back to the FBranch address. It is signed with the FBranch key unless Unsigned is set.
+func (fb *FBranch) newDataTX(destinationAddress string, utxos []*UTXO, amount satoshi.Token, fee satoshi.Token, data []byte, header string) (*DataTX, error) {
+	if fb.Unsigned {
+		return NewUnsignedDataTX(destinationAddress, fb.BitcoinAdd, utxos, amount, fee, data, header)
+	}
+	return NewDataTX(fb.BitcoinWIF, destinationAddress, fb.BitcoinAdd, utxos, amount, fee, data, header)
+}
+
 //GetEntriesFromTXIDs retrieve all the Entries fully contained in the transactions with the given IDs.
 func (fb *FBranch) GetEntriesFromTXIDs(txids []string, cacheOnly bool) ([]*Entry, error) {
 	tr := trace.New().Source("fbranch.go", "FBranch", "RetrievingEntries")
@@ -169,17 +238,19 @@ func (fb *FBranch) unpackEntryParts(txs []*DataTX) ([]*EntryPart, error) {
 	trail.Println(trace.Info("opening TXs").UTC().Append(tr))
 	parts := make([]*EntryPart, 0, len(txs))
 	for _, tx := range txs {
-		opr, header, err := tx.Data()
+		oprs, headers, err := tx.AllData()
 		if err != nil {
 			trail.Println(trace.Warning("error while getting OpReturn data from DataTX, probably is not a TRH transaction").Append(tr).UTC().Add("TXID", tx.GetTxID()).Error(err))
 			continue
 		}
-		ep, err := EntryPartFromEncrypted(fb.Password, opr)
-		if err != nil {
-			trail.Println(trace.Warning("error while exctracting entry part from encrypted bytes, probably the encrypting password was different").Append(tr).UTC().Add("header", header).Add("TXID", tx.GetTxID()).Error(err))
-			continue
+		for i, opr := range oprs {
+			ep, err := EntryPartFromEncrypted(fb.Password, opr)
+			if err != nil {
+				trail.Println(trace.Warning("error while exctracting entry part from encrypted bytes, probably the encrypting password was different").Append(tr).UTC().Add("header", headers[i]).Add("TXID", tx.GetTxID()).Error(err))
+				continue
+			}
+			parts = append(parts, ep)
 		}
-		parts = append(parts, ep)
 	}
 	return parts, nil
 }
@@ -10,6 +10,7 @@ import (
 
 	"github.com/ejfhp/ddb"
 	"github.com/ejfhp/ddb/miner"
+	"github.com/ejfhp/ddb/satoshi"
 )
 
 func TestFBranch_ProcessEntry(t *testing.T) {
@@ -478,3 +479,57 @@ func TestFBranch_DownloadAll(t *testing.T) {
 	}
 	t.Logf("downloaded entries: %d", n)
 }
+
+func TestFBranch_ProcessEntryFanOut(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	expl := newFakeExplorer()
+	blockchain := ddb.NewBlockchain(&fakeMiner{maxOpReturn: 1000}, expl, nil)
+	destinationAddress := "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	destinationKey := "L4ZaBkP1UTyxdEM7wysuPd1scHMLLf8sf8B2tcEcssUZ7ujrYWcQ"
+	changeAddress := "1EpFjTzJoNAFyJKVGATzxhgqXigUWLNWM6"
+	password := [32]byte{'f', 'a', 'n', 'o', 'u', 't'}
+	data, err := ioutil.ReadFile("testdata/image.png")
+	if err != nil {
+		t.Logf("failed to read file: %v", err)
+		t.FailNow()
+	}
+	fbranch := &ddb.FBranch{BitcoinWIF: destinationKey, BitcoinAdd: destinationAddress, Password: password, Blockchain: blockchain}
+	entry := ddb.NewEntryFromData("image.png", "image/png", data, []string{"fanout"}, "")
+	utxos := []*ddb.UTXO{{TXHash: "e6706b900df5a46253b8788f691cbe1506c1e9b76766f1f9d6b3602e1458f055", TXPos: 0, Value: satoshi.Bitcoin(0.01), ScriptPubKeyHex: "76a9142f353ff06fe8c4d558b9f58dce952948252e5df788ac"}}
+	txs, err := fbranch.ProcessEntryFanOut(entry, utxos, "123456789", changeAddress)
+	if err != nil {
+		t.Logf("failed to process entry: %v", err)
+		t.FailNow()
+	}
+	if len(txs) < 3 {
+		t.Logf("unexpected number of transactions: %d", len(txs))
+		t.FailNow()
+	}
+	funding := txs[0]
+	if len(funding.Outputs) != len(txs) {
+		t.Logf("funding TX should have an output for each part plus change: %d", len(funding.Outputs))
+		t.FailNow()
+	}
+	txids := []string{}
+	for i, tx := range txs[1:] {
+		if len(tx.Inputs) != 1 || tx.Inputs[0].PreviousTxID != funding.GetTxID() || tx.Inputs[0].PreviousTxOutIndex != uint32(i) {
+			t.Logf("part %d doesn't spend its own funding output", i)
+			t.FailNow()
+		}
+		if tx.Outputs[0].Satoshis != uint64(ddb.DustLimit) {
+			t.Logf("part %d has unexpected output value: %d", i, tx.Outputs[0].Satoshis)
+			t.FailNow()
+		}
+		expl.txs[tx.GetTxID()] = tx
+		txids = append([]string{tx.GetTxID()}, txids...)
+	}
+	entries, err := fbranch.GetEntriesFromTXIDs(txids, false)
+	if err != nil {
+		t.Logf("failed to get entries: %v", err)
+		t.FailNow()
+	}
+	if len(entries) != 1 || entries[0].DataHash != entry.DataHash {
+		t.Logf("unexpected entries retrieved: %d", len(entries))
+		t.FailNow()
+	}
+}
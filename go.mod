@@ -7,6 +7,8 @@ require (
 	github.com/bitcoinsv/bsvutil v0.0.0-20181216182056-1d77cf353ea9
 	github.com/ejfhp/trail v0.0.3
 	github.com/libsv/go-bt v0.0.11
+	github.com/tyler-smith/go-bip39 v1.1.0
+	go.etcd.io/bbolt v1.3.6
 	golang.org/x/crypto v0.0.0-20210616213533-5ff15b29337e
 	rsc.io/qr v0.2.0
 )
@@ -0,0 +1,189 @@
+package ddb_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/errs"
+	"github.com/ejfhp/ddb/miner"
+	"github.com/ejfhp/ddb/satoshi"
+)
+
+//fakeMiner accepts every TX not listed in refuse and records the submissions, fees are 500 sat/kB.
+type fakeMiner struct {
+	submitted   [][]string
+	refuse      map[string]string
+	options     miner.SubmitOptions
+	policy      *miner.FeePolicy
+	maxOpReturn int
+}
+
+func (m *fakeMiner) GetName() string {
+	return "fake"
+}
+
+func (m *fakeMiner) MaxOpReturn() int {
+	if m.maxOpReturn > 0 {
+		return m.maxOpReturn
+	}
+	return 100000
+}
+
+func (m *fakeMiner) GetFees() (miner.Fees, error) {
+	sat500 := satoshi.Satoshi(500)
+	sat250 := satoshi.Satoshi(250)
+	return miner.Fees{
+		{FeeType: "standard", MiningFee: miner.FeeUnit{Satoshis: &sat500, Bytes: 1000}, RelayFee: miner.FeeUnit{Satoshis: &sat250, Bytes: 1000}},
+		{FeeType: "data", MiningFee: miner.FeeUnit{Satoshis: &sat500, Bytes: 1000}, RelayFee: miner.FeeUnit{Satoshis: &sat250, Bytes: 1000}},
+	}, nil
+}
+
+func (m *fakeMiner) GetDataFee() (*miner.Fee, error) {
+	fees, _ := m.GetFees()
+	return fees.GetDataFee()
+}
+
+func (m *fakeMiner) GetStandardFee() (*miner.Fee, error) {
+	fees, _ := m.GetFees()
+	return fees.GetStandardFee()
+}
+
+func (m *fakeMiner) GetFeePolicy() miner.FeePolicy {
+	if m.policy != nil {
+		return *m.policy
+	}
+	return miner.DefaultFeePolicy()
+}
+
+func (m *fakeMiner) SetFeePolicy(policy miner.FeePolicy) {
+	m.policy = &policy
+}
+
+func (m *fakeMiner) SetSubmitOptions(options miner.SubmitOptions) {
+	m.options = options
+}
+
+func (m *fakeMiner) SubmitTX(rawTX string) (*miner.SubmitResult, error) {
+	res, err := m.SubmitMultiTX([]string{rawTX})
+	if err != nil {
+		return nil, err
+	}
+	if !res[0].Success() {
+		return res[0], &miner.SubmitError{Failures: res}
+	}
+	return res[0], nil
+}
+
+func (m *fakeMiner) SubmitMultiTX(rawTXs []string) ([]*miner.SubmitResult, error) {
+	res := make([]*miner.SubmitResult, 0, len(rawTXs))
+	for _, raw := range rawTXs {
+		dtx, err := ddb.DataTXFromHex(raw)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TX: %w", err)
+		}
+		result := miner.SubmitResult{TXID: dtx.GetTxID(), Result: miner.ResultSuccess, MinerID: "fake", Timestamp: time.Now()}
+		if desc, ok := m.refuse[result.TXID]; ok {
+			result.Result = miner.ResultFailure
+			result.Description = desc
+		}
+		res = append(res, &result)
+	}
+	m.submitted = append(m.submitted, rawTXs)
+	return res, nil
+}
+
+//fakeExplorer serves UTXOs and TXs from memory, TXs listed in confirmed have 1 confirmation.
+type fakeExplorer struct {
+	utxos     map[string][]*ddb.UTXO
+	txs       map[string]*ddb.DataTX
+	history   map[string][]string
+	confirmed map[string]bool
+}
+
+func newFakeExplorer() *fakeExplorer {
+	return &fakeExplorer{utxos: map[string][]*ddb.UTXO{}, txs: map[string]*ddb.DataTX{}, history: map[string][]string{}, confirmed: map[string]bool{}}
+}
+
+func (e *fakeExplorer) GetUTXOs(address string) ([]*ddb.UTXO, error) {
+	return e.utxos[address], nil
+}
+
+func (e *fakeExplorer) GetTX(txHash string) (*ddb.TX, error) {
+	tx := ddb.TX{ID: txHash}
+	if e.confirmed[txHash] {
+		tx.Confirmations = 1
+	}
+	return &tx, nil
+}
+
+func (e *fakeExplorer) GetRAWTXHEX(txHash string) ([]byte, error) {
+	tx, ok := e.txs[txHash]
+	if !ok {
+		return nil, fmt.Errorf("tx not found: %s", txHash)
+	}
+	return []byte(tx.ToString()), nil
+}
+
+func (e *fakeExplorer) GetTXIDs(address string) ([]string, error) {
+	return e.history[address], nil
+}
+
+//fakeHistoryExplorer is a fakeExplorer returning the history after a height, heights holds the block of the mined TXs.
+type fakeHistoryExplorer struct {
+	*fakeExplorer
+	heights     map[string]int
+	fromHeights []int
+}
+
+func (e *fakeHistoryExplorer) GetHistory(address string, fromHeight int) ([]*ddb.HistoryTX, error) {
+	e.fromHeights = append(e.fromHeights, fromHeight)
+	history := []*ddb.HistoryTX{}
+	for _, id := range e.history[address] {
+		height := e.heights[id]
+		if height > 0 && height < fromHeight {
+			continue
+		}
+		history = append(history, &ddb.HistoryTX{TXID: id, Height: height})
+	}
+	return history, nil
+}
+
+//fakeProofExplorer is a fakeHistoryExplorer giving block and merkle proof of the mined TXs.
+type fakeProofExplorer struct {
+	*fakeHistoryExplorer
+}
+
+func (e *fakeProofExplorer) GetTX(txHash string) (*ddb.TX, error) {
+	tx := ddb.TX{ID: txHash}
+	if height := e.heights[txHash]; height > 0 {
+		tx.Confirmations = 1
+		tx.BlockHeight = height
+		tx.BlockHash = fmt.Sprintf("block%d", height)
+	}
+	return &tx, nil
+}
+
+func (e *fakeProofExplorer) GetMerkleProof(txHash string) (json.RawMessage, error) {
+	if e.heights[txHash] <= 0 {
+		return nil, errs.ErrNotFound
+	}
+	return fakeProof(txHash, txHash), nil
+}
+
+//fakeProof is a TSC proof of a TX alone in its block with its sibling, so the merkle root is the double SHA256 of the TXID twice.
+func fakeProof(txOrID string, txid string) json.RawMessage {
+	hash, _ := hex.DecodeString(txid)
+	for i, j := 0, len(hash)-1; i < j; i, j = i+1, j-1 {
+		hash[i], hash[j] = hash[j], hash[i]
+	}
+	first := sha256.Sum256(append(append([]byte{}, hash...), hash...))
+	root := sha256.Sum256(first[:])
+	for i, j := 0, len(root)-1; i < j; i, j = i+1, j-1 {
+		root[i], root[j] = root[j], root[i]
+	}
+	return json.RawMessage(fmt.Sprintf(`{"index":1,"txOrId":"%s","targetType":"merkleRoot","target":"%s","nodes":["*"]}`, txOrID, hex.EncodeToString(root[:])))
+}
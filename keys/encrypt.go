@@ -55,3 +55,9 @@ func AESDecrypt(key [32]byte, encrypted []byte) ([]byte, error) {
 	}
 	return plaindata, err
 }
+
+//DeriveKey derives an AES key from the secret with scrypt, salt must be random and stored next to the data encrypted.
+func DeriveKey(secret string, salt []byte) ([32]byte, error) {
+	params := KDFParams{Name: KDFScrypt, Salt: salt, N: scryptN, R: scryptR, P: scryptP}
+	return params.Key(secret)
+}
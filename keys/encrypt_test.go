@@ -87,3 +87,22 @@ func TestEncryptDecryptFile(t *testing.T) {
 		}
 	}
 }
+
+func TestDeriveKey(t *testing.T) {
+	key1, err := keys.DeriveKey("1234", []byte("salt1"))
+	if err != nil {
+		t.Logf("failed to derive key: %v", err)
+		t.FailNow()
+	}
+	key2, _ := keys.DeriveKey("1234", []byte("salt1"))
+	if key1 != key2 {
+		t.Logf("same PIN and salt must derive the same key")
+		t.FailNow()
+	}
+	key3, _ := keys.DeriveKey("1234", []byte("salt2"))
+	key4, _ := keys.DeriveKey("1235", []byte("salt1"))
+	if key1 == key3 || key1 == key4 {
+		t.Logf("different PIN or salt must derive different keys")
+		t.FailNow()
+	}
+}
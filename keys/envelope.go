@@ -0,0 +1,286 @@
+package keys
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/ejfhp/ddb/errs"
+	"github.com/ejfhp/trail"
+	"github.com/ejfhp/trail/trace"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	//KeystoreMagic identifies the keystore files written in the envelope.
+	KeystoreMagic = "TRHKS"
+	//KeystoreVersion is the version of envelope and keystore JSON written by Save.
+	//Version 0 is the legacy file: the keystore JSON encrypted, without envelope.
+	KeystoreVersion = 1
+	//KDFPlain uses the PIN as AES key, zero padded, as the legacy keystore files.
+	KDFPlain = "plain"
+	//KDFScrypt derives the AES key from the PIN with scrypt.
+	KDFScrypt = "scrypt"
+	//KDFArgon2id derives the AES key from the PIN with argon2id.
+	KDFArgon2id = "argon2id"
+	//legacySuffix is appended to the name of the legacy keystore file kept by the previous migrations.
+	legacySuffix = ".v0"
+	//oldSuffix is appended to the name of the keystore file copy kept by the previous Update.
+	oldSuffix = ".old"
+)
+
+//Costs of scrypt for keystores and the keys derived by DeriveKey.
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+//KeystoreKDF is the KDF of the keystore files written by Save, KDFScrypt or KDFArgon2id.
+var KeystoreKDF = KDFScrypt
+
+//KDFParams are the parameters deriving the AES key of the keystore from the PIN.
+//N, R and P are the costs of scrypt, Time, Memory (KiB) and Threads the ones of argon2id.
+type KDFParams struct {
+	Name    string `json:"name"`
+	Salt    []byte `json:"salt,omitempty"`
+	N       int    `json:"n,omitempty"`
+	R       int    `json:"r,omitempty"`
+	P       int    `json:"p,omitempty"`
+	Time    uint32 `json:"time,omitempty"`
+	Memory  uint32 `json:"memory,omitempty"`
+	Threads uint8  `json:"threads,omitempty"`
+}
+
+//NewKDFParams returns the parameters of the KDF with a new random salt.
+func NewKDFParams(name string) (KDFParams, error) {
+	params := KDFParams{Name: name}
+	switch name {
+	case KDFScrypt:
+		params.N, params.R, params.P = scryptN, scryptR, scryptP
+	case KDFArgon2id:
+		params.Time, params.Memory, params.Threads = 3, 64*1024, 4
+	default:
+		return params, fmt.Errorf("unknown keystore KDF: %s", name)
+	}
+	params.Salt = make([]byte, 16)
+	_, err := io.ReadFull(rand.Reader, params.Salt)
+	if err != nil {
+		return params, fmt.Errorf("cannot generate keystore salt: %w", err)
+	}
+	return params, nil
+}
+
+//envelope is the content of the keystore file, the keystore JSON is encrypted in Data.
+type envelope struct {
+	Magic   string    `json:"magic"`
+	Version int       `json:"version"`
+	KDF     KDFParams `json:"kdf"`
+	Data    []byte    `json:"data"`
+}
+
+//Key returns the AES key of the PIN.
+func (p KDFParams) Key(pin string) ([32]byte, error) {
+	switch p.Name {
+	case KDFPlain:
+		return StringToPassword(pin), nil
+	case KDFScrypt:
+		var key [32]byte
+		derived, err := scrypt.Key([]byte(pin), p.Salt, p.N, p.R, p.P, len(key))
+		if err != nil {
+			return key, fmt.Errorf("cannot derive key: %w", err)
+		}
+		copy(key[:], derived)
+		return key, nil
+	case KDFArgon2id:
+		var key [32]byte
+		if len(p.Salt) == 0 || p.Time == 0 || p.Memory == 0 || p.Threads == 0 {
+			return key, fmt.Errorf("invalid argon2id parameters")
+		}
+		copy(key[:], argon2.IDKey([]byte(pin), p.Salt, p.Time, p.Memory, p.Threads, uint32(len(key))))
+		return key, nil
+	default:
+		return [32]byte{}, fmt.Errorf("unknown keystore KDF: %s", p.Name)
+	}
+}
+
+//sealKeystore encrypts the keystore JSON in the envelope, with the key derived from the PIN by kdf.
+func sealKeystore(encoded []byte, pin string, kdf string) ([]byte, error) {
+	params, err := NewKDFParams(kdf)
+	if err != nil {
+		return nil, err
+	}
+	env := envelope{Magic: KeystoreMagic, Version: KeystoreVersion, KDF: params}
+	key, err := env.KDF.Key(pin)
+	if err != nil {
+		return nil, err
+	}
+	env.Data, err = AESEncrypt(key, encoded)
+	if err != nil {
+		return nil, fmt.Errorf("cannot encrypt Keystore: %w", err)
+	}
+	sealed, err := json.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("cannot encode Keystore envelope: %w", err)
+	}
+	return sealed, nil
+}
+
+//openKeystore decrypts the keystore JSON, from the envelope or from the legacy file.
+//Returns the version of the file and the KDF of the PIN.
+func openKeystore(read []byte, pin string) ([]byte, int, string, error) {
+	env := envelope{}
+	if !bytes.HasPrefix(bytes.TrimSpace(read), []byte("{")) || json.Unmarshal(read, &env) != nil || env.Magic != KeystoreMagic {
+		encoded, err := AESDecrypt(StringToPassword(pin), read)
+		if err != nil {
+			return nil, 0, KDFPlain, fmt.Errorf("cannot decrypt Keystore file: %w", errs.ErrWrongPassword)
+		}
+		return encoded, 0, KDFPlain, nil
+	}
+	if env.Version > KeystoreVersion {
+		return nil, env.Version, env.KDF.Name, fmt.Errorf("keystore version %d is newer than the supported %d, update TRH", env.Version, KeystoreVersion)
+	}
+	key, err := env.KDF.Key(pin)
+	if err != nil {
+		return nil, env.Version, env.KDF.Name, err
+	}
+	encoded, err := AESDecrypt(key, env.Data)
+	if err != nil {
+		return nil, env.Version, env.KDF.Name, fmt.Errorf("cannot decrypt Keystore file: %w", errs.ErrWrongPassword)
+	}
+	return encoded, env.Version, env.KDF.Name, nil
+}
+
+//kdfOf returns the KDF used to save the keystore, the one it was loaded with unless weak.
+func (ks *Keystore) kdfOf() string {
+	if ks.kdf == "" || ks.kdf == KDFPlain {
+		return KeystoreKDF
+	}
+	return ks.kdf
+}
+
+//migrate rewrites the keystore file in the current version with a memory-hard KDF.
+//Once the new file reads back, the copies weakly encrypted with the PIN are wiped, no legacy file is kept.
+func (ks *Keystore) migrate() error {
+	tr := trace.New().Source("envelope.go", "Keystore", "migrate")
+	encoded, err := json.Marshal(ks)
+	if err != nil {
+		return fmt.Errorf("cannot encode Keystore: %w", err)
+	}
+	kdf := ks.kdfOf()
+	sealed, err := sealKeystore(encoded, ks.pin, kdf)
+	if err != nil {
+		return err
+	}
+	err = replaceFile(ks.pathname, sealed)
+	if err != nil {
+		return fmt.Errorf("cannot replace legacy keystore file: %w", err)
+	}
+	ks.kdf = kdf
+	err = ks.verifyFile()
+	if err != nil {
+		trail.Println(trace.Alert("migrated keystore file doesn't read back").Append(tr).UTC().Add("file", ks.pathname).Error(err))
+		return err
+	}
+	err = wipeBackups(ks.pathname)
+	if err != nil {
+		trail.Println(trace.Warning("cannot wipe weak keystore backups").Append(tr).UTC().Add("file", ks.pathname).Error(err))
+		return err
+	}
+	trail.Println(trace.Info("keystore file migrated").Append(tr).UTC().Add("file", ks.pathname))
+	return nil
+}
+
+//verifyFile checks that the keystore file opens with the PIN and holds the same keystore.
+func (ks *Keystore) verifyFile() error {
+	read, err := ioutil.ReadFile(ks.pathname)
+	if err != nil {
+		return fmt.Errorf("cannot read keystore file: %w", err)
+	}
+	encoded, _, _, err := openKeystore(read, ks.pin)
+	if err != nil {
+		return err
+	}
+	expected, err := json.Marshal(ks)
+	if err != nil {
+		return fmt.Errorf("cannot encode Keystore: %w", err)
+	}
+	if !bytes.Equal(encoded, expected) {
+		return fmt.Errorf("keystore file %s differs from the keystore", ks.pathname)
+	}
+	return nil
+}
+
+//wipeBackups overwrites and deletes the backups of the keystore file written by the previous versions.
+//They may be encrypted with the PIN as AES key, brute-forced instantly.
+func wipeBackups(pathname string) error {
+	for _, backup := range []string{pathname + oldSuffix, pathname + legacySuffix} {
+		err := wipeFile(backup)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("cannot delete keystore backup %s: %w", backup, err)
+		}
+	}
+	return nil
+}
+
+//wipeFile overwrites the file with zeros before deleting it.
+func wipeFile(file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(file, make([]byte, info.Size()), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Remove(file)
+}
+
+//ChangePIN saves the keystore encrypted with the new PIN, the backups encrypted with the old one are deleted.
+func (ks *Keystore) ChangePIN(newPIN string) error {
+	tr := trace.New().Source("envelope.go", "Keystore", "ChangePIN")
+	if ks.pathname == "" {
+		return fmt.Errorf("Filename of Keystore missing")
+	}
+	if newPIN == "" {
+		return fmt.Errorf("new PIN cannot be empty")
+	}
+	encoded, err := json.Marshal(ks)
+	if err != nil {
+		return fmt.Errorf("cannot encode Keystore: %w", err)
+	}
+	kdf := ks.kdfOf()
+	sealed, err := sealKeystore(encoded, newPIN, kdf)
+	if err != nil {
+		return err
+	}
+	err = replaceFile(ks.pathname, sealed)
+	if err != nil {
+		trail.Println(trace.Alert("cannot write keystore file").Append(tr).UTC().Add("file", ks.pathname).Error(err))
+		return fmt.Errorf("cannot write keystore file: %w", err)
+	}
+	ks.pin = newPIN
+	ks.kdf = kdf
+	err = wipeBackups(ks.pathname)
+	if err != nil {
+		trail.Println(trace.Warning("cannot delete keystore backup with old PIN").Append(tr).UTC().Add("file", ks.pathname).Error(err))
+		return err
+	}
+	return nil
+}
+
+//replaceFile writes data to a temporary file and renames it to file, file is never left half written.
+func replaceFile(file string, data []byte) error {
+	tmp := file + ".tmp"
+	err := ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
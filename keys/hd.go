@@ -0,0 +1,209 @@
+package keys
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bitcoinsv/bsvd/chaincfg"
+	"github.com/bitcoinsv/bsvutil"
+	"github.com/bitcoinsv/bsvutil/hdkeychain"
+)
+
+//HDNodesBranch is the hardened child of the source extended key the nodes are derived from, as m/0'/i.
+//The branch is hardened so that the xpub and a leaked node key don't expose the source key.
+const HDNodesBranch = hdkeychain.HardenedKeyStart + 0
+
+//IsExtendedKey tells whether key is a BIP32 extended private key, the source of the HD keystores.
+func IsExtendedKey(key string) bool {
+	xkey, err := hdkeychain.NewKeyFromString(key)
+	return err == nil && xkey.IsPrivate()
+}
+
+//NewKeystoreHD returns a keystore whose nodes are children of the extended private key xprv.
+//The source key and address, those of the BTrunk, are the ones of xprv itself.
+func NewKeystoreHD(xprv string, password string) (*Keystore, error) {
+	xkey, err := hdkeychain.NewKeyFromString(xprv)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode extended key: %w", err)
+	}
+	if !xkey.IsPrivate() {
+		return nil, fmt.Errorf("extended key is public, a watch-only instance cannot store")
+	}
+	wif, err := wifOf(xkey)
+	if err != nil {
+		return nil, err
+	}
+	ks, err := NewKeystore(wif, password)
+	if err != nil {
+		return nil, err
+	}
+	ks.source.xkey = xprv
+	return ks, nil
+}
+
+//XPub returns the extended public key of the nodes branch, enough to list the addresses of all the nodes.
+func (ks *Keystore) XPub() (string, error) {
+	branch, err := ks.nodesBranch()
+	if err != nil {
+		return "", err
+	}
+	xpub, err := branch.Neuter()
+	if err != nil {
+		return "", fmt.Errorf("cannot make extended public key: %w", err)
+	}
+	return xpub.String(), nil
+}
+
+//nodesBranch returns the extended key the nodes are derived from.
+func (ks *Keystore) nodesBranch() (*hdkeychain.ExtendedKey, error) {
+	if ks.source.xkey == "" {
+		return nil, fmt.Errorf("keystore source is not an extended key")
+	}
+	xkey, err := hdkeychain.NewKeyFromString(ks.source.xkey)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode source extended key: %w", err)
+	}
+	branch, err := xkey.Child(HDNodesBranch)
+	if err != nil {
+		return nil, fmt.Errorf("cannot derive nodes branch: %w", err)
+	}
+	return branch, nil
+}
+
+//newHDNode returns the node of the entity hash derived as the child following the last one.
+//The same entity hash gives the node already in the keystore.
+func (ks *Keystore) newHDNode(entityname string, entityhash [32]byte) (*Node, error) {
+	id := hex.EncodeToString(entityhash[:])
+	var next uint32
+	for _, n := range ks.nodes {
+		if !n.hd {
+			continue
+		}
+		if n.id == id {
+			return n, nil
+		}
+		if n.index >= next {
+			next = n.index + 1
+		}
+	}
+	branch, err := ks.nodesBranch()
+	if err != nil {
+		return nil, err
+	}
+	var child *hdkeychain.ExtendedKey
+	for {
+		if next >= hdkeychain.HardenedKeyStart {
+			return nil, fmt.Errorf("no more node indexes on the nodes branch")
+		}
+		child, err = branch.Child(next)
+		if !errors.Is(err, hdkeychain.ErrInvalidChild) {
+			break
+		}
+		//As BIP32, the invalid children are skipped
+		next++
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot derive node %d: %w", next, err)
+	}
+	wif, err := wifOf(child)
+	if err != nil {
+		return nil, err
+	}
+	add, err := AddressOf(wif)
+	if err != nil {
+		return nil, fmt.Errorf("error while generating address: %v", err)
+	}
+	//The password is not derivable from the xpub, as the one of the legacy nodes
+	pwdSeed := []byte{}
+	pwdSeed = append(pwdSeed, []byte(ks.source.password)...)
+	pwdSeed = append(pwdSeed, entityhash[:]...)
+	node := &Node{
+		name:      entityname,
+		timestamp: time.Now().Unix(),
+		key:       wif,
+		address:   add,
+		password:  sha256.Sum256(pwdSeed),
+		id:        id,
+		hd:        true,
+		index:     next,
+	}
+	ks.nodes = append(ks.nodes, node)
+	return node, nil
+}
+
+//RestoreHDNode adds to the keystore a node lost with the keystore file, as RestoreNode.
+//The key must be the child index of the nodes branch.
+func (ks *Keystore) RestoreHDNode(name string, key string, address string, password [32]byte, id string, timestamp int64, index uint32) (node *Node, added bool, err error) {
+	branch, err := ks.nodesBranch()
+	if err != nil {
+		return nil, false, err
+	}
+	child, err := branch.Child(index)
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot derive node %d: %w", index, err)
+	}
+	wif, err := wifOf(child)
+	if err != nil {
+		return nil, false, err
+	}
+	if wif != key {
+		return nil, false, fmt.Errorf("node key is not the child %d of the source extended key", index)
+	}
+	node, added, err = ks.RestoreNode(name, key, address, password, id, timestamp)
+	if err != nil {
+		return nil, false, err
+	}
+	node.hd = true
+	node.index = index
+	return node, added, nil
+}
+
+//XPubAddresses returns the addresses of the nodes from index from to from+count-1 derived from the xpub of the nodes branch.
+func XPubAddresses(xpub string, from uint32, count int) ([]string, error) {
+	xkey, err := hdkeychain.NewKeyFromString(strings.TrimSpace(xpub))
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode extended public key: %w", err)
+	}
+	if xkey.IsPrivate() {
+		return nil, fmt.Errorf("extended key is private, export the xpub")
+	}
+	addresses := []string{}
+	for i := from; len(addresses) < count && i < hdkeychain.HardenedKeyStart; i++ {
+		child, err := xkey.Child(i)
+		if errors.Is(err, hdkeychain.ErrInvalidChild) {
+			addresses = append(addresses, "")
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot derive node %d: %w", i, err)
+		}
+		pub, err := child.ECPubKey()
+		if err != nil {
+			return nil, fmt.Errorf("cannot get public key of node %d: %w", i, err)
+		}
+		//As AddressOf, the legacy address of the compressed key
+		add, err := bsvutil.NewAddressPubKey(pub.SerializeCompressed(), &chaincfg.MainNetParams)
+		if err != nil {
+			return nil, fmt.Errorf("cannot generate address of node %d: %w", i, err)
+		}
+		addresses = append(addresses, add.EncodeAddress())
+	}
+	return addresses, nil
+}
+
+//wifOf returns the compressed WIF of the private extended key.
+func wifOf(xkey *hdkeychain.ExtendedKey) (string, error) {
+	priv, err := xkey.ECPrivKey()
+	if err != nil {
+		return "", fmt.Errorf("cannot get private key: %w", err)
+	}
+	wif, err := bsvutil.NewWIF(priv, &chaincfg.MainNetParams, true)
+	if err != nil {
+		return "", fmt.Errorf("error while generating key: %v", err)
+	}
+	return wif.String(), nil
+}
@@ -0,0 +1,101 @@
+package keys_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ejfhp/ddb/keys"
+)
+
+//BIP32 test vector 1, master key and xpub of m/0'
+var hdMasterKey string = "xprv9s21ZrQH143K3QTDL4LXw2F7HEK3wJUD2nW2nRk4stbPy6cq3jPPqjiChkVvvNKmPGJxWUtg6LnF5kejMRNNU3TGtRBeJgk33yuGBxrMPHi"
+var hdNodesXPub string = "xpub68Gmy5EdvgibQVfPdqkBBCHxA5htiqg55crXYuXoQRKfDBFA1WEjWgP6LHhwBZeNK1VTsfTFUHCdrfp1bgwQ9xv5ski8PX9rL2dZXvgGDnw"
+
+func TestKeyStore_HD(t *testing.T) {
+	if !keys.IsExtendedKey(hdMasterKey) || keys.IsExtendedKey(destinationKey) || keys.IsExtendedKey(hdNodesXPub) {
+		t.Logf("extended private key not recognized")
+		t.FailNow()
+	}
+	ks, err := keys.NewKeystoreHD(hdMasterKey, "mainpassword")
+	if err != nil {
+		t.Logf("failed to generate HD keystore: %v", err)
+		t.FailNow()
+	}
+	xpub, err := ks.XPub()
+	if err != nil || xpub != hdNodesXPub {
+		t.Logf("unexpected xpub: %s %v", xpub, err)
+		t.FailNow()
+	}
+	nodes := []*keys.Node{}
+	for i, h := range [][32]byte{{'n', 'o', 'd', 'e', '0'}, {'n', 'o', 'd', 'e', '1'}, {'n', 'o', 'd', 'e', '2'}} {
+		node, err := ks.NewNode("test", h)
+		if err != nil {
+			t.Logf("failed to generate node: %v", err)
+			t.FailNow()
+		}
+		if node.Index() == nil || *node.Index() != uint32(i) {
+			t.Logf("unexpected index of node %d: %v", i, node.Index())
+			t.FailNow()
+		}
+		nodes = append(nodes, node)
+	}
+	again, err := ks.NewNode("test", [32]byte{'n', 'o', 'd', 'e', '1'})
+	if err != nil || again.Key() != nodes[1].Key() || len(ks.Nodes()) != 3 {
+		t.Logf("same entity should give the same node: %v", err)
+		t.FailNow()
+	}
+	addresses, err := keys.XPubAddresses(xpub, 0, 4)
+	if err != nil || len(addresses) != 4 {
+		t.Logf("failed to derive addresses from xpub: %d %v", len(addresses), err)
+		t.FailNow()
+	}
+	for i, n := range nodes {
+		if addresses[i] != n.Address() {
+			t.Logf("xpub address %d is %s, node address is %s", i, addresses[i], n.Address())
+			t.FailNow()
+		}
+	}
+	pathname := filepath.Join(os.TempDir(), "keystore_hd.trh")
+	defer os.Remove(pathname)
+	err = ks.Save(pathname, "1346")
+	if err != nil {
+		t.Logf("failed to save keystore: %v", err)
+		t.FailNow()
+	}
+	loaded, err := keys.LoadKeystore(pathname, "1346")
+	if err != nil {
+		t.Logf("failed to load keystore: %v", err)
+		t.FailNow()
+	}
+	if loaded.Source().XKey() != hdMasterKey || len(loaded.Nodes()) != 3 || *loaded.Nodes()[2].Index() != 2 {
+		t.Logf("HD keystore not loaded")
+		t.FailNow()
+	}
+	next, err := loaded.NewNode("test", [32]byte{'n', 'o', 'd', 'e', '3'})
+	if err != nil || *next.Index() != 3 || next.Address() != addresses[3] {
+		t.Logf("unexpected node after load: %v", err)
+		t.FailNow()
+	}
+	lost, err := keys.NewKeystoreHD(hdMasterKey, "mainpassword")
+	if err != nil {
+		t.Logf("failed to generate HD keystore: %v", err)
+		t.FailNow()
+	}
+	n := nodes[1]
+	_, _, err = lost.RestoreHDNode(n.Name(), n.Key(), n.Address(), n.Password(), n.ID(), n.Timestamp().Unix(), 0)
+	if err == nil {
+		t.Logf("node restored with wrong index")
+		t.FailNow()
+	}
+	restored, added, err := lost.RestoreHDNode(n.Name(), n.Key(), n.Address(), n.Password(), n.ID(), n.Timestamp().Unix(), 1)
+	if err != nil || !added || *restored.Index() != 1 {
+		t.Logf("failed to restore HD node: %t %v", added, err)
+		t.FailNow()
+	}
+	_, err = keys.NewKeystoreHD(hdNodesXPub, "mainpassword")
+	if err == nil {
+		t.Logf("keystore from xpub should fail")
+		t.FailNow()
+	}
+}
@@ -11,6 +11,9 @@ const (
 	KeygenVersion1 = 1
 	KeygenVersion2 = 2
 	KeygenVersion3 = 3
+	//KeygenVersionBIP39 marks the keystores made by FromMnemonic, the source phrase is a BIP39 mnemonic.
+	//MakeKeygen doesn't build it, the BIP39 passphrase is needed: use NewKeygenBIP39.
+	KeygenVersionBIP39 = 39
 )
 
 type Keygen interface {
@@ -0,0 +1,108 @@
+package keys
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/bitcoinsv/bsvd/chaincfg"
+	"github.com/bitcoinsv/bsvutil/hdkeychain"
+	"github.com/tyler-smith/go-bip39"
+	"golang.org/x/crypto/sha3"
+)
+
+//KeygenBIP39 generates key and password from a BIP39 mnemonic and optional passphrase.
+//The key is the one of the BIP32 master key of the seed, XKey returns the master key to make an HD keystore.
+type KeygenBIP39 struct {
+	initialized bool
+	mnemonic    string
+	passphrase  string
+	seed        []byte
+}
+
+//NewKeygenBIP39 returns the keygen of the mnemonics with the BIP39 passphrase, it may be empty.
+func NewKeygenBIP39(passphrase string) *KeygenBIP39 {
+	return &KeygenBIP39{passphrase: passphrase}
+}
+
+//Init checks the words and the checksum of the mnemonic phrase, number is not used.
+func (k *KeygenBIP39) Init(number int, phrase string) error {
+	mnemonic := strings.Join(strings.Fields(strings.ToLower(phrase)), " ")
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, k.passphrase)
+	if err != nil {
+		return fmt.Errorf("invalid BIP39 mnemonic: %w", err)
+	}
+	k.mnemonic = mnemonic
+	k.seed = seed
+	k.initialized = true
+	return nil
+}
+
+//Mnemonic returns the mnemonic normalized by Init.
+func (k *KeygenBIP39) Mnemonic() string {
+	return k.mnemonic
+}
+
+//XKey returns the BIP32 master extended private key of the seed.
+func (k *KeygenBIP39) XKey() (string, error) {
+	master, err := k.master()
+	if err != nil {
+		return "", err
+	}
+	return master.String(), nil
+}
+
+func (k *KeygenBIP39) WIF() (string, error) {
+	master, err := k.master()
+	if err != nil {
+		return "", err
+	}
+	return wifOf(master)
+}
+
+//Password returns a password derived from the seed, so it changes with the passphrase.
+func (k *KeygenBIP39) Password() ([32]byte, error) {
+	if !k.initialized {
+		return [32]byte{}, fmt.Errorf("keygen not initialized")
+	}
+	hash := sha3.Sum256(append([]byte("trh password"), k.seed...))
+	encoded := make([]byte, base64.URLEncoding.EncodedLen(32))
+	base64.URLEncoding.Encode(encoded, hash[:])
+	var pwd [32]byte
+	copy(pwd[:], encoded)
+	return pwd, nil
+}
+
+func (k *KeygenBIP39) master() (*hdkeychain.ExtendedKey, error) {
+	if !k.initialized {
+		return nil, fmt.Errorf("keygen not initialized")
+	}
+	master, err := hdkeychain.NewMaster(k.seed, &chaincfg.MainNetParams)
+	if err != nil {
+		return nil, fmt.Errorf("cannot generate master key: %w", err)
+	}
+	return master, nil
+}
+
+//FromMnemonic returns the HD keystore of the BIP39 mnemonic and passphrase, the source phrase is the mnemonic.
+func FromMnemonic(mnemonic string, passphrase string) (*Keystore, error) {
+	keygen := NewKeygenBIP39(passphrase)
+	err := keygen.Init(0, mnemonic)
+	if err != nil {
+		return nil, err
+	}
+	xkey, err := keygen.XKey()
+	if err != nil {
+		return nil, err
+	}
+	password, err := keygen.Password()
+	if err != nil {
+		return nil, fmt.Errorf("error while generating password: %w", err)
+	}
+	ks, err := NewKeystoreHD(xkey, PasswordToString(password))
+	if err != nil {
+		return nil, err
+	}
+	ks.SetPhrase(keygen.Mnemonic(), KeygenVersionBIP39)
+	return ks, nil
+}
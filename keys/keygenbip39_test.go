@@ -0,0 +1,89 @@
+package keys_test
+
+import (
+	"testing"
+
+	"github.com/ejfhp/ddb/keys"
+)
+
+func TestKeygenBIP39_New(t *testing.T) {
+	//BIP39 test vector, with passphrase TREZOR
+	mnemonic := "legal winner thank year wave sausage worth useful legal winner thank yellow"
+	k := keys.NewKeygenBIP39("TREZOR")
+	err := k.Init(0, "  Legal winner thank year wave sausage worth useful legal winner thank yellow ")
+	if err != nil {
+		t.Logf("cannot init KeygenBIP39: %v", err)
+		t.FailNow()
+	}
+	if k.Mnemonic() != mnemonic {
+		t.Logf("mnemonic not normalized: '%s'", k.Mnemonic())
+		t.FailNow()
+	}
+	xkey, err := k.XKey()
+	if err != nil || xkey != "xprv9s21ZrQH143K2gA81bYFHqU68xz1cX2APaSq5tt6MFSLeXnCKV1RVUJt9FWNTbrrryem4ZckN8k4Ls1H6nwdvDTvnV7zEXs2HgPezuVccsq" {
+		t.Logf("unexpected master key: %s %v", xkey, err)
+		t.FailNow()
+	}
+	wif, err := k.WIF()
+	if err != nil || !keys.IsExtendedKey(xkey) {
+		t.Logf("cannot generate WIF: %v", err)
+		t.FailNow()
+	}
+	pass, err := k.Password()
+	if err != nil {
+		t.Logf("cannot generate password: %v", err)
+		t.FailNow()
+	}
+	other := keys.NewKeygenBIP39("")
+	err = other.Init(0, mnemonic)
+	if err != nil {
+		t.Logf("cannot init KeygenBIP39: %v", err)
+		t.FailNow()
+	}
+	otherWIF, _ := other.WIF()
+	otherPass, _ := other.Password()
+	if otherWIF == wif || otherPass == pass {
+		t.Logf("passphrase should change key and password")
+		t.FailNow()
+	}
+	invalid := []string{
+		"legal winner thank year wave sausage worth useful legal winner thank thank",
+		"legal winner thank year wave sausage worth useful legal winner thank",
+		"legal winner thank year wave sausage worth useful legal winner thank yellowish",
+		"tanto va la gatta al lardo che ci lascia lo zampino 12",
+	}
+	for _, m := range invalid {
+		err := keys.NewKeygenBIP39("").Init(0, m)
+		if err == nil {
+			t.Logf("invalid mnemonic accepted: %s", m)
+			t.FailNow()
+		}
+	}
+}
+
+func TestKeygenBIP39_FromMnemonic(t *testing.T) {
+	ks, err := keys.FromMnemonic("legal winner thank year wave sausage worth useful legal winner thank yellow", "TREZOR")
+	if err != nil {
+		t.Logf("cannot generate keystore: %v", err)
+		t.FailNow()
+	}
+	if ks.Source().KeygenID() != keys.KeygenVersionBIP39 || ks.Source().XKey() == "" {
+		t.Logf("keystore source is not the mnemonic")
+		t.FailNow()
+	}
+	err = keys.CheckPasswordStrength(ks.Source().Password())
+	if err != nil {
+		t.Logf("weak password from mnemonic: %v", err)
+		t.FailNow()
+	}
+	node, err := ks.NewNode("test", [32]byte{'n', 'o', 'd', 'e'})
+	if err != nil || node.Index() == nil {
+		t.Logf("node is not HD: %v", err)
+		t.FailNow()
+	}
+	_, err = keys.MakeKeygen(keys.KeygenVersionBIP39)
+	if err == nil {
+		t.Logf("KeygenBIP39 needs the passphrase, MakeKeygen should not build it")
+		t.FailNow()
+	}
+}
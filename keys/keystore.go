@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"os"
 	"time"
 
@@ -29,6 +30,7 @@ type Source struct {
 	key      string
 	address  string
 	password string
+	xkey     string
 }
 
 func (so Source) MarshalJSON() ([]byte, error) {
@@ -38,12 +40,14 @@ func (so Source) MarshalJSON() ([]byte, error) {
 		Key      string `json:"key"`
 		Address  string `json:"address"`
 		Password string `json:"password,omitempty"`
+		XKey     string `json:"xkey,omitempty"`
 	}{
 		Phrase:   so.phrase,
 		KeygenID: so.keygenID,
 		Key:      so.key,
 		Address:  so.address,
 		Password: so.password,
+		XKey:     so.xkey,
 	})
 }
 
@@ -54,6 +58,7 @@ func (so *Source) UnmarshalJSON(data []byte) error {
 		Key      string `json:"key"`
 		Address  string `json:"address"`
 		Password string `json:"password,omitempty"`
+		XKey     string `json:"xkey,omitempty"`
 	}
 	err := json.Unmarshal(data, &un)
 	if err != nil {
@@ -65,6 +70,7 @@ func (so *Source) UnmarshalJSON(data []byte) error {
 		key:      un.Key,
 		address:  un.Address,
 		password: un.Password,
+		xkey:     un.XKey,
 	}
 	return nil
 }
@@ -89,6 +95,11 @@ func (so *Source) Password() string {
 	return so.password
 }
 
+//XKey returns the extended private key of the HD keystores, empty otherwise.
+func (so *Source) XKey() string {
+	return so.xkey
+}
+
 type Node struct {
 	name      string
 	key       string
@@ -96,47 +107,71 @@ type Node struct {
 	password  [32]byte
 	id        string
 	timestamp int64
+	hd        bool
+	index     uint32
 }
 
+//MarshalJSON writes the password in hex, legacy keystores have it as an array of numbers.
 func (no Node) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Name      string   `json:"name"`
-		Key       string   `json:"key"`
-		Address   string   `json:"address"`
-		Password  [32]byte `json:"password"`
-		HashHEX   string   `json:"hashhex"`
-		Timestamp int64    `json:"timestamp"`
+		Name      string  `json:"name"`
+		Key       string  `json:"key"`
+		Address   string  `json:"address"`
+		Password  string  `json:"password"`
+		HashHEX   string  `json:"hashhex"`
+		Timestamp int64   `json:"timestamp"`
+		Index     *uint32 `json:"index,omitempty"`
 	}{
 		Name:      no.name,
 		Key:       no.key,
 		Address:   no.address,
-		Password:  no.password,
+		Password:  hex.EncodeToString(no.password[:]),
 		HashHEX:   no.id,
 		Timestamp: no.timestamp,
+		Index:     no.Index(),
 	})
 }
 
 func (no *Node) UnmarshalJSON(data []byte) error {
 	var un struct {
-		Name      string   `json:"name"`
-		Key       string   `json:"key"`
-		Address   string   `json:"address"`
-		Password  [32]byte `json:"password"`
-		HashHEX   string   `json:"hashhex"`
-		Timestamp int64    `json:"timestamp"`
+		Name      string          `json:"name"`
+		Key       string          `json:"key"`
+		Address   string          `json:"address"`
+		Password  json.RawMessage `json:"password"`
+		HashHEX   string          `json:"hashhex"`
+		Timestamp int64           `json:"timestamp"`
+		Index     *uint32         `json:"index,omitempty"`
 	}
 	err := json.Unmarshal(data, &un)
 	if err != nil {
 		return err
 	}
+	var password [32]byte
+	var hexPassword string
+	if json.Unmarshal(un.Password, &hexPassword) == nil {
+		decoded, err := hex.DecodeString(hexPassword)
+		if err != nil || len(decoded) != len(password) {
+			return fmt.Errorf("invalid password of node %s", un.Name)
+		}
+		copy(password[:], decoded)
+	} else if len(un.Password) > 0 {
+		err = json.Unmarshal(un.Password, &password)
+		if err != nil {
+			return fmt.Errorf("invalid password of node %s: %w", un.Name, err)
+		}
+	}
 	*no = Node{
 		name:      un.Name,
 		key:       un.Key,
 		address:   un.Address,
-		password:  un.Password,
+		password:  password,
 		id:        un.HashHEX,
 		timestamp: un.Timestamp,
 	}
+	if un.Index != nil {
+		no.hd = true
+		no.index = *un.Index
+	}
 	return nil
 }
 
@@ -164,10 +199,20 @@ func (no *Node) Timestamp() time.Time {
 	return time.Unix(no.timestamp, 0)
 }
 
+//Index returns the child index of the HD nodes, nil for the nodes derived from the hash.
+func (no *Node) Index() *uint32 {
+	if !no.hd {
+		return nil
+	}
+	index := no.index
+	return &index
+}
+
 type Keystore struct {
 	source   *Source
 	nodes    []*Node
 	pin      string
+	kdf      string
 	pathname string
 }
 
@@ -185,7 +230,6 @@ func NewKeystore(mainKey string, password string) (*Keystore, error) {
 
 func LoadKeystore(filepath string, pin string) (*Keystore, error) {
 	tr := trace.New().Source("keys.go", "Keystore", "LoadKeystore")
-	var pinpass = StringToPassword(pin)
 	file, err := os.Open(filepath)
 	if err != nil {
 		trail.Println(trace.Alert("cannot open Keystore file").UTC().Add("filepath", filepath).Error(err).Append(tr))
@@ -197,10 +241,10 @@ func LoadKeystore(filepath string, pin string) (*Keystore, error) {
 		trail.Println(trace.Alert("error while reading Keystore file").UTC().Add("filepath", filepath).Error(err).Append(tr))
 		return nil, fmt.Errorf("error while reading Keystore file: %w", err)
 	}
-	encoded, err := AESDecrypt(pinpass, read)
+	encoded, version, kdf, err := openKeystore(read, pin)
 	if err != nil {
 		trail.Println(trace.Alert("cannot decrypt Keystore file").UTC().Add("filepath", filepath).Error(err).Append(tr))
-		return nil, fmt.Errorf("cannot decrypt Keystore file: %w", err)
+		return nil, err
 	}
 	ks := &Keystore{}
 	err = ks.UnmarshalJSON(encoded)
@@ -208,7 +252,20 @@ func LoadKeystore(filepath string, pin string) (*Keystore, error) {
 		return nil, err
 	}
 	ks.pin = pin
+	ks.kdf = kdf
 	ks.pathname = filepath
+	if version < KeystoreVersion || kdf == KDFPlain {
+		err = ks.migrate()
+		if err != nil {
+			trail.Println(trace.Warning("cannot migrate Keystore file").UTC().Add("filepath", filepath).Error(err).Append(tr))
+		}
+	} else {
+		//Keystores migrated by the previous versions have the weak legacy copy next to them
+		err = wipeBackups(filepath)
+		if err != nil {
+			trail.Println(trace.Warning("cannot wipe weak keystore backups").UTC().Add("filepath", filepath).Error(err).Append(tr))
+		}
+	}
 	return ks, nil
 }
 
@@ -232,23 +289,30 @@ func LoadKeystoreUnencrypted(filepath string) (*Keystore, error) {
 
 func (ks Keystore) MarshalJSON() ([]byte, error) {
 	return json.Marshal(struct {
-		Nodes  []*Node `json:"nodes"`
-		Source *Source `json:"source"`
+		Version int     `json:"version"`
+		Nodes   []*Node `json:"nodes"`
+		Source  *Source `json:"source"`
 	}{
-		Nodes:  ks.nodes,
-		Source: ks.source,
+		Version: KeystoreVersion,
+		Nodes:   ks.nodes,
+		Source:  ks.source,
 	})
 }
 
+//UnmarshalJSON reads the keystore JSON of every version up to KeystoreVersion, legacy ones have no version.
 func (ks *Keystore) UnmarshalJSON(data []byte) error {
 	var un struct {
-		Nodes  []*Node `json:"nodes"`
-		Source *Source `json:"source"`
+		Version int     `json:"version"`
+		Nodes   []*Node `json:"nodes"`
+		Source  *Source `json:"source"`
 	}
 	err := json.Unmarshal(data, &un)
 	if err != nil {
 		return err
 	}
+	if un.Version > KeystoreVersion {
+		return fmt.Errorf("keystore version %d is newer than the supported %d, update TRH", un.Version, KeystoreVersion)
+	}
 	*ks = Keystore{nodes: un.Nodes, source: un.Source}
 	return nil
 }
@@ -259,10 +323,14 @@ func (ks *Keystore) SetPhrase(passphrase string, keygenID int) {
 }
 
 //NewNode returns a node containing key, address and password derived from the source and the given entity hash.
+//If the source is an extended key the node key is the next child of the nodes branch.
 func (ks *Keystore) NewNode(entityname string, entityhash [32]byte) (*Node, error) {
 	if len(entityname) == 0 {
 		return nil, fmt.Errorf("entity name cannot be empty")
 	}
+	if ks.source.xkey != "" {
+		return ks.newHDNode(entityname, entityhash)
+	}
 	keySeed := []byte{}
 	//The new key is a function of the main key and the given hash
 	keySeed = append(keySeed, []byte(ks.source.key)...)
@@ -303,6 +371,33 @@ func (ks *Keystore) NewNode(entityname string, entityhash [32]byte) (*Node, erro
 	return node, nil
 }
 
+//RestoreNode adds to the keystore a node lost with the keystore file, as read from its MetaEntry.
+//Returns the node already in the keystore if there is one with the same key, added is false.
+func (ks *Keystore) RestoreNode(name string, key string, address string, password [32]byte, id string, timestamp int64) (node *Node, added bool, err error) {
+	add, err := AddressOf(key)
+	if err != nil {
+		return nil, false, fmt.Errorf("cannot generate address from node key: %w", err)
+	}
+	if add != address {
+		return nil, false, fmt.Errorf("node key doesn't match address %s", address)
+	}
+	for _, n := range ks.nodes {
+		if n.key == key {
+			return n, false, nil
+		}
+	}
+	node = &Node{
+		name:      name,
+		timestamp: timestamp,
+		key:       key,
+		address:   address,
+		password:  password,
+		id:        id,
+	}
+	ks.nodes = append(ks.nodes, node)
+	return node, true, nil
+}
+
 //GetNodeHash derives node hash from entity hash
 
 func (ks *Keystore) Source() *Source {
@@ -333,11 +428,11 @@ func (ks *Keystore) Save(filepath string, pin string) error {
 		trail.Println(trace.Alert("cannot encode Keystore").UTC().Error(err).Append(tr))
 		return fmt.Errorf("cannot encode Keystore: %w", err)
 	}
-	var pinpass = StringToPassword(pin)
-	encrypted, err := AESEncrypt(pinpass, encoded)
+	kdf := ks.kdfOf()
+	encrypted, err := sealKeystore(encoded, pin, kdf)
 	if err != nil {
 		trail.Println(trace.Alert("cannot encrypt Keystore").UTC().Error(err).Append(tr))
-		return fmt.Errorf("cannot encrypt Keystore: %w", err)
+		return err
 	}
 	if _, err := os.Stat(filepath); err == nil {
 		return fmt.Errorf("Keystore already exsist")
@@ -355,6 +450,7 @@ func (ks *Keystore) Save(filepath string, pin string) error {
 	}
 	ks.pathname = filepath
 	ks.pin = pin
+	ks.kdf = kdf
 	return nil
 }
 
@@ -382,35 +478,89 @@ func (ks *Keystore) SaveUnencrypted(filepath string) error {
 	return nil
 }
 
+//Update rewrites the keystore file, replaced only once the new one is fully written.
+//No copy of the previous file is kept, the weak ones left by the previous versions are wiped.
 func (ks *Keystore) Update() error {
 	tr := trace.New().Source("keys.go", "Keystore", "Update")
 	if ks.pathname == "" || ks.pin == "" {
 		return fmt.Errorf("PIN of Filenamen in Keystore missing")
 	}
-	oldFile := ks.pathname + ".old"
-	copy, err := ioutil.ReadFile(ks.pathname)
+	encoded, err := json.Marshal(ks)
+	if err != nil {
+		trail.Println(trace.Alert("cannot encode Keystore").UTC().Error(err).Append(tr))
+		return fmt.Errorf("cannot encode Keystore: %w", err)
+	}
+	kdf := ks.kdfOf()
+	sealed, err := sealKeystore(encoded, ks.pin, kdf)
 	if err != nil {
-		trail.Println(trace.Alert("cannot read current keystore file").Append(tr).UTC().Error(err))
-		return fmt.Errorf("cannot read current keystore file: %w", err)
+		trail.Println(trace.Alert("cannot encrypt Keystore").UTC().Error(err).Append(tr))
+		return err
 	}
-	err = os.Remove(oldFile)
+	err = replaceFile(ks.pathname, sealed)
 	if err != nil {
-		if !errors.Is(err, os.ErrNotExist) {
-			trail.Println(trace.Alert("cannot delete old keystore file").Append(tr).UTC().Error(err))
-			return fmt.Errorf("cannot delete old keystore file: %w", err)
+		trail.Println(trace.Alert("cannot write keystore file").Append(tr).UTC().Add("file", ks.pathname).Error(err))
+		return fmt.Errorf("cannot write keystore file: %w", err)
+	}
+	ks.kdf = kdf
+	return wipeBackups(ks.pathname)
+}
+
+//CacheKey derives from the PIN the key encrypting the metadata of the local cache.
+func (ks *Keystore) CacheKey(salt []byte) ([32]byte, error) {
+	if ks.pin == "" {
+		return [32]byte{}, fmt.Errorf("PIN of Keystore missing")
+	}
+	return DeriveKey(ks.pin, salt)
+}
+
+//MinPasswordBits is the minimum strength, estimated by CheckPasswordStrength, of the password of a new keystore.
+const MinPasswordBits = 50
+
+//ErrWeakPassword is returned by CheckPasswordStrength for passwords too easy to guess.
+var ErrWeakPassword = errors.New("password too weak")
+
+//CheckPasswordStrength estimates the bits of the password from its length and the classes of chars used,
+//passwords shorter than 10 chars, with few distinct chars or under MinPasswordBits are refused.
+func CheckPasswordStrength(password string) error {
+	if len(password) < 10 {
+		return fmt.Errorf("password shorter than 10 chars: %w", ErrWeakPassword)
+	}
+	distinct := map[rune]bool{}
+	var lower, upper, digit, other bool
+	for _, c := range password {
+		distinct[c] = true
+		switch {
+		case c >= 'a' && c <= 'z':
+			lower = true
+		case c >= 'A' && c <= 'Z':
+			upper = true
+		case c >= '0' && c <= '9':
+			digit = true
+		default:
+			other = true
 		}
 	}
-	err = ioutil.WriteFile(oldFile, copy, 0644)
-	if err != nil {
-		trail.Println(trace.Alert("cannot duplicate current keystore file").Append(tr).UTC().Error(err))
-		return fmt.Errorf("cannot duplicate current keystore file: %w", err)
+	if len(distinct) < 6 {
+		return fmt.Errorf("password with less than 6 different chars: %w", ErrWeakPassword)
 	}
-	err = os.Remove(ks.pathname)
-	if err != nil {
-		trail.Println(trace.Alert("cannot delete old keystore file").Append(tr).UTC().Error(err))
-		return fmt.Errorf("cannot delete old keystore file: %w", err)
+	charset := 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {other, 33}} {
+		if class.used {
+			charset += class.size
+		}
+	}
+	bits := float64(len(distinct)) * math.Log2(float64(charset))
+	if len(password) > len(distinct) {
+		//Repeated chars add less
+		bits += float64(len(password)-len(distinct)) * math.Log2(float64(charset)) / 2
 	}
-	return ks.Save(ks.pathname, ks.pin)
+	if bits < MinPasswordBits {
+		return fmt.Errorf("password strength %.0f bits, less than %d: %w", bits, MinPasswordBits, ErrWeakPassword)
+	}
+	return nil
 }
 
 func StringToPassword(password string) [32]byte {
@@ -3,10 +3,14 @@ package keys_test
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/ejfhp/ddb/errs"
 	"github.com/ejfhp/ddb/keys"
 )
 
@@ -77,6 +81,11 @@ func TestKeyStore_Save_LoadKeystore(t *testing.T) {
 		t.Logf("failed to save keystore: %v", err)
 		t.FailNow()
 	}
+	_, err = keys.LoadKeystore(keyfile, pin+"1")
+	if !errors.Is(err, errs.ErrWrongPassword) {
+		t.Logf("load with wrong pin should fail with ErrWrongPassword: %v", err)
+		t.FailNow()
+	}
 	ks2, err := keys.LoadKeystore(keyfile, pin)
 	if err != nil {
 		t.Logf("failed to load keystore: %v", err)
@@ -207,3 +216,181 @@ func TestKeyStore_GenerateKeyAndAddress(t *testing.T) {
 		}
 	}
 }
+
+func TestKeyStore_RestoreNode(t *testing.T) {
+	ks, err := keys.NewKeystore(destinationKey, "mainpassword")
+	if err != nil {
+		t.Logf("failed to generate keystore: %v", err)
+		t.FailNow()
+	}
+	node, err := ks.NewNode("test", [32]byte{'n', 'o', 'd', 'e'})
+	if err != nil {
+		t.Logf("failed to generate node: %v", err)
+		t.FailNow()
+	}
+	lost, err := keys.NewKeystore(destinationKey, "mainpassword")
+	if err != nil {
+		t.Logf("failed to generate keystore: %v", err)
+		t.FailNow()
+	}
+	restored, added, err := lost.RestoreNode(node.Name(), node.Key(), node.Address(), node.Password(), node.ID(), node.Timestamp().Unix())
+	if err != nil || !added {
+		t.Logf("failed to restore node: %t %v", added, err)
+		t.FailNow()
+	}
+	if restored.ID() != node.ID() || restored.Password() != node.Password() || len(lost.Nodes()) != 1 {
+		t.Logf("restored node is different")
+		t.FailNow()
+	}
+	_, added, err = lost.RestoreNode(node.Name(), node.Key(), node.Address(), node.Password(), node.ID(), node.Timestamp().Unix())
+	if err != nil || added || len(lost.Nodes()) != 1 {
+		t.Logf("node restored twice: %t %v", added, err)
+		t.FailNow()
+	}
+	_, _, err = lost.RestoreNode(node.Name(), node.Key(), destinationAddress, node.Password(), node.ID(), node.Timestamp().Unix())
+	if err == nil {
+		t.Logf("node with wrong address should not be restored")
+		t.FailNow()
+	}
+}
+
+func TestKeyStore_MigrateLegacy(t *testing.T) {
+	keyfile := "/tmp/keystore_legacy.trhk"
+	os.RemoveAll(keyfile)
+	os.RemoveAll(keyfile + ".v0")
+	defer os.RemoveAll(keyfile)
+	defer os.RemoveAll(keyfile + ".v0")
+	defer os.RemoveAll(keyfile + ".old")
+	pin := "trh"
+	nodePassword := sha256.Sum256([]byte("nodepassword"))
+	passwordNumbers := make([]string, len(nodePassword))
+	for i, b := range nodePassword {
+		passwordNumbers[i] = fmt.Sprintf("%d", b)
+	}
+	legacy := fmt.Sprintf(`{"nodes":[{"name":"test","key":"%s","address":"%s","password":[%s],"hashhex":"abcd","timestamp":1636000000}],"source":{"key":"%s","address":"%s","password":"mainpassword"}}`,
+		changeKey, changeAddress, strings.Join(passwordNumbers, ","), destinationKey, destinationAddress)
+	encrypted, err := keys.AESEncrypt(keys.StringToPassword(pin), []byte(legacy))
+	if err != nil {
+		t.Logf("failed to encrypt legacy keystore: %v", err)
+		t.FailNow()
+	}
+	for _, f := range []string{keyfile, keyfile + ".v0", keyfile + ".old"} {
+		//The previous versions left weak copies next to the keystore
+		err = ioutil.WriteFile(f, encrypted, 0600)
+		if err != nil {
+			t.Logf("failed to write legacy keystore: %v", err)
+			t.FailNow()
+		}
+	}
+	for i := 0; i < 2; i++ {
+		ks, err := keys.LoadKeystore(keyfile, pin)
+		if err != nil {
+			t.Logf("%d - failed to load keystore: %v", i, err)
+			t.FailNow()
+		}
+		node, err := ks.GetNode("abcd")
+		if err != nil || node.Password() != nodePassword || node.Address() != changeAddress {
+			t.Logf("%d - node not loaded: %v", i, err)
+			t.FailNow()
+		}
+		if ks.Source().Password() != "mainpassword" {
+			t.Logf("%d - source not loaded", i)
+			t.FailNow()
+		}
+		migrated, err := ioutil.ReadFile(keyfile)
+		if err != nil || !strings.Contains(string(migrated), keys.KeystoreMagic) {
+			t.Logf("%d - keystore file not migrated: %v", i, err)
+			t.FailNow()
+		}
+		for _, weak := range []string{keyfile + ".v0", keyfile + ".old"} {
+			if _, err := os.Stat(weak); !errors.Is(err, os.ErrNotExist) {
+				t.Logf("%d - weak keystore copy %s not deleted: %v", i, weak, err)
+				t.FailNow()
+			}
+		}
+		err = ks.Update()
+		if err != nil {
+			t.Logf("%d - failed to update keystore: %v", i, err)
+			t.FailNow()
+		}
+		if _, err := os.Stat(keyfile + ".old"); !errors.Is(err, os.ErrNotExist) {
+			t.Logf("%d - update should not keep a copy: %v", i, err)
+			t.FailNow()
+		}
+	}
+	ioutil.WriteFile(keyfile+".v0", encrypted, 0600)
+	_, err = keys.LoadKeystore(keyfile, pin)
+	if _, serr := os.Stat(keyfile + ".v0"); err != nil || !errors.Is(serr, os.ErrNotExist) {
+		t.Logf("legacy copy left by a previous migration not deleted: %v %v", err, serr)
+		t.FailNow()
+	}
+	_, err = keys.LoadKeystore(keyfile, "wrong")
+	if !errors.Is(err, errs.ErrWrongPassword) {
+		t.Logf("unexpected error with wrong PIN: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestKeyStore_KDF_ChangePIN(t *testing.T) {
+	defer func() { keys.KeystoreKDF = keys.KDFScrypt }()
+	for _, kdf := range []string{keys.KDFScrypt, keys.KDFArgon2id} {
+		keyfile := "/tmp/keystore_kdf.trhk"
+		os.RemoveAll(keyfile)
+		keys.KeystoreKDF = kdf
+		ks, err := keys.NewKeystore(destinationKey, "mainpassword")
+		if err != nil {
+			t.Logf("%s - failed to create keystore: %v", kdf, err)
+			t.FailNow()
+		}
+		err = ks.Save(keyfile, "1346")
+		if err != nil {
+			t.Logf("%s - failed to save keystore: %v", kdf, err)
+			t.FailNow()
+		}
+		saved, _ := ioutil.ReadFile(keyfile)
+		if !strings.Contains(string(saved), `"name": "`+kdf+`"`) {
+			t.Logf("%s - KDF not recorded in keystore file", kdf)
+			t.FailNow()
+		}
+		loaded, err := keys.LoadKeystore(keyfile, "1346")
+		if err != nil {
+			t.Logf("%s - failed to load keystore: %v", kdf, err)
+			t.FailNow()
+		}
+		err = loaded.ChangePIN("73916")
+		if err != nil {
+			t.Logf("%s - failed to change PIN: %v", kdf, err)
+			t.FailNow()
+		}
+		_, err = keys.LoadKeystore(keyfile, "1346")
+		if !errors.Is(err, errs.ErrWrongPassword) {
+			t.Logf("%s - old PIN should not open the keystore: %v", kdf, err)
+			t.FailNow()
+		}
+		changed, err := keys.LoadKeystore(keyfile, "73916")
+		if err != nil || changed.Source().Key() != destinationKey {
+			t.Logf("%s - failed to load keystore with new PIN: %v", kdf, err)
+			t.FailNow()
+		}
+		os.RemoveAll(keyfile)
+	}
+}
+
+func TestCheckPasswordStrength(t *testing.T) {
+	weak := []string{"1346", "testpass", "aaaaaaaaaaaa", "abababababab"}
+	for _, p := range weak {
+		err := keys.CheckPasswordStrength(p)
+		if !errors.Is(err, keys.ErrWeakPassword) {
+			t.Logf("password %s should be weak: %v", p, err)
+			t.FailNow()
+		}
+	}
+	strong := []string{"mainpassword", "test password 2021", "Th3R4bb1tH0le!"}
+	for _, p := range strong {
+		err := keys.CheckPasswordStrength(p)
+		if err != nil {
+			t.Logf("password %s should be strong enough: %v", p, err)
+			t.FailNow()
+		}
+	}
+}
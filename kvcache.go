@@ -0,0 +1,859 @@
+package ddb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ejfhp/ddb/miner"
+	"github.com/ejfhp/trail"
+	"github.com/ejfhp/trail/trace"
+	bolt "go.etcd.io/bbolt"
+)
+
+const kvCacheFile = "cache.db"
+
+//kvOptions waits a bit for the lock on the file held by another process.
+var kvOptions = &bolt.Options{Timeout: 2 * time.Second}
+
+//kvReadOptions opens the file read-only, many processes can read at the same time.
+var kvReadOptions = &bolt.Options{Timeout: 2 * time.Second, ReadOnly: true}
+
+var (
+	bucketTXs       = []byte("txs")
+	bucketTXMeta    = []byte("txmeta")
+	bucketAddresses = []byte("addresses")
+	bucketEntries   = []byte("entries")
+	bucketStatus    = []byte("status")
+	bucketChains    = []byte("chains")
+	bucketMisc      = []byte("misc")
+	kvBuckets       = [][]byte{bucketTXs, bucketTXMeta, bucketAddresses, bucketEntries, bucketStatus, bucketChains, bucketMisc}
+)
+
+//KVCache keeps the cache in a single embedded key-value file, faster than TXCache when the cache holds many TXs.
+//The file is opened, and locked, only for each operation, so long running commands don't keep other processes out.
+type KVCache struct {
+	path     string
+	maxBytes int64
+	key      *[32]byte
+	sealTXs  bool
+	mu       sync.Mutex
+	//used holds the last use time of the TXs read, written with the next update
+	used map[string]int64
+}
+
+func NewUserKVCache() (*KVCache, error) {
+	return NewKVCache(userCachePath())
+}
+
+//NewKVCache opens, or creates, the key-value cache file in the dir path.
+func NewKVCache(path string) (*KVCache, error) {
+	tr := trace.New().Source("kvcache.go", "KVCache", "NewKVCache")
+	trail.Println(trace.Debug("new KVCache").UTC().Add("path", path).Append(tr))
+	err := os.MkdirAll(path, 0700)
+	if err != nil {
+		trail.Println(trace.Alert("error creating cache dir").UTC().Add("path", path).Error(err).Append(tr))
+		return nil, fmt.Errorf("error creating cache dir '%s': %w", path, err)
+	}
+	cache := KVCache{path: path, used: map[string]int64{}}
+	err = cache.createBuckets()
+	if err != nil {
+		trail.Println(trace.Alert("error opening cache file").UTC().Add("path", path).Error(err).Append(tr))
+		return nil, err
+	}
+	return &cache, nil
+}
+
+func (c *KVCache) DirPath() string {
+	return c.path
+}
+
+//Close writes the use time of the TXs read since the last update, the file is not kept open.
+func (c *KVCache) Close() error {
+	c.mu.Lock()
+	pending := len(c.used)
+	c.mu.Unlock()
+	if pending == 0 {
+		return nil
+	}
+	err := c.update(func(btx *bolt.Tx) error { return nil })
+	if err != nil {
+		return fmt.Errorf("error writing use time of TXs to cache file in '%s': %w", c.path, err)
+	}
+	return nil
+}
+
+//SetMaxBytes sets the size above which stored TXs are evicted, 0 means no limit.
+func (c *KVCache) SetMaxBytes(maxBytes int64) {
+	c.maxBytes = maxBytes
+}
+
+func (c *KVCache) StoreTX(id string, tx []byte) error {
+	tr := trace.New().Source("kvcache.go", "KVCache", "StoreTX")
+	trail.Println(trace.Debug("storing TX").UTC().Add("path", c.path).Add("id", id).Append(tr))
+	err := c.update(func(btx *bolt.Tx) error {
+		sealed, err := sealMeta(c.txKey(), tx)
+		if err != nil {
+			return err
+		}
+		err = btx.Bucket(bucketTXs).Put(c.dbKey(bucketTXs, id), sealed)
+		if err != nil {
+			return err
+		}
+		meta := TXMeta{}
+		found, err := c.getJSON(btx, bucketTXMeta, id, &meta)
+		if err != nil {
+			return err
+		}
+		now := time.Now().Unix()
+		if !found {
+			meta.Added = now
+		}
+		meta.TXID = id
+		meta.Size = len(tx)
+		meta.Used = now
+		err = c.putJSON(btx, bucketTXMeta, id, &meta)
+		if err != nil {
+			return err
+		}
+		_, err = c.evict(btx, c.maxBytes)
+		return err
+	})
+	if err != nil {
+		trail.Println(trace.Alert("error storing tx to cache").UTC().Add("path", c.path).Add("id", id).Error(err).Append(tr))
+		return fmt.Errorf("error storing tx '%s' to cache file in '%s': %w", id, c.path, err)
+	}
+	return nil
+}
+
+func (c *KVCache) RetrieveTX(id string) ([]byte, error) {
+	tr := trace.New().Source("kvcache.go", "KVCache", "RetrieveTX")
+	trail.Println(trace.Debug("retrieving TX").UTC().Add("id", id).Append(tr))
+	var tx []byte
+	corrupted := false
+	err := c.view(func(btx *bolt.Tx) error {
+		stored := btx.Bucket(bucketTXs).Get(c.dbKey(bucketTXs, id))
+		if stored == nil {
+			return ErrNotCached
+		}
+		//Values returned by bolt are valid only inside the transaction
+		plain, err := openMeta(c.key, append([]byte{}, stored...))
+		if err != nil || !txMatches(id, plain) {
+			corrupted = true
+			return nil
+		}
+		tx = plain
+		return nil
+	})
+	if err == nil && corrupted {
+		//A corrupted TX is dropped so that it is downloaded again
+		trail.Println(trace.Alert("corrupted tx in cache").UTC().Add("path", c.path).Add("id", id).Append(tr))
+		err = c.update(func(btx *bolt.Tx) error {
+			return c.removeTX(btx, id)
+		})
+	}
+	if err == nil && tx != nil {
+		//Use time is needed only for eviction, it is written with the next update instead of syncing the file on every read
+		c.mu.Lock()
+		c.used[id] = time.Now().Unix()
+		c.mu.Unlock()
+	}
+	if err != nil {
+		if errors.Is(err, ErrNotCached) {
+			trail.Println(trace.Alert("tx not in cache").UTC().Add("path", c.path).Add("id", id).Append(tr))
+			return nil, ErrNotCached
+		}
+		trail.Println(trace.Alert("error retrieving tx from cache").UTC().Add("path", c.path).Add("id", id).Error(err).Append(tr))
+		return nil, fmt.Errorf("error retrieving tx '%s' from cache file in '%s': %w", id, c.path, err)
+	}
+	if tx == nil {
+		return nil, ErrNotCached
+	}
+	return tx, nil
+}
+
+func (c *KVCache) StoreTXIDs(address string, txids []string) error {
+	tr := trace.New().Source("kvcache.go", "KVCache", "StoreTXIDs")
+	trail.Println(trace.Debug("storing TXIDs").UTC().Add("path", c.path).Add("address", address).Append(tr))
+	err := c.update(func(btx *bolt.Tx) error {
+		addinfo := AddressInfo{Address: address, TXIDs: []string{}}
+		_, err := c.getJSON(btx, bucketAddresses, address, &addinfo)
+		if err != nil {
+			return err
+		}
+		addinfo.TXIDs = mergeTXIDs(addinfo.TXIDs, txids)
+		return c.putJSON(btx, bucketAddresses, address, &addinfo)
+	})
+	if err != nil {
+		trail.Println(trace.Alert("error storing txid to cache").UTC().Add("path", c.path).Add("address", address).Error(err).Append(tr))
+		return fmt.Errorf("error storing txid for address '%s' to cache file in '%s': %w", address, c.path, err)
+	}
+	return nil
+}
+
+//GetTXIDs returns the TXs of the address, ErrNotCached if the address is unknown.
+func (c *KVCache) GetTXIDs(address string) ([]string, error) {
+	addinfo, err := c.GetAddressInfo(address)
+	if err != nil {
+		return nil, err
+	}
+	return addinfo.TXIDs, nil
+}
+
+//StoreAddressInfo replaces the history of the address.
+func (c *KVCache) StoreAddressInfo(info *AddressInfo) error {
+	err := c.put(bucketAddresses, info.Address, info)
+	if err != nil {
+		return fmt.Errorf("error storing address '%s' to cache file in '%s': %w", info.Address, c.path, err)
+	}
+	return nil
+}
+
+//GetAddressInfo returns the history of the address, ErrNotCached if the address is unknown.
+func (c *KVCache) GetAddressInfo(address string) (*AddressInfo, error) {
+	tr := trace.New().Source("kvcache.go", "KVCache", "GetAddressInfo")
+	trail.Println(trace.Debug("getting address info").UTC().Add("path", c.path).Add("address", address).Append(tr))
+	addinfo := AddressInfo{}
+	found, err := c.get(bucketAddresses, address, &addinfo)
+	if err != nil {
+		trail.Println(trace.Alert("error retrieving address from cache").UTC().Add("path", c.path).Add("address", address).Error(err).Append(tr))
+		return nil, fmt.Errorf("error retrieving address '%s' from cache file in '%s': %w", address, c.path, err)
+	}
+	if !found {
+		trail.Println(trace.Alert("address not in cache").UTC().Add("path", c.path).Add("address", address).Append(tr))
+		return nil, ErrNotCached
+	}
+	if addinfo.TXIDs == nil {
+		addinfo.TXIDs = []string{}
+	}
+	return &addinfo, nil
+}
+
+//StoreFees keeps the last fee quote of the miner.
+func (c *KVCache) StoreFees(fees miner.Fees) error {
+	err := c.put(bucketMisc, "fees", fees)
+	if err != nil {
+		return fmt.Errorf("error storing fees to cache file in '%s': %w", c.path, err)
+	}
+	return nil
+}
+
+//RetrieveFees returns the last fee quote stored, ErrNotCached if there is none.
+func (c *KVCache) RetrieveFees() (miner.Fees, error) {
+	fees := miner.Fees{}
+	found, err := c.get(bucketMisc, "fees", &fees)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving fees from cache file in '%s': %w", c.path, err)
+	}
+	if !found {
+		return nil, ErrNotCached
+	}
+	return fees, nil
+}
+
+func (c *KVCache) StoreTXStatus(status *TXStatus) error {
+	tr := trace.New().Source("kvcache.go", "KVCache", "StoreTXStatus")
+	trail.Println(trace.Debug("storing TX status").UTC().Add("path", c.path).Add("id", status.TXID).Add("status", status.Status).Append(tr))
+	err := c.put(bucketStatus, status.TXID, status)
+	if err != nil {
+		return fmt.Errorf("error storing status of tx '%s' to cache file in '%s': %w", status.TXID, c.path, err)
+	}
+	return nil
+}
+
+func (c *KVCache) RetrieveTXStatus(id string) (*TXStatus, error) {
+	var status TXStatus
+	found, err := c.get(bucketStatus, id, &status)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving status of tx '%s' from cache file in '%s': %w", id, c.path, err)
+	}
+	if !found {
+		return nil, ErrNotCached
+	}
+	return &status, nil
+}
+
+//RecordCallback updates the status of the TX notified by the miner callback.
+func (c *KVCache) RecordCallback(cb *miner.Callback) error {
+	return recordCallback(c, cb)
+}
+
+func (c *KVCache) StoreChainProgress(progress *ChainProgress) error {
+	err := c.put(bucketChains, progress.ID, progress)
+	if err != nil {
+		return fmt.Errorf("error storing progress of chain '%s' to cache file in '%s': %w", progress.ID, c.path, err)
+	}
+	return nil
+}
+
+func (c *KVCache) RetrieveChainProgress(id string) (*ChainProgress, error) {
+	var progress ChainProgress
+	found, err := c.get(bucketChains, id, &progress)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving progress of chain '%s' from cache file in '%s': %w", id, c.path, err)
+	}
+	if !found {
+		return nil, ErrNotCached
+	}
+	return &progress, nil
+}
+
+func (c *KVCache) DeleteChainProgress(id string) error {
+	err := c.update(func(btx *bolt.Tx) error {
+		return btx.Bucket(bucketChains).Delete(c.dbKey(bucketChains, id))
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting progress of chain '%s' from cache file in '%s': %w", id, c.path, err)
+	}
+	return nil
+}
+
+//ListChainProgress returns the IDs of the chains not completely submitted.
+func (c *KVCache) ListChainProgress() ([]string, error) {
+	ids := []string{}
+	err := c.view(func(btx *bolt.Tx) error {
+		return btx.Bucket(bucketChains).ForEach(func(k, v []byte) error {
+			if c.txKey() == nil {
+				ids = append(ids, string(k))
+				return nil
+			}
+			//Keys are hidden, the ID is in the progress
+			plain, err := openMeta(c.key, v)
+			if err != nil {
+				return err
+			}
+			progress := ChainProgress{}
+			err = json.Unmarshal(plain, &progress)
+			if err != nil {
+				return fmt.Errorf("error unmarshaling chain progress: %w", err)
+			}
+			ids = append(ids, progress.ID)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing chains in cache file in '%s': %w", c.path, err)
+	}
+	return ids, nil
+}
+
+//SetTXHeight records the height of the block including the TX, TXs not in cache are ignored.
+func (c *KVCache) SetTXHeight(id string, height int) error {
+	return c.update(func(btx *bolt.Tx) error {
+		meta := TXMeta{}
+		found, err := c.getJSON(btx, bucketTXMeta, id, &meta)
+		if err != nil || !found || meta.Height == height {
+			return err
+		}
+		meta.Height = height
+		return c.putJSON(btx, bucketTXMeta, id, &meta)
+	})
+}
+
+//IndexEntry records the TXs storing the entry with the given hash.
+func (c *KVCache) IndexEntry(hash string, txids []string) error {
+	return c.update(func(btx *bolt.Tx) error {
+		existing := []string{}
+		_, err := c.getJSON(btx, bucketEntries, hash, &existing)
+		if err != nil {
+			return err
+		}
+		return c.putJSON(btx, bucketEntries, hash, mergeTXIDs(existing, txids))
+	})
+}
+
+//EntryTXIDs returns the TXs storing the entry with the given hash, ErrNotCached if the entry is unknown.
+func (c *KVCache) EntryTXIDs(hash string) ([]string, error) {
+	txids := []string{}
+	_, err := c.get(bucketEntries, hash, &txids)
+	if err != nil {
+		return nil, err
+	}
+	if len(txids) == 0 {
+		return nil, ErrNotCached
+	}
+	return txids, nil
+}
+
+//Stats returns the number and size of the cached TXs and the number of addresses and entries indexed.
+func (c *KVCache) Stats() (*CacheStats, error) {
+	stats := CacheStats{}
+	err := c.view(func(btx *bolt.Tx) error {
+		metas, err := c.loadMetas(btx)
+		if err != nil {
+			return err
+		}
+		stats.TXs = len(metas)
+		for _, meta := range metas {
+			stats.Bytes += int64(meta.Size)
+			if meta.Height > 0 {
+				stats.Confirmed++
+			} else {
+				stats.Unconfirmed++
+			}
+		}
+		stats.Addresses = btx.Bucket(bucketAddresses).Stats().KeyN
+		stats.Entries = btx.Bucket(bucketEntries).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error reading stats of cache file in '%s': %w", c.path, err)
+	}
+	return &stats, nil
+}
+
+//Verify recomputes the ID of every cached TX. TXs without metadata are indexed, metadata without TX are removed.
+//Corrupted TXs are only reported, Prune deletes them.
+func (c *KVCache) Verify() (*CacheReport, error) {
+	tr := trace.New().Source("kvcache.go", "KVCache", "Verify")
+	report := CacheReport{Missing: []string{}, Corrupted: []string{}}
+	err := c.update(func(btx *bolt.Tx) error {
+		txs := btx.Bucket(bucketTXs)
+		metas := btx.Bucket(bucketTXMeta)
+		unindexed := map[string]int{}
+		unreadable := [][]byte{}
+		err := txs.ForEach(func(k, v []byte) error {
+			id := string(k)
+			report.Checked++
+			plain, err := openMeta(c.key, v)
+			if c.txKey() != nil {
+				//Keys are hidden, the ID is read from the TX
+				id = ""
+				if tx, txErr := DataTXFromBytes(plain); err == nil && txErr == nil && string(c.dbKey(bucketTXs, tx.GetTxID())) == string(k) {
+					id = tx.GetTxID()
+				}
+			}
+			if id == "" {
+				//Without ID it cannot be deleted later by Prune
+				trail.Println(trace.Warning("deleting unreadable tx from cache").UTC().Add("key", string(k)).Append(tr))
+				unreadable = append(unreadable, append([]byte{}, k...))
+				report.Corrupted = append(report.Corrupted, string(k))
+				return nil
+			}
+			if err != nil || !txMatches(id, plain) {
+				trail.Println(trace.Warning("corrupted tx in cache").UTC().Add("id", id).Append(tr))
+				report.Corrupted = append(report.Corrupted, id)
+			}
+			if metas.Get(k) == nil {
+				unindexed[id] = len(plain)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		missing := [][]byte{}
+		err = metas.ForEach(func(k, v []byte) error {
+			if txs.Get(k) != nil {
+				return nil
+			}
+			id := string(k)
+			meta := TXMeta{}
+			if plain, err := openMeta(c.key, v); err == nil && json.Unmarshal(plain, &meta) == nil && meta.TXID != "" {
+				id = meta.TXID
+			}
+			report.Missing = append(report.Missing, id)
+			missing = append(missing, append([]byte{}, k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		//Buckets cannot be changed while iterating
+		for _, k := range unreadable {
+			err = txs.Delete(k)
+			if err != nil {
+				return err
+			}
+			err = metas.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		for _, k := range missing {
+			err = metas.Delete(k)
+			if err != nil {
+				return err
+			}
+		}
+		for id, size := range unindexed {
+			err = c.putJSON(btx, bucketTXMeta, id, &TXMeta{TXID: id, Size: size, Added: time.Now().Unix()})
+			if err != nil {
+				return err
+			}
+			report.Indexed++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error verifying cache file in '%s': %w", c.path, err)
+	}
+	return &report, nil
+}
+
+//Prune deletes the corrupted TXs and then evicts TXs until the cache is not bigger than maxBytes.
+//Unconfirmed TXs are evicted first, least recently used first. Returns the IDs of the TXs deleted.
+func (c *KVCache) Prune(maxBytes int64) ([]string, error) {
+	report, err := c.Verify()
+	if err != nil {
+		return nil, err
+	}
+	removed := []string{}
+	err = c.update(func(btx *bolt.Tx) error {
+		for _, id := range report.Corrupted {
+			err := c.removeTX(btx, id)
+			if err != nil {
+				return err
+			}
+			removed = append(removed, id)
+		}
+		evicted, err := c.evict(btx, maxBytes)
+		removed = append(removed, evicted...)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error pruning cache file in '%s': %w", c.path, err)
+	}
+	return removed, nil
+}
+
+//Size returns the number of TXs in cache.
+func (c *KVCache) Size() (int, error) {
+	size := 0
+	err := c.view(func(btx *bolt.Tx) error {
+		size = btx.Bucket(bucketTXMeta).Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		return -1, fmt.Errorf("error reading size of cache file in '%s': %w", c.path, err)
+	}
+	return size, nil
+}
+
+//Clear empties the cache, the file stays open.
+func (c *KVCache) Clear() error {
+	tr := trace.New().Source("kvcache.go", "KVCache", "Clear")
+	trail.Println(trace.Debug("clearing cache").UTC().Add("dir", c.path).Append(tr))
+	err := c.update(func(btx *bolt.Tx) error {
+		for _, name := range kvBuckets {
+			err := btx.DeleteBucket(name)
+			if err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error clearing cache file in '%s': %w", c.path, err)
+	}
+	return c.createBuckets()
+}
+
+//evict deletes the TXs exceeding maxBytes in the running transaction.
+func (c *KVCache) evict(btx *bolt.Tx, maxBytes int64) ([]string, error) {
+	tr := trace.New().Source("kvcache.go", "KVCache", "evict")
+	evicted := []string{}
+	if maxBytes <= 0 {
+		return evicted, nil
+	}
+	//Stored TXs are never smaller than the plain ones, metadata are decrypted only if something has to be evicted
+	stored := int64(0)
+	err := btx.Bucket(bucketTXs).ForEach(func(k, v []byte) error {
+		stored += int64(len(v))
+		return nil
+	})
+	if err != nil || stored <= maxBytes {
+		return evicted, err
+	}
+	metas, err := c.loadMetas(btx)
+	if err != nil {
+		return evicted, err
+	}
+	ids := evictionOrder(metas, maxBytes, func(id string, meta *TXMeta) time.Time {
+		if meta.Used == 0 {
+			return time.Unix(meta.Added, 0)
+		}
+		return time.Unix(meta.Used, 0)
+	})
+	for _, id := range ids {
+		err := c.removeTX(btx, id)
+		if err != nil {
+			return evicted, err
+		}
+		evicted = append(evicted, id)
+	}
+	if len(evicted) > 0 {
+		trail.Println(trace.Info("evicted TXs from cache").UTC().Add("evicted", fmt.Sprintf("%d", len(evicted))).Append(tr))
+	}
+	return evicted, nil
+}
+
+func (c *KVCache) removeTX(btx *bolt.Tx, id string) error {
+	err := btx.Bucket(bucketTXs).Delete(c.dbKey(bucketTXs, id))
+	if err != nil {
+		return err
+	}
+	return btx.Bucket(bucketTXMeta).Delete(c.dbKey(bucketTXMeta, id))
+}
+
+//open opens the cache file, read-only if readOnly is true. The caller holds mu.
+func (c *KVCache) open(readOnly bool) (*bolt.DB, error) {
+	options := kvOptions
+	if readOnly {
+		options = kvReadOptions
+	}
+	db, err := bolt.Open(filepath.Join(c.path, kvCacheFile), 0600, options)
+	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, fmt.Errorf("cache file in '%s' is in use by another process", c.path)
+		}
+		return nil, fmt.Errorf("error opening cache file in '%s': %w", c.path, err)
+	}
+	return db, nil
+}
+
+//update runs fn in a read-write transaction, the file is locked only for the operation.
+//The use time of the TXs read since the last update is written in the same transaction.
+func (c *KVCache) update(fn func(btx *bolt.Tx) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	db, err := c.open(false)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	err = db.Update(func(btx *bolt.Tx) error {
+		for id, used := range c.used {
+			meta := TXMeta{}
+			found, err := c.getJSON(btx, bucketTXMeta, id, &meta)
+			if err != nil {
+				return err
+			}
+			if !found || meta.Used >= used {
+				continue
+			}
+			meta.TXID = id
+			meta.Used = used
+			err = c.putJSON(btx, bucketTXMeta, id, &meta)
+			if err != nil {
+				return err
+			}
+		}
+		return fn(btx)
+	})
+	if err == nil {
+		c.used = map[string]int64{}
+	}
+	return err
+}
+
+//view runs fn in a read-only transaction, other processes can read the file at the same time.
+func (c *KVCache) view(fn func(btx *bolt.Tx) error) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	db, err := c.open(true)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(fn)
+}
+
+func (c *KVCache) createBuckets() error {
+	err := c.update(func(btx *bolt.Tx) error {
+		for _, name := range kvBuckets {
+			_, err := btx.CreateBucketIfNotExists(name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error creating buckets in cache file in '%s': %w", c.path, err)
+	}
+	return nil
+}
+
+func (c *KVCache) get(bucket []byte, key string, v interface{}) (bool, error) {
+	found := false
+	err := c.view(func(btx *bolt.Tx) error {
+		var err error
+		found, err = c.getJSON(btx, bucket, key, v)
+		return err
+	})
+	return found, err
+}
+
+func (c *KVCache) put(bucket []byte, key string, v interface{}) error {
+	return c.update(func(btx *bolt.Tx) error {
+		return c.putJSON(btx, bucket, key, v)
+	})
+}
+
+func (c *KVCache) loadMetas(btx *bolt.Tx) (map[string]*TXMeta, error) {
+	err := c.checkKey(btx, bucketTXMeta)
+	if err != nil {
+		return nil, err
+	}
+	metas := map[string]*TXMeta{}
+	err = btx.Bucket(bucketTXMeta).ForEach(func(k, v []byte) error {
+		plain, err := openMeta(c.key, v)
+		if err != nil {
+			return err
+		}
+		meta := TXMeta{}
+		err = json.Unmarshal(plain, &meta)
+		if err != nil {
+			return fmt.Errorf("error unmarshaling metadata of tx '%s': %w", string(k), err)
+		}
+		if meta.TXID == "" {
+			//Written before the ID was kept, the key is the ID
+			meta.TXID = string(k)
+		}
+		metas[meta.TXID] = &meta
+		return nil
+	})
+	return metas, err
+}
+
+//getJSON unmarshals the value of key into v, returns false if the key is not in the bucket.
+func (c *KVCache) getJSON(btx *bolt.Tx, bucket []byte, key string, v interface{}) (bool, error) {
+	err := c.checkKey(btx, bucket)
+	if err != nil {
+		return false, err
+	}
+	stored := btx.Bucket(bucket).Get(c.dbKey(bucket, key))
+	if stored == nil {
+		return false, nil
+	}
+	plain, err := openMeta(c.key, stored)
+	if err != nil {
+		return true, err
+	}
+	err = json.Unmarshal(plain, v)
+	if err != nil {
+		return true, fmt.Errorf("error unmarshaling '%s': %w", key, err)
+	}
+	return true, nil
+}
+
+func (c *KVCache) putJSON(btx *bolt.Tx, bucket []byte, key string, v interface{}) error {
+	err := c.checkKey(btx, bucket)
+	if err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("error marshaling '%s': %w", key, err)
+	}
+	if isSealedBucket(bucket) {
+		bytes, err = sealMeta(c.key, bytes)
+		if err != nil {
+			return err
+		}
+	}
+	return btx.Bucket(bucket).Put(c.dbKey(bucket, key), bytes)
+}
+
+//MigrateCache copies the content of the dir of files cache into the cache to.
+//The files are not deleted, the caller clears from when the migration succeeds.
+func MigrateCache(from *TXCache, to Cache) (*CacheStats, error) {
+	tr := trace.New().Source("kvcache.go", "", "MigrateCache")
+	//Verify indexes TX files written before the index existed
+	_, err := from.Verify()
+	if err != nil {
+		return nil, err
+	}
+	names, err := from.listNames()
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".trh") {
+			continue
+		}
+		base := strings.TrimSuffix(name, ".trh")
+		switch {
+		case base == cacheIndexName || isTXID(base):
+			//TXs are copied from the index below
+		case base == "fees":
+			fees, err := from.RetrieveFees()
+			if err == nil {
+				err = to.StoreFees(fees)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error migrating fees: %w", err)
+			}
+		case strings.HasPrefix(base, "status-"):
+			//Read by name, the name is not the TX ID when TXs are encrypted
+			var status TXStatus
+			err := from.readMetaFile(name, &status)
+			if err == nil {
+				err = to.StoreTXStatus(&status)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error migrating status '%s': %w", base, err)
+			}
+		case strings.HasPrefix(base, chainProgressPrefix):
+			var progress ChainProgress
+			err := from.readMetaFile(name, &progress)
+			if err == nil {
+				err = to.StoreChainProgress(&progress)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("error migrating chain progress '%s': %w", base, err)
+			}
+		default:
+			//Address info written by versions older than the index
+			_, err := from.GetTXIDs(base)
+			if err != nil && !errors.Is(err, ErrNotCached) {
+				trail.Println(trace.Warning("cannot migrate file").UTC().Add("name", name).Error(err).Append(tr))
+			}
+		}
+	}
+	unlock, err := from.lockIndex()
+	if err != nil {
+		return nil, err
+	}
+	index, err := from.loadIndex()
+	unlock()
+	if err != nil {
+		return nil, err
+	}
+	for id, meta := range index.TXs {
+		tx, err := from.RetrieveTX(id)
+		if errors.Is(err, ErrNotCached) {
+			trail.Println(trace.Warning("skipping tx not in cache").UTC().Add("id", id).Append(tr))
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error migrating tx '%s': %w", id, err)
+		}
+		err = to.StoreTX(id, tx)
+		if err == nil && meta.Height > 0 {
+			err = to.SetTXHeight(id, meta.Height)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error migrating tx '%s': %w", id, err)
+		}
+	}
+	for address, addinfo := range index.Addresses {
+		err = to.StoreAddressInfo(addinfo)
+		if err != nil {
+			return nil, fmt.Errorf("error migrating address '%s': %w", address, err)
+		}
+	}
+	for hash, txids := range index.Entries {
+		err = to.IndexEntry(hash, txids)
+		if err != nil {
+			return nil, fmt.Errorf("error migrating entry '%s': %w", hash, err)
+		}
+	}
+	return to.Stats()
+}
@@ -0,0 +1,235 @@
+package ddb_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/miner"
+)
+
+func TestKVCache_StoreRetrieve(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	dir := filepath.Join(os.TempDir(), "kv_trh")
+	defer os.RemoveAll(dir)
+	cache, err := ddb.NewKVCache(dir)
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	defer cache.Close()
+	tx := Helper_FakeTX(t)
+	err = cache.StoreTX(tx.GetTxID(), tx.ToBytes())
+	if err != nil {
+		t.Logf("failed to store tx: %v", err)
+		t.FailNow()
+	}
+	stored, err := cache.RetrieveTX(tx.GetTxID())
+	if err != nil {
+		t.Logf("failed to retrieve tx: %v", err)
+		t.FailNow()
+	}
+	if string(stored) != string(tx.ToBytes()) {
+		t.Logf("retrieved tx is different")
+		t.FailNow()
+	}
+	_, err = cache.RetrieveTX("0fe1ac2e5a96c25bcbd7a2fd5a7be86f97c2a8e0e3a9b1b5e0e1c2ab3e0cf4a1")
+	if err != ddb.ErrNotCached {
+		t.Logf("unexpected error for unknown tx: %v", err)
+		t.FailNow()
+	}
+	address := "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	err = cache.StoreTXIDs(address, []string{"a", "b"})
+	if err != nil {
+		t.Logf("failed to store txids: %v", err)
+		t.FailNow()
+	}
+	err = cache.StoreTXIDs(address, []string{"b", "c"})
+	if err != nil {
+		t.Logf("failed to store txids: %v", err)
+		t.FailNow()
+	}
+	txids, err := cache.GetTXIDs(address)
+	if err != nil || len(txids) != 3 {
+		t.Logf("unexpected txids: %v %v", txids, err)
+		t.FailNow()
+	}
+	err = cache.RecordCallback(&miner.Callback{CallbackTXID: tx.GetTxID(), CallbackReason: miner.CallbackMerkleProof, BlockHeight: 715000})
+	if err != nil {
+		t.Logf("failed to record callback: %v", err)
+		t.FailNow()
+	}
+	status, err := cache.RetrieveTXStatus(tx.GetTxID())
+	if err != nil || status.Status != ddb.TXStatusMined {
+		t.Logf("unexpected status: %v %v", status, err)
+		t.FailNow()
+	}
+	err = cache.StoreChainProgress(&ddb.ChainProgress{ID: "chain1", TXs: []string{"a"}})
+	if err != nil {
+		t.Logf("failed to store chain progress: %v", err)
+		t.FailNow()
+	}
+	chains, err := cache.ListChainProgress()
+	if err != nil || len(chains) != 1 || chains[0] != "chain1" {
+		t.Logf("unexpected chains: %v %v", chains, err)
+		t.FailNow()
+	}
+	stats, err := cache.Stats()
+	if err != nil {
+		t.Logf("failed to get stats: %v", err)
+		t.FailNow()
+	}
+	if stats.TXs != 1 || stats.Confirmed != 1 || stats.Addresses != 1 {
+		t.Logf("unexpected stats: %+v", stats)
+		t.FailNow()
+	}
+	err = cache.Clear()
+	if err != nil {
+		t.Logf("failed to clear cache: %v", err)
+		t.FailNow()
+	}
+	size, err := cache.Size()
+	if err != nil || size != 0 {
+		t.Logf("unexpected size after clear: %d %v", size, err)
+		t.FailNow()
+	}
+}
+
+func TestKVCache_Evict(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "kv_evict_trh")
+	defer os.RemoveAll(dir)
+	cache, err := ddb.NewKVCache(dir)
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	defer cache.Close()
+	confirmed, unconfirmed := Helper_FakeTX(t), Helper_FakeTX(t)
+	for _, tx := range []*ddb.DataTX{confirmed, unconfirmed} {
+		err = cache.StoreTX(tx.GetTxID(), tx.ToBytes())
+		if err != nil {
+			t.Logf("failed to store tx: %v", err)
+			t.FailNow()
+		}
+	}
+	err = cache.SetTXHeight(confirmed.GetTxID(), 715000)
+	if err != nil {
+		t.Logf("failed to set height: %v", err)
+		t.FailNow()
+	}
+	removed, err := cache.Prune(int64(len(confirmed.ToBytes())))
+	if err != nil {
+		t.Logf("failed to prune: %v", err)
+		t.FailNow()
+	}
+	if len(removed) != 1 || removed[0] != unconfirmed.GetTxID() {
+		t.Logf("unexpected txs removed: %v", removed)
+		t.FailNow()
+	}
+	_, err = cache.RetrieveTX(confirmed.GetTxID())
+	if err != nil {
+		t.Logf("confirmed tx should be kept: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestKVCache_SharedFile(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "kv_shared_trh")
+	defer os.RemoveAll(dir)
+	first, err := ddb.NewKVCache(dir)
+	if err != nil {
+		t.Logf("failed to create cache: %v", err)
+		t.FailNow()
+	}
+	defer first.Close()
+	//The file is not kept locked between operations, another instance can use it
+	second, err := ddb.NewKVCache(dir)
+	if err != nil {
+		t.Logf("failed to open cache in use: %v", err)
+		t.FailNow()
+	}
+	defer second.Close()
+	tx := Helper_FakeTX(t)
+	err = first.StoreTX(tx.GetTxID(), tx.ToBytes())
+	if err != nil {
+		t.Logf("failed to store tx: %v", err)
+		t.FailNow()
+	}
+	stored, err := second.RetrieveTX(tx.GetTxID())
+	if err != nil || string(stored) != string(tx.ToBytes()) {
+		t.Logf("failed to retrieve tx stored by another instance: %v", err)
+		t.FailNow()
+	}
+	err = second.StoreTXStatus(&ddb.TXStatus{TXID: tx.GetTxID(), Status: "submitted"})
+	if err != nil {
+		t.Logf("failed to store status after read: %v", err)
+		t.FailNow()
+	}
+	status, err := first.RetrieveTXStatus(tx.GetTxID())
+	if err != nil || status.Status != "submitted" {
+		t.Logf("failed to retrieve status stored by another instance: %v", err)
+		t.FailNow()
+	}
+}
+
+func TestMigrateCache(t *testing.T) {
+	dir := filepath.Join(os.TempDir(), "migrate_trh")
+	defer os.RemoveAll(dir)
+	from, err := ddb.NewTXCache(dir)
+	if err != nil {
+		t.Logf("failed to create file cache: %v", err)
+		t.FailNow()
+	}
+	tx := Helper_FakeTX(t)
+	err = from.StoreTX(tx.GetTxID(), tx.ToBytes())
+	if err != nil {
+		t.Logf("failed to store tx: %v", err)
+		t.FailNow()
+	}
+	err = from.SetTXHeight(tx.GetTxID(), 715000)
+	if err != nil {
+		t.Logf("failed to set height: %v", err)
+		t.FailNow()
+	}
+	err = from.StoreTXIDs("1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X", []string{tx.GetTxID()})
+	if err != nil {
+		t.Logf("failed to store txids: %v", err)
+		t.FailNow()
+	}
+	err = from.IndexEntry("entryhash", []string{tx.GetTxID()})
+	if err != nil {
+		t.Logf("failed to index entry: %v", err)
+		t.FailNow()
+	}
+	err = from.StoreFees(miner.DefaultFees())
+	if err != nil {
+		t.Logf("failed to store fees: %v", err)
+		t.FailNow()
+	}
+	to, err := ddb.NewKVCache(dir)
+	if err != nil {
+		t.Logf("failed to create kv cache: %v", err)
+		t.FailNow()
+	}
+	defer to.Close()
+	stats, err := ddb.MigrateCache(from, to)
+	if err != nil {
+		t.Logf("failed to migrate: %v", err)
+		t.FailNow()
+	}
+	if stats.TXs != 1 || stats.Confirmed != 1 || stats.Addresses != 1 || stats.Entries != 1 {
+		t.Logf("unexpected stats after migration: %+v", stats)
+		t.FailNow()
+	}
+	_, err = to.RetrieveFees()
+	if err != nil {
+		t.Logf("fees not migrated: %v", err)
+		t.FailNow()
+	}
+	txids, err := to.EntryTXIDs("entryhash")
+	if err != nil || len(txids) != 1 || txids[0] != tx.GetTxID() {
+		t.Logf("unexpected entry txids: %v %v", txids, err)
+		t.FailNow()
+	}
+}
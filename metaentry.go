@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/ejfhp/ddb/errs"
 	"github.com/ejfhp/ddb/keys"
 )
 
@@ -20,6 +21,7 @@ type MetaEntry struct {
 	Timestamp int64    `json:"e"`
 	Notes     string   `json:"o,omitempty"`
 	Size      int      `json:"s"`
+	Index     *uint32  `json:"i,omitempty"`
 }
 
 func NewMetaEntry(node *keys.Node, entry *Entry) *MetaEntry {
@@ -38,14 +40,15 @@ func NewMetaEntry(node *keys.Node, entry *Entry) *MetaEntry {
 		DataHash:  entry.DataHash,
 		Timestamp: requestTime,
 		Notes:     entry.Notes,
-		Size:      entry.Size}
+		Size:      entry.Size,
+		Index:     node.Index()}
 	return &meta
 }
 
 func MetaEntryFromEncrypted(password [32]byte, encrypted []byte) (*MetaEntry, error) {
 	encoded, err := keys.AESDecrypt(password, encrypted)
 	if err != nil {
-		return nil, fmt.Errorf("cannot decrypt data: %w", err)
+		return nil, fmt.Errorf("cannot decrypt data: %w", errs.ErrWrongPassword)
 	}
 	var mentry MetaEntry
 	err = json.Unmarshal(encoded, &mentry)
@@ -0,0 +1,103 @@
+package miner
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ejfhp/trail"
+	"github.com/ejfhp/trail/trace"
+)
+
+const (
+	CallbackMerkleProof        = "merkleProof"
+	CallbackDoubleSpend        = "doubleSpend"
+	CallbackDoubleSpendAttempt = "doubleSpendAttempt"
+)
+
+//MaxCallbackBytes is the largest callback body accepted, merkle proofs are a few KB.
+const MaxCallbackBytes = 1 << 20
+
+//Callback is the payload sent by the miner to the callBackUrl of a submitted TX
+type Callback struct {
+	ApiVersion      string          `json:"apiVersion"`
+	Timestamp       time.Time       `json:"timestamp"`
+	MinerID         string          `json:"minerId"`
+	BlockHash       string          `json:"blockHash"`
+	BlockHeight     int             `json:"blockHeight"`
+	CallbackTXID    string          `json:"callbackTxId"`
+	CallbackReason  string          `json:"callbackReason"`
+	CallbackPayload json.RawMessage `json:"callbackPayload"`
+}
+
+//CallbackReceiver is an http.Handler that receives mAPI callbacks and passes them to OnCallback.
+//If Token is set, requests must carry it in the Authorization header.
+type CallbackReceiver struct {
+	Token      string
+	OnCallback func(cb *Callback) error
+}
+
+func (r *CallbackReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	t := trace.New().Source("callback.go", "CallbackReceiver", "ServeHTTP")
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if r.Token != "" {
+		auth := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(auth), []byte(r.Token)) != 1 {
+			trail.Println(trace.Warning("callback with wrong token").UTC().Add("remote", req.RemoteAddr).Append(t))
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, MaxCallbackBytes))
+	if err != nil {
+		trail.Println(trace.Alert("error while reading callback").UTC().Error(err).Append(t))
+		http.Error(w, "cannot read body", http.StatusBadRequest)
+		return
+	}
+	cb, err := ParseCallback(body)
+	if err != nil {
+		trail.Println(trace.Alert("error while parsing callback").UTC().Error(err).Append(t))
+		http.Error(w, "cannot parse callback", http.StatusBadRequest)
+		return
+	}
+	trail.Println(trace.Info("callback received").UTC().Add("txid", cb.CallbackTXID).Add("reason", cb.CallbackReason).Append(t))
+	if r.OnCallback != nil {
+		err = r.OnCallback(cb)
+		if err != nil {
+			trail.Println(trace.Alert("error while processing callback").UTC().Add("txid", cb.CallbackTXID).Error(err).Append(t))
+			http.Error(w, "cannot process callback", http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+//ParseCallback decodes a callback, both enveloped in a JSONEnvelope and plain.
+func ParseCallback(body []byte) (*Callback, error) {
+	envelope := struct {
+		Payload string `json:"payload"`
+	}{}
+	err := json.Unmarshal(body, &envelope)
+	if err != nil {
+		return nil, fmt.Errorf("error while unmarshalling callback: %w", err)
+	}
+	if envelope.Payload != "" {
+		body = []byte(envelope.Payload)
+	}
+	cb := Callback{}
+	err = json.Unmarshal(body, &cb)
+	if err != nil {
+		return nil, fmt.Errorf("error while unmarshalling callback payload: %w", err)
+	}
+	if cb.CallbackTXID == "" {
+		return nil, fmt.Errorf("callback without txid")
+	}
+	return &cb, nil
+}
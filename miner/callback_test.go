@@ -0,0 +1,71 @@
+package miner_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ejfhp/ddb/miner"
+)
+
+func TestCallbackReceiver_ServeHTTP(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	payload := `{"payload":"{\"apiVersion\":\"1.4.0\",\"timestamp\":\"2021-11-13T07:37:44.8783319Z\",\"minerId\":\"030d1fe5c1b560efe196ba40540ce9017c20daa9504c4c4cec6184fc702d9f274e\",\"blockHash\":\"34bbc00697512058cb040e1c7bbba5d03a2e94270093eb28114747430137f9b7\",\"blockHeight\":153,\"callbackTxId\":\"8750e986a296d39262dfeb12b0e5ae2f4c2aa2d4fbc4a6a8cf4bc2bb1e18e2c2\",\"callbackReason\":\"merkleProof\",\"callbackPayload\":{\"flags\":2}}","signature":"","publicKey":"","encoding":"UTF-8","mimetype":"application/json"}`
+	var received *miner.Callback
+	receiver := &miner.CallbackReceiver{Token: "secret", OnCallback: func(cb *miner.Callback) error {
+		received = cb
+		return nil
+	}}
+
+	req := httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(payload))
+	rec := httptest.NewRecorder()
+	receiver.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Logf("callback without token should be refused, status: %d", rec.Code)
+		t.FailNow()
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(payload))
+	req.Header.Set("Authorization", "secre")
+	rec = httptest.NewRecorder()
+	receiver.ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Logf("callback with wrong token should be refused, status: %d", rec.Code)
+		t.FailNow()
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(strings.Repeat(" ", miner.MaxCallbackBytes)+payload))
+	req.Header.Set("Authorization", "secret")
+	rec = httptest.NewRecorder()
+	receiver.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || received != nil {
+		t.Logf("oversized callback should be refused, status: %d", rec.Code)
+		t.FailNow()
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/callback", strings.NewReader(payload))
+	req.Header.Set("Authorization", "secret")
+	rec = httptest.NewRecorder()
+	receiver.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Logf("unexpected status: %d", rec.Code)
+		t.FailNow()
+	}
+	if received == nil {
+		t.Logf("callback not received")
+		t.FailNow()
+	}
+	if received.CallbackTXID != "8750e986a296d39262dfeb12b0e5ae2f4c2aa2d4fbc4a6a8cf4bc2bb1e18e2c2" {
+		t.Logf("unexpected txid: %s", received.CallbackTXID)
+		t.FailNow()
+	}
+	if received.CallbackReason != miner.CallbackMerkleProof {
+		t.Logf("unexpected reason: %s", received.CallbackReason)
+		t.FailNow()
+	}
+	if received.BlockHeight != 153 {
+		t.Logf("unexpected block height: %d", received.BlockHeight)
+		t.FailNow()
+	}
+}
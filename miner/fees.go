@@ -23,6 +23,22 @@ type Fee struct {
 	RelayFee  FeeUnit `json:"relayFee"` // Fee for retaining Tx in secondary mempool
 }
 
+//Rate returns the satoshi per kB paid for this fee type, the highest of mining and relay fee.
+func (f *Fee) Rate() float64 {
+	rate := f.MiningFee.rate()
+	if relay := f.RelayFee.rate(); relay > rate {
+		rate = relay
+	}
+	return rate
+}
+
+func (u FeeUnit) rate() float64 {
+	if u.Satoshis == nil || u.Bytes <= 0 {
+		return 0
+	}
+	return float64(*u.Satoshis) * 1000 / float64(u.Bytes)
+}
+
 //Fees is the returned array of Fee from the miner
 type Fees []*Fee
 
@@ -44,16 +60,68 @@ func (f Fees) GetDataFee() (*Fee, error) {
 	return nil, fmt.Errorf("data fee not found")
 }
 
-//CalculateFee return the amount of satoshi to set as fee for the given TX
+//FeePolicy adjusts the fee calculated from the miner fee quote.
+//Multiplier scales the calculated fee (0 means 1), MinFee is the absolute floor in satoshi.
+type FeePolicy struct {
+	Multiplier float64         `json:"multiplier"`
+	MinFee     satoshi.Satoshi `json:"minfee"`
+}
+
+//DefaultFeePolicy pays exactly what the miner asks.
+func DefaultFeePolicy() FeePolicy {
+	return FeePolicy{Multiplier: 1, MinFee: 0}
+}
+
+//Apply returns the given fee adjusted by multiplier and floor of the policy.
+func (p FeePolicy) Apply(fee satoshi.Satoshi) satoshi.Satoshi {
+	if p.Multiplier > 0 {
+		fee = satoshi.Satoshi(math.Ceil(float64(fee) * p.Multiplier))
+	}
+	if fee < p.MinFee {
+		fee = p.MinFee
+	}
+	return fee
+}
+
+//CalculateFee return the amount of satoshi to set as fee for a TX made of the given number of standard and data (OP_RETURN) bytes.
+func (f Fees) CalculateFee(standardBytes int, dataBytes int) (satoshi.Satoshi, error) {
+	t := trace.New().Source("fees.go", "Fees", "CalculateFee")
+	stdFee, err := f.GetStandardFee()
+	if err != nil {
+		trail.Println(trace.Alert("cannot get standard fee").UTC().Error(err).Append(t))
+		return 0, fmt.Errorf("cannot get standard fee: %w", err)
+	}
+	totalFeeSat := stdFee.CalculateFee(standardBytes)
+	if dataBytes > 0 {
+		dataFee, err := f.GetDataFee()
+		if err != nil {
+			trail.Println(trace.Alert("cannot get data fee").UTC().Error(err).Append(t))
+			return 0, fmt.Errorf("cannot get data fee: %w", err)
+		}
+		totalFeeSat = totalFeeSat.Add(dataFee.CalculateFee(dataBytes))
+	}
+	trail.Println(trace.Info("calculated fee").UTC().Add("standardBytes", fmt.Sprintf("%d", standardBytes)).Add("dataBytes", fmt.Sprintf("%d", dataBytes)).Add("totalFee", fmt.Sprintf("%d", totalFeeSat)).Append(t))
+	return totalFeeSat, nil
+}
+
+//CalculateFee return the amount of satoshi to pay for the given number of bytes of this fee type.
+//The result satisfies both the mining fee and the relay fee.
 func (f *Fee) CalculateFee(numBytes int) satoshi.Satoshi {
 	t := trace.New().Source("fees.go", "Fee", "CalculateFee")
-	feebuffer := 4
-	size := numBytes + feebuffer
-	feeMulti := float64(size) / float64(f.MiningFee.Bytes)
-	miningFeeSat := satoshi.Satoshi(math.Ceil(feeMulti * float64(*f.MiningFee.Satoshis)))
-	// relayFeeSat := math.Ceil(feeMulti * float64(standardFee.RelayFee.Satoshis))
-	relayFeeSat := satoshi.Satoshi(0)
-	totalFeeSat := miningFeeSat.Add(relayFeeSat)
-	trail.Println(trace.Info("calculated fee").UTC().Add("size", fmt.Sprintf("%d", size)).Add("miningFeeSat", fmt.Sprintf("%d", miningFeeSat)).Add("relayFee", fmt.Sprintf("%d", relayFeeSat)).Add("totalFee", fmt.Sprintf("%d", totalFeeSat)).Append(t))
+	miningFeeSat := f.MiningFee.feeOf(numBytes)
+	relayFeeSat := f.RelayFee.feeOf(numBytes)
+	totalFeeSat := miningFeeSat
+	if relayFeeSat > totalFeeSat {
+		totalFeeSat = relayFeeSat
+	}
+	trail.Println(trace.Info("calculated fee").UTC().Add("type", f.FeeType).Add("size", fmt.Sprintf("%d", numBytes)).Add("miningFeeSat", fmt.Sprintf("%d", miningFeeSat)).Add("relayFee", fmt.Sprintf("%d", relayFeeSat)).Add("totalFee", fmt.Sprintf("%d", totalFeeSat)).Append(t))
 	return totalFeeSat
 }
+
+func (u FeeUnit) feeOf(numBytes int) satoshi.Satoshi {
+	if u.Satoshis == nil || u.Bytes <= 0 {
+		return 0
+	}
+	feeMulti := float64(numBytes) / float64(u.Bytes)
+	return satoshi.Satoshi(math.Ceil(feeMulti * float64(*u.Satoshis)))
+}
@@ -40,7 +40,7 @@ func TestFee_CalculateFee(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to calculate fee: %v", err)
 	}
-	expected := satoshi.Satoshi(341)
+	expected := satoshi.Satoshi(339)
 	//Fee calculation must be consistent
 	for i := 0; i < 200; i++ {
 		fee := stdFee.CalculateFee(len(tx))
@@ -84,7 +84,7 @@ func TestFee_CalculateFee1035(t *testing.T) {
 	if err != nil {
 		t.Fatalf("failed to calculate fee: %v", err)
 	}
-	expected := satoshi.Satoshi(520)
+	expected := satoshi.Satoshi(518)
 	//Fee calculation must be consistent
 	for i := 0; i < 200; i++ {
 		fee := stdFee.CalculateFee(len(tx))
@@ -94,3 +94,93 @@ func TestFee_CalculateFee1035(t *testing.T) {
 
 	}
 }
+
+func TestFee_CalculateFee_Relay(t *testing.T) {
+	sat500 := satoshi.Satoshi(500)
+	sat750 := satoshi.Satoshi(750)
+	fee := miner.Fee{
+		FeeType:   "standard",
+		MiningFee: miner.FeeUnit{Satoshis: &sat500, Bytes: 1000},
+		RelayFee:  miner.FeeUnit{Satoshis: &sat750, Bytes: 1000},
+	}
+	expected := satoshi.Satoshi(750)
+	calculated := fee.CalculateFee(1000)
+	if calculated != expected {
+		t.Logf("fee should be %d but is %d", expected, calculated)
+		t.FailNow()
+	}
+}
+
+func TestFees_CalculateFee_StandardAndData(t *testing.T) {
+	sat500 := satoshi.Satoshi(500)
+	sat250 := satoshi.Satoshi(250)
+	sat100 := satoshi.Satoshi(100)
+	fees := miner.Fees{
+		{
+			FeeType:   "standard",
+			MiningFee: miner.FeeUnit{Satoshis: &sat500, Bytes: 1000},
+			RelayFee:  miner.FeeUnit{Satoshis: &sat250, Bytes: 1000},
+		},
+		{
+			FeeType:   "data",
+			MiningFee: miner.FeeUnit{Satoshis: &sat250, Bytes: 1000},
+			RelayFee:  miner.FeeUnit{Satoshis: &sat100, Bytes: 1000},
+		},
+	}
+	cases := []struct {
+		standard int
+		data     int
+		expected satoshi.Satoshi
+	}{
+		{standard: 200, data: 0, expected: 100},
+		{standard: 200, data: 10000, expected: 2600},
+		{standard: 0, data: 1001, expected: 251},
+	}
+	for i, c := range cases {
+		fee, err := fees.CalculateFee(c.standard, c.data)
+		if err != nil {
+			t.Logf("%d - failed to calculate fee: %v", i, err)
+			t.FailNow()
+		}
+		if fee != c.expected {
+			t.Logf("%d - fee should be %d but is %d", i, c.expected, fee)
+			t.FailNow()
+		}
+	}
+}
+
+func TestFee_Rate(t *testing.T) {
+	sat50 := satoshi.Satoshi(50)
+	sat250 := satoshi.Satoshi(250)
+	fee := miner.Fee{FeeType: "data", MiningFee: miner.FeeUnit{Satoshis: &sat50, Bytes: 1000}, RelayFee: miner.FeeUnit{Satoshis: &sat250, Bytes: 500}}
+	if fee.Rate() != 500 {
+		t.Logf("rate is not the highest of mining and relay: %f", fee.Rate())
+		t.FailNow()
+	}
+	empty := miner.Fee{FeeType: "data", MiningFee: miner.FeeUnit{Satoshis: &sat50}}
+	if empty.Rate() != 0 {
+		t.Logf("rate of a fee without bytes: %f", empty.Rate())
+		t.FailNow()
+	}
+}
+
+func TestFeePolicy_Apply(t *testing.T) {
+	cases := []struct {
+		policy   miner.FeePolicy
+		fee      satoshi.Satoshi
+		expected satoshi.Satoshi
+	}{
+		{policy: miner.DefaultFeePolicy(), fee: 123, expected: 123},
+		{policy: miner.FeePolicy{}, fee: 123, expected: 123},
+		{policy: miner.FeePolicy{Multiplier: 1.5}, fee: 123, expected: 185},
+		{policy: miner.FeePolicy{Multiplier: 1, MinFee: 200}, fee: 123, expected: 200},
+		{policy: miner.FeePolicy{Multiplier: 2, MinFee: 200}, fee: 123, expected: 246},
+	}
+	for i, c := range cases {
+		fee := c.policy.Apply(c.fee)
+		if fee != c.expected {
+			t.Logf("%d - fee should be %d but is %d", i, c.expected, fee)
+			t.FailNow()
+		}
+	}
+}
@@ -2,10 +2,6 @@ package miner
 
 import "time"
 
-const (
-	ResponseSuccess = "success"
-)
-
 type SingleTXResponse struct {
 	ApiVersion                string    `json:"apiVersion"`
 	Timestamp                 time.Time `json:"timestamp"`
@@ -40,12 +36,36 @@ type MultiTXResponse struct {
 
 type TX struct {
 	Rawtx              string `json:"rawtx"`
-	CallBackUrl        string `json:"callBackUrl"`
-	CallBackToken      string `json:"callBackToken"`
+	CallBackUrl        string `json:"callBackUrl,omitempty"`
+	CallBackToken      string `json:"callBackToken,omitempty"`
 	MerkleProof        bool   `json:"merkleProof"`
-	MerkleFormat       string `json:"merkleFormat"`
+	MerkleFormat       string `json:"merkleFormat,omitempty"`
 	DsCheck            bool   `json:"dsCheck"`
-	CallBackEncryption string `json:"callBackEncryption"`
+	CallBackEncryption string `json:"callBackEncryption,omitempty"`
+}
+
+//SubmitOptions are the mAPI options used when submitting transactions.
+//When CallBackURL is set the miner notifies double spends and merkle proofs to it.
+type SubmitOptions struct {
+	CallBackURL        string
+	CallBackToken      string
+	CallBackEncryption string
+	MerkleProof        bool
+	MerkleFormat       string
+	DsCheck            bool
+}
+
+//NewTX builds the mAPI TX for the given raw tx applying the options.
+func (o SubmitOptions) NewTX(rawTX string) TX {
+	return TX{
+		Rawtx:              rawTX,
+		CallBackUrl:        o.CallBackURL,
+		CallBackToken:      o.CallBackToken,
+		CallBackEncryption: o.CallBackEncryption,
+		MerkleProof:        o.MerkleProof,
+		MerkleFormat:       o.MerkleFormat,
+		DsCheck:            o.DsCheck,
+	}
 }
 
 //Miner is an interface that describes miner interctions
@@ -55,7 +75,10 @@ type Miner interface {
 	GetFees() (Fees, error)
 	GetDataFee() (*Fee, error)
 	GetStandardFee() (*Fee, error)
-	//SubmitTX submit the given raw tx to Taal MAPI and if succeed return TXID
-	SubmitTX(rawTX string) (string, error)
-	SubmitMultiTX(rawTX []string) ([][]string, error)
+	GetFeePolicy() FeePolicy
+	SetFeePolicy(policy FeePolicy)
+	SetSubmitOptions(options SubmitOptions)
+	//SubmitTX submit the given raw tx to Taal MAPI and returns the result, error if the TX is refused
+	SubmitTX(rawTX string) (*SubmitResult, error)
+	SubmitMultiTX(rawTX []string) ([]*SubmitResult, error)
 }
@@ -0,0 +1,93 @@
+package miner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/ejfhp/ddb/satoshi"
+)
+
+//Offline is a Miner that works without connection using a fixed fee schedule. It cannot submit TXs.
+type Offline struct {
+	Fees    Fees
+	Policy  FeePolicy
+	MaxData int
+}
+
+//NewOffline returns an Offline miner with the given fee schedule, DefaultFees if nil.
+func NewOffline(fees Fees) *Offline {
+	if fees == nil {
+		fees = DefaultFees()
+	}
+	return &Offline{Fees: fees, Policy: DefaultFeePolicy(), MaxData: 100000}
+}
+
+//DefaultFees is the fee schedule used when no quote is available: 500 sat/kB for standard bytes, 250 sat/kB for data bytes.
+func DefaultFees() Fees {
+	std := satoshi.Satoshi(500)
+	data := satoshi.Satoshi(250)
+	return Fees{
+		{FeeType: "standard", MiningFee: FeeUnit{Satoshis: &std, Bytes: 1000}, RelayFee: FeeUnit{Satoshis: &data, Bytes: 1000}},
+		{FeeType: "data", MiningFee: FeeUnit{Satoshis: &data, Bytes: 1000}, RelayFee: FeeUnit{Satoshis: &data, Bytes: 1000}},
+	}
+}
+
+//FeesFromFile reads a fee schedule, a JSON array of Fee as returned by mAPI feeQuote.
+func FeesFromFile(file string) (Fees, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read fee schedule %s: %w", file, err)
+	}
+	fees := Fees{}
+	err = json.Unmarshal(data, &fees)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode fee schedule %s: %w", file, err)
+	}
+	if _, err := fees.GetStandardFee(); err != nil {
+		return nil, fmt.Errorf("invalid fee schedule %s: %w", file, err)
+	}
+	if _, err := fees.GetDataFee(); err != nil {
+		return nil, fmt.Errorf("invalid fee schedule %s: %w", file, err)
+	}
+	return fees, nil
+}
+
+func (o *Offline) GetName() string {
+	return "offline"
+}
+
+func (o *Offline) MaxOpReturn() int {
+	return o.MaxData
+}
+
+func (o *Offline) GetFees() (Fees, error) {
+	return o.Fees, nil
+}
+
+func (o *Offline) GetDataFee() (*Fee, error) {
+	return o.Fees.GetDataFee()
+}
+
+func (o *Offline) GetStandardFee() (*Fee, error) {
+	return o.Fees.GetStandardFee()
+}
+
+func (o *Offline) GetFeePolicy() FeePolicy {
+	return o.Policy
+}
+
+func (o *Offline) SetFeePolicy(policy FeePolicy) {
+	o.Policy = policy
+}
+
+func (o *Offline) SetSubmitOptions(options SubmitOptions) {
+}
+
+func (o *Offline) SubmitTX(rawTX string) (*SubmitResult, error) {
+	return nil, fmt.Errorf("offline miner cannot submit TXs")
+}
+
+func (o *Offline) SubmitMultiTX(rawTXs []string) ([]*SubmitResult, error) {
+	return nil, fmt.Errorf("offline miner cannot submit TXs")
+}
@@ -0,0 +1,64 @@
+package miner_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ejfhp/ddb/miner"
+)
+
+func TestOffline_Fees(t *testing.T) {
+	off := miner.NewOffline(nil)
+	std, err := off.GetStandardFee()
+	if err != nil {
+		t.Logf("failed to get standard fee: %v", err)
+		t.FailNow()
+	}
+	if std.Rate() != 500 {
+		t.Logf("unexpected standard rate: %f", std.Rate())
+		t.FailNow()
+	}
+	data, err := off.GetDataFee()
+	if err != nil {
+		t.Logf("failed to get data fee: %v", err)
+		t.FailNow()
+	}
+	if data.Rate() != 250 {
+		t.Logf("unexpected data rate: %f", data.Rate())
+		t.FailNow()
+	}
+	_, err = off.SubmitTX("00")
+	if err == nil {
+		t.Logf("offline miner should not submit")
+		t.FailNow()
+	}
+}
+
+func TestOffline_FeesFromFile(t *testing.T) {
+	file := filepath.Join(os.TempDir(), "fees_trh.json")
+	defer os.Remove(file)
+	schedule, _ := json.Marshal(miner.DefaultFees())
+	err := ioutil.WriteFile(file, schedule, 0600)
+	if err != nil {
+		t.Logf("failed to write fee schedule: %v", err)
+		t.FailNow()
+	}
+	fees, err := miner.FeesFromFile(file)
+	if err != nil {
+		t.Logf("failed to read fee schedule: %v", err)
+		t.FailNow()
+	}
+	if len(fees) != 2 {
+		t.Logf("unexpected number of fees: %d", len(fees))
+		t.FailNow()
+	}
+	ioutil.WriteFile(file, []byte(`[{"feeType":"standard"}]`), 0600)
+	_, err = miner.FeesFromFile(file)
+	if err == nil {
+		t.Logf("schedule without data fee should be refused")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,88 @@
+package miner
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ejfhp/ddb/errs"
+)
+
+//ResultType is the outcome of the submission of a TX as returned by mAPI in returnResult.
+type ResultType string
+
+const (
+	ResultSuccess ResultType = "success"
+	ResultFailure ResultType = "failure"
+)
+
+//SubmitResult is the outcome of the submission of a single TX.
+type SubmitResult struct {
+	TXID           string     `json:"txid"`
+	Result         ResultType `json:"result"`
+	Description    string     `json:"description"`
+	ConflictedWith []string   `json:"conflictedwith,omitempty"`
+	MinerID        string     `json:"minerid"`
+	Timestamp      time.Time  `json:"timestamp"`
+}
+
+//Success returns true if the miner accepted the TX.
+func (r *SubmitResult) Success() bool {
+	return r.Result == ResultSuccess
+}
+
+func (r *SubmitResult) String() string {
+	if r.Description == "" {
+		return fmt.Sprintf("%s: %s", r.TXID, r.Result)
+	}
+	return fmt.Sprintf("%s: %s (%s)", r.TXID, r.Result, r.Description)
+}
+
+//SubmitError collects the TXs refused by the miner in a single submission.
+type SubmitError struct {
+	Failures []*SubmitResult
+}
+
+func (e *SubmitError) Error() string {
+	descs := make([]string, len(e.Failures))
+	for i, f := range e.Failures {
+		descs[i] = f.String()
+	}
+	return fmt.Sprintf("miner refused %d TXs: %s", len(e.Failures), strings.Join(descs, "; "))
+}
+
+func (e *SubmitError) Is(target error) bool {
+	return target == errs.ErrMinerRejected
+}
+
+//Failed returns the failed results, nil if all the TXs have been accepted.
+func Failed(results []*SubmitResult) *SubmitError {
+	failures := []*SubmitResult{}
+	for _, r := range results {
+		if !r.Success() {
+			failures = append(failures, r)
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	return &SubmitError{Failures: failures}
+}
+
+//Result returns the SubmitResult of the single TX response.
+func (r *SingleTXResponse) Result() *SubmitResult {
+	return &SubmitResult{TXID: r.TXID, Result: ResultType(r.ReturnResult), Description: r.ResultDescription, MinerID: r.MinerID, Timestamp: r.Timestamp}
+}
+
+//Results returns a SubmitResult for every TX of the multi TX response.
+func (r *MultiTXResponse) Results() []*SubmitResult {
+	results := make([]*SubmitResult, 0, len(r.TXS))
+	for _, tx := range r.TXS {
+		res := SubmitResult{TXID: tx.TXID, Result: ResultType(tx.ReturnResult), Description: tx.ResultDescription, MinerID: r.MinerID, Timestamp: r.Timestamp}
+		for _, c := range tx.ConflictedWith {
+			res.ConflictedWith = append(res.ConflictedWith, c.TXID)
+		}
+		results = append(results, &res)
+	}
+	return results
+}
@@ -0,0 +1,60 @@
+package miner_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/ejfhp/ddb/errs"
+	"github.com/ejfhp/ddb/miner"
+)
+
+func TestMultiTXResponse_Results(t *testing.T) {
+	payload := `{"apiVersion":"1.4.0","timestamp":"2021-11-13T10:00:00.000Z","minerId":"03e92d3e5c3f7bd945dfbf48e7a99393b1bfb3f11f380ae30d286e7ff2aec5a270","currentHighestBlockHash":"","currentHighestBlockHeight":715000,"txSecondMempoolExpiry":0,
+	"txs":[{"txid":"aaaa","returnResult":"success","resultDescription":""},
+	{"txid":"bbbb","returnResult":"failure","resultDescription":"Missing inputs","conflictedWith":[{"txid":"cccc","size":191,"hex":"00"}]}],"failureCount":1}`
+	resp := miner.MultiTXResponse{}
+	err := json.Unmarshal([]byte(payload), &resp)
+	if err != nil {
+		t.Logf("cannot unmarshal payload: %v", err)
+		t.FailNow()
+	}
+	results := resp.Results()
+	if len(results) != 2 {
+		t.Logf("unexpected number of results: %d", len(results))
+		t.FailNow()
+	}
+	if !results[0].Success() || results[0].TXID != "aaaa" || results[0].MinerID != resp.MinerID || results[0].Timestamp.IsZero() {
+		t.Logf("unexpected first result: %v", results[0])
+		t.FailNow()
+	}
+	if results[1].Success() || results[1].Description != "Missing inputs" || len(results[1].ConflictedWith) != 1 || results[1].ConflictedWith[0] != "cccc" {
+		t.Logf("unexpected second result: %v", results[1])
+		t.FailNow()
+	}
+	failed := miner.Failed(results)
+	if failed == nil || len(failed.Failures) != 1 || failed.Failures[0].TXID != "bbbb" {
+		t.Logf("unexpected failures: %v", failed)
+		t.FailNow()
+	}
+	var err2 error = failed
+	var subErr *miner.SubmitError
+	if !errors.As(err2, &subErr) {
+		t.Logf("failures should be a SubmitError")
+		t.FailNow()
+	}
+	if miner.Failed(results[:1]) != nil {
+		t.Logf("successful results should not fail")
+		t.FailNow()
+	}
+}
+
+func TestSubmitError_Is(t *testing.T) {
+	failed := &miner.SubmitError{Failures: []*miner.SubmitResult{{TXID: "aaaa", Result: miner.ResultFailure, Description: "Missing inputs"}}}
+	err := fmt.Errorf("failed to submit txs: %w", failed)
+	if !errors.Is(err, errs.ErrMinerRejected) {
+		t.Logf("SubmitError should match ErrMinerRejected: %v", err)
+		t.FailNow()
+	}
+}
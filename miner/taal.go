@@ -7,17 +7,20 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"github.com/ejfhp/ddb/errs"
 	"github.com/ejfhp/trail"
 	"github.com/ejfhp/trail/trace"
 )
 
 type TAAL struct {
 	BaseURL string
+	Policy  FeePolicy
+	Options SubmitOptions
 	fees    Fees
 }
 
 func NewTAAL() *TAAL {
-	return &TAAL{BaseURL: "https://mapi.taal.com/mapi"}
+	return &TAAL{BaseURL: "https://mapi.taal.com/mapi", Policy: DefaultFeePolicy()}
 }
 
 func (l *TAAL) GetName() string {
@@ -38,7 +41,7 @@ func (l *TAAL) GetFees() (Fees, error) {
 	resp, err := http.Get(url)
 	if err != nil {
 		trail.Println(trace.Alert("error while getting fee").UTC().Add("url", url).Error(err).Append(t))
-		return nil, fmt.Errorf("error while getting fee: %w", err)
+		return nil, fmt.Errorf("error while getting fee: %w", errs.Network("taal", url, err))
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
@@ -96,63 +99,69 @@ func (l *TAAL) GetStandardFee() (*Fee, error) {
 	return stdFee, nil
 }
 
-//SubmitTX submit the given raw tx to Tall MAPI and if succeed return TXID
-func (l *TAAL) SubmitTX(rawTX string) (string, error) {
+//GetFeePolicy returns the policy applied to the fees calculated from TAAL fee quote
+func (l *TAAL) GetFeePolicy() FeePolicy {
+	return l.Policy
+}
+
+//SetFeePolicy sets the policy applied to the fees calculated from TAAL fee quote
+func (l *TAAL) SetFeePolicy(policy FeePolicy) {
+	l.Policy = policy
+}
+
+//SetSubmitOptions sets the mAPI options used by SubmitTX and SubmitMultiTX
+func (l *TAAL) SetSubmitOptions(options SubmitOptions) {
+	l.Options = options
+}
+
+//SubmitTX submit the given raw tx to Tall MAPI and returns the result, error if the TX is refused
+func (l *TAAL) SubmitTX(rawTX string) (*SubmitResult, error) {
 	t := trace.New().Source("taal.go", "TAAL", "SubmitTX")
 	url := fmt.Sprintf("%s/tx", l.BaseURL)
 	trail.Println(trace.Debug("submit tx").UTC().Add("url", url).Append(t))
 	//fmt.Printf("\n\n %s \n\n", rawTX)
-	mapiSubmitTX := TX{
-		Rawtx:       rawTX,
-		MerkleProof: false,
-		DsCheck:     false,
-	}
+	mapiSubmitTX := l.Options.NewTX(rawTX)
 	payload, err := json.Marshal(mapiSubmitTX)
 	if err != nil {
-		return "", fmt.Errorf("error while marshalling MapiSubmitTX: %w", err)
+		return nil, fmt.Errorf("error while marshalling MapiSubmitTX: %w", err)
 	}
 	resp, err := http.Post(url, "application/json", bytes.NewReader(payload))
 	if err != nil {
-		return "", fmt.Errorf("error while posting TX: %w", err)
+		return nil, fmt.Errorf("error while posting TX: %w", errs.Network("taal", url, err))
 	}
 	body, _ := ioutil.ReadAll(resp.Body)
 	if resp.StatusCode != 200 {
-		return "", fmt.Errorf("miner replied with bad status: %s", resp.Status)
+		return nil, errs.NetworkStatus("taal", url, resp.Status)
 
 	}
 	mapiResponse := make(map[string]interface{})
 	err = json.Unmarshal(body, &mapiResponse)
 	if err != nil {
-		return "", fmt.Errorf("error while unmarshalling mapi response: %w", err)
+		return nil, fmt.Errorf("error while unmarshalling mapi response: %w", err)
 	}
 	responsePayload := mapiResponse["payload"].(string)
 	mapiPayload := SingleTXResponse{}
 	err = json.Unmarshal([]byte(responsePayload), &mapiPayload)
 	if err != nil {
-		return "", fmt.Errorf("error while unmarshalling mapi payload: %w", err)
+		return nil, fmt.Errorf("error while unmarshalling mapi payload: %w", err)
 	}
-	if mapiPayload.ReturnResult != "success" {
+	result := mapiPayload.Result()
+	if !result.Success() {
 		trail.Println(trace.Alert("mapi call unsuccesful").UTC().Add("return", mapiPayload.ReturnResult).Add("returnDecription", mapiPayload.ResultDescription).Append(t))
-		return "", fmt.Errorf("mapi call unsuccesfull: %s, %s", mapiPayload.ReturnResult, mapiPayload.ResultDescription)
-
+		return result, &SubmitError{Failures: []*SubmitResult{result}}
 	}
-	txid := mapiPayload.TXID
-	return txid, nil
+	return result, nil
 }
 
-//SubmitMultiTX sumbmits multiple transactions and return an array of txid and corressponding result
-func (l *TAAL) SubmitMultiTX(rawTXs []string) ([][]string, error) {
+//SubmitMultiTX sumbmits multiple transactions and return the result of each one in the same order
+func (l *TAAL) SubmitMultiTX(rawTXs []string) ([]*SubmitResult, error) {
 	t := trace.New().Source("taal.go", "TAAL", "SubmitMultiTX")
 	url := fmt.Sprintf("%s/txs", l.BaseURL)
 	trail.Println(trace.Debug("submit multi tx").UTC().Add("url", url).Append(t))
 	//fmt.Printf("\n\n %s \n\n", rawTX)
 	mapiSubmitMultiTX := make([]TX, len(rawTXs))
 	for i, rawTX := range rawTXs {
-		mapiSubmitMultiTX[i] = TX{
-			Rawtx:       rawTX,
-			MerkleProof: false,
-			DsCheck:     false,
-		}
+		mapiSubmitMultiTX[i] = l.Options.NewTX(rawTX)
 	}
 	payload, err := json.Marshal(mapiSubmitMultiTX)
 	if err != nil {
@@ -162,13 +171,13 @@ func (l *TAAL) SubmitMultiTX(rawTXs []string) ([][]string, error) {
 	resp, err := http.Post(url, "application/json", bytes.NewReader(payload))
 	if err != nil {
 		trail.Println(trace.Alert("error while posting MultiTX").UTC().Add("url", url).Error(err).Append(t))
-		return nil, fmt.Errorf("error while posting MultiTX: %w", err)
+		return nil, fmt.Errorf("error while posting MultiTX: %w", errs.Network("taal", url, err))
 	}
 	body, _ := ioutil.ReadAll(resp.Body)
 	trail.Println(trace.Info("miner response").UTC().Add("url", url).Add("response", string(body)).Append(t))
 	if resp.StatusCode != 200 {
 		trail.Println(trace.Alert("miner replied with bad status").UTC().Add("url", url).Add("status", resp.Status).Append(t))
-		return nil, fmt.Errorf("miner replied with bad status: %s", resp.Status)
+		return nil, errs.NetworkStatus("taal", url, resp.Status)
 
 	}
 	mapiResponse := make(map[string]interface{})
@@ -184,9 +193,5 @@ func (l *TAAL) SubmitMultiTX(rawTXs []string) ([][]string, error) {
 		trail.Println(trace.Alert("error while unmarshalling mapi payload").UTC().Add("url", url).Error(err).Append(t))
 		return nil, fmt.Errorf("error while unmarshalling mapi payload: %w", err)
 	}
-	responseTXs := make([][]string, 0, len(mapiPayload.TXS))
-	for _, res := range mapiPayload.TXS {
-		responseTXs = append(responseTXs, []string{res.TXID, res.ResultDescription})
-	}
-	return responseTXs, nil
+	return mapiPayload.Results(), nil
 }
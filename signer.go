@@ -0,0 +1,149 @@
+package ddb
+
+import (
+	"fmt"
+
+	"github.com/ejfhp/ddb/keys"
+	"github.com/ejfhp/trail"
+	"github.com/ejfhp/trail/trace"
+	bt "github.com/libsv/go-bt"
+	"github.com/libsv/go-bt/bscript"
+)
+
+//Signer signs the inputs of a DataTX built unsigned.
+type Signer interface {
+	Sign(tx *DataTX) error
+}
+
+//KeySigner signs in process the inputs locked to the addresses of its keys.
+type KeySigner struct {
+	signers map[string]*bt.InternalSigner
+}
+
+//NewKeySigner returns a KeySigner with the given WIF keys.
+func NewKeySigner(wifs ...string) (*KeySigner, error) {
+	ks := KeySigner{signers: make(map[string]*bt.InternalSigner, len(wifs))}
+	for _, wif := range wifs {
+		signer, err := internalSigner(wif)
+		if err != nil {
+			return nil, err
+		}
+		address, err := keys.AddressOf(wif)
+		if err != nil {
+			return nil, fmt.Errorf("error getting address of key: %w", err)
+		}
+		script, err := bscript.NewP2PKHFromAddress(address)
+		if err != nil {
+			return nil, fmt.Errorf("error getting locking script of address %s: %w", address, err)
+		}
+		ks.signers[script.ToString()] = signer
+	}
+	return &ks, nil
+}
+
+//Sign signs every input of the TX, previous output script and value are taken from the SourceOutputs.
+//Fails if an input is locked to an address of which the signer has no key.
+func (s *KeySigner) Sign(tx *DataTX) error {
+	tr := trace.New().Source("signer.go", "KeySigner", "Sign")
+	err := tx.fillInputsFromSourceOutputs()
+	if err != nil {
+		return err
+	}
+	for i, in := range tx.Inputs {
+		signer, ok := s.signers[in.PreviousTxScript.ToString()]
+		if !ok {
+			trail.Println(trace.Alert("no key for input").UTC().Add("input", fmt.Sprintf("%d", i)).Add("txid", in.PreviousTxID).Append(tr))
+			return fmt.Errorf("no key for input %d spending %s:%d", i, in.PreviousTxID, in.PreviousTxOutIndex)
+		}
+		err = tx.Tx.Sign(uint32(i), signer)
+		if err != nil {
+			return fmt.Errorf("cannot sign input %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+//SignTXs signs the TXs in the given order. Signing changes the TXID, so the inputs spending an output of
+//a previous TX of the list, like the chained TXs of a store, are relinked to the signed TX before signing.
+func SignTXs(signer Signer, txs []*DataTX) error {
+	tr := trace.New().Source("signer.go", "", "SignTXs")
+	unsignedIDs := make([]string, len(txs))
+	for i, tx := range txs {
+		unsignedIDs[i] = tx.GetTxID()
+	}
+	for i, tx := range txs {
+		err := signer.Sign(tx)
+		if err != nil {
+			return fmt.Errorf("failed to sign TX %d: %w", i, err)
+		}
+		signedID := tx.GetTxID()
+		if signedID == unsignedIDs[i] {
+			continue
+		}
+		for _, next := range txs[i+1:] {
+			for j, in := range next.Inputs {
+				if in.PreviousTxID == unsignedIDs[i] {
+					in.PreviousTxID = signedID
+					next.SourceOutputs[j].TXHash = signedID
+				}
+			}
+		}
+		trail.Println(trace.Debug("TX signed").UTC().Add("unsigned", unsignedIDs[i]).Add("txid", signedID).Append(tr))
+	}
+	return nil
+}
+
+//IsSigned returns true if every input has an unlocking script.
+func (t *DataTX) IsSigned() bool {
+	for _, in := range t.Inputs {
+		if in.UnlockingScript == nil || len(*in.UnlockingScript) == 0 {
+			return false
+		}
+	}
+	return len(t.Inputs) > 0
+}
+
+func (t *DataTX) fillInputsFromSourceOutputs() error {
+	if len(t.SourceOutputs) != len(t.Inputs) {
+		return fmt.Errorf("source outputs and inputs have different length: %d %d", len(t.SourceOutputs), len(t.Inputs))
+	}
+	for i, in := range t.Inputs {
+		so := t.SourceOutputs[i]
+		if so.TXHash != in.PreviousTxID || so.TXPos != in.PreviousTxOutIndex {
+			return fmt.Errorf("source output %d doesn't match input %s:%d", i, in.PreviousTxID, in.PreviousTxOutIndex)
+		}
+		script, err := bscript.NewFromHexString(so.ScriptPubKeyHex)
+		if err != nil {
+			return fmt.Errorf("cannot decode script of source output %d: %w", i, err)
+		}
+		in.PreviousTxScript = script
+		in.PreviousTxSatoshis = uint64(so.Value)
+	}
+	return nil
+}
+
+//PortableTX is the JSON form of a DataTX, it carries the source outputs needed to sign the TX on another machine.
+type PortableTX struct {
+	Hex           string          `json:"hex"`
+	SourceOutputs []*SourceOutput `json:"sourceoutputs"`
+	Signed        bool            `json:"signed"`
+}
+
+//ToPortable returns the DataTX in its portable form.
+func (t *DataTX) ToPortable() *PortableTX {
+	return &PortableTX{Hex: t.ToString(), SourceOutputs: t.SourceOutputs, Signed: t.IsSigned()}
+}
+
+//DataTX rebuilds the DataTX from its portable form.
+func (p *PortableTX) DataTX() (*DataTX, error) {
+	dtx, err := DataTXFromHex(p.Hex)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode portable TX: %w", err)
+	}
+	dtx.SourceOutputs = p.SourceOutputs
+	err = dtx.fillInputsFromSourceOutputs()
+	if err != nil {
+		return nil, fmt.Errorf("portable TX is inconsistent: %w", err)
+	}
+	return dtx, nil
+}
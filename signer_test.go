@@ -0,0 +1,160 @@
+package ddb_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/keys"
+	"github.com/ejfhp/ddb/satoshi"
+	"github.com/libsv/go-bt/bscript"
+)
+
+func TestSigner_ExportSignImport(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	utxos := fakeUTXOs(10000, 5000)
+	script, _ := bscript.NewP2PKHFromAddress(destinationAddress)
+	for _, u := range utxos {
+		u.ScriptPubKeyHex = script.ToString()
+	}
+	unsigned, err := ddb.NewUnsignedDataTX(destinationAddress, destinationAddress, utxos, satoshi.Satoshi(1000), satoshi.Satoshi(200), []byte("data"), "test01234")
+	if err != nil {
+		t.Logf("failed to build unsigned TX: %v", err)
+		t.FailNow()
+	}
+	if unsigned.IsSigned() {
+		t.Logf("TX should not be signed")
+		t.FailNow()
+	}
+	exported, err := json.Marshal(unsigned.ToPortable())
+	if err != nil {
+		t.Logf("failed to export TX: %v", err)
+		t.FailNow()
+	}
+	portable := ddb.PortableTX{}
+	err = json.Unmarshal(exported, &portable)
+	if err != nil {
+		t.Logf("failed to decode portable TX: %v", err)
+		t.FailNow()
+	}
+	if portable.Signed {
+		t.Logf("portable TX should not be signed")
+		t.FailNow()
+	}
+	imported, err := portable.DataTX()
+	if err != nil {
+		t.Logf("failed to import TX: %v", err)
+		t.FailNow()
+	}
+	wrongSigner, err := ddb.NewKeySigner("L2mk9qzXebT1gfwUuALMJrbqBtrJxGUN5JnVeqQTGRXytqpXsPr8")
+	if err != nil {
+		t.Logf("failed to create signer: %v", err)
+		t.FailNow()
+	}
+	err = wrongSigner.Sign(imported)
+	if err == nil {
+		t.Logf("signer without the key should fail")
+		t.FailNow()
+	}
+	signer, err := ddb.NewKeySigner(destinationKey)
+	if err != nil {
+		t.Logf("failed to create signer: %v", err)
+		t.FailNow()
+	}
+	err = signer.Sign(imported)
+	if err != nil {
+		t.Logf("failed to sign: %v", err)
+		t.FailNow()
+	}
+	if !imported.IsSigned() {
+		t.Logf("TX should be signed")
+		t.FailNow()
+	}
+	signed, err := ddb.NewDataTX(destinationKey, destinationAddress, destinationAddress, utxos, satoshi.Satoshi(1000), satoshi.Satoshi(200), []byte("data"), "test01234")
+	if err != nil {
+		t.Logf("failed to build signed TX: %v", err)
+		t.FailNow()
+	}
+	if signed.GetTxID() != imported.GetTxID() {
+		t.Logf("TX signed after import differs: %s %s", signed.GetTxID(), imported.GetTxID())
+		t.FailNow()
+	}
+}
+
+func TestSigner_SignTXs(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	keystore, err := keys.NewKeystore(destinationKey, "mainpassword")
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	entry := ddb.NewEntryFromData("chained.txt", "text/plain", []byte(strings.Repeat("TRH - The Rabbit Hole, signed offline. ", 30)), []string{"sign"}, "notes")
+	for _, layout := range []string{ddb.LayoutChain, ddb.LayoutFanOut} {
+		explorer := newFakeExplorer()
+		utxos := fakeUTXOs(100000)
+		script, _ := bscript.NewP2PKHFromAddress(destinationAddress)
+		utxos[0].ScriptPubKeyHex = script.ToString()
+		explorer.utxos[destinationAddress] = utxos
+		blockchain := ddb.NewBlockchain(&fakeMiner{maxOpReturn: 300}, explorer, nil)
+		btrunk := ddb.NewBTrunk(destinationKey, destinationAddress, keystore.Source().Password(), blockchain)
+		btrunk.SetLayout(layout)
+		btrunk.SetUnsigned(true)
+		node, err := keystore.NewNode(entry.Name+layout, entry.HashOfEntry())
+		if err != nil {
+			t.Logf("failed to generate node: %v", err)
+			t.FailNow()
+		}
+		txs, err := btrunk.TXOfBranchedEntry(node, entry, "test01234", satoshi.Satoshi(100000), false)
+		if err != nil {
+			t.Logf("failed to build unsigned TXs with layout %s: %v", layout, err)
+			t.FailNow()
+		}
+		if len(txs) < 3 {
+			t.Logf("entry should need more than one part with layout %s: %d TXs", layout, len(txs))
+			t.FailNow()
+		}
+		for i, tx := range txs {
+			if tx.IsSigned() {
+				t.Logf("TX %d should not be signed", i)
+				t.FailNow()
+			}
+		}
+		signer, err := ddb.NewKeySigner(destinationKey, node.Key())
+		if err != nil {
+			t.Logf("failed to create signer: %v", err)
+			t.FailNow()
+		}
+		err = ddb.SignTXs(signer, txs)
+		if err != nil {
+			t.Logf("failed to sign TXs with layout %s: %v", layout, err)
+			t.FailNow()
+		}
+		for i, tx := range txs {
+			if !tx.IsSigned() {
+				t.Logf("TX %d should be signed", i)
+				t.FailNow()
+			}
+		}
+		if layout == ddb.LayoutChain {
+			for i, tx := range txs[1:] {
+				if tx.Inputs[0].PreviousTxID != txs[i].GetTxID() {
+					t.Logf("TX %d doesn't spend the signed TX %d", i+1, i)
+					t.FailNow()
+				}
+			}
+		} else {
+			for i, tx := range txs[2:] {
+				if tx.Inputs[0].PreviousTxID != txs[1].GetTxID() || tx.Inputs[0].PreviousTxOutIndex != uint32(i) {
+					t.Logf("TX %d doesn't spend its output of the signed funding TX", i+2)
+					t.FailNow()
+				}
+			}
+		}
+		err = blockchain.CheckUnspent(txs)
+		if err != nil {
+			t.Logf("signed TXs should spend only the funding UTXO: %v", err)
+			t.FailNow()
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 
+	"github.com/ejfhp/ddb/errs"
 	"github.com/ejfhp/ddb/keys"
 	"github.com/ejfhp/ddb/satoshi"
 	"github.com/ejfhp/trail"
@@ -18,6 +19,8 @@ const (
 	VER_AES     = "0001" //4 bytes
 	headerLen   = 9
 	FakeTXValue = 20000000
+	//1 push + 72 DER signature + 1 sighash + 1 push + 33 compressed public key
+	maxP2PKHUnlockingLen = 108
 )
 
 type SourceOutput struct {
@@ -52,6 +55,21 @@ type DataTX struct {
 //NewDataTX builds a DataTX with the given params. Output order is: 1:destination, 2:opreturn, 3:change.
 func NewDataTX(sourceKey string, destinationAddress string, changeAddress string, inutxo []*UTXO, amount satoshi.Token, fee satoshi.Token, data []byte, header string) (*DataTX, error) {
 	t := trace.New().Source("transaction.go", "DataTX", "NewDataTX")
+	dtx, err := NewUnsignedDataTX(destinationAddress, changeAddress, inutxo, amount, fee, data, header)
+	if err != nil {
+		return nil, err
+	}
+	err = signAll(dtx.Tx, sourceKey)
+	if err != nil {
+		trail.Println(trace.Alert("error signing the transaction").UTC().Append(t).Error(err))
+		return nil, fmt.Errorf("error signing the transaction: %w", err)
+	}
+	return dtx, nil
+}
+
+//NewUnsignedDataTX builds a DataTX like NewDataTX without signing it, so that it can be signed by a Signer.
+func NewUnsignedDataTX(destinationAddress string, changeAddress string, inutxo []*UTXO, amount satoshi.Token, fee satoshi.Token, data []byte, header string) (*DataTX, error) {
+	t := trace.New().Source("transaction.go", "DataTX", "NewUnsignedDataTX")
 	var payload []byte = nil
 	var err error
 	if data != nil {
@@ -61,44 +79,49 @@ func NewDataTX(sourceKey string, destinationAddress string, changeAddress string
 			return nil, fmt.Errorf("cannot add header: %w", err)
 		}
 	}
-	tx, err := NewTX(sourceKey, destinationAddress, changeAddress, inutxo, amount, fee, payload)
+	tx, err := newUnsignedTX(destinationAddress, changeAddress, inutxo, amount, fee, payload)
 	if err != nil {
 		trail.Println(trace.Alert("error creating a new transaction").UTC().Append(t).Error(err))
 		return nil, fmt.Errorf("error creating a new transaction: %w", err)
 	}
-	sourceOutputs := []*SourceOutput{}
-	for _, utx := range inutxo {
-		sourceOutput := SourceOutput{TXPos: utx.TXPos, TXHash: utx.TXHash, Value: utx.Value.Satoshi(), ScriptPubKeyHex: utx.ScriptPubKeyHex}
-		sourceOutputs = append(sourceOutputs, &sourceOutput)
-	}
-	dtx := DataTX{SourceOutputs: sourceOutputs, Tx: tx}
+	dtx := DataTX{SourceOutputs: sourceOutputsOf(inutxo), Tx: tx}
 	return &dtx, nil
 }
 
 //NewMultiInputTX builds a DataTX transaction collecting the amount from the multiple UTXO given. Inputs is a map of key and corresponfing UTXOs.
 func NewMultiInputTX(destinationAddress string, inputs map[string][]*UTXO, fee satoshi.Token) (*DataTX, error) {
-	tr := trace.New().Source("transaction.go", "", "NewMultiInputTX")
-	tx := bt.NewTx()
-	satInput := satoshi.Satoshi(0)
-	sourceOutputs := []*SourceOutput{}
+	inutxo := []*UTXO{}
 	signers := []*bt.InternalSigner{}
 	for k, utxos := range inputs {
-		key, err := keys.DecodeWIF(k)
+		signer, err := internalSigner(k)
 		if err != nil {
-			return nil, fmt.Errorf("error decoding WIF '%s': %w", k, err)
+			return nil, err
 		}
-		signer := &bt.InternalSigner{PrivateKey: key, SigHashFlag: 0x40 | 0x01}
-		for _, utx := range utxos {
-			input, err := bt.NewInputFromUTXO(utx.TXHash, utx.TXPos, uint64(utx.Value.Satoshi()), utx.ScriptPubKeyHex, math.MaxUint32)
-			if err != nil {
-				return nil, fmt.Errorf("cannot get UTXO input: %w", err)
-			}
-			satInput = satInput.Add(utx.Value)
-			tx.AddInput(input)
+		for range utxos {
 			signers = append(signers, signer)
-			sourceOutput := SourceOutput{TXPos: utx.TXPos, TXHash: utx.TXHash, Value: utx.Value.Satoshi(), ScriptPubKeyHex: utx.ScriptPubKeyHex}
-			sourceOutputs = append(sourceOutputs, &sourceOutput)
 		}
+		inutxo = append(inutxo, utxos...)
+	}
+	dtx, err := NewUnsignedMultiInputTX(destinationAddress, inutxo, fee)
+	if err != nil {
+		return nil, err
+	}
+	for i := range dtx.Inputs {
+		err = dtx.Sign(uint32(i), signers[i])
+		if err != nil {
+			return nil, fmt.Errorf("cannot sign input %d: %w", i, err)
+		}
+	}
+	return dtx, nil
+}
+
+//NewUnsignedMultiInputTX builds a DataTX sending all the given UTXOs, less the fee, to destinationAddress without signing it.
+func NewUnsignedMultiInputTX(destinationAddress string, inutxo []*UTXO, fee satoshi.Token) (*DataTX, error) {
+	tr := trace.New().Source("transaction.go", "", "NewUnsignedMultiInputTX")
+	tx := bt.NewTx()
+	satInput, err := addInputs(tx, inutxo)
+	if err != nil {
+		return nil, err
 	}
 	satOutput, err := satInput.Sub(fee)
 	if err != nil {
@@ -110,48 +133,163 @@ func NewMultiInputTX(destinationAddress string, inputs map[string][]*UTXO, fee s
 		return nil, fmt.Errorf("cannot create output, destinationAddress %s amount %0.8f: %w", destinationAddress, satOutput.Bitcoin(), err)
 	}
 	tx.AddOutput(outputDest)
-	if len(signers) != len(tx.Inputs) {
-		return nil, fmt.Errorf("signers and inputs have different length")
+	dtx := DataTX{SourceOutputs: sourceOutputsOf(inutxo), Tx: tx}
+	return &dtx, nil
+}
+
+//NewFanOutTX builds a DataTX with one output to destinationAddress for each of the given amounts. Remaining value goes to changeAddress as last output.
+func NewFanOutTX(sourceKey string, destinationAddress string, changeAddress string, inutxo []*UTXO, amounts []satoshi.Satoshi, fee satoshi.Token) (*DataTX, error) {
+	dtx, err := NewUnsignedFanOutTX(destinationAddress, changeAddress, inutxo, amounts, fee)
+	if err != nil {
+		return nil, err
 	}
-	for i := range tx.Inputs {
-		err = tx.Sign(uint32(i), signers[i])
+	err = signAll(dtx.Tx, sourceKey)
+	if err != nil {
+		return nil, err
+	}
+	return dtx, nil
+}
+
+//NewUnsignedFanOutTX builds a DataTX like NewFanOutTX without signing it, so that it can be signed by a Signer.
+func NewUnsignedFanOutTX(destinationAddress string, changeAddress string, inutxo []*UTXO, amounts []satoshi.Satoshi, fee satoshi.Token) (*DataTX, error) {
+	tr := trace.New().Source("transaction.go", "", "NewUnsignedFanOutTX")
+	tx := bt.NewTx()
+	satInput, err := addInputs(tx, inutxo)
+	if err != nil {
+		return nil, err
+	}
+	satOut := fee.Satoshi()
+	for i, amount := range amounts {
+		output, err := bt.NewP2PKHOutputFromAddress(destinationAddress, uint64(amount))
 		if err != nil {
-			return nil, fmt.Errorf("cannot sign input %d: %w", i, err)
+			return nil, fmt.Errorf("cannot create output %d, amount %0.8f: %w", i, amount.Bitcoin(), err)
 		}
+		tx.AddOutput(output)
+		satOut = satOut.Add(amount)
 	}
-	dtx := DataTX{SourceOutputs: sourceOutputs, Tx: tx}
+	trail.Println(trace.Info(fmt.Sprintf("in:%d out:%d fee:%d outputs:%d", satInput.Satoshi(), satOut.Satoshi(), fee.Satoshi(), len(amounts))).Append(tr).UTC())
+	err = addChange(tx, changeAddress, satInput, satOut)
+	if err != nil {
+		return nil, err
+	}
+	dtx := DataTX{SourceOutputs: sourceOutputsOf(inutxo), Tx: tx}
+	return &dtx, nil
+}
+
+//BatchOutput is an output of a batch TX: a P2PKH output to Address if Address is not empty, followed by an OP_RETURN output if Data is not nil.
+type BatchOutput struct {
+	Address string
+	Value   satoshi.Satoshi
+	Data    []byte
+}
+
+//NewBatchDataTX builds a DataTX with the given outputs, each data output gets the given header. Remaining value goes to changeAddress as last output.
+func NewBatchDataTX(sourceKey string, changeAddress string, inutxo []*UTXO, outputs []*BatchOutput, fee satoshi.Token, header string) (*DataTX, error) {
+	dtx, err := NewUnsignedBatchDataTX(changeAddress, inutxo, outputs, fee, header)
+	if err != nil {
+		return nil, err
+	}
+	err = signAll(dtx.Tx, sourceKey)
+	if err != nil {
+		return nil, err
+	}
+	return dtx, nil
+}
+
+//NewUnsignedBatchDataTX builds a DataTX like NewBatchDataTX without signing it, so that it can be signed by a Signer.
+func NewUnsignedBatchDataTX(changeAddress string, inutxo []*UTXO, outputs []*BatchOutput, fee satoshi.Token, header string) (*DataTX, error) {
+	tr := trace.New().Source("transaction.go", "", "NewUnsignedBatchDataTX")
+	tx := bt.NewTx()
+	satInput, err := addInputs(tx, inutxo)
+	if err != nil {
+		return nil, err
+	}
+	satOut := fee.Satoshi()
+	for i, out := range outputs {
+		if out.Address != "" {
+			output, err := bt.NewP2PKHOutputFromAddress(out.Address, uint64(out.Value))
+			if err != nil {
+				return nil, fmt.Errorf("cannot create output %d, address %s amount %0.8f: %w", i, out.Address, out.Value.Bitcoin(), err)
+			}
+			tx.AddOutput(output)
+			satOut = satOut.Add(out.Value)
+		}
+		if out.Data != nil {
+			payload, err := addDataHeader(header, out.Data)
+			if err != nil {
+				trail.Println(trace.Alert("cannot add header").UTC().Add("header", header).Error(err).Append(tr))
+				return nil, fmt.Errorf("cannot add header: %w", err)
+			}
+			outOpRet, err := bt.NewOpReturnOutput(payload)
+			if err != nil {
+				return nil, fmt.Errorf("cannot create OP_RETURN output %d: %w", i, err)
+			}
+			tx.AddOutput(outOpRet)
+		}
+	}
+	trail.Println(trace.Info(fmt.Sprintf("in:%d out:%d fee:%d outputs:%d", satInput.Satoshi(), satOut.Satoshi(), fee.Satoshi(), len(outputs))).Append(tr).UTC())
+	err = addChange(tx, changeAddress, satInput, satOut)
+	if err != nil {
+		return nil, err
+	}
+	dtx := DataTX{SourceOutputs: sourceOutputsOf(inutxo), Tx: tx}
 	return &dtx, nil
 }
 
 //NewTX builds a bt.TX transaction with the given params. To move all the amount connected to the address use put EmptyWallet as amount.
 func NewTX(sourceKey string, destinationAddress string, changeAddress string, inutxo []*UTXO, amount satoshi.Token, fee satoshi.Token, opreturn []byte) (*bt.Tx, error) {
-	tr := trace.New().Source("transaction.go", "", "NewTX")
-	tx := bt.NewTx()
-	satInput := satoshi.Satoshi(0)
-	for _, utx := range inutxo {
-		input, err := bt.NewInputFromUTXO(utx.TXHash, utx.TXPos, uint64(utx.Value.Satoshi()), utx.ScriptPubKeyHex, math.MaxUint32)
+	tx, err := newUnsignedTX(destinationAddress, changeAddress, inutxo, amount, fee, opreturn)
+	if err != nil {
+		return nil, err
+	}
+	err = signAll(tx, sourceKey)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+//signAll signs every input of the TX with the given key.
+func signAll(tx *bt.Tx, sourceKey string) error {
+	signer, err := internalSigner(sourceKey)
+	if err != nil {
+		return err
+	}
+	for i := range tx.Inputs {
+		err = tx.Sign(uint32(i), signer)
 		if err != nil {
-			return nil, fmt.Errorf("cannot get UTXO input: %w", err)
+			return fmt.Errorf("cannot sign input %d: %w", i, err)
 		}
-		satInput = satInput.Add(utx.Value)
-		tx.AddInput(input)
 	}
-	if satInput == 0 {
-		trail.Println(trace.Alert("input is 0").Append(tr).UTC())
-		return nil, fmt.Errorf("input is 0")
+	return nil
+}
+
+//internalSigner returns the SIGHASH_ALL|FORKID signer of the given WIF key.
+func internalSigner(wif string) (*bt.InternalSigner, error) {
+	k, err := keys.DecodeWIF(wif)
+	if err != nil {
+		return nil, fmt.Errorf("error decoding key: %w", err)
+	}
+	return &bt.InternalSigner{PrivateKey: k, SigHashFlag: 0x40 | 0x01}, nil
+}
+
+func newUnsignedTX(destinationAddress string, changeAddress string, inutxo []*UTXO, amount satoshi.Token, fee satoshi.Token, opreturn []byte) (*bt.Tx, error) {
+	tr := trace.New().Source("transaction.go", "", "newUnsignedTX")
+	tx := bt.NewTx()
+	satInput, err := addInputs(tx, inutxo)
+	if err != nil {
+		return nil, err
 	}
 	satDest := satoshi.Satoshi(0)
-	satChange := satoshi.Satoshi(0)
 	if amount.Bitcoin() < 0 {
 		trail.Println(trace.Alert("requested output amount is negative").Append(tr).UTC())
 		return nil, fmt.Errorf("requested output amount is negative")
 	}
 	if amount.Satoshi() == satoshi.EmptyWallet {
 		trail.Println(trace.Warning("requested output is EmptyWallet").Append(tr).UTC())
-		var err error
 		satDest, err = satInput.Sub(fee)
 		if err != nil {
-			return nil, fmt.Errorf("cannot define output value, input/output/fee %0.8f/%0.8f/%0.8f: %w", satInput.Bitcoin(), fee.Bitcoin(), satDest.Bitcoin(), err)
+			return nil, fmt.Errorf("cannot define output value, input/output/fee %0.8f/%0.8f/%0.8f: %w", satInput.Bitcoin(), fee.Bitcoin(), satDest.Bitcoin(), errs.ErrInsufficientFunds)
 		}
 	} else {
 		satDest = amount.Satoshi()
@@ -172,30 +310,52 @@ func NewTX(sourceKey string, destinationAddress string, changeAddress string, in
 	}
 	satOut := satDest.Add(fee)
 	trail.Println(trace.Info(fmt.Sprintf("in:%d out:%d fee:%d", satInput.Satoshi(), satDest.Satoshi(), fee.Satoshi())).Append(tr).UTC())
-	satChange, err = satInput.Sub(satOut)
+	err = addChange(tx, changeAddress, satInput, satOut)
 	if err != nil {
-		return nil, fmt.Errorf("cannot define change value, input/output/fee %0.8f/%0.8f/%0.8f: %w", satInput.Bitcoin(), satDest.Bitcoin(), fee.Bitcoin(), err)
+		return nil, err
 	}
-	if satChange.Satoshi() > 0 {
-		outputChange, err := bt.NewP2PKHOutputFromAddress(changeAddress, uint64(satChange.Satoshi()))
+	return tx, nil
+}
+
+//addInputs adds to the TX an input for each UTXO and returns their total value, that cannot be 0.
+func addInputs(tx *bt.Tx, inutxo []*UTXO) (satoshi.Satoshi, error) {
+	tr := trace.New().Source("transaction.go", "", "addInputs")
+	satInput := satoshi.Satoshi(0)
+	for _, utx := range inutxo {
+		input, err := bt.NewInputFromUTXO(utx.TXHash, utx.TXPos, uint64(utx.Value.Satoshi()), utx.ScriptPubKeyHex, math.MaxUint32)
 		if err != nil {
-			return nil, fmt.Errorf("cannot create output, changeAddress %s amount %0.8f: %w", changeAddress, satChange.Bitcoin(), err)
+			return 0, fmt.Errorf("cannot get UTXO input: %w", err)
 		}
-		tx.AddOutput(outputChange)
+		satInput = satInput.Add(utx.Value)
+		tx.AddInput(input)
 	}
+	if satInput == 0 {
+		trail.Println(trace.Alert("input is 0").Append(tr).UTC())
+		return 0, fmt.Errorf("input is 0")
+	}
+	return satInput, nil
+}
 
-	k, err := keys.DecodeWIF(sourceKey)
+//addChange adds to the TX the output to changeAddress of what is left of satInput once paid satOut, the fee included.
+//No output is added if what is left is below DustLimit, it goes to the miner: a dust output makes the TX non standard.
+func addChange(tx *bt.Tx, changeAddress string, satInput satoshi.Satoshi, satOut satoshi.Satoshi) error {
+	tr := trace.New().Source("transaction.go", "", "addChange")
+	satChange, err := satInput.Sub(satOut)
 	if err != nil {
-		return nil, fmt.Errorf("error decoding key: %w", err)
+		return fmt.Errorf("cannot define change value, input/output+fee %0.8f/%0.8f: %w", satInput.Bitcoin(), satOut.Bitcoin(), errs.ErrInsufficientFunds)
 	}
-	signer := &bt.InternalSigner{PrivateKey: k, SigHashFlag: 0x40 | 0x01}
-	for i := range tx.Inputs {
-		err = tx.Sign(uint32(i), signer)
+	if satChange.Satoshi() > 0 && satChange.Satoshi() < DustLimit {
+		trail.Println(trace.Info("dust change added to fee").Append(tr).UTC().Add("change", fmt.Sprintf("%d", satChange.Satoshi())))
+		return nil
+	}
+	if satChange.Satoshi() > 0 {
+		outputChange, err := bt.NewP2PKHOutputFromAddress(changeAddress, uint64(satChange.Satoshi()))
 		if err != nil {
-			return nil, fmt.Errorf("cannot sign input %d: %w", i, err)
+			return fmt.Errorf("cannot create output, changeAddress %s amount %0.8f: %w", changeAddress, satChange.Bitcoin(), err)
 		}
+		tx.AddOutput(outputChange)
 	}
-	return tx, nil
+	return nil
 }
 
 func DataTXFromHex(h string) (*DataTX, error) {
@@ -221,9 +381,10 @@ func DataTXFromBytes(b []byte) (*DataTX, error) {
 	return &dtx, nil
 }
 
-//OpReturn returns OP_RETURN data
-func (t *DataTX) OpReturn() ([]byte, error) {
+//OpReturn returns the data of all the OP_RETURN outputs, in output order
+func (t *DataTX) OpReturn() ([][]byte, error) {
 	// trail.Println(trace.Info("reading OP_RETURN from DataTX").UTC().Append(tr))
+	opreturns := [][]byte{}
 	for _, o := range t.Outputs {
 		if o.LockingScript.IsData() {
 			// fmt.Println(o.ToBytes())
@@ -241,25 +402,49 @@ func (t *DataTX) OpReturn() ([]byte, error) {
 					continue
 				}
 				//Third place in ops
-				return v, nil
+				opreturns = append(opreturns, v)
+				break
 			}
 		}
 	}
-	return nil, fmt.Errorf("no OP_RETURN found")
+	if len(opreturns) == 0 {
+		return nil, fmt.Errorf("no OP_RETURN found")
+	}
+	return opreturns, nil
 }
 
-//OpReturn returns data and header of the OP_RETURN data
+//Data returns data and header of the first OP_RETURN output
 func (t *DataTX) Data() ([]byte, string, error) {
 	// trail.Println(trace.Info("reading encrypted data from DataTX").UTC().Append(tr))
-	opret, err := t.OpReturn()
+	data, headers, err := t.AllData()
 	if err != nil {
-		return nil, "", fmt.Errorf("error extracting OP_RETURN: %w", err)
+		return nil, "", err
 	}
-	header, data, err := stripDataHeader(opret)
+	return data[0], headers[0], nil
+}
+
+//AllData returns data and header of every OP_RETURN output with a header, outputs without one are not TRH data and are skipped
+func (t *DataTX) AllData() ([][]byte, []string, error) {
+	tr := trace.New().Source("transaction.go", "DataTX", "AllData")
+	opreturns, err := t.OpReturn()
 	if err != nil {
-		return nil, "", fmt.Errorf("error stripping header from data: %w", err)
+		return nil, nil, fmt.Errorf("error extracting OP_RETURN: %w", err)
 	}
-	return data, header, nil
+	data := make([][]byte, 0, len(opreturns))
+	headers := make([]string, 0, len(opreturns))
+	for _, opret := range opreturns {
+		header, d, err := stripDataHeader(opret)
+		if err != nil {
+			trail.Println(trace.Warning("skipping OP_RETURN without header").UTC().Add("TXID", t.GetTxID()).Error(err).Append(tr))
+			continue
+		}
+		data = append(data, d)
+		headers = append(headers, header)
+	}
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("no OP_RETURN with header found")
+	}
+	return data, headers, nil
 }
 
 //Fee returns fee of TX
@@ -288,6 +473,29 @@ func (t *DataTX) TotInOutFee() (satoshi.Satoshi, satoshi.Satoshi, satoshi.Satosh
 	return totInput, satoshi.Satoshi(totOutput), fee, nil
 }
 
+//Sizes returns the number of standard bytes and of data (OP_RETURN) bytes of the TX as defined by the feespec.
+//Unlocking scripts are counted at their maximum P2PKH length, so the result doesn't depend on the signatures.
+func (t *DataTX) Sizes() (int, int) {
+	dataBytes := 0
+	for _, out := range t.Outputs {
+		if out.LockingScript.IsData() {
+			dataBytes += len(*out.LockingScript)
+		}
+	}
+	unlockPadding := 0
+	for _, in := range t.Inputs {
+		unlockLen := 0
+		if in.UnlockingScript != nil {
+			unlockLen = len(*in.UnlockingScript)
+		}
+		if unlockLen < maxP2PKHUnlockingLen {
+			unlockPadding += maxP2PKHUnlockingLen - unlockLen
+		}
+	}
+	standardBytes := len(t.ToBytes()) - dataBytes + unlockPadding
+	return standardBytes, dataBytes
+}
+
 func (t *DataTX) UTXOs() []*UTXO {
 	utxos := make([]*UTXO, 0)
 	for op, out := range t.Outputs {
@@ -329,6 +537,15 @@ func addDataHeader(header string, data []byte) ([]byte, error) {
 	return payload, nil
 }
 
+func sourceOutputsOf(inutxo []*UTXO) []*SourceOutput {
+	sourceOutputs := []*SourceOutput{}
+	for _, utx := range inutxo {
+		sourceOutput := SourceOutput{TXPos: utx.TXPos, TXHash: utx.TXHash, Value: utx.Value.Satoshi(), ScriptPubKeyHex: utx.ScriptPubKeyHex}
+		sourceOutputs = append(sourceOutputs, &sourceOutput)
+	}
+	return sourceOutputs
+}
+
 func fakeKeyAddUTXO(num int) (string, string, []*UTXO) {
 	//Sample Address and Key
 	address := "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
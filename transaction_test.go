@@ -7,6 +7,7 @@ import (
 
 	"github.com/ejfhp/ddb"
 	"github.com/ejfhp/ddb/satoshi"
+	"github.com/libsv/go-bt"
 )
 
 func TestTransaction_NewTX(t *testing.T) {
@@ -364,3 +365,147 @@ func TestTransaction_NewDataTX_Data(t *testing.T) {
 
 	}
 }
+
+func TestTransaction_DataTX_Sizes(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	datatx := Helper_FakeTX(t)
+	std, data := datatx.Sizes()
+	opret, err := datatx.OpReturn()
+	if err != nil {
+		t.Logf("failed to get OP_RETURN: %v", err)
+		t.FailNow()
+	}
+	if data <= len(opret[0]) {
+		t.Logf("data bytes %d should include OP_RETURN payload of %d bytes plus opcodes", data, len(opret[0]))
+		t.FailNow()
+	}
+	if std+data < len(datatx.ToBytes()) {
+		t.Logf("sizes %d+%d smaller than TX %d", std, data, len(datatx.ToBytes()))
+		t.FailNow()
+	}
+	if std+data > len(datatx.ToBytes())+len(datatx.Inputs)*3 {
+		t.Logf("sizes %d+%d too big for TX %d", std, data, len(datatx.ToBytes()))
+		t.FailNow()
+	}
+}
+
+func TestTransaction_NewFanOutTX(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	destinationAddress := "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	destinationKey := "L4ZaBkP1UTyxdEM7wysuPd1scHMLLf8sf8B2tcEcssUZ7ujrYWcQ"
+	changeAddress := "1EpFjTzJoNAFyJKVGATzxhgqXigUWLNWM6"
+	txid := "e6706b900df5a46253b8788f691cbe1506c1e9b76766f1f9d6b3602e1458f055"
+	scriptHex := "76a9142f353ff06fe8c4d558b9f58dce952948252e5df788ac"
+	balance := satoshi.Bitcoin(0.000402740)
+	fee := satoshi.Satoshi(170)
+	amounts := []satoshi.Satoshi{1000, 2000, 3000}
+	utxos := []*ddb.UTXO{{TXHash: txid, TXPos: 1, Value: balance, ScriptPubKeyHex: scriptHex}}
+	tx, err := ddb.NewFanOutTX(destinationKey, destinationAddress, changeAddress, utxos, amounts, fee)
+	if err != nil {
+		t.Logf("failed to create tx: %v", err)
+		t.FailNow()
+	}
+	if len(tx.Outputs) != len(amounts)+1 {
+		t.Logf("wrong number of output: %d", len(tx.Outputs))
+		t.FailNow()
+	}
+	for i, a := range amounts {
+		if tx.Outputs[i].Satoshis != uint64(a) {
+			t.Logf("output %d has wrong value: %d", i, tx.Outputs[i].Satoshis)
+			t.FailNow()
+		}
+	}
+	_, _, txfee, err := tx.TotInOutFee()
+	if err != nil {
+		t.Logf("failed to get fee: %v", err)
+		t.FailNow()
+	}
+	if txfee != fee {
+		t.Logf("unexpected fee: %d", txfee)
+		t.FailNow()
+	}
+}
+
+func TestTransaction_NewBatchDataTX(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	key := "L4ZaBkP1UTyxdEM7wysuPd1scHMLLf8sf8B2tcEcssUZ7ujrYWcQ"
+	address := "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	utxos := []*ddb.UTXO{{TXHash: "e6706b900df5a46253b8788f691cbe1506c1e9b76766f1f9d6b3602e1458f055", TXPos: 0, Value: satoshi.Satoshi(10000).Bitcoin(), ScriptPubKeyHex: "76a9142f353ff06fe8c4d558b9f58dce952948252e5df788ac"}}
+	outputs := []*ddb.BatchOutput{
+		{Data: []byte("first")},
+		{Address: address, Value: satoshi.Satoshi(546), Data: []byte("second")},
+		{Data: []byte("third")},
+	}
+	tx, err := ddb.NewBatchDataTX(key, address, utxos, outputs, satoshi.Satoshi(200), "test01234")
+	if err != nil {
+		t.Logf("failed to build batch TX: %v", err)
+		t.FailNow()
+	}
+	//3 data outputs, 1 P2PKH output, change
+	if len(tx.Outputs) != 5 {
+		t.Logf("unexpected number of outputs: %d", len(tx.Outputs))
+		t.FailNow()
+	}
+	data, headers, err := tx.AllData()
+	if err != nil {
+		t.Logf("failed to read data: %v", err)
+		t.FailNow()
+	}
+	for i, exp := range []string{"first", "second", "third"} {
+		if string(data[i]) != exp || headers[i] != "test01234" {
+			t.Logf("unexpected data %d: %s %s", i, string(data[i]), headers[i])
+			t.FailNow()
+		}
+	}
+	first, _, err := tx.Data()
+	if err != nil || string(first) != "first" {
+		t.Logf("Data should return the first OP_RETURN: %s %v", string(first), err)
+		t.FailNow()
+	}
+	_, _, fee, err := tx.TotInOutFee()
+	if err != nil {
+		t.Logf("failed to get fee: %v", err)
+		t.FailNow()
+	}
+	if fee != 200 {
+		t.Logf("unexpected fee: %d", fee)
+		t.FailNow()
+	}
+}
+
+func TestTransaction_AllData_ForeignOpReturn(t *testing.T) {
+	key := "L4ZaBkP1UTyxdEM7wysuPd1scHMLLf8sf8B2tcEcssUZ7ujrYWcQ"
+	address := "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	utxos := []*ddb.UTXO{{TXHash: "e6706b900df5a46253b8788f691cbe1506c1e9b76766f1f9d6b3602e1458f055", TXPos: 0, Value: satoshi.Satoshi(10000).Bitcoin(), ScriptPubKeyHex: "76a9142f353ff06fe8c4d558b9f58dce952948252e5df788ac"}}
+	tx, err := ddb.NewBatchDataTX(key, address, utxos, []*ddb.BatchOutput{{Data: []byte("trh")}}, satoshi.Satoshi(200), "test01234")
+	if err != nil {
+		t.Logf("failed to build batch TX: %v", err)
+		t.FailNow()
+	}
+	foreign, err := bt.NewOpReturnOutput([]byte("memo"))
+	if err != nil {
+		t.Logf("failed to build foreign OP_RETURN: %v", err)
+		t.FailNow()
+	}
+	tx.Outputs = append([]*bt.Output{foreign}, tx.Outputs...)
+	data, headers, err := tx.AllData()
+	if err != nil {
+		t.Logf("failed to read data: %v", err)
+		t.FailNow()
+	}
+	if len(data) != 1 || string(data[0]) != "trh" || headers[0] != "test01234" {
+		t.Logf("unexpected data: %d %v", len(data), headers)
+		t.FailNow()
+	}
+	first, _, err := tx.Data()
+	if err != nil || string(first) != "trh" {
+		t.Logf("Data should skip the foreign OP_RETURN: %s %v", string(first), err)
+		t.FailNow()
+	}
+	tx.Outputs = []*bt.Output{foreign}
+	_, _, err = tx.AllData()
+	if err == nil {
+		t.Logf("TX with only a foreign OP_RETURN should have no data")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,43 @@
+package trh
+
+import (
+	"fmt"
+
+	"github.com/ejfhp/ddb"
+)
+
+//ExportArchive writes to file all the TXs of the source and node addresses of the keystore, with the merkle proofs of the mined ones.
+func (t *TRH) ExportArchive(file string) (*ddb.Archive, error) {
+	addresses := []string{t.keystore.Source().Address()}
+	for _, n := range t.keystore.Nodes() {
+		addresses = append(addresses, n.Address())
+	}
+	archive, err := t.blockchain.ExportArchive(addresses)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build archive: %w", err)
+	}
+	err = archive.Write(file)
+	if err != nil {
+		return nil, err
+	}
+	return archive, nil
+}
+
+//ImportArchive stores in the cache the TXs of the archive file, so that the files can be retrieved with cacheOnly, without explorer.
+func (t *TRH) ImportArchive(file string) (*ddb.Archive, error) {
+	if t.blockchain == nil {
+		err := t.connect()
+		if err != nil {
+			return nil, err
+		}
+	}
+	archive, err := ddb.ReadArchive(file)
+	if err != nil {
+		return nil, err
+	}
+	err = t.blockchain.ImportArchive(archive)
+	if err != nil {
+		return nil, fmt.Errorf("failed to import archive: %w", err)
+	}
+	return archive, nil
+}
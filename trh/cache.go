@@ -0,0 +1,155 @@
+package trh
+
+import (
+	"fmt"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/keys"
+)
+
+//SetCacheMaxBytes sets the size above which TXs are evicted from the cache, 0 means no limit.
+func (t *TRH) SetCacheMaxBytes(maxBytes int64) {
+	t.cacheMaxBytes = maxBytes
+	if t.cache != nil {
+		t.cache.SetMaxBytes(maxBytes)
+	}
+}
+
+//CacheStats returns the content summary of the user cache.
+func (t *TRH) CacheStats() (*ddb.CacheStats, error) {
+	cache, err := t.userCache()
+	if err != nil {
+		return nil, err
+	}
+	return cache.Stats()
+}
+
+//VerifyCache checks the integrity of the TXs in the user cache and fixes its index.
+func (t *TRH) VerifyCache() (*ddb.CacheReport, error) {
+	cache, err := t.userCache()
+	if err != nil {
+		return nil, err
+	}
+	return cache.Verify()
+}
+
+//PruneCache deletes corrupted TXs and evicts TXs until the user cache is not bigger than maxBytes, DefaultCacheMaxBytes if 0.
+func (t *TRH) PruneCache(maxBytes int64) ([]string, error) {
+	cache, err := t.userCache()
+	if err != nil {
+		return nil, err
+	}
+	if maxBytes <= 0 {
+		maxBytes = ddb.DefaultCacheMaxBytes
+	}
+	return cache.Prune(maxBytes)
+}
+
+//SetCacheType selects the cache backend, ddb.CacheTypeFile or ddb.CacheTypeKV.
+//Empty means the key-value cache if it exists in the user cache dir, the dir of files otherwise.
+func (t *TRH) SetCacheType(cacheType string) error {
+	switch cacheType {
+	case "", ddb.CacheTypeFile, ddb.CacheTypeKV:
+		t.cacheType = cacheType
+		return nil
+	default:
+		return fmt.Errorf("unknown cache type '%s', use %s or %s", cacheType, ddb.CacheTypeFile, ddb.CacheTypeKV)
+	}
+}
+
+//SetCacheEncryption makes the cache metadata encrypted with a key derived from the PIN of the keystore.
+//A cache already encrypted is always opened with the key.
+func (t *TRH) SetCacheEncryption(encrypt bool) {
+	t.encryptCache = encrypt
+}
+
+//SetCacheTXEncryption makes the raw TXs of the cache encrypted too and their IDs hidden, so that the cache doesn't tell which TXs are cached.
+//It implies the encryption of the metadata.
+func (t *TRH) SetCacheTXEncryption(encrypt bool) {
+	t.encryptTXs = encrypt
+}
+
+//SetCacheKeystore sets the keystore whose PIN unlocks the encrypted cache, needed by the commands not using a keystore.
+func (t *TRH) SetCacheKeystore(keystore *keys.Keystore) {
+	t.cacheKeys = keystore
+}
+
+//MigrateCache moves the content of the dir of files cache into the key-value cache file and deletes the old files.
+func (t *TRH) MigrateCache() (*ddb.CacheStats, error) {
+	if t.cache != nil {
+		return nil, fmt.Errorf("cannot migrate a cache in use")
+	}
+	from, err := ddb.NewUserTXCache()
+	if err != nil {
+		return nil, fmt.Errorf("cannot open cache: %w", err)
+	}
+	to, err := ddb.NewUserKVCache()
+	if err != nil {
+		return nil, fmt.Errorf("cannot open key-value cache: %w", err)
+	}
+	defer to.Close()
+	encrypt := t.encryptCache || t.encryptTXs || from.Encrypted()
+	from.SetEncryptTXs(t.encryptTXs)
+	err = encryptCache(from, t.cacheKeys, encrypt)
+	if err != nil {
+		return nil, err
+	}
+	//TXs encrypted in the old cache stay encrypted
+	to.SetEncryptTXs(t.encryptTXs || from.EncryptsTXs())
+	err = encryptCache(to, t.cacheKeys, encrypt)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := ddb.MigrateCache(from, to)
+	if err != nil {
+		return nil, fmt.Errorf("failed to migrate cache: %w", err)
+	}
+	err = from.Clear()
+	if err != nil {
+		return stats, fmt.Errorf("cache migrated but old files not deleted: %w", err)
+	}
+	return stats, nil
+}
+
+//encryptCache sets the key derived from the PIN of the keystore if the cache must be encrypted.
+func encryptCache(cache ddb.Cache, keystore *keys.Keystore, encrypt bool) error {
+	if !encrypt {
+		return nil
+	}
+	if keystore == nil {
+		return fmt.Errorf("cannot open cache: %w", ddb.ErrCacheLocked)
+	}
+	salt, err := cache.CacheSalt()
+	if err != nil {
+		return fmt.Errorf("cannot get cache salt: %w", err)
+	}
+	key, err := keystore.CacheKey(salt)
+	if err != nil {
+		return fmt.Errorf("cannot derive cache key: %w", err)
+	}
+	err = cache.SetEncryptionKey(key)
+	if err != nil {
+		return fmt.Errorf("cannot unlock cache: %w", err)
+	}
+	return nil
+}
+
+//userCache returns the cache in use, opening the user cache the first time.
+func (t *TRH) userCache() (ddb.Cache, error) {
+	if t.cache != nil {
+		return t.cache, nil
+	}
+	cache, err := ddb.NewUserCache(t.cacheType)
+	if err != nil {
+		return nil, fmt.Errorf("cannot open cache: %w", err)
+	}
+	cache.SetMaxBytes(t.cacheMaxBytes)
+	cache.SetEncryptTXs(t.encryptTXs)
+	err = encryptCache(cache, t.cacheKeys, t.encryptCache || t.encryptTXs || cache.Encrypted())
+	if err != nil {
+		cache.Close()
+		return nil, err
+	}
+	t.cache = cache
+	return cache, nil
+}
@@ -0,0 +1,46 @@
+package trh
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/miner"
+)
+
+//CallbackHandler returns the handler that records miner callbacks into the user cache.
+func (t *TRH) CallbackHandler(token string) (http.Handler, error) {
+	cache, err := t.userCache()
+	if err != nil {
+		return nil, err
+	}
+	return &miner.CallbackReceiver{Token: token, OnCallback: cache.RecordCallback}, nil
+}
+
+//ServeCallbacks listens on address for miner callbacks on the given path, it blocks until the server fails.
+func (t *TRH) ServeCallbacks(address string, path string, token string) error {
+	handler, err := t.CallbackHandler(token)
+	if err != nil {
+		return fmt.Errorf("cannot build callback handler: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle(path, handler)
+	err = http.ListenAndServe(address, mux)
+	if err != nil {
+		return fmt.Errorf("error while serving callbacks on %s: %w", address, err)
+	}
+	return nil
+}
+
+//TXStatus returns the last known status of the submitted TX.
+func (t *TRH) TXStatus(txid string) (*ddb.TXStatus, error) {
+	cache, err := t.userCache()
+	if err != nil {
+		return nil, err
+	}
+	status, err := cache.RetrieveTXStatus(txid)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get status of tx %s: %w", txid, err)
+	}
+	return status, nil
+}
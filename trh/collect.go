@@ -1,57 +1,134 @@
 package trh
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/ejfhp/ddb"
-	"github.com/ejfhp/ddb/keys"
-	"github.com/ejfhp/ddb/miner"
+	"github.com/ejfhp/ddb/errs"
+	"github.com/ejfhp/ddb/satoshi"
 	"github.com/ejfhp/trail"
 	"github.com/ejfhp/trail/trace"
 )
 
-//Collect gather all the UTXO left in branches to the main address. Return the txids.
-func (t *TRH) Collect(keystore *keys.Keystore) ([]string, error) {
-	tr := trace.New().Source("collect.go", "", "cmdCollect")
-	woc := ddb.NewWOC()
-	taal := miner.NewTAAL()
-	cache, err := ddb.NewUserTXCache()
-	if err != nil {
-		return nil, fmt.Errorf("cannot open cache")
-	}
-	blockchain := ddb.NewBlockchain(taal, woc, cache)
+//MaxCollectInputs is the max number of inputs of a single collecting TX, bigger sweeps are split in more TXs.
+var MaxCollectInputs = 100
 
-	utxos := make(map[string][]*ddb.UTXO)
-	for _, n := range keystore.Nodes() {
-		u, err := blockchain.GetUTXO(n.Address())
-		if err != nil && err.Error() != "found no UTXO" {
-			return nil, fmt.Errorf("error while retrieving UTXO for address %s: %w", n.Address, err)
+type keyUTXO struct {
+	key  string
+	utxo *ddb.UTXO
+}
+
+//Collect gathers all the UTXOs left in the nodes to the source address. UTXOs not worth the fee of their input are skipped.
+//If dryRun is true the collecting TXs are built but not submitted.
+func (t *TRH) Collect(dryRun bool) ([]*ddb.DataTX, error) {
+	tr := trace.New().Source("collect.go", "TRH", "Collect")
+	found := []keyUTXO{}
+	for _, n := range t.keystore.Nodes() {
+		u, err := t.blockchain.GetUTXO(n.Address())
+		if err != nil && !errors.Is(err, errs.ErrNoUTXO) {
+			return nil, fmt.Errorf("error while retrieving UTXO for address %s: %w", n.Address(), err)
 		}
-		if len(u) > 0 {
-			utxos[n.Key()] = u
+		for _, utxo := range u {
+			found = append(found, keyUTXO{key: n.Key(), utxo: utxo})
 		}
 	}
-	var ids []string
-	if len(utxos) > 0 {
-		fee, err := blockchain.EstimateStandardTXFee(len(utxos))
-		if err != nil {
-			return nil, fmt.Errorf("error while estimating collecting tx fee: %w", err)
+	if len(found) == 0 {
+		return []*ddb.DataTX{}, nil
+	}
+	inputFee, err := t.inputFee()
+	if err != nil {
+		return nil, err
+	}
+	inputs := []keyUTXO{}
+	for _, in := range found {
+		if in.utxo.Value.Satoshi() <= inputFee {
+			trail.Println(trace.Warning("skipping dust UTXO").UTC().Add("txid", in.utxo.TXHash).Add("value", fmt.Sprintf("%d", in.utxo.Value.Satoshi())).Append(tr))
+			continue
 		}
-		//TODO COLLECT HAS ISSUES
-		collectingTX, err := ddb.NewMultiInputTX(keystore.Source().Address(), utxos, fee)
-		if err != nil {
-			return nil, fmt.Errorf("error while building collecting TX: %w", err)
+		inputs = append(inputs, in)
+	}
+	txs := []*ddb.DataTX{}
+	for start := 0; start < len(inputs); start += MaxCollectInputs {
+		end := start + MaxCollectInputs
+		if end > len(inputs) {
+			end = len(inputs)
 		}
-		txres, err := blockchain.Submit([]*ddb.DataTX{collectingTX})
+		tx, err := t.collectingTX(inputs[start:end])
 		if err != nil {
-			trail.Println(trace.Alert("error submitting collecting TX").Append(tr).UTC().Error(err))
-			return nil, fmt.Errorf("error submitting collecting TX: %w", err)
+			return nil, err
 		}
-		ids = make([]string, len(txres))
-		for i, tx := range txres {
-			ids[i] = tx[0]
+		if tx != nil {
+			txs = append(txs, tx)
 		}
+	}
+	if dryRun || len(txs) == 0 {
+		return txs, nil
+	}
+	_, err = t.blockchain.Submit(txs)
+	if err != nil {
+		trail.Println(trace.Alert("error submitting collecting TX").Append(tr).UTC().Error(err))
+		return nil, fmt.Errorf("error submitting collecting TX: %w", err)
+	}
+	return txs, nil
+}
 
+//collectingTX builds the TX spending the given inputs, the fee is calculated on the TX itself. Returns nil if the inputs don't cover the fee.
+//Inputs are signed by a KeySigner, so an UTXO not locked to the address of its node key is refused instead of getting a wrong signature.
+func (t *TRH) collectingTX(inputs []keyUTXO) (*ddb.DataTX, error) {
+	tr := trace.New().Source("collect.go", "TRH", "collectingTX")
+	utxos := make([]*ddb.UTXO, 0, len(inputs))
+	wifs := []string{}
+	seen := make(map[string]bool)
+	tot := satoshi.Satoshi(0)
+	for _, in := range inputs {
+		utxos = append(utxos, in.utxo)
+		tot = tot.Add(in.utxo.Value)
+		if !seen[in.key] {
+			seen[in.key] = true
+			wifs = append(wifs, in.key)
+		}
+	}
+	signer, err := ddb.NewKeySigner(wifs...)
+	if err != nil {
+		return nil, fmt.Errorf("error while creating collecting TX signer: %w", err)
+	}
+	draft, err := t.signedCollectingTX(signer, utxos, satoshi.Satoshi(1))
+	if err != nil {
+		return nil, err
+	}
+	fee, err := t.blockchain.EstimateFee(draft)
+	if err != nil {
+		return nil, fmt.Errorf("error while estimating collecting TX fee: %w", err)
+	}
+	if tot <= fee {
+		trail.Println(trace.Warning("collecting TX not worth its fee").UTC().Add("inputs", fmt.Sprintf("%d", len(inputs))).Add("value", fmt.Sprintf("%d", tot)).Add("fee", fmt.Sprintf("%d", fee)).Append(tr))
+		return nil, nil
+	}
+	return t.signedCollectingTX(signer, utxos, fee)
+}
+
+func (t *TRH) signedCollectingTX(signer ddb.Signer, utxos []*ddb.UTXO, fee satoshi.Satoshi) (*ddb.DataTX, error) {
+	collectingTX, err := ddb.NewUnsignedMultiInputTX(t.keystore.Source().Address(), utxos, fee)
+	if err != nil {
+		return nil, fmt.Errorf("error while building collecting TX: %w", err)
+	}
+	err = signer.Sign(collectingTX)
+	if err != nil {
+		return nil, fmt.Errorf("error while signing collecting TX: %w", err)
+	}
+	return collectingTX, nil
+}
+
+//inputFee is the fee of adding one P2PKH input to a TX.
+func (t *TRH) inputFee() (satoshi.Satoshi, error) {
+	one, err := t.blockchain.EstimateStandardTXFee(1)
+	if err != nil {
+		return 0, fmt.Errorf("error while estimating input fee: %w", err)
+	}
+	two, err := t.blockchain.EstimateStandardTXFee(2)
+	if err != nil {
+		return 0, fmt.Errorf("error while estimating input fee: %w", err)
 	}
-	return ids, nil
+	return two.Sub(one)
 }
@@ -0,0 +1,132 @@
+package trh_test
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/ejfhp/ddb/keys"
+	"github.com/ejfhp/ddb/miner"
+	"github.com/ejfhp/ddb/satoshi"
+	"github.com/ejfhp/ddb/trh"
+	"github.com/libsv/go-bt/bscript"
+)
+
+func TestCollect_DryRun_NoNodes(t *testing.T) {
+	key := "L5T6uSMcr9nkdSiPWpUDRfCKS8X6hSi16k4aqeJPMadVJJkYGf8h"
+	keystore, err := keys.NewKeystore(key, "testpassword")
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	th := trh.NewWithoutKeystore()
+	err = th.SetKeystore(keystore)
+	if err != nil {
+		t.Logf("failed to set keystore: %v", err)
+		t.FailNow()
+	}
+	txs, err := th.Collect(true)
+	if err != nil {
+		t.Logf("collect failed: %v", err)
+		t.FailNow()
+	}
+	if len(txs) != 0 {
+		t.Logf("unexpected number of collecting TXs: %d", len(txs))
+		t.FailNow()
+	}
+}
+
+//collectTRH returns an offline TRH whose keystore has a node for each of the given UTXO sets, UTXOs are served by the fake explorer.
+func collectTRH(t *testing.T, values ...[]satoshi.Satoshi) (*trh.TRH, *keys.Keystore) {
+	keystore, err := keys.NewKeystore("L5T6uSMcr9nkdSiPWpUDRfCKS8X6hSi16k4aqeJPMadVJJkYGf8h", "testpassword")
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	explorer := newFakeExplorer()
+	for i, nodeValues := range values {
+		name := string(rune('a' + i))
+		node, err := keystore.NewNode(name, sha256.Sum256([]byte(name)))
+		if err != nil {
+			t.Logf("failed to generate node: %v", err)
+			t.FailNow()
+		}
+		for _, v := range nodeValues {
+			err = explorer.addUTXO(node.Address(), v)
+			if err != nil {
+				t.Logf("failed to add UTXO: %v", err)
+				t.FailNow()
+			}
+		}
+	}
+	th := trh.NewWithoutKeystore()
+	th.SetOffline(true, miner.DefaultFees())
+	th.SetExplorer(explorer)
+	err = th.SetKeystore(keystore)
+	if err != nil {
+		t.Logf("failed to set keystore: %v", err)
+		t.FailNow()
+	}
+	return th, keystore
+}
+
+func TestCollect_DryRun_SkipDust(t *testing.T) {
+	th, keystore := collectTRH(t, []satoshi.Satoshi{10000, 10}, []satoshi.Satoshi{20})
+	txs, err := th.Collect(true)
+	if err != nil {
+		t.Logf("collect failed: %v", err)
+		t.FailNow()
+	}
+	if len(txs) != 1 || len(txs[0].Inputs) != 1 {
+		t.Logf("dust UTXOs should be skipped: %d TXs", len(txs))
+		t.FailNow()
+	}
+	if !txs[0].IsSigned() || len(txs[0].Outputs) != 1 {
+		t.Logf("unexpected collecting TX")
+		t.FailNow()
+	}
+	_, _, fee, err := txs[0].TotInOutFee()
+	if err != nil || fee <= 0 || txs[0].Outputs[0].Satoshis != uint64(10000-fee) {
+		t.Logf("unexpected collected value: %d fee: %d", txs[0].Outputs[0].Satoshis, fee)
+		t.FailNow()
+	}
+	source, err := bscript.NewP2PKHFromAddress(keystore.Source().Address())
+	if err != nil || txs[0].Outputs[0].LockingScript.ToString() != source.ToString() {
+		t.Logf("collected value should go to the source address")
+		t.FailNow()
+	}
+}
+
+func TestCollect_DryRun_Batches(t *testing.T) {
+	defer func(max int) { trh.MaxCollectInputs = max }(trh.MaxCollectInputs)
+	trh.MaxCollectInputs = 2
+	th, _ := collectTRH(t, []satoshi.Satoshi{10000, 10000, 10000}, []satoshi.Satoshi{10000, 10000})
+	txs, err := th.Collect(true)
+	if err != nil {
+		t.Logf("collect failed: %v", err)
+		t.FailNow()
+	}
+	if len(txs) != 3 {
+		t.Logf("5 inputs should be split in 3 TXs: %d", len(txs))
+		t.FailNow()
+	}
+	for i, expected := range []int{2, 2, 1} {
+		if len(txs[i].Inputs) != expected || !txs[i].IsSigned() {
+			t.Logf("unexpected inputs of TX %d: %d", i, len(txs[i].Inputs))
+			t.FailNow()
+		}
+	}
+}
+
+func TestCollect_DryRun_Uneconomic(t *testing.T) {
+	//80 satoshi are more than the fee of an input but less than the fee of the whole TX
+	th, _ := collectTRH(t, []satoshi.Satoshi{80})
+	txs, err := th.Collect(true)
+	if err != nil {
+		t.Logf("collect failed: %v", err)
+		t.FailNow()
+	}
+	if len(txs) != 0 {
+		t.Logf("collecting TX not worth its fee should be skipped: %d TXs", len(txs))
+		t.FailNow()
+	}
+}
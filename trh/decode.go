@@ -0,0 +1,48 @@
+package trh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/ejfhp/ddb"
+)
+
+//Decode extracts from the raw TX or block files the MetaEntries and Entries of the keystore, without explorer.
+//Entries are saved in outFolder.
+func (t *TRH) Decode(files []string, outFolder string) (*ddb.Decoded, error) {
+	passwords := [][32]byte{}
+	for _, n := range t.keystore.Nodes() {
+		passwords = append(passwords, n.Password())
+	}
+	return decodeFiles(files, outFolder, t.keystore.Source().Password(), passwords...)
+}
+
+//DecodeWithPassword extracts from the raw TX or block files the MetaEntries encrypted with password and their Entries.
+//Entries are saved in outFolder.
+func (t *TRH) DecodeWithPassword(password string, files []string, outFolder string) (*ddb.Decoded, error) {
+	return decodeFiles(files, outFolder, password)
+}
+
+func decodeFiles(files []string, outFolder string, password string, nodePasswords ...[32]byte) (*ddb.Decoded, error) {
+	txs := []*ddb.DataTX{}
+	for _, f := range files {
+		ftxs, err := ddb.ReadRawTXs(f)
+		if err != nil {
+			return nil, err
+		}
+		txs = append(txs, ftxs...)
+	}
+	decoded, err := ddb.DecodeTXs(txs, password, nodePasswords...)
+	if err != nil {
+		return nil, fmt.Errorf("error while decoding TXs: %w", err)
+	}
+	for _, e := range decoded.Entries {
+		//Names come from the TXs, never write outside outFolder
+		err = ioutil.WriteFile(filepath.Join(outFolder, filepath.Base(e.Name)), e.Data, 0444)
+		if err != nil {
+			return nil, fmt.Errorf("error while saving entry: %w", err)
+		}
+	}
+	return decoded, nil
+}
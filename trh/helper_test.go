@@ -0,0 +1,59 @@
+package trh_test
+
+import (
+	"fmt"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/satoshi"
+	"github.com/libsv/go-bt/bscript"
+)
+
+//fakeExplorer serves UTXOs and TXs from memory.
+type fakeExplorer struct {
+	utxos   map[string][]*ddb.UTXO
+	txs     map[string]string
+	history map[string][]string
+	count   int
+}
+
+func newFakeExplorer() *fakeExplorer {
+	return &fakeExplorer{utxos: map[string][]*ddb.UTXO{}, txs: map[string]string{}, history: map[string][]string{}}
+}
+
+//addTX adds the TX to the history of address.
+func (e *fakeExplorer) addTX(address string, tx *ddb.DataTX) {
+	e.txs[tx.GetTxID()] = tx.ToString()
+	e.history[address] = append(e.history[address], tx.GetTxID())
+}
+
+//addUTXO adds to address an UTXO of the given value locked to its P2PKH script.
+func (e *fakeExplorer) addUTXO(address string, value satoshi.Satoshi) error {
+	script, err := bscript.NewP2PKHFromAddress(address)
+	if err != nil {
+		return err
+	}
+	e.count++
+	txid := fmt.Sprintf("%064x", e.count)
+	e.utxos[address] = append(e.utxos[address], &ddb.UTXO{TXHash: txid, TXPos: 0, Value: value.Bitcoin(), ScriptPubKeyHex: script.ToString()})
+	return nil
+}
+
+func (e *fakeExplorer) GetUTXOs(address string) ([]*ddb.UTXO, error) {
+	return e.utxos[address], nil
+}
+
+func (e *fakeExplorer) GetTX(txHash string) (*ddb.TX, error) {
+	return nil, fmt.Errorf("tx not found: %s", txHash)
+}
+
+func (e *fakeExplorer) GetRAWTXHEX(txHash string) ([]byte, error) {
+	hex, ok := e.txs[txHash]
+	if !ok {
+		return nil, fmt.Errorf("tx not found: %s", txHash)
+	}
+	return []byte(hex), nil
+}
+
+func (e *fakeExplorer) GetTXIDs(address string) ([]string, error) {
+	return append([]string{}, e.history[address]...), nil
+}
@@ -8,8 +8,18 @@ import (
 	"github.com/ejfhp/ddb/keys"
 )
 
+//KeystoreGenFromKey saves the keystore of the key, if it is an extended private key the nodes are its HD children.
 func (t *TRH) KeystoreGenFromKey(pin string, bitcoinKey string, password string, pathname string) (*keys.Keystore, error) {
-	keystore, err := keys.NewKeystore(bitcoinKey, password)
+	err := keys.CheckPasswordStrength(password)
+	if err != nil {
+		return nil, err
+	}
+	var keystore *keys.Keystore
+	if keys.IsExtendedKey(bitcoinKey) {
+		keystore, err = keys.NewKeystoreHD(bitcoinKey, password)
+	} else {
+		keystore, err = keys.NewKeystore(bitcoinKey, password)
+	}
 	if err != nil {
 		return nil, fmt.Errorf("provided key %s has issues: %w", bitcoinKey, err)
 	}
@@ -39,6 +49,135 @@ func (t *TRH) KeystoreGenFromPhrase(pin string, phrase string, keygenID int, pat
 	return keystore, nil
 }
 
+//KeystoreGenFromMnemonic saves the HD keystore of the BIP39 mnemonic and passphrase, the passphrase may be empty.
+func (t *TRH) KeystoreGenFromMnemonic(pin string, mnemonic string, passphrase string, pathname string) (*keys.Keystore, error) {
+	keystore, err := keys.FromMnemonic(mnemonic, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("error while generating keystore from mnemonic: %w", err)
+	}
+	err = keystore.Save(pathname, pin)
+	if err != nil {
+		return nil, fmt.Errorf("error while saving keystore to local file %s: %w", pathname, err)
+	}
+	showKeystore(keystore)
+	return keystore, nil
+}
+
+//KeystoreScan rebuilds the keystore of the passphrase, with the nodes of all the files stored on the BTrunk address, and saves it.
+//If the keystore file exists it must have the same source, the missing nodes are added to it.
+func (t *TRH) KeystoreScan(pin string, phrase string, keygenID int, pathname string) ([]*keys.Node, error) {
+	wif, password, err := keys.FromPassphrase(phrase, keygenID)
+	if err != nil {
+		return nil, fmt.Errorf("error while generating key using passphrase: %w", err)
+	}
+	keystore, err := keys.NewKeystore(wif, password)
+	if err != nil {
+		return nil, fmt.Errorf("error while generating keystore from passphrase '%s' with keygen '%d': %w", phrase, keygenID, err)
+	}
+	keystore.SetPhrase(phrase, keygenID)
+	return t.scanKeystore(pin, keystore, pathname)
+}
+
+//KeystoreScanKey rebuilds the keystore of the key and password like KeystoreScan, if the key is an extended private key the HD nodes are restored.
+func (t *TRH) KeystoreScanKey(pin string, bitcoinKey string, password string, pathname string) ([]*keys.Node, error) {
+	var keystore *keys.Keystore
+	var err error
+	if keys.IsExtendedKey(bitcoinKey) {
+		keystore, err = keys.NewKeystoreHD(bitcoinKey, password)
+	} else {
+		keystore, err = keys.NewKeystore(bitcoinKey, password)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("provided key %s has issues: %w", bitcoinKey, err)
+	}
+	return t.scanKeystore(pin, keystore, pathname)
+}
+
+//KeystoreScanMnemonic rebuilds the HD keystore of the BIP39 mnemonic and passphrase like KeystoreScan, the passphrase may be empty.
+func (t *TRH) KeystoreScanMnemonic(pin string, mnemonic string, passphrase string, pathname string) ([]*keys.Node, error) {
+	keystore, err := keys.FromMnemonic(mnemonic, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("error while generating keystore from mnemonic: %w", err)
+	}
+	return t.scanKeystore(pin, keystore, pathname)
+}
+
+//scanKeystore adds to the keystore the nodes of all the files stored on its BTrunk address and saves it.
+//If the keystore file exists it must have the same source, the missing nodes are added to it.
+func (t *TRH) scanKeystore(pin string, keystore *keys.Keystore, pathname string) ([]*keys.Node, error) {
+	_, err := os.Stat(pathname)
+	exists := err == nil
+	if exists {
+		existing, err := keys.LoadKeystore(pathname, pin)
+		if err != nil {
+			return nil, fmt.Errorf("keystore %s exists and cannot be loaded, move it away to rebuild it: %w", pathname, err)
+		}
+		if existing.Source().Key() != keystore.Source().Key() {
+			return nil, fmt.Errorf("keystore %s exists with another source key, move it away to rebuild it", pathname)
+		}
+		keystore = existing
+	}
+	err = t.SetKeystore(keystore)
+	if err != nil {
+		return nil, err
+	}
+	restored, err := t.btrunk.RestoreNodes(keystore, false)
+	if err != nil {
+		return nil, fmt.Errorf("error while scanning BTrunk address: %w", err)
+	}
+	if exists {
+		//Save refuses to overwrite, the loaded keystore knows its file and PIN
+		err = keystore.Update()
+	} else {
+		err = keystore.Save(pathname, pin)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while saving keystore to local file %s: %w", pathname, err)
+	}
+	return restored, nil
+}
+
+//KeystoreChangePIN encrypts the keystore with newPIN, the encrypted cache is encrypted again with the key of newPIN.
+func (t *TRH) KeystoreChangePIN(pin string, newPIN string, pathname string) error {
+	keystore, err := keys.LoadKeystore(pathname, pin)
+	if err != nil {
+		return fmt.Errorf("error while loading keystore: %w", err)
+	}
+	t.cacheKeys = keystore
+	cache, err := t.userCache()
+	if err != nil {
+		return err
+	}
+	if !cache.Encrypted() {
+		return keystore.ChangePIN(newPIN)
+	}
+	salt, err := cache.CacheSalt()
+	if err != nil {
+		return fmt.Errorf("cannot get cache salt: %w", err)
+	}
+	oldKey, err := keys.DeriveKey(pin, salt)
+	if err != nil {
+		return fmt.Errorf("cannot derive cache key: %w", err)
+	}
+	newKey, err := keys.DeriveKey(newPIN, salt)
+	if err != nil {
+		return fmt.Errorf("cannot derive cache key: %w", err)
+	}
+	err = cache.ChangeEncryptionKey(newKey)
+	if err != nil {
+		return fmt.Errorf("cannot encrypt cache with the new PIN: %w", err)
+	}
+	err = keystore.ChangePIN(newPIN)
+	if err != nil {
+		//The cache must stay readable with the PIN of the keystore
+		if rerr := cache.ChangeEncryptionKey(oldKey); rerr != nil {
+			return fmt.Errorf("PIN not changed and cache left encrypted with the new PIN (%v): %w", rerr, err)
+		}
+		return fmt.Errorf("error while changing PIN: %w", err)
+	}
+	return nil
+}
+
 func (t *TRH) KeystoreShow() error {
 	showKeystore(t.keystore)
 	return nil
@@ -88,8 +227,18 @@ func showKeystore(keystore *keys.Keystore) {
 		fmt.Printf("   Source Phrase: %s\n", source.Phrase())
 		fmt.Printf("   Source KeygenID: %d\n", source.KeygenID())
 	}
+	if source.XKey() != "" {
+		fmt.Printf("   Source Extended Key: %s\n", source.XKey())
+		if xpub, err := keystore.XPub(); err == nil {
+			fmt.Printf("   Nodes XPub: %s\n", xpub)
+		}
+	}
 	fmt.Printf("   Nodes:\n")
 	for _, n := range keystore.Nodes() {
-		fmt.Printf(" - Key: %s Address: %s  Name: %s (%d)  Hash:  %s\n", n.Key(), n.Address(), n.Name(), len(n.Password()), n.ID())
+		index := ""
+		if i := n.Index(); i != nil {
+			index = fmt.Sprintf("  Index: %d", *i)
+		}
+		fmt.Printf(" - Key: %s Address: %s  Name: %s (%d)  Hash:  %s%s\n", n.Key(), n.Address(), n.Name(), len(n.Password()), n.ID(), index)
 	}
 }
@@ -1,20 +1,30 @@
 package trh_test
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
 
+	"github.com/ejfhp/ddb/keys"
+	"github.com/ejfhp/ddb/miner"
 	"github.com/ejfhp/ddb/trh"
 )
 
 func TestKeystore_KeystoreGenFromKey(t *testing.T) {
 	address := "1H2KZJA9TjspsL7uPBUPdPzueeLbtvXs8R"
 	key := "L5T6uSMcr9nkdSiPWpUDRfCKS8X6hSi16k4aqeJPMadVJJkYGf8h"
-	password := "testpassword"
+	password := "test password 2021"
 	pin := "0000"
 	pathname := filepath.Join(os.TempDir(), "keystore.trh")
+	os.Remove(pathname)
+	defer os.Remove(pathname)
 	th := &trh.TRH{}
+	_, err := th.KeystoreGenFromKey(pin, key, "testpass", pathname)
+	if !errors.Is(err, keys.ErrWeakPassword) {
+		t.Logf("weak password should be refused: %v", err)
+		t.FailNow()
+	}
 	keystore, err := th.KeystoreGenFromKey(pin, key, password, pathname)
 	if err != nil {
 		t.Logf("keystore form key failed: %v", err)
@@ -37,3 +47,63 @@ func TestKeystore_KeystoreGenFromKey(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+func TestKeystore_KeystoreScanKey_Existing(t *testing.T) {
+	key := "L4ZaBkP1UTyxdEM7wysuPd1scHMLLf8sf8B2tcEcssUZ7ujrYWcQ"
+	password := "test password 2021"
+	pin := "0000"
+	pathname := filepath.Join(os.TempDir(), "keystore_scan.trh")
+	os.Remove(pathname)
+	defer os.Remove(pathname)
+	keystore, err := keys.NewKeystore(key, password)
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	err = keystore.Save(pathname, pin)
+	if err != nil {
+		t.Logf("failed to save keystore: %v", err)
+		t.FailNow()
+	}
+	sim := trh.NewWithoutKeystore()
+	sim.SetOffline(true, miner.DefaultFees())
+	sim.SetExplorer(newFakeExplorer())
+	simKeystore, err := keys.NewKeystore(key, password)
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	err = sim.SetKeystore(simKeystore)
+	if err != nil {
+		t.Logf("failed to set keystore: %v", err)
+		t.FailNow()
+	}
+	txs, _, err := sim.Simulate("image.png", "../testdata/image.png", []string{"label1"}, "notes", "123456789", 10000000)
+	if err != nil {
+		t.Logf("failed to simulate store: %v", err)
+		t.FailNow()
+	}
+	explorer := newFakeExplorer()
+	explorer.addTX(keystore.Source().Address(), txs[0])
+	th := trh.NewWithoutKeystore()
+	th.SetOffline(true, miner.DefaultFees())
+	th.SetExplorer(explorer)
+	restored, err := th.KeystoreScanKey(pin, key, password, pathname)
+	if err != nil {
+		t.Logf("failed to scan into existing keystore: %v", err)
+		t.FailNow()
+	}
+	if len(restored) != 1 {
+		t.Logf("unexpected number of restored nodes: %d", len(restored))
+		t.FailNow()
+	}
+	saved, err := keys.LoadKeystore(pathname, pin)
+	if err != nil {
+		t.Logf("failed to load scanned keystore: %v", err)
+		t.FailNow()
+	}
+	if len(saved.Nodes()) != 1 || saved.Nodes()[0].ID() != restored[0].ID() {
+		t.Logf("restored nodes not persisted: %d", len(saved.Nodes()))
+		t.FailNow()
+	}
+}
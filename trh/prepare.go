@@ -0,0 +1,106 @@
+package trh
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"time"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/satoshi"
+)
+
+//Prepared is a set of TXs saved to file to be reviewed and submitted later.
+type Prepared struct {
+	Name      string            `json:"name,omitempty"`
+	EntryHash string            `json:"entryhash,omitempty"`
+	Layout    string            `json:"layout,omitempty"`
+	Fee       uint64            `json:"fee"`
+	Created   int64             `json:"created"`
+	TXs       []*ddb.PortableTX `json:"txs"`
+}
+
+func newPrepared(txs []*ddb.DataTX) *Prepared {
+	prepared := Prepared{Created: time.Now().Unix(), TXs: make([]*ddb.PortableTX, len(txs))}
+	for i, tx := range txs {
+		prepared.TXs[i] = tx.ToPortable()
+		_, _, fee, err := tx.TotInOutFee()
+		if err == nil {
+			prepared.Fee += uint64(fee)
+		}
+	}
+	return &prepared
+}
+
+//DataTXs returns the prepared TXs.
+func (p *Prepared) DataTXs() ([]*ddb.DataTX, error) {
+	txs := make([]*ddb.DataTX, len(p.TXs))
+	for i, ptx := range p.TXs {
+		tx, err := ptx.DataTX()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode TX %d: %w", i, err)
+		}
+		txs[i] = tx
+	}
+	return txs, nil
+}
+
+//ReadPrepared reads a Prepared from file.
+func ReadPrepared(file string) (*Prepared, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TXs file %s: %w", file, err)
+	}
+	prepared := Prepared{}
+	err = json.Unmarshal(data, &prepared)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode TXs file %s: %w", file, err)
+	}
+	return &prepared, nil
+}
+
+func writePrepared(prepared *Prepared, file string) error {
+	data, err := json.MarshalIndent(prepared, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode TXs: %w", err)
+	}
+	err = ioutil.WriteFile(file, data, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write TXs file %s: %w", file, err)
+	}
+	return nil
+}
+
+//Prepare builds and signs all the TXs storing the file, like Store, and writes them to outFile instead of submitting them.
+//If unsigned is true the TXs are not signed, they can be signed offline by SignFile.
+//The keystore is updated with the new node so the file can be retrieved once the TXs are submitted with SubmitFile.
+func (t *TRH) Prepare(name string, pathfile string, labels []string, notes string, txheader string, maxSpend uint64, outFile string, unsigned bool) (*Prepared, error) {
+	ent, err := ddb.NewEntryFromFile(filepath.Base(pathfile), pathfile, labels, notes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate entry from file: %w", err)
+	}
+	node, err := t.keystore.NewNode(name, ent.HashOfEntry())
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate new node: %w", err)
+	}
+	t.btrunk.SetUnsigned(unsigned)
+	defer t.btrunk.SetUnsigned(false)
+	txs, err := t.btrunk.TXOfBranchedEntry(node, ent, txheader, satoshi.Satoshi(maxSpend), false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate txs for entry: %w", err)
+	}
+	err = t.keystore.Update()
+	if err != nil {
+		return nil, fmt.Errorf("failed to update keystore: %w", err)
+	}
+	prepared := newPrepared(txs)
+	prepared.Name = ent.Name
+	prepared.EntryHash = node.ID()
+	prepared.Layout = t.btrunk.Layout()
+	err = writePrepared(prepared, outFile)
+	if err != nil {
+		return nil, err
+	}
+	return prepared, nil
+}
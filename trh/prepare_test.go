@@ -0,0 +1,134 @@
+package trh_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/keys"
+	"github.com/ejfhp/ddb/miner"
+	"github.com/ejfhp/ddb/satoshi"
+	"github.com/ejfhp/ddb/trh"
+)
+
+func TestPrepare_ExportImport(t *testing.T) {
+	key := "L4ZaBkP1UTyxdEM7wysuPd1scHMLLf8sf8B2tcEcssUZ7ujrYWcQ"
+	address := "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	utxos := []*ddb.UTXO{{TXHash: "e6706b900df5a46253b8788f691cbe1506c1e9b76766f1f9d6b3602e1458f055", TXPos: 0, Value: satoshi.Satoshi(10000).Bitcoin(), ScriptPubKeyHex: "76a914f44a7664767a5b76633739ef47ec6dfa408e37a088ac"}}
+	tx, err := ddb.NewDataTX(key, address, address, utxos, satoshi.Satoshi(1000), satoshi.Satoshi(200), []byte("data"), "test01234")
+	if err != nil {
+		t.Logf("failed to build TX: %v", err)
+		t.FailNow()
+	}
+	file := filepath.Join(os.TempDir(), "prepared_trh.json")
+	defer os.Remove(file)
+	th := trh.NewWithoutKeystore()
+	err = th.ExportTXs([]*ddb.DataTX{tx}, file)
+	if err != nil {
+		t.Logf("failed to export TXs: %v", err)
+		t.FailNow()
+	}
+	prepared, err := trh.ReadPrepared(file)
+	if err != nil {
+		t.Logf("failed to read prepared: %v", err)
+		t.FailNow()
+	}
+	if prepared.Fee != 200 {
+		t.Logf("unexpected fee: %d", prepared.Fee)
+		t.FailNow()
+	}
+	txs, err := prepared.DataTXs()
+	if err != nil {
+		t.Logf("failed to decode TXs: %v", err)
+		t.FailNow()
+	}
+	if len(txs) != 1 || txs[0].GetTxID() != tx.GetTxID() || !txs[0].IsSigned() {
+		t.Logf("unexpected imported TXs")
+		t.FailNow()
+	}
+}
+
+func TestPrepare_PrepareSignFile(t *testing.T) {
+	// trail.SetWriter(os.Stdout)
+	key := "L5T6uSMcr9nkdSiPWpUDRfCKS8X6hSi16k4aqeJPMadVJJkYGf8h"
+	keystore, err := keys.NewKeystore(key, "testpassword")
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	ksfile := filepath.Join(os.TempDir(), "prepare_keystore.trh")
+	defer os.Remove(ksfile)
+	err = keystore.Save(ksfile, "0000")
+	if err != nil {
+		t.Logf("failed to save keystore: %v", err)
+		t.FailNow()
+	}
+	datafile := filepath.Join(os.TempDir(), "prepare_data.txt")
+	defer os.Remove(datafile)
+	err = ioutil.WriteFile(datafile, []byte(strings.Repeat("TRH - The Rabbit Hole, prepared offline. ", 200)), 0600)
+	if err != nil {
+		t.Logf("failed to write data file: %v", err)
+		t.FailNow()
+	}
+	explorer := newFakeExplorer()
+	err = explorer.addUTXO(keystore.Source().Address(), satoshi.Satoshi(100000))
+	if err != nil {
+		t.Logf("failed to add UTXO: %v", err)
+		t.FailNow()
+	}
+	th := trh.NewWithoutKeystore()
+	th.SetOffline(true, miner.DefaultFees())
+	th.SetExplorer(explorer)
+	err = th.SetKeystore(keystore)
+	if err != nil {
+		t.Logf("failed to set keystore: %v", err)
+		t.FailNow()
+	}
+	unsignedFile := filepath.Join(os.TempDir(), "prepared_unsigned_trh.json")
+	defer os.Remove(unsignedFile)
+	prepared, err := th.Prepare("prepared", datafile, []string{"prepare"}, "notes", "test01234", 100000, unsignedFile, true)
+	if err != nil {
+		t.Logf("failed to prepare: %v", err)
+		t.FailNow()
+	}
+	if prepared.EntryHash == "" || prepared.Layout != ddb.LayoutChain || len(prepared.TXs) < 2 {
+		t.Logf("unexpected prepared: %s %s %d", prepared.EntryHash, prepared.Layout, len(prepared.TXs))
+		t.FailNow()
+	}
+	for i, ptx := range prepared.TXs {
+		if ptx.Signed {
+			t.Logf("TX %d should not be signed", i)
+			t.FailNow()
+		}
+	}
+	signedFile := filepath.Join(os.TempDir(), "prepared_signed_trh.json")
+	defer os.Remove(signedFile)
+	txs, err := th.SignFile(unsignedFile, signedFile)
+	if err != nil {
+		t.Logf("failed to sign file: %v", err)
+		t.FailNow()
+	}
+	signed, err := trh.ReadPrepared(signedFile)
+	if err != nil {
+		t.Logf("failed to read signed file: %v", err)
+		t.FailNow()
+	}
+	if signed.EntryHash != prepared.EntryHash || len(signed.TXs) != len(txs) {
+		t.Logf("signed file doesn't match the prepared one")
+		t.FailNow()
+	}
+	for i, ptx := range signed.TXs {
+		if !ptx.Signed {
+			t.Logf("TX %d should be signed", i)
+			t.FailNow()
+		}
+	}
+	utxo := explorer.utxos[keystore.Source().Address()][0]
+	if txs[0].Inputs[0].PreviousTxID != utxo.TXHash {
+		t.Logf("first TX doesn't spend the source UTXO: %s", txs[0].Inputs[0].PreviousTxID)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,103 @@
+package trh
+
+import (
+	"fmt"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/miner"
+)
+
+//Signer returns a signer holding the keys of the source and of all the nodes of the keystore.
+func (t *TRH) Signer() (ddb.Signer, error) {
+	wifs := []string{t.keystore.Source().Key()}
+	for _, n := range t.keystore.Nodes() {
+		wifs = append(wifs, n.Key())
+	}
+	signer, err := ddb.NewKeySigner(wifs...)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create signer: %w", err)
+	}
+	return signer, nil
+}
+
+//ExportTXs writes the given TXs, signed or not, to file.
+func (t *TRH) ExportTXs(txs []*ddb.DataTX, file string) error {
+	return writePrepared(newPrepared(txs), file)
+}
+
+//ImportTXs reads the TXs written by ExportTXs or Prepare.
+func (t *TRH) ImportTXs(file string) ([]*ddb.DataTX, error) {
+	prepared, err := ReadPrepared(file)
+	if err != nil {
+		return nil, err
+	}
+	return prepared.DataTXs()
+}
+
+//SignFile signs the unsigned TXs of inFile with the keys of the keystore and writes them to outFile, name, entry and layout are kept.
+//It doesn't need a connection, so it can run on an offline machine.
+func (t *TRH) SignFile(inFile string, outFile string) ([]*ddb.DataTX, error) {
+	prepared, err := ReadPrepared(inFile)
+	if err != nil {
+		return nil, err
+	}
+	txs, err := prepared.DataTXs()
+	if err != nil {
+		return nil, err
+	}
+	signer, err := t.Signer()
+	if err != nil {
+		return nil, err
+	}
+	err = ddb.SignTXs(signer, txs)
+	if err != nil {
+		return nil, err
+	}
+	prepared.TXs = newPrepared(txs).TXs
+	err = writePrepared(prepared, outFile)
+	if err != nil {
+		return nil, err
+	}
+	return txs, nil
+}
+
+//SubmitFile submits the signed TXs of the file, written by Prepare or SignFile, after checking their inputs are still unspent.
+//It doesn't need the keystore.
+func (t *TRH) SubmitFile(file string) ([]*miner.SubmitResult, error) {
+	if t.blockchain == nil {
+		err := t.connect()
+		if err != nil {
+			return nil, err
+		}
+	}
+	prepared, err := ReadPrepared(file)
+	if err != nil {
+		return nil, err
+	}
+	txs, err := prepared.DataTXs()
+	if err != nil {
+		return nil, err
+	}
+	for i, tx := range txs {
+		if !tx.IsSigned() {
+			return nil, fmt.Errorf("TX %d is not signed", i)
+		}
+	}
+	err = t.blockchain.CheckUnspent(txs)
+	if err != nil {
+		return nil, fmt.Errorf("transactions cannot be submitted anymore: %w", err)
+	}
+	var txres []*miner.SubmitResult
+	if prepared.Layout == ddb.LayoutChain {
+		txres, err = t.chainSubmitter().Submit(txs)
+	} else {
+		txres, err = t.blockchain.Submit(txs)
+	}
+	if err != nil {
+		return txres, fmt.Errorf("failed to submit txs: %w", err)
+	}
+	if prepared.EntryHash != "" {
+		t.indexEntry(prepared.EntryHash, txs)
+	}
+	return txres, nil
+}
@@ -30,3 +30,16 @@ func (t *TRH) Simulate(name string, pathfile string, labels []string, notes stri
 	}
 	return txs, uint64(totFee), nil
 }
+
+//Estimate returns the breakdown of the cost of storing the file, using the fake UTXO.
+func (t *TRH) Estimate(name string, pathfile string, labels []string, notes string, txheader string) (*ddb.CostEstimate, error) {
+	txs, _, err := t.Simulate(name, pathfile, labels, notes, txheader, 10000000)
+	if err != nil {
+		return nil, err
+	}
+	estimate, err := t.blockchain.EstimateCost(txs, t.btrunk.Layout())
+	if err != nil {
+		return nil, fmt.Errorf("failed to estimate cost: %w", err)
+	}
+	return estimate, nil
+}
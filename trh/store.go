@@ -5,10 +5,16 @@ import (
 	"path/filepath"
 
 	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/keys"
+	"github.com/ejfhp/ddb/miner"
 	"github.com/ejfhp/ddb/satoshi"
+	"github.com/ejfhp/trail"
+	"github.com/ejfhp/trail/trace"
 )
 
-func (t *TRH) Store(name string, pathfile string, labels []string, notes string, txheader string, maxSpend uint64) ([]string, error) {
+//Store stores the file in the blockchain and returns the result of the submission of every TX.
+//If the miner refuses some TXs the results are returned together with the error.
+func (t *TRH) Store(name string, pathfile string, labels []string, notes string, txheader string, maxSpend uint64) ([]*miner.SubmitResult, error) {
 	ent, err := ddb.NewEntryFromFile(filepath.Base(pathfile), pathfile, labels, notes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate entry from file: %w", err)
@@ -33,15 +39,83 @@ func (t *TRH) Store(name string, pathfile string, labels []string, notes string,
 		}
 		totFee = totFee.Add(fee)
 	}
-	txres, err := t.blockchain.Submit(txs)
+	var txres []*miner.SubmitResult
+	if t.btrunk.Layout() == ddb.LayoutFanOut {
+		//Part TXs have at most two unconfirmed ancestors
+		txres, err = t.blockchain.Submit(txs)
+	} else {
+		txres, err = t.chainSubmitter().Submit(txs)
+	}
+	if err != nil {
+		return txres, fmt.Errorf("failed to submit txs: %w", err)
+	}
+	t.indexEntry(node.ID(), txs)
+	return txres, nil
+}
+
+//StoreBatch stores all the given small files in a single transaction, every file must fit in one OP_RETURN.
+func (t *TRH) StoreBatch(pathfiles []string, labels []string, notes string, txheader string, maxSpend uint64) (string, error) {
+	nodes := make([]*keys.Node, 0, len(pathfiles))
+	entries := make([]*ddb.Entry, 0, len(pathfiles))
+	for _, pathfile := range pathfiles {
+		ent, err := ddb.NewEntryFromFile(filepath.Base(pathfile), pathfile, labels, notes)
+		if err != nil {
+			return "", fmt.Errorf("failed to generate entry from file %s: %w", pathfile, err)
+		}
+		node, err := t.keystore.NewNode(ent.Name, ent.HashOfEntry())
+		if err != nil {
+			return "", fmt.Errorf("failed to generate new node: %w", err)
+		}
+		nodes = append(nodes, node)
+		entries = append(entries, ent)
+	}
+	tx, err := t.btrunk.TXOfBatchedEntries(nodes, entries, txheader, satoshi.Satoshi(maxSpend), false)
+	if err != nil {
+		return "", fmt.Errorf("failed to build batch tx: %w", err)
+	}
+	err = t.keystore.Update()
+	if err != nil {
+		return "", fmt.Errorf("failed to update keystore: %w", err)
+	}
+	txres, err := t.blockchain.Submit([]*ddb.DataTX{tx})
 	if err != nil {
-		return nil, fmt.Errorf("failed to submit txs: %w", err)
+		return "", fmt.Errorf("failed to submit batch tx: %w", err)
+	}
+	for _, node := range nodes {
+		t.indexEntry(node.ID(), []*ddb.DataTX{tx})
 	}
-	ids := make([]string, len(txres))
-	for i, tx := range txres {
-		ids[i] = tx[0]
+	return txres[0].TXID, nil
+}
+
+//indexEntry records in the cache the TXs storing the entry, so that it can be retrieved without scanning the address history.
+func (t *TRH) indexEntry(entryHash string, txs []*ddb.DataTX) {
+	tr := trace.New().Source("store.go", "TRH", "indexEntry")
+	if t.cache == nil {
+		return
+	}
+	txids := make([]string, len(txs))
+	for i, tx := range txs {
+		txids[i] = tx.GetTxID()
+	}
+	err := t.cache.IndexEntry(entryHash, txids)
+	if err != nil {
+		trail.Println(trace.Warning("cannot index entry in cache").UTC().Add("hash", entryHash).Error(err).Append(tr))
 	}
-	return ids, nil
 }
 
-//TODO add store with TX from simulate as input
+//ResumeStores continues the submission of the chains interrupted before being completely submitted.
+func (t *TRH) ResumeStores() (map[string][]*miner.SubmitResult, error) {
+	pending, err := t.cache.ListChainProgress()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending chains: %w", err)
+	}
+	resumed := make(map[string][]*miner.SubmitResult)
+	for _, id := range pending {
+		txres, err := t.chainSubmitter().Resume(id)
+		resumed[id] = txres
+		if err != nil {
+			return resumed, fmt.Errorf("failed to resume chain %s: %w", id, err)
+		}
+	}
+	return resumed, nil
+}
@@ -2,6 +2,7 @@ package trh
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/ejfhp/ddb"
 	"github.com/ejfhp/ddb/keys"
@@ -9,12 +10,25 @@ import (
 )
 
 type TRH struct {
-	miner      miner.Miner
-	explorer   ddb.Explorer
-	cache      *ddb.TXCache
-	blockchain *ddb.Blockchain
-	btrunk     *ddb.BTrunk
-	keystore   *keys.Keystore
+	miner         miner.Miner
+	explorer      ddb.Explorer
+	cache         ddb.Cache
+	blockchain    *ddb.Blockchain
+	btrunk        *ddb.BTrunk
+	keystore      *keys.Keystore
+	submitOptions miner.SubmitOptions
+	feePolicy     *miner.FeePolicy
+	ancestorLimit int
+	fanOut        bool
+	coinSelection *ddb.CoinSelection
+	offline       bool
+	feeSchedule   miner.Fees
+	cacheMaxBytes int64
+	cacheType     string
+	historyTTL    time.Duration
+	encryptCache  bool
+	encryptTXs    bool
+	cacheKeys     *keys.Keystore
 }
 
 func NewWithoutKeystore() *TRH {
@@ -22,15 +36,135 @@ func NewWithoutKeystore() *TRH {
 }
 
 func (t *TRH) SetKeystore(keystore *keys.Keystore) error {
-	t.explorer = ddb.NewWOC()
-	t.miner = miner.NewTAAL()
-	var err error
-	t.cache, err = ddb.NewUserTXCache()
+	if t.cacheKeys == nil {
+		t.cacheKeys = keystore
+	}
+	err := t.connect()
 	if err != nil {
-		return fmt.Errorf("cannot create cache")
+		return err
 	}
-	t.blockchain = ddb.NewBlockchain(t.miner, t.explorer, t.cache)
 	t.keystore = keystore
 	t.btrunk = ddb.NewBTrunk(keystore.Source().Key(), keystore.Source().Address(), keystore.Source().Password(), t.blockchain)
+	if t.fanOut {
+		t.btrunk.SetLayout(ddb.LayoutFanOut)
+	}
+	if t.coinSelection != nil {
+		t.btrunk.SetCoinSelection(t.coinSelection)
+	}
+	return nil
+}
+
+//SetKeystoreOffline sets the keystore without connecting to explorer and miner, enough to sign TXs.
+func (t *TRH) SetKeystoreOffline(keystore *keys.Keystore) {
+	t.keystore = keystore
+}
+
+//connect sets up explorer, miner, cache and blockchain.
+func (t *TRH) connect() error {
+	if t.explorer == nil {
+		t.explorer = ddb.NewWOC()
+	}
+	_, err := t.userCache()
+	if err != nil {
+		return err
+	}
+	if t.offline {
+		fees := t.feeSchedule
+		if fees == nil {
+			//Last quote received, DefaultFees if never connected
+			fees, _ = t.cache.RetrieveFees()
+		}
+		t.miner = miner.NewOffline(fees)
+	} else {
+		t.miner = miner.NewTAAL()
+	}
+	t.miner.SetSubmitOptions(t.submitOptions)
+	if t.feePolicy != nil {
+		t.miner.SetFeePolicy(*t.feePolicy)
+	}
+	t.blockchain = ddb.NewBlockchain(t.miner, t.explorer, t.cache)
+	t.blockchain.SetHistoryTTL(t.historyTTL)
+	return nil
+}
+
+//SetExplorer replaces the explorer used to read the blockchain, WhatsOnChain by default. It must be called before SetKeystore.
+func (t *TRH) SetExplorer(explorer ddb.Explorer) {
+	t.explorer = explorer
+}
+
+//SetOffline makes fee estimations use the given fee schedule, or the last cached quote if nil, instead of asking the miner.
+//Offline TRH cannot submit TXs.
+func (t *TRH) SetOffline(offline bool, fees miner.Fees) {
+	t.offline = offline
+	t.feeSchedule = fees
+}
+
+//SetSubmitOptions sets double spend check and callbacks options used when submitting transactions.
+func (t *TRH) SetSubmitOptions(options miner.SubmitOptions) {
+	t.submitOptions = options
+	if t.miner != nil {
+		t.miner.SetSubmitOptions(options)
+	}
+}
+
+//SetFeePolicy sets multiplier and floor applied by the miner to the fees calculated from its fee quote.
+func (t *TRH) SetFeePolicy(policy miner.FeePolicy) {
+	t.feePolicy = &policy
+	if t.miner != nil {
+		t.miner.SetFeePolicy(policy)
+	}
+}
+
+//Close releases the cache, pending writes like the use time of the TXs read are done now.
+func (t *TRH) Close() error {
+	if t.cache == nil {
+		return nil
+	}
+	return t.cache.Close()
+}
+
+//SetHistoryTTL sets how long the cached history of an address is used without asking the explorer.
+func (t *TRH) SetHistoryTTL(ttl time.Duration) {
+	t.historyTTL = ttl
+	if t.blockchain != nil {
+		t.blockchain.SetHistoryTTL(ttl)
+	}
+}
+
+//SetAncestorLimit sets the max number of unconfirmed chained TXs submitted at once.
+func (t *TRH) SetAncestorLimit(limit int) {
+	t.ancestorLimit = limit
+}
+
+//SetFanOut makes the parts of the stored files spend parallel UTXOs instead of a single chain.
+func (t *TRH) SetFanOut(fanOut bool) {
+	t.fanOut = fanOut
+	if t.btrunk != nil {
+		layout := ddb.LayoutChain
+		if fanOut {
+			layout = ddb.LayoutFanOut
+		}
+		t.btrunk.SetLayout(layout)
+	}
+}
+
+//SetCoinSelection sets the strategy used to choose the UTXOs of the source address and the outpoints (txid:pos) that must be spent.
+func (t *TRH) SetCoinSelection(strategy string, pinned []string) error {
+	selection, err := ddb.NewCoinSelection(strategy, pinned)
+	if err != nil {
+		return fmt.Errorf("invalid coin selection: %w", err)
+	}
+	t.coinSelection = selection
+	if t.btrunk != nil {
+		t.btrunk.SetCoinSelection(selection)
+	}
 	return nil
 }
+
+func (t *TRH) chainSubmitter() *ddb.ChainSubmitter {
+	submitter := ddb.NewChainSubmitter(t.blockchain)
+	if t.ancestorLimit > 0 {
+		submitter.AncestorLimit = t.ancestorLimit
+	}
+	return submitter
+}
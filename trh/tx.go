@@ -1,9 +1,11 @@
 package trh
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/errs"
 	"github.com/ejfhp/ddb/keys"
 	"github.com/ejfhp/ddb/miner"
 )
@@ -11,11 +13,12 @@ import (
 func (t *TRH) ListAllTX(keystore *keys.Keystore) (map[string][]string, error) {
 	woc := ddb.NewWOC()
 	taal := miner.NewTAAL()
-	cache, err := ddb.NewUserTXCache()
+	cache, err := t.userCache()
 	if err != nil {
-		return nil, fmt.Errorf("cannot open cache")
+		return nil, err
 	}
 	blockchain := ddb.NewBlockchain(taal, woc, cache)
+	blockchain.SetHistoryTTL(t.historyTTL)
 	if err != nil {
 		return nil, fmt.Errorf("error while loading keystore: %w", err)
 	}
@@ -39,11 +42,12 @@ func (t *TRH) ListUTXOs(keystore *keys.Keystore) (map[string][]*ddb.UTXO, error)
 	passwordAddress := map[string]string{}
 	woc := ddb.NewWOC()
 	taal := miner.NewTAAL()
-	cache, err := ddb.NewUserTXCache()
+	cache, err := t.userCache()
 	if err != nil {
-		return nil, fmt.Errorf("cannot open cache")
+		return nil, err
 	}
 	blockchain := ddb.NewBlockchain(taal, woc, cache)
+	blockchain.SetHistoryTTL(t.historyTTL)
 	for _, no := range keystore.Nodes() {
 		passwordAddress[no.Name()] = no.Address()
 	}
@@ -52,7 +56,7 @@ func (t *TRH) ListUTXOs(keystore *keys.Keystore) (map[string][]*ddb.UTXO, error)
 	for _, add := range passwordAddress {
 		utxos, err := blockchain.GetUTXO(add)
 		if err != nil {
-			if err.Error() != "found no UTXO" {
+			if !errors.Is(err, errs.ErrNoUTXO) {
 				return nil, fmt.Errorf("error while retrieving unspend outputs (UTXO): %w", err)
 			}
 			utxos = []*ddb.UTXO{}
@@ -0,0 +1,153 @@
+package trh
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/errs"
+	"github.com/ejfhp/ddb/satoshi"
+	"github.com/ejfhp/trail"
+	"github.com/ejfhp/trail/trace"
+)
+
+//Balance returns the amount of unspent satoshi of the source address and of every node address of the keystore.
+func (t *TRH) Balance() (map[string]satoshi.Satoshi, error) {
+	addresses := []string{t.keystore.Source().Address()}
+	for _, n := range t.keystore.Nodes() {
+		addresses = append(addresses, n.Address())
+	}
+	balance := make(map[string]satoshi.Satoshi, len(addresses))
+	for _, add := range addresses {
+		utxos, err := t.blockchain.GetUTXO(add)
+		if err != nil {
+			if !errors.Is(err, errs.ErrNoUTXO) {
+				return nil, fmt.Errorf("error while retrieving unspent outputs of %s: %w", add, err)
+			}
+			utxos = []*ddb.UTXO{}
+		}
+		tot := satoshi.Satoshi(0)
+		for _, u := range utxos {
+			tot = tot.Add(u.Value)
+		}
+		balance[add] = tot
+	}
+	return balance, nil
+}
+
+//Send pays amount from the source address to destinationAddress, change goes back to the source address.
+//Only the UTXOs needed are spent, chosen with the configured coin selection. If simulate is true the fake UTXO is used and the TX is not submitted.
+func (t *TRH) Send(destinationAddress string, amount satoshi.Satoshi, simulate bool) (*ddb.DataTX, error) {
+	dataTX, err := t.PrepareSend(destinationAddress, amount, simulate)
+	if err != nil {
+		return nil, err
+	}
+	return t.signAndSubmit(dataTX, simulate)
+}
+
+//PrepareSend builds the unsigned TX of Send.
+func (t *TRH) PrepareSend(destinationAddress string, amount satoshi.Satoshi, simulate bool) (*ddb.DataTX, error) {
+	tr := trace.New().Source("wallet.go", "TRH", "PrepareSend")
+	source := t.keystore.Source()
+	utxos, err := t.sourceUTXOs(simulate)
+	if err != nil {
+		return nil, err
+	}
+	selection := t.coinSelection
+	if selection == nil {
+		selection = &ddb.CoinSelection{Strategy: ddb.SelectLargestFirst}
+	}
+	//The fee depends on the number of inputs, selection is repeated until it covers amount and fee.
+	numUTXO := 1
+	var selected []*ddb.UTXO
+	var fee satoshi.Satoshi
+	for {
+		fee, err = t.blockchain.EstimateStandardTXFee(numUTXO)
+		if err != nil {
+			return nil, fmt.Errorf("error while estimating send tx fee: %w", err)
+		}
+		selected, err = selection.Select(utxos, amount.Add(fee))
+		if err != nil {
+			return nil, fmt.Errorf("error while selecting UTXOs: %w", err)
+		}
+		if len(selected) <= numUTXO {
+			break
+		}
+		numUTXO = len(selected)
+	}
+	dataTX, err := ddb.NewUnsignedDataTX(destinationAddress, source.Address(), selected, amount, fee, nil, "")
+	if err != nil {
+		return nil, fmt.Errorf("error while building send TX: %w", err)
+	}
+	trail.Println(trace.Info("send TX built").UTC().Add("amount", fmt.Sprintf("%d", amount)).Add("fee", fmt.Sprintf("%d", fee)).Append(tr))
+	return dataTX, nil
+}
+
+//Consolidate merges all the UTXOs of the source address into a single one, it returns nil TX if there is a single UTXO.
+//If simulate is true the fake UTXO is used and the TX is not submitted.
+func (t *TRH) Consolidate(simulate bool) (*ddb.DataTX, error) {
+	dataTX, err := t.PrepareConsolidate(simulate)
+	if err != nil || dataTX == nil {
+		return nil, err
+	}
+	return t.signAndSubmit(dataTX, simulate)
+}
+
+//PrepareConsolidate builds the unsigned TX of Consolidate, nil if there is a single UTXO and nothing to merge.
+func (t *TRH) PrepareConsolidate(simulate bool) (*ddb.DataTX, error) {
+	tr := trace.New().Source("wallet.go", "TRH", "PrepareConsolidate")
+	source := t.keystore.Source()
+	utxos, err := t.sourceUTXOs(simulate)
+	if err != nil {
+		return nil, err
+	}
+	if len(utxos) == 0 {
+		return nil, fmt.Errorf("no UTXO to consolidate")
+	}
+	if len(utxos) == 1 {
+		trail.Println(trace.Info("single UTXO, nothing to consolidate").UTC().Append(tr))
+		return nil, nil
+	}
+	fee, err := t.blockchain.EstimateStandardTXFee(len(utxos))
+	if err != nil {
+		return nil, fmt.Errorf("error while estimating consolidating tx fee: %w", err)
+	}
+	dataTX, err := ddb.NewUnsignedMultiInputTX(source.Address(), utxos, fee)
+	if err != nil {
+		return nil, fmt.Errorf("error while building consolidating TX: %w", err)
+	}
+	trail.Println(trace.Info("consolidating TX built").UTC().Add("inputs", fmt.Sprintf("%d", len(utxos))).Add("fee", fmt.Sprintf("%d", fee)).Append(tr))
+	return dataTX, nil
+}
+
+//signAndSubmit signs the TX with the keys of the keystore and submits it, unless simulate is true.
+func (t *TRH) signAndSubmit(dataTX *ddb.DataTX, simulate bool) (*ddb.DataTX, error) {
+	if simulate {
+		//The fake UTXO is not locked to the keystore addresses
+		return dataTX, nil
+	}
+	signer, err := t.Signer()
+	if err != nil {
+		return nil, err
+	}
+	err = signer.Sign(dataTX)
+	if err != nil {
+		return nil, fmt.Errorf("error signing TX: %w", err)
+	}
+	_, err = t.blockchain.Submit([]*ddb.DataTX{dataTX})
+	if err != nil {
+		return nil, fmt.Errorf("error submitting TX: %w", err)
+	}
+	return dataTX, nil
+}
+
+func (t *TRH) sourceUTXOs(simulate bool) ([]*ddb.UTXO, error) {
+	if simulate {
+		return t.blockchain.GetFakeUTXO(), nil
+	}
+	utxos, err := t.blockchain.GetUTXO(t.keystore.Source().Address())
+	if err != nil {
+		return nil, fmt.Errorf("error while retrieving source unspent outputs: %w", err)
+	}
+	return utxos, nil
+}
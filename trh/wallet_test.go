@@ -0,0 +1,47 @@
+package trh_test
+
+import (
+	"testing"
+
+	"github.com/ejfhp/ddb/keys"
+	"github.com/ejfhp/ddb/miner"
+	"github.com/ejfhp/ddb/satoshi"
+	"github.com/ejfhp/ddb/trh"
+)
+
+func TestWallet_SendConsolidate_Simulate(t *testing.T) {
+	key := "L5T6uSMcr9nkdSiPWpUDRfCKS8X6hSi16k4aqeJPMadVJJkYGf8h"
+	destination := "1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X"
+	keystore, err := keys.NewKeystore(key, "testpassword")
+	if err != nil {
+		t.Logf("failed to build keystore: %v", err)
+		t.FailNow()
+	}
+	th := trh.NewWithoutKeystore()
+	//Offline miner, the fee quote is not asked
+	th.SetOffline(true, miner.DefaultFees())
+	err = th.SetKeystore(keystore)
+	if err != nil {
+		t.Logf("failed to set keystore: %v", err)
+		t.FailNow()
+	}
+	tx, err := th.Send(destination, satoshi.Satoshi(1000), true)
+	if err != nil {
+		t.Logf("send failed: %v", err)
+		t.FailNow()
+	}
+	if len(tx.Outputs) != 2 || tx.Outputs[0].Satoshis != 1000 {
+		t.Logf("unexpected send outputs: %d", len(tx.Outputs))
+		t.FailNow()
+	}
+	if len(tx.SourceOutputs) != len(tx.Inputs) {
+		t.Logf("source outputs not filled: %d", len(tx.SourceOutputs))
+		t.FailNow()
+	}
+	//The fake UTXO is a single one, nothing to merge
+	tx, err = th.Consolidate(true)
+	if err != nil || tx != nil {
+		t.Logf("consolidate of a single UTXO should be skipped: %v", err)
+		t.FailNow()
+	}
+}
@@ -0,0 +1,67 @@
+package trh
+
+import (
+	"fmt"
+
+	"github.com/ejfhp/ddb"
+	"github.com/ejfhp/ddb/keys"
+	"github.com/ejfhp/ddb/miner"
+)
+
+//DefaultWatchGap is the number of consecutive unused node addresses after which WatchXPub stops.
+const DefaultWatchGap = 20
+
+//KeystoreXPub returns the extended public key of the nodes of the HD keystore, to give to a watch-only instance.
+func (t *TRH) KeystoreXPub(pin string, pathname string) (string, error) {
+	keystore, err := keys.LoadKeystore(pathname, pin)
+	if err != nil {
+		return "", fmt.Errorf("error while loading keystore: %w", err)
+	}
+	return keystore.XPub()
+}
+
+//WatchXPub lists the node addresses derived from xpub with their TXs, without keystore.
+//The addresses are listed in index order until gap consecutive ones have no TXs, DefaultWatchGap if gap is not positive.
+func (t *TRH) WatchXPub(xpub string, gap int) ([]string, map[string][]string, error) {
+	if gap <= 0 {
+		gap = DefaultWatchGap
+	}
+	cache, err := t.userCache()
+	if err != nil {
+		return nil, nil, err
+	}
+	blockchain := ddb.NewBlockchain(miner.NewTAAL(), ddb.NewWOC(), cache)
+	blockchain.SetHistoryTTL(t.historyTTL)
+	addresses := []string{}
+	txs := map[string][]string{}
+	unused := 0
+	for from := uint32(0); unused < gap; from += uint32(gap) {
+		batch, err := keys.XPubAddresses(xpub, from, gap)
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+		for _, add := range batch {
+			if unused >= gap {
+				break
+			}
+			if add == "" {
+				continue
+			}
+			txids, err := blockchain.ListTXIDs(add, false)
+			if err != nil {
+				return nil, nil, fmt.Errorf("error while retrieving transactions of %s: %w", add, err)
+			}
+			if len(txids) == 0 {
+				unused++
+				continue
+			}
+			unused = 0
+			addresses = append(addresses, add)
+			txs[add] = txids
+		}
+	}
+	return addresses, txs, nil
+}
@@ -0,0 +1,89 @@
+package ddb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"fmt"
+	"io"
+
+	"github.com/ejfhp/ddb/errs"
+	"github.com/ejfhp/ddb/keys"
+)
+
+const (
+	//HeaderLegacy is the header of the TXs whose MetaEntries are encrypted with the BTrunk password zero padded to 32 bytes.
+	HeaderLegacy = "TRH202101"
+	//HeaderSalted is the header of the TXs whose MetaEntries are encrypted with a key derived from the BTrunk password
+	//and a random salt, saltedMark and the salt precede the encrypted MetaEntry.
+	HeaderSalted = "TRH202202"
+	trunkSaltLen = 16
+)
+
+//saltedMark tells the salted MetaEntries from the other data of the TXs, so no key is derived for them.
+var saltedMark = []byte("tks1")
+
+//trunkKeys encrypts and decrypts the MetaEntries with the BTrunk password.
+//The derivation is slow, the keys derived are remembered by salt and a single salt is used for all the MetaEntries written.
+type trunkKeys struct {
+	password string
+	legacy   [32]byte
+	salt     []byte
+	derived  map[string][32]byte
+}
+
+func newTrunkKeys(password string) *trunkKeys {
+	return &trunkKeys{password: password, legacy: keys.StringToPassword(password), derived: map[string][32]byte{}}
+}
+
+func (tk *trunkKeys) key(salt []byte) ([32]byte, error) {
+	if key, ok := tk.derived[string(salt)]; ok {
+		return key, nil
+	}
+	key, err := keys.DeriveKey(tk.password, salt)
+	if err != nil {
+		return key, err
+	}
+	tk.derived[string(salt)] = key
+	return key, nil
+}
+
+//encrypt returns the MetaEntry encrypted for a TX with header, salted only if header is HeaderSalted.
+func (tk *trunkKeys) encrypt(me *MetaEntry, header string) ([]byte, error) {
+	if header != HeaderSalted {
+		return me.Encrypt(tk.legacy)
+	}
+	if tk.salt == nil {
+		salt := make([]byte, trunkSaltLen)
+		_, err := io.ReadFull(rand.Reader, salt)
+		if err != nil {
+			return nil, fmt.Errorf("cannot generate salt: %w", err)
+		}
+		tk.salt = salt
+	}
+	key, err := tk.key(tk.salt)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := me.Encrypt(key)
+	if err != nil {
+		return nil, err
+	}
+	data := append(append([]byte{}, saltedMark...), tk.salt...)
+	return append(data, encrypted...), nil
+}
+
+//decrypt returns the MetaEntry in the data of a TX output with header.
+func (tk *trunkKeys) decrypt(header string, data []byte) (*MetaEntry, error) {
+	if header != HeaderSalted {
+		return MetaEntryFromEncrypted(tk.legacy, data)
+	}
+	if !bytes.HasPrefix(data, saltedMark) || len(data) <= len(saltedMark)+trunkSaltLen {
+		return nil, fmt.Errorf("data is not a salted MetaEntry: %w", errs.ErrWrongPassword)
+	}
+	data = data[len(saltedMark):]
+	key, err := tk.key(data[:trunkSaltLen])
+	if err != nil {
+		return nil, err
+	}
+	return MetaEntryFromEncrypted(key, data[trunkSaltLen:])
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"net/http"
 
+	"github.com/ejfhp/ddb/errs"
 	"github.com/ejfhp/trail"
 	"github.com/ejfhp/trail/trace"
 )
@@ -22,6 +23,15 @@ type wocu struct {
 	Value  uint64 `json:"value"`
 }
 
+//wocHistoryPage is a page of the confirmed or unconfirmed history of an address.
+type wocHistoryPage struct {
+	Result        []*wocu `json:"result"`
+	NextPageToken string  `json:"nextPageToken"`
+}
+
+//wocHistoryPageLimit is the number of TXs asked for each page of the confirmed history.
+const wocHistoryPageLimit = 1000
+
 type WOC struct {
 	BaseURL string
 }
@@ -37,13 +47,13 @@ func (w *WOC) GetUTXOs(address string) ([]*UTXO, error) {
 	resp, err := http.Get(url)
 	if err != nil {
 		trail.Println(trace.Alert("error while getting unspent").UTC().Add("address", address).Add("url", url).Error(err).Append(t))
-		return nil, fmt.Errorf("error while getting unspent: %w", err)
+		return nil, fmt.Errorf("error while getting unspent: %w", errs.Network("whatsonchain", url, err))
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		trail.Println(trace.Alert("error while reading response").UTC().Add("address", address).Add("url", url).Error(err).Append(t))
-		return nil, fmt.Errorf("error while reading response: %w", err)
+		return nil, fmt.Errorf("error while reading response: %w", errs.Network("whatsonchain", url, err))
 	}
 	unspent := []*wocu{}
 	err = json.Unmarshal(body, &unspent)
@@ -81,13 +91,13 @@ func (w *WOC) GetTX(txHash string) (*TX, error) {
 	resp, err := http.Get(url)
 	if err != nil {
 		trail.Println(trace.Alert("error while getting TX").UTC().Add("txHash", txHash).Add("url", url).Error(err).Append(t))
-		return nil, fmt.Errorf("error while getting TX: %w", err)
+		return nil, fmt.Errorf("error while getting TX: %w", errs.Network("whatsonchain", url, err))
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		trail.Println(trace.Alert("error while reading response").UTC().Add("txHash", txHash).Add("url", url).Error(err).Append(t))
-		return nil, fmt.Errorf("error while reading response: %w", err)
+		return nil, fmt.Errorf("error while reading response: %w", errs.Network("whatsonchain", url, err))
 	}
 	tx := TX{}
 	err = json.Unmarshal(body, &tx)
@@ -106,40 +116,120 @@ func (w *WOC) GetRAWTXHEX(txHash string) ([]byte, error) {
 	resp, err := http.Get(url)
 	if err != nil {
 		trail.Println(trace.Alert("error while getting TX").UTC().Add("txHash", txHash).Add("url", url).Error(err).Append(t))
-		return nil, fmt.Errorf("error while getting TX: %w", err)
+		return nil, fmt.Errorf("error while getting TX: %w", errs.Network("whatsonchain", url, err))
 	}
 	defer resp.Body.Close()
 	hex, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		trail.Println(trace.Alert("error while reading response").UTC().Add("txHash", txHash).Add("url", url).Error(err).Append(t))
-		return nil, fmt.Errorf("error while reading response: %w", err)
+		return nil, fmt.Errorf("error while reading response: %w", errs.Network("whatsonchain", url, err))
 	}
 	return hex, nil
 }
 
 func (w *WOC) GetTXIDs(address string) ([]string, error) {
-	t := trace.New().Source("whatsonchain.go", "WOC", "GetTXIDs")
-	url := fmt.Sprintf("%s/address/%s/history", w.BaseURL, address)
+	history, err := w.GetHistory(address, 0)
+	if err != nil {
+		return nil, err
+	}
+	txids := make([]string, len(history))
+	for i, h := range history {
+		txids[i] = h.TXID
+	}
+	return txids, nil
+}
+
+//GetHistory returns the TXs of the address mined from fromHeight on and the unconfirmed ones.
+//The confirmed history is read newest first, one page at a time, and paging stops at the first TX older than fromHeight.
+func (w *WOC) GetHistory(address string, fromHeight int) ([]*HistoryTX, error) {
+	t := trace.New().Source("whatsonchain.go", "WOC", "GetHistory")
+	unconfirmed, err := w.getHistoryPage(fmt.Sprintf("%s/address/%s/unconfirmed/history", w.BaseURL, address))
+	if err != nil {
+		return nil, err
+	}
+	history := make([]*HistoryTX, 0, len(unconfirmed.Result))
+	for _, u := range unconfirmed.Result {
+		history = append(history, &HistoryTX{TXID: u.TXHash})
+	}
+	pageToken := ""
+	for {
+		url := fmt.Sprintf("%s/address/%s/confirmed/history?order=desc&limit=%d", w.BaseURL, address, wocHistoryPageLimit)
+		if pageToken != "" {
+			url = fmt.Sprintf("%s&pageToken=%s", url, pageToken)
+		}
+		page, err := w.getHistoryPage(url)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range page.Result {
+			if int(u.Height) < fromHeight {
+				trail.Println(trace.Debug("history synced").UTC().Add("address", address).Add("fromHeight", fmt.Sprintf("%d", fromHeight)).Append(t))
+				return history, nil
+			}
+			history = append(history, &HistoryTX{TXID: u.TXHash, Height: int(u.Height)})
+		}
+		if page.NextPageToken == "" {
+			return history, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+func (w *WOC) getHistoryPage(url string) (*wocHistoryPage, error) {
+	t := trace.New().Source("whatsonchain.go", "WOC", "getHistoryPage")
 	resp, err := http.Get(url)
 	if err != nil {
-		trail.Println(trace.Alert("error while getting unspent").UTC().Add("address", address).Add("url", url).Error(err).Append(t))
-		return nil, fmt.Errorf("error while getting unspent: %w", err)
+		trail.Println(trace.Alert("error while getting history").UTC().Add("url", url).Error(err).Append(t))
+		return nil, fmt.Errorf("error while getting history: %w", errs.Network("whatsonchain", url, err))
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error while getting history: %w", errs.NetworkStatus("whatsonchain", url, resp.Status))
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		trail.Println(trace.Alert("error while reading response").UTC().Add("address", address).Add("url", url).Error(err).Append(t))
-		return nil, fmt.Errorf("error while reading response: %w", err)
+		trail.Println(trace.Alert("error while reading response").UTC().Add("url", url).Error(err).Append(t))
+		return nil, fmt.Errorf("error while reading response: %w", errs.Network("whatsonchain", url, err))
 	}
-	txs := []*wocu{}
-	err = json.Unmarshal(body, &txs)
+	page := wocHistoryPage{}
+	err = json.Unmarshal(body, &page)
 	if err != nil {
-		trail.Println(trace.Alert("error while unmarshalling").UTC().Add("address", address).Add("url", url).Error(err).Append(t))
+		trail.Println(trace.Alert("error while unmarshalling").UTC().Add("url", url).Error(err).Append(t))
 		return nil, fmt.Errorf("error while unmarshalling: %w", err)
 	}
-	txids := make([]string, len(txs))
-	for i, t := range txs {
-		txids[i] = t.TXHash
+	return &page, nil
+}
+
+//GetMerkleProof returns the merkle proof of the TX in TSC format, errs.ErrNotFound if the TX is not mined.
+func (w *WOC) GetMerkleProof(txHash string) (json.RawMessage, error) {
+	t := trace.New().Source("whatsonchain.go", "WOC", "GetMerkleProof")
+	url := fmt.Sprintf("%s/tx/%s/proof/tsc", w.BaseURL, txHash)
+	trail.Println(trace.Debug("get merkle proof").UTC().Add("hash", txHash).Add("url", url).Append(t))
+	resp, err := http.Get(url)
+	if err != nil {
+		trail.Println(trace.Alert("error while getting merkle proof").UTC().Add("txHash", txHash).Add("url", url).Error(err).Append(t))
+		return nil, fmt.Errorf("error while getting merkle proof: %w", errs.Network("whatsonchain", url, err))
 	}
-	return txids, nil
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("merkle proof of TX %s: %w", txHash, errs.ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error while getting merkle proof: %w", errs.NetworkStatus("whatsonchain", url, resp.Status))
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		trail.Println(trace.Alert("error while reading response").UTC().Add("txHash", txHash).Add("url", url).Error(err).Append(t))
+		return nil, fmt.Errorf("error while reading response: %w", errs.Network("whatsonchain", url, err))
+	}
+	proof := json.RawMessage{}
+	err = json.Unmarshal(body, &proof)
+	if err != nil {
+		trail.Println(trace.Alert("error while unmarshalling").UTC().Add("txHash", txHash).Add("url", url).Error(err).Append(t))
+		return nil, fmt.Errorf("error while unmarshalling: %w", err)
+	}
+	if string(proof) == "null" {
+		return nil, fmt.Errorf("merkle proof of TX %s: %w", txHash, errs.ErrNotFound)
+	}
+	return proof, nil
 }
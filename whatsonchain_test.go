@@ -1,6 +1,10 @@
 package ddb_test
 
 import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/ejfhp/ddb"
@@ -78,3 +82,45 @@ func TestWOC_GetTXIDs(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestWhatsOnChain_GetHistoryPages(t *testing.T) {
+	pages := map[string]string{
+		"":   `{"result":[{"tx_hash":"d","height":715002},{"tx_hash":"c","height":715001}],"nextPageToken":"p2"}`,
+		"p2": `{"result":[{"tx_hash":"b","height":715000},{"tx_hash":"a","height":700000}],"nextPageToken":"p3"}`,
+		"p3": `{"result":[{"tx_hash":"z","height":600000}]}`,
+	}
+	asked := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/unconfirmed/history") {
+			fmt.Fprint(w, `{"result":[{"tx_hash":"e"}]}`)
+			return
+		}
+		token := r.URL.Query().Get("pageToken")
+		asked = append(asked, token)
+		fmt.Fprint(w, pages[token])
+	}))
+	defer server.Close()
+	woc := &ddb.WOC{BaseURL: server.URL}
+	history, err := woc.GetHistory("1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X", 715000)
+	if err != nil {
+		t.Logf("failed to get history: %v", err)
+		t.FailNow()
+	}
+	ids := []string{}
+	for _, h := range history {
+		ids = append(ids, h.TXID)
+	}
+	if strings.Join(ids, ",") != "e,d,c,b" || history[0].Height != 0 || history[3].Height != 715000 {
+		t.Logf("unexpected history: %v", ids)
+		t.FailNow()
+	}
+	if len(asked) != 2 {
+		t.Logf("pages older than the height should not be asked: %v", asked)
+		t.FailNow()
+	}
+	txids, err := woc.GetTXIDs("1PGh5YtRoohzcZF7WX8SJeZqm6wyaCte7X")
+	if err != nil || len(txids) != 6 {
+		t.Logf("unexpected full history: %v %v", txids, err)
+		t.FailNow()
+	}
+}